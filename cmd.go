@@ -1,23 +1,80 @@
 package main
 
 import (
+	"bufio"
+	"errors"
 	"flag"
 	"fmt"
+	"github.com/hdt3213/rdb/bytefmt"
+	"github.com/hdt3213/rdb/core"
 	"github.com/hdt3213/rdb/helper"
+	"github.com/hdt3213/rdb/model"
+	"io"
+	"net"
 	"os"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 )
 
 const help = `
 This is a tool to parse Redis' RDB files
 Options:
-  -c command, including: json/memory/aof
-  -o output file path
+  -c command, including: json/memory/aof/bigkey/flamegraph/filter/merge/split/rewrite/downgrade/compat/dump-decode/pitr/import/serve
+  -o output file path, "-" means stdout for json/memory/aof/bigkey/flamegraph
   -n number of result, using in 
-  -port listen port for flame graph web service
+  -port listen port for flame graph web service, or for serve command whose default value is 6380
   -sep separator for flamegraph, rdb will separate key by it, default value is ":". 
 		supporting multi separators: -sep sep1 -sep sep2 
   -regex using regex expression filter keys
+  -db only keep keys in the given database, supporting multi databases: -db 0 -db 2
+  -type only keep keys of the given type(string/list/set/hash/zset/stream/module), supporting multi types
+  -min-size only keep keys whose rdb encoded size is not less than it, such as 1024 or 1KB
+  -max-size only keep keys whose rdb encoded size is not greater than it
+  -expire only keep keys in the given expiration state(persistent/volatile/expired), supporting multi states
+  -conflict policy for keys existing in more than one file when merging: first-wins/last-wins/newest-expiration/error
+  -shards split rdb into given number of nodes with equal hash slots
+  -slots slot map file for split, each line contains node name and slot ranges, such as: node-a 0-5460,16000
+  -skip-other-db skip keys out of db 0 when splitting instead of failing, since redis cluster only supports db 0
+  -rules rewrite rules file to rename keys or move them between databases, working with rewrite/aof/json commands
+  -dry-run list keys would be changed by rewrite rules and collisions without writing rdb
+  -drop-expired drop keys expired before reference time
+  -ref-time reference time for -drop-expired, RFC3339 or unix timestamp in seconds, default value is now
+  -rebase-ttl shift all expirations by the age of snapshot, as if the snapshot was taken just now
+  -snapshot-time snapshot time for -rebase-ttl, RFC3339 or unix timestamp in seconds, default value is ctime of rdb
+  -max-ttl clamp ttl of keys to it, such as 720h
+  -ttl-jitter add random duration up to it to expiration of keys, such as 10m
+  -default-ttl set ttl for persistent keys matching regex, such as '^session:.*=24h', supporting multi rules
+  -anonymize anonymize rules file, working with filter/json/aof commands
+  -secret secret for anonymizer, default value is environment variable RDB_ANONYMIZE_SECRET
+  -sample keep a deterministic fraction of keys, such as 0.01
+  -sample-seed seed for -sample and -reservoir, default value is 0
+  -reservoir keep given number of randomly chosen keys
+  -target-version rdb version for downgrade, or version of RESTORE payloads, such as 9 for redis 5.0 to 6.2
+  -strict fail instead of dropping data which could not be represented in target version
+  -redis redis version to check compatibility with, such as 6.2
+  -restore write RESTORE commands with DUMP payloads instead of SET, RPUSH etc. in aof, payload version is 9 by default
+  -replace add REPLACE to RESTORE commands, overwriting existing keys
+  -batch max number of elements per command of collections in aof, default value is unlimited
+  -batch-size max bytes of elements per command of collections in aof, such as 1MB
+  -multi wrap commands of each key in MULTI/EXEC in aof
+  -multipart write aof directory of redis 7 with manifest, base file and incr file into output path
+  -resp-base write base file of -multipart in RESP instead of rdb
+  compressed input such as dump.rdb.gz is recognized by magic number, output of json/memory/aof/bigkey is compressed
+		if output file is named like dump.json.gz
+  -from fetch snapshot from running redis by replication instead of reading src file, such as redis://:password@host:6379
+  -to import keys into running redis, such as redis://:password@host:6379
+  -concurrency number of connections for import, default value is 1
+  -pipeline max number of commands sent before reading replies for import, default value is 100
+  -rate max number of keys sent per second for import, default value is unlimited
+  -retries max times of retrying a key on transient errors such as LOADING for import, 0 disables retrying, default value is 3
+  -cluster import into redis cluster, keys are routed to masters by CLUSTER SLOTS and MOVED/ASK are followed
+  -workers number of goroutines decoding rdb for json/aof/import, 0 means number of cpus,
+		default value is 1
+  -until replay aof up to the time for pitr, RFC3339 or unix timestamp in seconds, requiring aof-timestamp-enabled
+  -skip-unknown skip commands which could not be replayed such as PFADD when loading aof, keys written by them
+		are reported
 
 Examples:
 parameters between '[' and ']' is optional
@@ -26,11 +83,53 @@ parameters between '[' and ']' is optional
 2. generate memory report
   rdb -c memory -o memory.csv dump.rdb
 3. convert to aof file
-  rdb -c aof -o dump.aof dump.rdb
+  rdb -c aof -o dump.aof [-batch 1000] [-batch-size 1MB] [-multi] dump.rdb
 4. get largest keys
   rdb -c bigkey [-o dump.aof] [-n 10] dump.rdb
 5. draw flamegraph
-  rdb -c flamegraph [-port 16379] [-sep :] dump.rdb
+  rdb -c flamegraph [-port 16379] [-sep :] [-o flamegraph.html] dump.rdb
+6. filter keys into a new rdb file
+  rdb -c filter -o out.rdb [-db 2] [-regex '^order:.*'] [-type hash] [-min-size 1KB] [-expire persistent] dump.rdb
+7. merge several rdb files into one
+  rdb -c merge -o out.rdb [-conflict first-wins] a.rdb b.rdb
+8. split rdb into one file per cluster node by hash slot
+  rdb -c split -o <output_dir> [-shards 3] [-slots slots.txt] [-skip-other-db] dump.rdb
+9. rename keys or move keys between databases by rules
+  rdb -c rewrite -rules rules.txt [-dry-run] -o out.rdb dump.rdb
+10. restore old backup without resurrecting expired keys
+  rdb -c filter -o out.rdb -rebase-ttl -drop-expired [-ttl-jitter 10m] [-max-ttl 720h] dump.rdb
+11. anonymize keys and values
+  rdb -c filter -o out.rdb -anonymize anonymize.txt -secret <secret> dump.rdb
+12. generate memory report of 1% keys and estimate totals
+  rdb -c memory -o memory.csv -sample 0.01 [-sample-seed 1] dump.rdb
+13. rewrite rdb for older redis
+  rdb -c downgrade -target-version 9 [-strict] -o out.rdb dump.rdb
+14. check whether rdb could be loaded by given redis version
+  rdb -c compat [-redis 6.2] dump.rdb
+15. convert to aof file of RESTORE commands
+  rdb -c aof -o dump.aof -restore [-target-version 9] [-replace] dump.rdb
+16. decode payloads of DUMP command into json, each line of payloads.txt is '[key ]<hex or quoted payload>'
+  rdb -c dump-decode -o dump.json payloads.txt
+17. convert to aof directory of redis 7
+  rdb -c aof -multipart [-resp-base] -o appendonlydir dump.rdb
+18. generate memory report of aof with rdb preamble or aof directory of redis 7
+  rdb -c memory -o memory.csv appendonlydir
+19. restore rdb at a point in time from rdb and aof
+  rdb -c pitr -until 2026-10-01T12:00:00Z -o out.rdb [base.rdb] appendonlydir
+20. convert compressed rdb to compressed json
+  rdb -c json -o dump.json.gz dump.rdb.gz
+21. read rdb from stdin and write json to stdout
+  redis-cli --rdb - | rdb -c json -o - -
+22. fetch snapshot from running redis and convert to json without writing rdb to disk
+  rdb -c json -from redis://:password@127.0.0.1:6379 -o dump.json
+23. import rdb into running redis
+  rdb -c import -to redis://:password@127.0.0.1:6379 [-concurrency 4] [-pipeline 100] [-rate 10000] [-restore] dump.rdb
+24. import rdb into redis cluster, url of any node is accepted
+  rdb -c import -cluster -to redis://127.0.0.1:7000 dump.rdb
+25. serve rdb as a read-only redis on localhost, then inspect it by redis-cli -p 6380
+  rdb -c serve [-port 6380] dump.rdb
+26. convert large rdb to json using all cpus
+  rdb -c json -workers 0 -o dump.json dump.rdb
 `
 
 type separators []string
@@ -44,6 +143,395 @@ func (s *separators) Set(value string) error {
 	return nil
 }
 
+// stringList is a flag which could be set multiple times
+type stringList []string
+
+func (s *stringList) String() string {
+	return strings.Join(*s, " ")
+}
+
+func (s *stringList) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+// intList is a flag which could be set multiple times
+type intList []int
+
+func (l *intList) String() string {
+	parts := make([]string, len(*l))
+	for i, v := range *l {
+		parts[i] = strconv.Itoa(v)
+	}
+	return strings.Join(parts, " ")
+}
+
+func (l *intList) Set(value string) error {
+	v, err := strconv.Atoi(value)
+	if err != nil {
+		return err
+	}
+	*l = append(*l, v)
+	return nil
+}
+
+// parseSize accepts bytes count or human readable size like 1KB
+func parseSize(s string) (int, error) {
+	if s == "" {
+		return 0, nil
+	}
+	if n, err := strconv.Atoi(s); err == nil {
+		return n, nil
+	}
+	n, err := bytefmt.ParseSize(s)
+	if err != nil {
+		return 0, fmt.Errorf("illegal size %s: %v", s, err)
+	}
+	return int(n), nil
+}
+
+func splitRDB(src, outputDir, slotMap string, shards int, options ...interface{}) ([]*helper.SplitStat, error) {
+	var nodes []*helper.ClusterNode
+	var err error
+	if slotMap != "" {
+		var f *os.File
+		f, err = os.Open(slotMap)
+		if err != nil {
+			return nil, err
+		}
+		defer func() {
+			_ = f.Close()
+		}()
+		nodes, err = helper.ParseSlotMap(f)
+	} else {
+		nodes, err = helper.EqualShards(shards)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return helper.SplitRDB(src, outputDir, nodes, options...)
+}
+
+// printSplitReport prints keys skipped by split, since redis cluster only supports db 0
+func printSplitReport(report *helper.SplitReport) {
+	dbs := make([]int, 0, len(report.SkippedKeys))
+	for db := range report.SkippedKeys {
+		dbs = append(dbs, db)
+	}
+	sort.Ints(dbs)
+	for _, db := range dbs {
+		fmt.Printf("skipped %d keys in db %d, redis cluster only supports db 0\n", report.SkippedKeys[db], db)
+	}
+}
+
+func readRewriteRules(filename string) ([]*helper.RewriteRule, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	return helper.ParseRewriteRules(f)
+}
+
+func readAnonymizeRules(filename string) ([]*helper.AnonymizeRule, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	return helper.ParseAnonymizeRules(f)
+}
+
+func printSampleReport(w io.Writer, report *helper.SampleReport) {
+	fmt.Fprintf(w, "sampled %d of %d keys (%.2f%%)\n", report.SampledKeys, report.ScannedKeys, report.Ratio()*100)
+	fmt.Fprintf(w, "estimated total: %d keys, %s\n", report.EstimateKeys(""),
+		bytefmt.FormatSize(uint64(report.EstimateSize(""))))
+	types := make([]string, 0, len(report.TypeKeys))
+	for typ := range report.TypeKeys {
+		types = append(types, typ)
+	}
+	sort.Strings(types)
+	for _, typ := range types {
+		fmt.Fprintf(w, "  %s: %d keys, %s\n", typ, report.EstimateKeys(typ),
+			bytefmt.FormatSize(uint64(report.EstimateSize(typ))))
+	}
+}
+
+// printSkipReport prints commands skipped when loading aof and keys written by them, whose values may be stale
+func printSkipReport(w io.Writer, report *helper.AOFSkipReport) {
+	names := make([]string, 0, len(report.Commands))
+	for name := range report.Commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "skipped %d %s commands\n", report.Commands[name], strings.ToUpper(name))
+	}
+	dbs := make([]int, 0, len(report.Keys))
+	for db := range report.Keys {
+		dbs = append(dbs, db)
+	}
+	sort.Ints(dbs)
+	for _, db := range dbs {
+		keys := make([]string, 0, len(report.Keys[db]))
+		for key := range report.Keys[db] {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		fmt.Fprintf(w, "stale keys in db %d: %s\n", db, strings.Join(keys, ", "))
+	}
+}
+
+func printRewritePlan(plan *helper.RewritePlan, printChanges bool) {
+	if plan == nil {
+		return
+	}
+	if printChanges {
+		for _, change := range plan.Changes {
+			fmt.Printf("%d %s -> %d %s\n", change.SrcDB, change.SrcKey, change.DB, change.Key)
+		}
+		fmt.Printf("%d keys would be changed\n", len(plan.Changes))
+	}
+	for _, collision := range plan.Collisions {
+		sources := make([]string, len(collision.Sources))
+		for i, source := range collision.Sources {
+			sources[i] = strconv.Itoa(source.SrcDB) + " " + source.SrcKey
+		}
+		fmt.Printf("collision: %d %s from %s\n", collision.DB, collision.Key, strings.Join(sources, ", "))
+	}
+}
+
+func printCompatReport(report *helper.CompatReport) {
+	fmt.Printf("rdb version: %d\n", report.RDBVersion)
+	fmt.Printf("minimal redis version: %s\n", report.MinRedisVersion)
+	for _, feature := range report.Features {
+		fmt.Printf("  %s (redis %s): %d\n", feature.Name, feature.RedisVersion, feature.Count)
+	}
+	if len(report.Modules) > 0 {
+		fmt.Printf("required modules: %s\n", strings.Join(report.Modules, ", "))
+	}
+	if len(report.Functions) > 0 {
+		fmt.Printf("function libraries: %s\n", strings.Join(report.Functions, ", "))
+	}
+	if report.TargetVersion == "" {
+		return
+	}
+	if report.Compatible() {
+		fmt.Printf("compatible with redis %s\n", report.TargetVersion)
+		return
+	}
+	fmt.Printf("incompatible with redis %s:\n", report.TargetVersion)
+	for _, issue := range report.Issues {
+		switch {
+		case issue.Key == "":
+			fmt.Printf("  %s requires redis %s\n", issue.Feature, issue.RedisVersion)
+		case issue.Type == model.FunctionsType || issue.Type == model.ModuleAuxType:
+			fmt.Printf("  %s %s: %s requires redis %s\n", issue.Type, issue.Key, issue.Feature, issue.RedisVersion)
+		default:
+			fmt.Printf("  db %d key %s: %s requires redis %s\n", issue.DB, issue.Key, issue.Feature, issue.RedisVersion)
+		}
+	}
+	if version := core.RDBVersionOfRedis(report.TargetVersion); version >= 7 {
+		fmt.Printf("try: rdb -c downgrade -target-version %d -o <output> <src>\n", version)
+	}
+}
+
+// makeCmdOptions makes options of aof and import commands
+func makeCmdOptions(restore bool, targetVersion int, replace bool, batch int, batchSizeStr string,
+	multi bool) ([]interface{}, error) {
+	var options []interface{}
+	if restore {
+		version := targetVersion
+		if version == 0 {
+			version = 9
+		}
+		options = append(options, helper.WithRestoreOption(version, replace))
+	}
+	batchSize, err := parseSize(batchSizeStr)
+	if err != nil {
+		return nil, err
+	}
+	if batch > 0 || batchSize > 0 {
+		options = append(options, helper.WithBatchOption(batch, batchSize))
+	}
+	if multi {
+		options = append(options, helper.WithTransactionOption())
+	}
+	return options, nil
+}
+
+func printImportResult(result *helper.ImportResult) {
+	if result == nil {
+		return
+	}
+	for _, failure := range result.Failures {
+		fmt.Printf("failed: key %s of db %d: %s\n", failure.Key, failure.DB, failure.Err)
+	}
+	if len(result.Nodes) > 1 {
+		addrs := make([]string, 0, len(result.Nodes))
+		for addr := range result.Nodes {
+			addrs = append(addrs, addr)
+		}
+		sort.Strings(addrs)
+		for _, addr := range addrs {
+			fmt.Printf("node %s: %d keys\n", addr, result.Nodes[addr])
+		}
+	}
+	fmt.Printf("imported %d keys, %d failed, %d commands sent\n", result.Keys, len(result.Failures), result.Commands)
+}
+
+// serveSnapshot answers read commands with keys in rdb on localhost until the process exits
+func serveSnapshot(src string, port int) error {
+	if port == 0 {
+		port = 6380
+	}
+	server, err := helper.NewSnapshotServer(src)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = server.Close()
+	}()
+	listener, err := net.Listen("tcp", "127.0.0.1:"+strconv.Itoa(port))
+	if err != nil {
+		return err
+	}
+	fmt.Printf("serving %s, try: redis-cli -p %d\n", src, port)
+	return server.Serve(listener)
+}
+
+// runWithStdio runs reader based helper, src and output could be "-" which means stdin and stdout
+func runWithStdio(stdin io.Reader, src string, output string, fn func(input io.Reader, output io.Writer) error) error {
+	input := stdin
+	if src != "-" {
+		file, err := os.Open(src)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_ = file.Close()
+		}()
+		input = file // name of file is used to recognize aof
+	}
+	if output == "" {
+		return errors.New("output file path is required")
+	}
+	if output == "-" {
+		writer := bufio.NewWriter(os.Stdout)
+		err := fn(input, writer)
+		if err != nil {
+			return err
+		}
+		return writer.Flush()
+	}
+	file, err := helper.CreateOutput(output)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+	err = fn(input, file)
+	if err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// pointInTimeRDB runs pitr command, args are [rdb] aof
+func pointInTimeRDB(args []string, output string, untilStr string, options ...interface{}) error {
+	if untilStr == "" {
+		return errors.New("-until is required")
+	}
+	until, err := parseTime(untilStr)
+	if err != nil {
+		return err
+	}
+	var rdbFilename, aofPath string
+	switch len(args) {
+	case 1:
+		aofPath = args[0]
+	case 2:
+		rdbFilename, aofPath = args[0], args[1]
+	default:
+		return errors.New("usage: rdb -c pitr -until <time> -o <output> [rdb] <aof>")
+	}
+	result, err := helper.PointInTimeRDB(rdbFilename, aofPath, output, until, options...)
+	if err != nil {
+		return err
+	}
+	if result.LastTimestamp.IsZero() {
+		fmt.Printf("replayed %d commands, warning: no timestamp annotation found, "+
+			"aof-timestamp-enabled may be off\n", result.Commands)
+		return nil
+	}
+	fmt.Printf("replayed %d commands up to %s", result.Commands, result.LastTimestamp.UTC().Format(time.RFC3339))
+	if result.Truncated {
+		fmt.Print(", later commands are skipped")
+	}
+	fmt.Println()
+	if result.Expired > 0 {
+		fmt.Printf("dropped %d keys expired at %s\n", result.Expired, until.UTC().Format(time.RFC3339))
+	}
+	return nil
+}
+
+// parseTime accepts RFC3339 or unix timestamp in seconds, empty string returns zero time
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if sec, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(sec, 0), nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("illegal time %s, RFC3339 or unix timestamp is required", s)
+	}
+	return t, nil
+}
+
+func makeExpirationOptions(dropExpired bool, refTimeStr string, rebaseTTL bool, snapshotTimeStr string,
+	maxTTL, ttlJitter time.Duration, defaultTTLs []string) ([]interface{}, error) {
+	var options []interface{}
+	if dropExpired {
+		refTime, err := parseTime(refTimeStr)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, helper.WithDropExpiredOption(refTime))
+	}
+	if rebaseTTL {
+		snapshotTime, err := parseTime(snapshotTimeStr)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, helper.WithRebaseExpirationOption(snapshotTime))
+	}
+	if maxTTL != 0 {
+		options = append(options, helper.WithMaxTTLOption(maxTTL))
+	}
+	if ttlJitter != 0 {
+		options = append(options, helper.WithTTLJitterOption(ttlJitter))
+	}
+	for _, rule := range defaultTTLs {
+		i := strings.LastIndexByte(rule, '=')
+		if i < 0 {
+			return nil, fmt.Errorf("illegal default ttl %s, <regex>=<ttl> is required", rule)
+		}
+		ttl, err := time.ParseDuration(rule[i+1:])
+		if err != nil {
+			return nil, fmt.Errorf("illegal default ttl %s: %v", rule, err)
+		}
+		options = append(options, helper.WithDefaultTTLOption(rule[:i], ttl))
+	}
+	return options, nil
+}
+
 func main() {
 	flagSet := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
 	var cmd string
@@ -52,12 +540,88 @@ func main() {
 	var port int
 	var seps separators
 	var regexExpr string
+	var dbs intList
+	var types stringList
+	var minSizeStr, maxSizeStr string
+	var expireStates stringList
+	var conflictPolicy string
+	var shards int
+	var slotMap string
+	var rulesFile string
+	var dryRun bool
+	var dropExpired, rebaseTTL bool
+	var refTimeStr, snapshotTimeStr string
+	var maxTTL, ttlJitter time.Duration
+	var defaultTTLs stringList
+	var anonymizeFile, secret string
+	var sampleFraction float64
+	var sampleSeed int64
+	var reservoirSize int
+	var targetVersion int
+	var strict bool
+	var redisVersion string
+	var restore, replace bool
+	var batch int
+	var batchSizeStr string
+	var multi bool
+	var multipart, respBase bool
+	var untilStr string
+	var from string
+	var to string
+	var concurrency, pipeline, rate, retries int
+	var cluster bool
+	var workers int
+	var skipUnknown bool
+	var skipOtherDBs bool
 	flagSet.StringVar(&cmd, "c", "", "command for rdb: json")
 	flagSet.StringVar(&output, "o", "", "output file path")
 	flagSet.IntVar(&n, "n", 0, "")
 	flagSet.IntVar(&port, "port", 0, "listen port for web")
 	flagSet.Var(&seps, "sep", "separator for flamegraph")
 	flagSet.StringVar(&regexExpr, "regex", "", "regex expression")
+	flagSet.Var(&dbs, "db", "only keep keys in the given database")
+	flagSet.Var(&types, "type", "only keep keys of the given type")
+	flagSet.StringVar(&minSizeStr, "min-size", "", "only keep keys whose size is not less than it")
+	flagSet.StringVar(&maxSizeStr, "max-size", "", "only keep keys whose size is not greater than it")
+	flagSet.Var(&expireStates, "expire", "only keep keys in the given expiration state")
+	flagSet.StringVar(&conflictPolicy, "conflict", helper.FirstWins, "policy for duplicate keys when merging")
+	flagSet.IntVar(&shards, "shards", 0, "split into given number of nodes")
+	flagSet.StringVar(&slotMap, "slots", "", "slot map file for split")
+	flagSet.BoolVar(&skipOtherDBs, "skip-other-db", false, "skip keys out of db 0 when splitting")
+	flagSet.StringVar(&rulesFile, "rules", "", "rewrite rules file")
+	flagSet.BoolVar(&dryRun, "dry-run", false, "list keys would be changed by rewrite rules")
+	flagSet.BoolVar(&dropExpired, "drop-expired", false, "drop keys expired before reference time")
+	flagSet.StringVar(&refTimeStr, "ref-time", "", "reference time for -drop-expired")
+	flagSet.BoolVar(&rebaseTTL, "rebase-ttl", false, "shift all expirations by the age of snapshot")
+	flagSet.StringVar(&snapshotTimeStr, "snapshot-time", "", "snapshot time for -rebase-ttl")
+	flagSet.DurationVar(&maxTTL, "max-ttl", 0, "clamp ttl of keys to it")
+	flagSet.DurationVar(&ttlJitter, "ttl-jitter", 0, "add random duration up to it to expiration of keys")
+	flagSet.Var(&defaultTTLs, "default-ttl", "set ttl for persistent keys matching regex")
+	flagSet.StringVar(&anonymizeFile, "anonymize", "", "anonymize rules file")
+	flagSet.StringVar(&secret, "secret", os.Getenv("RDB_ANONYMIZE_SECRET"), "secret for anonymizer")
+	flagSet.Float64Var(&sampleFraction, "sample", 0, "keep a deterministic fraction of keys")
+	flagSet.Int64Var(&sampleSeed, "sample-seed", 0, "seed for sampling")
+	flagSet.IntVar(&reservoirSize, "reservoir", 0, "keep given number of randomly chosen keys")
+	flagSet.IntVar(&targetVersion, "target-version", 0, "rdb version for downgrade")
+	flagSet.BoolVar(&strict, "strict", false, "fail on data which could not be represented in target version")
+	flagSet.StringVar(&redisVersion, "redis", "", "redis version to check compatibility with")
+	flagSet.BoolVar(&restore, "restore", false, "write RESTORE commands in aof")
+	flagSet.BoolVar(&replace, "replace", false, "add REPLACE to RESTORE commands")
+	flagSet.IntVar(&batch, "batch", 0, "max number of elements per command in aof")
+	flagSet.StringVar(&batchSizeStr, "batch-size", "", "max bytes of elements per command in aof")
+	flagSet.BoolVar(&multi, "multi", false, "wrap commands of each key in MULTI/EXEC")
+	flagSet.BoolVar(&multipart, "multipart", false, "write aof directory of redis 7")
+	flagSet.BoolVar(&respBase, "resp-base", false, "write base file of multi-part aof in RESP")
+	flagSet.StringVar(&untilStr, "until", "", "replay aof up to the time for pitr")
+	flagSet.StringVar(&from, "from", "", "fetch snapshot from redis by replication")
+	flagSet.StringVar(&to, "to", "", "url of redis to import into")
+	flagSet.IntVar(&concurrency, "concurrency", 1, "number of connections for import")
+	flagSet.IntVar(&pipeline, "pipeline", 100, "max number of commands in pipeline for import")
+	flagSet.IntVar(&rate, "rate", 0, "max number of keys per second for import")
+	flagSet.BoolVar(&cluster, "cluster", false, "import into redis cluster, routing keys by hash slot")
+	flagSet.IntVar(&retries, "retries", 3, "max times of retrying a key on transient errors for import")
+	flagSet.IntVar(&workers, "workers", 1, "number of goroutines decoding rdb, 0 means number of cpus")
+	flagSet.BoolVar(&skipUnknown, "skip-unknown", false, "skip unsupported commands when loading aof")
 	_ = flagSet.Parse(os.Args[1:]) // ExitOnError
 	src := flagSet.Arg(0)
 
@@ -65,41 +629,242 @@ func main() {
 		println(help)
 		return
 	}
+	var stdin io.Reader = os.Stdin
+	if from != "" {
+		switch cmd {
+		case "json", "memory", "aof", "bigkey", "flamegraph", "import":
+		default:
+			println("-from is only supported by json/memory/aof/bigkey/flamegraph/import")
+			return
+		}
+		snapshot, err := helper.FetchSnapshot(from)
+		if err != nil {
+			fmt.Printf("error: %v\n", err)
+			return
+		}
+		defer func() {
+			_ = snapshot.Close()
+		}()
+		stdin, src = snapshot, "-"
+	}
 	if src == "" {
 		println("src file is required")
 		return
 	}
 
 	var options []interface{}
+	if workers != 1 {
+		options = append(options, helper.WithParallelOption(workers))
+	}
 	if regexExpr != "" {
 		options = append(options, helper.WithRegexOption(regexExpr))
 	}
+	if len(dbs) > 0 {
+		options = append(options, helper.WithDBOption(dbs...))
+	}
+	if len(types) > 0 {
+		options = append(options, helper.WithTypeOption(types...))
+	}
+	if len(expireStates) > 0 {
+		options = append(options, helper.WithExpireStateOption(expireStates...))
+	}
+	minSize, err := parseSize(minSizeStr)
+	if err != nil {
+		fmt.Printf("error: %v\n", err)
+		return
+	}
+	maxSize, err := parseSize(maxSizeStr)
+	if err != nil {
+		fmt.Printf("error: %v\n", err)
+		return
+	}
+	if minSize > 0 || maxSize > 0 {
+		options = append(options, helper.WithSizeOption(minSize, maxSize))
+	}
+	expirationOptions, err := makeExpirationOptions(dropExpired, refTimeStr, rebaseTTL, snapshotTimeStr,
+		maxTTL, ttlJitter, defaultTTLs)
+	if err != nil {
+		fmt.Printf("error: %v\n", err)
+		return
+	}
+	options = append(options, expirationOptions...)
+	if rulesFile != "" {
+		rules, err := readRewriteRules(rulesFile)
+		if err != nil {
+			fmt.Printf("error: %v\n", err)
+			return
+		}
+		options = append(options, helper.WithRewriteOption(rules...))
+	}
+	if anonymizeFile != "" {
+		rules, err := readAnonymizeRules(anonymizeFile)
+		if err != nil {
+			fmt.Printf("error: %v\n", err)
+			return
+		}
+		options = append(options, helper.WithAnonymizeOption(secret, rules...))
+	}
+	var sampleReport *helper.SampleReport
+	if sampleFraction != 0 || reservoirSize != 0 {
+		if reservoirSize != 0 {
+			options = append(options, helper.WithReservoirOption(reservoirSize, sampleSeed))
+		} else {
+			options = append(options, helper.WithSampleOption(sampleFraction, sampleSeed))
+		}
+		sampleReport = &helper.SampleReport{}
+		options = append(options, helper.WithSampleReportOption(sampleReport))
+	}
+
+	var skipReport *helper.AOFSkipReport
+	if skipUnknown {
+		skipReport = &helper.AOFSkipReport{}
+		options = append(options, helper.WithSkipUnknownOption(skipReport))
+	}
+
+	if cmd == "aof" || cmd == "import" {
+		cmdOptions, err := makeCmdOptions(restore, targetVersion, replace, batch, batchSizeStr, multi)
+		if err != nil {
+			fmt.Printf("error: %v\n", err)
+			return
+		}
+		options = append(options, cmdOptions...)
+	}
 
-	var err error
 	switch cmd {
 	case "json":
+		if src == "-" || output == "-" {
+			err = runWithStdio(stdin, src, output, func(input io.Reader, output io.Writer) error {
+				return helper.ToJsonsFromReader(input, output, options...)
+			})
+			break
+		}
 		err = helper.ToJsons(src, output, options...)
 	case "memory":
+		if src == "-" || output == "-" {
+			err = runWithStdio(stdin, src, output, func(input io.Reader, output io.Writer) error {
+				return helper.MemoryProfileFromReader(input, output, options...)
+			})
+			break
+		}
 		err = helper.MemoryProfile(src, output, options...)
 	case "aof":
-		err = helper.ToAOF(src, output, options)
+		if multipart {
+			if respBase {
+				options = append(options, helper.WithRESPBaseOption())
+			}
+			err = helper.ToMultipartAOF(src, output, options...)
+			break
+		}
+		if src == "-" || output == "-" {
+			err = runWithStdio(stdin, src, output, func(input io.Reader, output io.Writer) error {
+				return helper.ToAOFFromReader(input, output, options...)
+			})
+			break
+		}
+		err = helper.ToAOF(src, output, options...)
 	case "bigkey":
 		if output == "" {
-			err = helper.FindBiggestKeys(src, n, os.Stdout, options...)
+			output = "-"
+		}
+		err = runWithStdio(stdin, src, output, func(input io.Reader, output io.Writer) error {
+			return helper.FindBiggestKeysFromReader(input, n, output, options...)
+		})
+	case "import":
+		if to == "" {
+			println("-to is required")
+			return
+		}
+		var result *helper.ImportResult
+		options = append(options, helper.WithImportOption(concurrency, pipeline, rate, retries))
+		if cluster {
+			options = append(options, helper.WithClusterOption())
+		}
+		if src == "-" {
+			result, err = helper.ImportFromReader(stdin, to, options...)
+		} else {
+			result, err = helper.ImportRDB(src, to, options...)
+		}
+		printImportResult(result)
+	case "filter":
+		err = helper.FilterRDB(src, output, options...)
+	case "merge":
+		var conflicts []*helper.MergeConflict
+		conflicts, err = helper.MergeRDB(flagSet.Args(), output,
+			append(options, helper.WithConflictPolicyOption(conflictPolicy))...)
+		for i := 0; err == nil && i < len(conflicts); i++ {
+			conflict := conflicts[i]
+			fmt.Printf("conflict: db %d key %s in %s, kept %s\n", conflict.DB, conflict.Key,
+				strings.Join(conflict.Files, ","), conflict.Winner)
+		}
+	case "split":
+		var stats []*helper.SplitStat
+		report := &helper.SplitReport{}
+		options = append(options, helper.WithSplitReportOption(report))
+		if skipOtherDBs {
+			options = append(options, helper.WithSkipOtherDBsOption())
+		}
+		stats, err = splitRDB(src, output, slotMap, shards, options...)
+		for _, stat := range stats {
+			fmt.Printf("%s: %d keys, %s, %s\n", stat.Name, stat.KeyCount,
+				bytefmt.FormatSize(uint64(stat.Bytes)), stat.Filename)
+		}
+		printSplitReport(report)
+	case "rewrite":
+		if rulesFile == "" {
+			println("rules file is required")
+			return
+		}
+		var plan *helper.RewritePlan
+		if dryRun {
+			plan, err = helper.PlanRewrite(src, options...)
 		} else {
-			var outputFile *os.File
-			outputFile, err = os.Create(output)
-			if err != nil {
-				fmt.Printf("open output faild: %v", err)
+			plan, err = helper.RewriteRDB(src, output, options...)
+		}
+		printRewritePlan(plan, dryRun)
+	case "downgrade":
+		if targetVersion == 0 {
+			println("target version is required")
+			return
+		}
+		if strict {
+			options = append(options, helper.WithStrictOption())
+		}
+		var issues []*helper.DowngradeIssue
+		issues, err = helper.DowngradeRDB(src, output, targetVersion, options...)
+		for _, issue := range issues {
+			if issue.Key == "" {
+				fmt.Printf("dropped %s: %s\n", issue.Type, issue.Reason)
+			} else {
+				fmt.Printf("dropped %s %s of db %d: %s\n", issue.Type, issue.Key, issue.DB, issue.Reason)
 			}
-			defer func() {
-				_ = outputFile.Close()
-			}()
-			err = helper.FindBiggestKeys(src, n, outputFile, options...)
+		}
+	case "dump-decode":
+		err = helper.DecodeDumpFile(src, output)
+	case "pitr":
+		err = pointInTimeRDB(flagSet.Args(), output, untilStr, options...)
+	case "compat":
+		var report *helper.CompatReport
+		report, err = helper.CheckCompat(src, redisVersion)
+		if err == nil {
+			printCompatReport(report)
 		}
 	case "flamegraph":
+		if output != "" {
+			err = runWithStdio(stdin, src, output, func(input io.Reader, output io.Writer) error {
+				return helper.FlameGraphFromReader(input, output, seps, options...)
+			})
+			break
+		}
+		if src == "-" {
+			println("-o is required to draw flamegraph of stdin")
+			return
+		}
 		_, err = helper.FlameGraph(src, port, seps, options...)
-		<-make(chan struct{})
+		if err == nil {
+			<-make(chan struct{})
+		}
+	case "serve":
+		err = serveSnapshot(src, port)
 	default:
 		println("unknown command")
 		return
@@ -108,4 +873,18 @@ func main() {
 		fmt.Printf("error: %v\n", err)
 		return
 	}
+	if skipReport != nil {
+		if output == "-" {
+			printSkipReport(os.Stderr, skipReport)
+		} else {
+			printSkipReport(os.Stdout, skipReport)
+		}
+	}
+	if sampleReport != nil && (cmd == "memory" || cmd == "bigkey") {
+		if output == "-" {
+			printSampleReport(os.Stderr, sampleReport) // keep stdout clean for output
+		} else {
+			printSampleReport(os.Stdout, sampleReport)
+		}
+	}
 }
@@ -1,7 +1,11 @@
 package main
 
 import (
+	"bufio"
+	"fmt"
+	"net"
 	"os"
+	"strings"
 	"testing"
 )
 
@@ -47,15 +51,197 @@ func TestCmd(t *testing.T) {
 		t.Error("command memory failed")
 	}
 
+	os.Args = []string{"", "-c", "filter", "-o", "tmp/filter.rdb", "-db", "0", "-type", "string",
+		"-min-size", "1KB", "-max-size", "4096", "-expire", "persistent", "cases/memory.rdb"}
+	main()
+	if f, _ := os.Stat("tmp/filter.rdb"); f == nil {
+		t.Error("command filter failed")
+	}
+
+	os.Args = []string{"", "-c", "merge", "-o", "tmp/merge.rdb", "-conflict", "last-wins",
+		"cases/memory.rdb", "cases/multiple_databases.rdb", "cases/memory.rdb"}
+	main()
+	if f, _ := os.Stat("tmp/merge.rdb"); f == nil {
+		t.Error("command merge failed")
+	}
+
+	os.Args = []string{"", "-c", "split", "-o", "tmp/split", "-shards", "2", "-skip-other-db",
+		"cases/multiple_databases.rdb"}
+	main()
+	if f, _ := os.Stat("tmp/split/shard-1.rdb"); f == nil {
+		t.Error("command split failed")
+	}
+	os.Args = []string{"", "-c", "split", "-o", "tmp/split", "-shards", "2", "cases/memory.rdb"}
+	main()
+	if f, _ := os.Stat("tmp/split/shard-1.rdb"); f == nil {
+		t.Error("command split failed")
+	}
+	err = os.WriteFile("tmp/slots.txt", []byte("node-a 0-8191\nnode-b 8192-16383\n"), 0644)
+	if err != nil {
+		t.Error(err)
+	}
+	os.Args = []string{"", "-c", "split", "-o", "tmp/split", "-slots", "tmp/slots.txt", "cases/memory.rdb"}
+	main()
+	if f, _ := os.Stat("tmp/split/node-b.rdb"); f == nil {
+		t.Error("command split failed")
+	}
+
+	err = os.WriteFile("tmp/rules.txt", []byte("add-prefix t1:\nmove-db 0 1\n"), 0644)
+	if err != nil {
+		t.Error(err)
+	}
+	os.Args = []string{"", "-c", "rewrite", "-o", "tmp/rewrite.rdb", "-rules", "tmp/rules.txt", "cases/memory.rdb"}
+	main()
+	if f, _ := os.Stat("tmp/rewrite.rdb"); f == nil {
+		t.Error("command rewrite failed")
+	}
+	os.Args = []string{"", "-c", "rewrite", "-dry-run", "-rules", "tmp/rules.txt", "cases/memory.rdb"}
+	main()
+	os.Args = []string{"", "-c", "aof", "-o", "tmp/rewrite.aof", "-rules", "tmp/rules.txt", "cases/memory.rdb"}
+	main()
+	if f, _ := os.Stat("tmp/rewrite.aof"); f == nil {
+		t.Error("command aof with rules failed")
+	}
+
+	os.Args = []string{"", "-c", "filter", "-o", "tmp/expiration.rdb", "-rebase-ttl", "-drop-expired",
+		"-ttl-jitter", "10m", "-max-ttl", "720h", "-default-ttl", "^s$=24h", "cases/memory.rdb"}
+	main()
+	if f, _ := os.Stat("tmp/expiration.rdb"); f == nil {
+		t.Error("command filter with expiration options failed")
+	}
+
+	err = os.WriteFile("tmp/anonymize.txt", []byte("fake ^(.*)$\n"), 0644)
+	if err != nil {
+		t.Error(err)
+	}
+	os.Args = []string{"", "-c", "json", "-o", "tmp/anonymize.json", "-anonymize", "tmp/anonymize.txt",
+		"-secret", "secret", "cases/memory.rdb"}
+	main()
+	if f, _ := os.Stat("tmp/anonymize.json"); f == nil {
+		t.Error("command json with anonymizer failed")
+	}
+
+	os.Args = []string{"", "-c", "memory", "-o", "tmp/memory_sample.csv", "-sample", "0.5", "-sample-seed", "1",
+		"cases/memory.rdb"}
+	main()
+	if f, _ := os.Stat("tmp/memory_sample.csv"); f == nil {
+		t.Error("command memory with sampling failed")
+	}
+	os.Args = []string{"", "-c", "bigkey", "-n", "2", "-reservoir", "3", "cases/memory.rdb"}
+	main()
+
+	os.Args = []string{"", "-c", "downgrade", "-target-version", "7", "-o", "tmp/downgrade.rdb", "cases/memory.rdb"}
+	main()
+	if f, _ := os.Stat("tmp/downgrade.rdb"); f == nil {
+		t.Error("command downgrade failed")
+	}
+	os.Args = []string{"", "-c", "compat", "-redis", "3.2", "cases/memory.rdb"}
+	main()
+	os.Args = []string{"", "-c", "compat", "tmp/downgrade.rdb"}
+	main()
+	os.Args = []string{"", "-c", "aof", "-restore", "-replace", "-o", "tmp/restore.aof", "cases/memory.rdb"}
+	main()
+	if f, _ := os.Stat("tmp/restore.aof"); f == nil {
+		t.Error("command aof with restore failed")
+	}
+	os.Args = []string{"", "-c", "aof", "-batch", "2", "-batch-size", "1KB", "-multi", "-o", "tmp/batch.aof",
+		"cases/memory.rdb"}
+	main()
+	if f, _ := os.Stat("tmp/batch.aof"); f == nil {
+		t.Error("command aof with batch failed")
+	}
+	os.Args = []string{"", "-c", "aof", "-multipart", "-resp-base", "-o", "tmp/appendonlydir", "cases/memory.rdb"}
+	main()
+	if f, _ := os.Stat("tmp/appendonlydir/appendonly.aof.manifest"); f == nil {
+		t.Error("command aof with multipart failed")
+	}
+	os.Args = []string{"", "-c", "memory", "-o", "tmp/appendonlydir.csv", "tmp/appendonlydir"}
+	main()
+	if f, _ := os.Stat("tmp/appendonlydir.csv"); f == nil {
+		t.Error("command memory of aof directory failed")
+	}
+	err = os.WriteFile("tmp/unknown.aof", []byte("*2\r\n$5\r\nPFADD\r\n$1\r\nh\r\n*3\r\n$3\r\nSET\r\n$1\r\na\r\n$1\r\n1\r\n"), 0644)
+	if err != nil {
+		t.Error(err)
+	}
+	os.Args = []string{"", "-c", "json", "-skip-unknown", "-o", "tmp/unknown.json", "tmp/unknown.aof"}
+	main()
+	if f, _ := os.Stat("tmp/unknown.json"); f == nil {
+		t.Error("command json of aof with unknown commands failed")
+	}
+	os.Args = []string{"", "-c", "pitr", "-until", "2026-10-01T12:00:00Z", "-o", "tmp/pitr.rdb", "tmp/appendonlydir"}
+	main()
+	if f, _ := os.Stat("tmp/pitr.rdb"); f == nil {
+		t.Error("command pitr failed")
+	}
+	os.Args = []string{"", "-c", "bigkey", "-o", "tmp/bigkey.csv.gz", "-n", "3", "cases/memory.rdb"}
+	main()
+	if f, _ := os.Stat("tmp/bigkey.csv.gz"); f == nil {
+		t.Error("command bigkey with compressed output failed")
+	}
+	stdin := os.Stdin
+	os.Stdin, err = os.Open("cases/memory.rdb")
+	if err != nil {
+		t.Error(err)
+	}
+	os.Args = []string{"", "-c", "json", "-o", "tmp/stdin.json", "-"}
+	main()
+	_ = os.Stdin.Close()
+	os.Stdin = stdin
+	if f, _ := os.Stat("tmp/stdin.json"); f == nil {
+		t.Error("command json from stdin failed")
+	}
+	os.Args = []string{"", "-c", "json", "-workers", "0", "-o", "tmp/parallel.json", "cases/memory.rdb"}
+	main()
+	if f, _ := os.Stat("tmp/parallel.json"); f == nil {
+		t.Error("command json with workers failed")
+	}
+	os.Args = []string{"", "-c", "flamegraph", "-o", "tmp/flamegraph.html", "cases/memory.rdb"}
+	main()
+	if f, _ := os.Stat("tmp/flamegraph.html"); f == nil {
+		t.Error("command flamegraph with output failed")
+	}
+	err = os.WriteFile("tmp/payloads.txt", []byte("mykey 00c00a0900be6d06895a28000a\n"), 0644)
+	if err != nil {
+		t.Error(err)
+	}
+	os.Args = []string{"", "-c", "dump-decode", "-o", "tmp/payloads.json", "tmp/payloads.txt"}
+	main()
+	if f, _ := os.Stat("tmp/payloads.json"); f == nil {
+		t.Error("command dump-decode failed")
+	}
+
 	// test error command line
 	os.Args = []string{"", "-c", "json", "-o", "tmp/output", "/none/a"}
 	main()
 	os.Args = []string{"", "-c", "aof", "-o", "tmp/output", "/none/a"}
 	main()
+	os.Args = []string{"", "-c", "filter", "-o", "tmp/output", "/none/a"}
+	main()
+	os.Args = []string{"", "-c", "json", "-anonymize", "/none/a", "-o", "tmp/output", "cases/memory.rdb"}
+	main()
+	os.Args = []string{"", "-c", "filter", "-drop-expired", "-ref-time", "yesterday", "-o", "tmp/output", "cases/memory.rdb"}
+	main()
+	os.Args = []string{"", "-c", "filter", "-default-ttl", "^s$", "-o", "tmp/output", "cases/memory.rdb"}
+	main()
+	os.Args = []string{"", "-c", "rewrite", "-o", "tmp/output", "cases/memory.rdb"}
+	main()
+	os.Args = []string{"", "-c", "rewrite", "-rules", "/none/a", "-o", "tmp/output", "cases/memory.rdb"}
+	main()
+	os.Args = []string{"", "-c", "split", "-o", "tmp/split", "-slots", "/none/a", "cases/memory.rdb"}
+	main()
+	os.Args = []string{"", "-c", "filter", "-min-size", "1XB", "-o", "tmp/output", "cases/memory.rdb"}
+	main()
 	os.Args = []string{"", "-c", "memory", "-o", "tmp/output", "/none/a"}
 	main()
+	os.Args = []string{"", "-c", "downgrade", "-o", "tmp/output", "cases/memory.rdb"}
+	main()
+	os.Args = []string{"", "-c", "compat", "/none/a"}
+	main()
 	os.Args = []string{"", "-c", "bigkey", "-o", "tmp/output", "/none/a"}
 	main()
+	os.Args = []string{"", "-c", "serve", "/none/a"}
+	main()
 
 	os.Args = []string{"", "-c", "bigkey", "-o", "/none/a", "-n", "10", "cases/memory.rdb"}
 	main()
@@ -75,3 +261,114 @@ func TestCmd(t *testing.T) {
 	os.Args = []string{"", "-c", "aof"}
 	main()
 }
+
+func TestCmdFrom(t *testing.T) {
+	err := os.MkdirAll("tmp", os.ModePerm)
+	if err != nil {
+		return
+	}
+	defer func() {
+		err := os.RemoveAll("tmp")
+		if err != nil {
+			t.Logf("remove tmp directory failed: %v", err)
+		}
+	}()
+	rdb, err := os.ReadFile("cases/memory.rdb")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	// a fake master replies commands by name, arguments are ignored
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer func() {
+		_ = listener.Close()
+	}()
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer func() {
+			_ = conn.Close()
+		}()
+		reader := bufio.NewReader(conn)
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			switch strings.TrimSpace(line) {
+			case "REPLCONF":
+				_, _ = conn.Write([]byte("+OK\r\n"))
+			case "PSYNC":
+				_, _ = fmt.Fprintf(conn, "+FULLRESYNC 8de1787ba490483314a4d30f1c628bc5025eb761 0\r\n$%d\r\n%s", len(rdb), rdb)
+			}
+		}
+	}()
+	os.Args = []string{"", "-c", "json", "-from", "redis://" + listener.Addr().String(), "-o", "tmp/from.json"}
+	main()
+	actual, err := os.ReadFile("tmp/from.json")
+	if err != nil {
+		t.Error("command json from redis failed")
+		return
+	}
+	os.Args = []string{"", "-c", "json", "-o", "tmp/file.json", "cases/memory.rdb"}
+	main()
+	expect, err := os.ReadFile("tmp/file.json")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if string(actual) != string(expect) {
+		t.Error("wrong json of snapshot")
+	}
+}
+
+func TestCmdImport(t *testing.T) {
+	// a fake redis replies +OK to every command and counts them
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer func() {
+		_ = listener.Close()
+	}()
+	commands := make(chan int, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer func() {
+			_ = conn.Close()
+		}()
+		reader := bufio.NewReader(conn)
+		count := 0
+		defer func() {
+			commands <- count
+		}()
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			if strings.HasPrefix(line, "*") {
+				count++
+				_, _ = conn.Write([]byte("+OK\r\n"))
+			}
+		}
+	}()
+	os.Args = []string{"", "-c", "import", "-to", "redis://" + listener.Addr().String(), "-pipeline", "10",
+		"cases/memory.rdb"}
+	main()
+	if count := <-commands; count == 0 {
+		t.Error("nothing imported")
+	}
+	os.Args = []string{"", "-c", "import", "cases/memory.rdb"}
+	main()
+}
@@ -0,0 +1,75 @@
+package core
+
+import (
+	"strconv"
+)
+
+// arenaChunkSize is size of chunks allocated by arena, larger slices are allocated from heap and never reused
+const arenaChunkSize = 64 * 1024
+
+// arena allocates byte slices from chunks which are reused after reset
+type arena struct {
+	chunks [][]byte
+	index  int // index of chunk allocating from
+	used   int // allocated bytes of current chunk
+}
+
+// alloc returns a byte slice of length n, it is valid until reset
+func (a *arena) alloc(n int) []byte {
+	if n > arenaChunkSize {
+		return make([]byte, n)
+	}
+	for a.index < len(a.chunks) && a.used+n > arenaChunkSize {
+		a.index++
+		a.used = 0
+	}
+	if a.index == len(a.chunks) {
+		a.chunks = append(a.chunks, make([]byte, arenaChunkSize))
+	}
+	// limit capacity, so that appending to the slice never overwrites next one
+	b := a.chunks[a.index][a.used : a.used+n : a.used+n]
+	a.used += n
+	return b
+}
+
+// reset releases all slices allocated by arena, chunks are kept for reuse
+func (a *arena) reset() {
+	a.index = 0
+	a.used = 0
+}
+
+// WithBufferReuse makes decoder allocate strings, ziplist and listpack entries and formatted integers from an arena
+// which is reused for every key, to reduce allocations. Byte slices and strings of objects passed to callback of Parse,
+// including keys, are valid only until the callback returns, copy them if they are kept after that.
+// It is ignored during parallel parsing, see WithWorkers
+func (dec *Decoder) WithBufferReuse() *Decoder {
+	dec.arena = &arena{}
+	return dec
+}
+
+// WithRawIntegers makes decoder report integer-encoded string values by IsInt and IntValue of model.StringObject
+// without formatting them, Value of the object is nil then. Bytes of model.StringObject returns value in either case
+func (dec *Decoder) WithRawIntegers() *Decoder {
+	dec.rawIntegers = true
+	return dec
+}
+
+// alloc returns a byte slice of length n, it is allocated from arena if buffer reuse is enabled
+func (dec *Decoder) alloc(n int) []byte {
+	if dec.arena == nil {
+		return make([]byte, n)
+	}
+	return dec.arena.alloc(n)
+}
+
+// formatInt formats integer as decimal string, it is allocated from arena if buffer reuse is enabled
+func (dec *Decoder) formatInt(val int64) []byte {
+	if dec.arena == nil {
+		return strconv.AppendInt(nil, val, 10)
+	}
+	var buf [20]byte
+	s := strconv.AppendInt(buf[:0], val, 10)
+	b := dec.arena.alloc(len(s))
+	copy(b, s)
+	return b
+}
@@ -0,0 +1,162 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/hdt3213/rdb/model"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBufferReuse(t *testing.T) {
+	filenames, err := filepath.Glob(filepath.Join("..", "cases", "*.rdb"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	parse := func(dec *Decoder) (string, error) {
+		var result []string
+		err := dec.WithSpecialOpCode().Parse(func(object model.RedisObject) bool {
+			data, err := json.Marshal(object)
+			if err != nil {
+				t.Error(err)
+			}
+			result = append(result, string(data))
+			return true
+		})
+		return strings.Join(result, "\n"), err
+	}
+	for _, filename := range filenames {
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		expect, err := parse(NewDecoder(bytes.NewReader(data)))
+		if err != nil {
+			t.Errorf("%s: %v", filename, err)
+			continue
+		}
+		actual, err := parse(NewDecoder(bytes.NewReader(data)).WithBufferReuse().WithRawIntegers())
+		if err != nil {
+			t.Errorf("%s: %v", filename, err)
+			continue
+		}
+		if actual != expect {
+			t.Errorf("%s: wrong result with buffer reuse", filename)
+		}
+		actual, err = parse(NewDecoder(bytes.NewReader(data)).WithBufferReuse().WithWorkers(2))
+		if err != nil {
+			t.Errorf("%s: %v", filename, err)
+			continue
+		}
+		if actual != expect {
+			t.Errorf("%s: wrong result with buffer reuse and workers", filename)
+		}
+	}
+}
+
+func TestRawIntegers(t *testing.T) {
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	errs := []error{
+		enc.WriteHeader(),
+		enc.WriteDBHeader(0, 2, 0),
+		enc.WriteStringObject("int", []byte("-12345")),
+		enc.WriteStringObject("str", []byte("hello")),
+		enc.WriteEnd(),
+	}
+	for _, err := range errs {
+		if err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	data := buf.Bytes()
+	for _, workers := range []int{1, 2} {
+		var objects []*model.StringObject
+		err := NewDecoder(bytes.NewReader(data)).WithRawIntegers().WithWorkers(workers).Parse(func(object model.RedisObject) bool {
+			objects = append(objects, object.(*model.StringObject))
+			return true
+		})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if len(objects) != 2 {
+			t.Errorf("expect 2 objects with %d workers, actual %d", workers, len(objects))
+			return
+		}
+		if !objects[0].IsInt || objects[0].IntValue != -12345 || objects[0].Value != nil {
+			t.Errorf("wrong integer with %d workers: %+v", workers, objects[0])
+		}
+		if objects[1].IsInt || string(objects[1].Value) != "hello" {
+			t.Errorf("wrong string with %d workers: %+v", workers, objects[1])
+		}
+	}
+	// integers decoded raw are written back as they are
+	var objects []model.RedisObject
+	err := NewDecoder(bytes.NewReader(data)).WithRawIntegers().Parse(func(object model.RedisObject) bool {
+		objects = append(objects, object)
+		return true
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	buf.Reset()
+	enc = NewEncoder(&buf)
+	err = enc.WriteHeader()
+	if err == nil {
+		err = enc.WriteDBHeader(0, 2, 0)
+	}
+	for _, object := range objects {
+		if err == nil {
+			err = enc.WriteObject(object)
+		}
+	}
+	if err == nil {
+		err = enc.WriteEnd()
+	}
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	var values []string
+	err = NewDecoder(bytes.NewReader(buf.Bytes())).Parse(func(object model.RedisObject) bool {
+		values = append(values, string(object.(*model.StringObject).Value))
+		return true
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if strings.Join(values, ",") != "-12345,hello" {
+		t.Errorf("wrong values after round trip: %q", values)
+	}
+}
+
+func TestArena(t *testing.T) {
+	a := &arena{}
+	b1 := a.alloc(10)
+	b2 := a.alloc(arenaChunkSize - 5)
+	if len(a.chunks) != 2 {
+		t.Errorf("expect 2 chunks, actual %d", len(a.chunks))
+	}
+	b1 = append(b1, 'x')
+	if len(b2) != arenaChunkSize-5 || b2[0] != 0 {
+		t.Error("append should not overwrite next slice")
+	}
+	big := a.alloc(arenaChunkSize + 1)
+	if len(big) != arenaChunkSize+1 || len(a.chunks) != 2 {
+		t.Error("large slice should not be allocated from chunks")
+	}
+	a.reset()
+	a.alloc(1)
+	a.alloc(arenaChunkSize)
+	if len(a.chunks) != 2 {
+		t.Errorf("chunks should be reused, actual %d chunks", len(a.chunks))
+	}
+}
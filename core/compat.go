@@ -0,0 +1,127 @@
+package core
+
+import (
+	"strconv"
+	"strings"
+)
+
+type codeInfo struct {
+	name         string
+	redisVersion string // minimal redis version which could load it
+}
+
+var codeInfos = map[byte]*codeInfo{
+	typeString:              {name: "RDB_TYPE_STRING", redisVersion: "1.0"},
+	typeList:                {name: "RDB_TYPE_LIST", redisVersion: "1.0"},
+	typeSet:                 {name: "RDB_TYPE_SET", redisVersion: "1.0"},
+	typeZset:                {name: "RDB_TYPE_ZSET", redisVersion: "1.2"},
+	typeHash:                {name: "RDB_TYPE_HASH", redisVersion: "2.0"},
+	typeZset2:               {name: "RDB_TYPE_ZSET_2", redisVersion: "4.0"},
+	typeModule:              {name: "RDB_TYPE_MODULE", redisVersion: "4.0"},
+	typeModule2:             {name: "RDB_TYPE_MODULE_2", redisVersion: "4.0"},
+	typeHashZipMap:          {name: "RDB_TYPE_HASH_ZIPMAP", redisVersion: "2.2"},
+	typeListZipList:         {name: "RDB_TYPE_LIST_ZIPLIST", redisVersion: "2.6"},
+	typeSetIntSet:           {name: "RDB_TYPE_SET_INTSET", redisVersion: "2.6"},
+	typeZsetZipList:         {name: "RDB_TYPE_ZSET_ZIPLIST", redisVersion: "2.6"},
+	typeHashZipList:         {name: "RDB_TYPE_HASH_ZIPLIST", redisVersion: "2.6"},
+	typeListQuickList:       {name: "RDB_TYPE_LIST_QUICKLIST", redisVersion: "3.2"},
+	typeStreamListPacks:     {name: "RDB_TYPE_STREAM_LISTPACKS", redisVersion: "5.0"},
+	typeHashListPack:        {name: "RDB_TYPE_HASH_LISTPACK", redisVersion: "7.0"},
+	typeZsetListPack:        {name: "RDB_TYPE_ZSET_LISTPACK", redisVersion: "7.0"},
+	typeListQuickList2:      {name: "RDB_TYPE_LIST_QUICKLIST_2", redisVersion: "7.0"},
+	typeStreamListPacks2:    {name: "RDB_TYPE_STREAM_LISTPACKS_2", redisVersion: "7.0"},
+	typeSetListPack:         {name: "RDB_TYPE_SET_LISTPACK", redisVersion: "7.2"},
+	typeStreamListPacks3:    {name: "RDB_TYPE_STREAM_LISTPACKS_3", redisVersion: "7.2"},
+	typeHashMetadataPreGA:   {name: "RDB_TYPE_HASH_METADATA_PRE_GA", redisVersion: "7.4"},
+	typeHashListPackExPreGA: {name: "RDB_TYPE_HASH_LISTPACK_EX_PRE_GA", redisVersion: "7.4"},
+	typeHashMetadata:        {name: "RDB_TYPE_HASH_METADATA", redisVersion: "7.4"},
+	typeHashListPackEx:      {name: "RDB_TYPE_HASH_LISTPACK_EX", redisVersion: "7.4"},
+	opCodeFunction2:         {name: "RDB_OPCODE_FUNCTION2", redisVersion: "7.0"},
+	opCodeFunction:          {name: "RDB_OPCODE_FUNCTION_PRE_GA", redisVersion: "7.0-rc1"},
+	opCodeModuleAux:         {name: "RDB_OPCODE_MODULE_AUX", redisVersion: "5.0"},
+	opCodeIdle:              {name: "RDB_OPCODE_IDLE", redisVersion: "5.0"},
+	opCodeFreq:              {name: "RDB_OPCODE_FREQ", redisVersion: "5.0"},
+	opCodeAux:               {name: "RDB_OPCODE_AUX", redisVersion: "3.2"},
+	opCodeResizeDB:          {name: "RDB_OPCODE_RESIZEDB", redisVersion: "3.2"},
+	opCodeExpireTimeMs:      {name: "RDB_OPCODE_EXPIRETIME_MS", redisVersion: "2.6"},
+	opCodeExpireTime:        {name: "RDB_OPCODE_EXPIRETIME", redisVersion: "1.0"},
+	opCodeSelectDB:          {name: "RDB_OPCODE_SELECTDB", redisVersion: "1.0"},
+	opCodeEOF:               {name: "RDB_OPCODE_EOF", redisVersion: "1.0"},
+}
+
+// CodeName returns name of an opcode or type flag in redis source code, such as RDB_TYPE_HASH_LISTPACK,
+// and the minimal redis version which could load it. ok is false if code is unknown
+func CodeName(code byte) (name string, redisVersion string, ok bool) {
+	info := codeInfos[code]
+	if info == nil {
+		return "", "", false
+	}
+	return info.name, info.redisVersion, true
+}
+
+// IsPreReleaseCode returns whether code is written only by pre-release redis, so that no released redis could load it
+func IsPreReleaseCode(code byte) bool {
+	return code == opCodeFunction
+}
+
+// IsTypeCode returns whether code is a type flag of key rather than an opcode
+func IsTypeCode(code byte) bool {
+	return code < opCodeFunction2
+}
+
+// rdbVersionRedis is the first redis version writing each rdb version, redis refuses rdb of higher version than its own
+var rdbVersionRedis = []string{
+	1:  "1.0",
+	2:  "2.4",
+	3:  "2.6",
+	4:  "2.6",
+	5:  "2.6",
+	6:  "2.6",
+	7:  "3.2",
+	8:  "4.0",
+	9:  "5.0",
+	10: "7.0",
+	11: "7.2",
+	12: "7.4",
+}
+
+// RedisVersionOfRDB returns the minimal redis version which could load rdb of the given version
+func RedisVersionOfRDB(rdbVersion int) string {
+	if rdbVersion <= 0 || rdbVersion >= len(rdbVersionRedis) {
+		return ""
+	}
+	return rdbVersionRedis[rdbVersion]
+}
+
+// RDBVersionOfRedis returns the highest rdb version the given redis version could load, such as 9 for "6.2"
+func RDBVersionOfRedis(redisVersion string) int {
+	result := 0
+	for version := 1; version < len(rdbVersionRedis); version++ {
+		if CompareRedisVersion(rdbVersionRedis[version], redisVersion) <= 0 {
+			result = version
+		}
+	}
+	return result
+}
+
+// CompareRedisVersion compares versions like "6.2" and "7.0.11" numerically, returns -1 if a < b, 1 if a > b,
+// 0 if equal. Missing parts are treated as 0
+func CompareRedisVersion(a, b string) int {
+	partsA := strings.Split(a, ".")
+	partsB := strings.Split(b, ".")
+	for i := 0; i < len(partsA) || i < len(partsB); i++ {
+		var x, y int
+		if i < len(partsA) {
+			x, _ = strconv.Atoi(partsA[i])
+		}
+		if i < len(partsB) {
+			y, _ = strconv.Atoi(partsB[i])
+		}
+		if x < y {
+			return -1
+		} else if x > y {
+			return 1
+		}
+	}
+	return 0
+}
@@ -0,0 +1,43 @@
+package core
+
+import "testing"
+
+func TestRedisVersion(t *testing.T) {
+	compareCases := []struct {
+		a, b   string
+		expect int
+	}{
+		{"6.2", "6.2.0", 0},
+		{"6.2", "7.0", -1},
+		{"7.0.11", "7.0", 1},
+		{"10.0", "9.9", 1},
+	}
+	for _, c := range compareCases {
+		if actual := CompareRedisVersion(c.a, c.b); actual != c.expect {
+			t.Errorf("compare %s with %s: expect %d, actual %d", c.a, c.b, c.expect, actual)
+		}
+	}
+	rdbCases := map[string]int{
+		"3.2":   7,
+		"4.0.9": 8,
+		"6.2":   9,
+		"7.0":   10,
+		"7.2.4": 11,
+		"8.0":   12,
+		"1.0":   1,
+	}
+	for redisVersion, expect := range rdbCases {
+		if actual := RDBVersionOfRedis(redisVersion); actual != expect {
+			t.Errorf("rdb version of redis %s: expect %d, actual %d", redisVersion, expect, actual)
+		}
+	}
+	if RedisVersionOfRDB(9) != "5.0" || RedisVersionOfRDB(13) != "" {
+		t.Error("wrong redis version of rdb")
+	}
+	if name, version, ok := CodeName(typeHashListPack); !ok || name != "RDB_TYPE_HASH_LISTPACK" || version != "7.0" {
+		t.Error("wrong code name")
+	}
+	if !IsTypeCode(typeString) || IsTypeCode(opCodeAux) {
+		t.Error("wrong type code")
+	}
+}
@@ -18,8 +18,19 @@ type Decoder struct {
 	input     *bufio.Reader
 	readCount int
 	buffer    []byte
+	capturing bool
+	captured  []byte // raw bytes read during capturing
 
 	withSpecialOpCode bool
+	version           int             // rdb version in header
+	codeCallback      func(code byte) // receives every opcode and type flag
+	workers           int             // number of goroutines decoding values, see WithWorkers
+	arena             *arena          // reused buffer of byte slices, see WithBufferReuse
+	scratch           *arena          // buffer of values dropped during size-only parsing
+	rawIntegers       bool
+	sizeOnly          bool
+	// frameCallback receives keys whose values are read but not decoded during parallel parsing
+	frameCallback func(flag byte, base *model.BaseObject, raw []byte) bool
 }
 
 // NewDecoder creates a new RDB decoder
@@ -30,20 +41,47 @@ func NewDecoder(reader io.Reader) *Decoder {
 	return parser
 }
 
-// WithSpecialOpCode enables returning model.AuxObject to callback
+// WithSpecialOpCode enables returning model.AuxObject, model.DBSizeObject, model.FunctionsObject
+// and model.ModuleAuxObject to callback
 func (dec *Decoder) WithSpecialOpCode() *Decoder {
 	dec.withSpecialOpCode = true
 	return dec
 }
 
+// WithCodeCallback sets a callback receiving every opcode and type flag read from rdb, see CodeName.
+// Type flag of a key is received before the key is passed to callback of Parse
+func (dec *Decoder) WithCodeCallback(cb func(code byte)) *Decoder {
+	dec.codeCallback = cb
+	return dec
+}
+
+// Version returns rdb version in header, it is available after header has been parsed
+func (dec *Decoder) Version() int {
+	return dec.version
+}
+
+// SpecialOpCodeEnabled returns whether special opcodes are returned to callback
+func (dec *Decoder) SpecialOpCodeEnabled() bool {
+	return dec.withSpecialOpCode
+}
+
+// Offset returns number of bytes read from input. In callback of Parse, it is the end of the key just decoded,
+// whose type flag begins at Offset() - GetSize() - 1, see DecodeObject
+func (dec *Decoder) Offset() int {
+	return dec.readCount
+}
+
 var magicNumber = []byte("REDIS")
 
 const (
 	minVersion = 1
-	maxVersion = 9
+	maxVersion = 12
 )
 
 const (
+	opCodeFunction2    = 245 /* function library data */
+	opCodeFunction     = 246 /* old function library data for 7.0 rc1 and rc2 */
+	opCodeModuleAux    = 247 /* Module auxiliary data. */
 	opCodeIdle         = 248 /* LRU idle time. */
 	opCodeFreq         = 249 /* LFU frequency. */
 	opCodeAux          = 250 /* RDB aux field. */
@@ -71,6 +109,16 @@ const (
 	typeHashZipList
 	typeListQuickList
 	typeStreamListPacks
+	typeHashListPack
+	typeZsetListPack
+	typeListQuickList2
+	typeStreamListPacks2
+	typeSetListPack
+	typeStreamListPacks3
+	typeHashMetadataPreGA   // hash with field expiration of pre-release redis 7.4
+	typeHashListPackExPreGA // listpack hash with field expiration of pre-release redis 7.4
+	typeHashMetadata        // hash with field expiration
+	typeHashListPackEx      // listpack hash with field expiration
 )
 
 // checkHeader checks whether input has valid RDB file header
@@ -93,12 +141,25 @@ func (dec *Decoder) checkHeader() error {
 	if version < minVersion || version > maxVersion {
 		return fmt.Errorf("cannot parse version: %d", version)
 	}
+	dec.version = version
 	return nil
 }
 
 func (dec *Decoder) readObject(flag byte, base *model.BaseObject) (model.RedisObject, error) {
 	switch flag {
 	case typeString:
+		if dec.rawIntegers {
+			bs, val, isInt, err := dec.readStringOrInt()
+			if err != nil {
+				return nil, err
+			}
+			return &model.StringObject{
+				BaseObject: base,
+				Value:      bs,
+				IsInt:      isInt,
+				IntValue:   val,
+			}, nil
+		}
 		bs, err := dec.readString()
 		if err != nil {
 			return nil, err
@@ -108,6 +169,7 @@ func (dec *Decoder) readObject(flag byte, base *model.BaseObject) (model.RedisOb
 			Value:      bs,
 		}, nil
 	case typeList:
+		base.Encoding = model.LinkedListEncoding
 		list, err := dec.readList()
 		if err != nil {
 			return nil, err
@@ -117,6 +179,7 @@ func (dec *Decoder) readObject(flag byte, base *model.BaseObject) (model.RedisOb
 			Values:     list,
 		}, nil
 	case typeSet:
+		base.Encoding = model.HashTableEncoding
 		set, err := dec.readSet()
 		if err != nil {
 			return nil, err
@@ -126,6 +189,7 @@ func (dec *Decoder) readObject(flag byte, base *model.BaseObject) (model.RedisOb
 			Members:    set,
 		}, nil
 	case typeSetIntSet:
+		base.Encoding = model.IntSetEncoding
 		set, err := dec.readIntSet()
 		if err != nil {
 			return nil, err
@@ -134,7 +198,18 @@ func (dec *Decoder) readObject(flag byte, base *model.BaseObject) (model.RedisOb
 			BaseObject: base,
 			Members:    set,
 		}, nil
+	case typeSetListPack:
+		base.Encoding = model.ListPackEncoding
+		set, err := dec.readListPack()
+		if err != nil {
+			return nil, err
+		}
+		return &model.SetObject{
+			BaseObject: base,
+			Members:    set,
+		}, nil
 	case typeHash:
+		base.Encoding = model.HashTableEncoding
 		hash, err := dec.readHashMap()
 		if err != nil {
 			return nil, err
@@ -144,6 +219,7 @@ func (dec *Decoder) readObject(flag byte, base *model.BaseObject) (model.RedisOb
 			Hash:       hash,
 		}, nil
 	case typeListZipList:
+		base.Encoding = model.ZipListEncoding
 		list, err := dec.readZipList()
 		if err != nil {
 			return nil, err
@@ -153,6 +229,7 @@ func (dec *Decoder) readObject(flag byte, base *model.BaseObject) (model.RedisOb
 			Values:     list,
 		}, nil
 	case typeListQuickList:
+		base.Encoding = model.QuickListEncoding
 		list, err := dec.readQuickList()
 		if err != nil {
 			return nil, err
@@ -161,7 +238,18 @@ func (dec *Decoder) readObject(flag byte, base *model.BaseObject) (model.RedisOb
 			BaseObject: base,
 			Values:     list,
 		}, nil
+	case typeListQuickList2:
+		base.Encoding = model.QuickList2Encoding
+		list, err := dec.readQuickList2()
+		if err != nil {
+			return nil, err
+		}
+		return &model.ListObject{
+			BaseObject: base,
+			Values:     list,
+		}, nil
 	case typeHashZipMap:
+		base.Encoding = model.ZipMapEncoding
 		m, err := dec.readZipMapHash()
 		if err != nil {
 			return nil, err
@@ -171,6 +259,7 @@ func (dec *Decoder) readObject(flag byte, base *model.BaseObject) (model.RedisOb
 			Hash:       m,
 		}, nil
 	case typeHashZipList:
+		base.Encoding = model.ZipListEncoding
 		m, err := dec.readZipListHash()
 		if err != nil {
 			return nil, err
@@ -179,7 +268,18 @@ func (dec *Decoder) readObject(flag byte, base *model.BaseObject) (model.RedisOb
 			BaseObject: base,
 			Hash:       m,
 		}, nil
+	case typeHashListPack:
+		base.Encoding = model.ListPackEncoding
+		m, err := dec.readListPackHash()
+		if err != nil {
+			return nil, err
+		}
+		return &model.HashObject{
+			BaseObject: base,
+			Hash:       m,
+		}, nil
 	case typeZset:
+		base.Encoding = model.SkipListEncoding
 		entries, err := dec.readZSet(false)
 		if err != nil {
 			return nil, err
@@ -189,6 +289,7 @@ func (dec *Decoder) readObject(flag byte, base *model.BaseObject) (model.RedisOb
 			Entries:    entries,
 		}, nil
 	case typeZset2:
+		base.Encoding = model.SkipListEncoding
 		entries, err := dec.readZSet(true)
 		if err != nil {
 			return nil, err
@@ -198,6 +299,7 @@ func (dec *Decoder) readObject(flag byte, base *model.BaseObject) (model.RedisOb
 			Entries:    entries,
 		}, nil
 	case typeZsetZipList:
+		base.Encoding = model.ZipListEncoding
 		entries, err := dec.readZipListZSet()
 		if err != nil {
 			return nil, err
@@ -206,6 +308,69 @@ func (dec *Decoder) readObject(flag byte, base *model.BaseObject) (model.RedisOb
 			BaseObject: base,
 			Entries:    entries,
 		}, nil
+	case typeZsetListPack:
+		base.Encoding = model.ListPackEncoding
+		entries, err := dec.readListPackZSet()
+		if err != nil {
+			return nil, err
+		}
+		return &model.ZSetObject{
+			BaseObject: base,
+			Entries:    entries,
+		}, nil
+	case typeStreamListPacks, typeStreamListPacks2, typeStreamListPacks3:
+		version := 1
+		if flag == typeStreamListPacks2 {
+			version = 2
+		} else if flag == typeStreamListPacks3 {
+			version = 3
+		}
+		stream, err := dec.readStream(version)
+		if err != nil {
+			return nil, err
+		}
+		stream.BaseObject = base
+		return stream, nil
+	case typeModule2:
+		moduleID, _, err := dec.readLength()
+		if err != nil {
+			return nil, err
+		}
+		value, err := dec.readModuleValue()
+		if err != nil {
+			return nil, err
+		}
+		name, _ := ModuleTypeName(moduleID)
+		return &model.ModuleObject{
+			BaseObject: base,
+			ModuleID:   moduleID,
+			ModuleName: name,
+			Value:      value,
+		}, nil
+	case typeModule:
+		return nil, errors.New("module values of RDB_TYPE_MODULE are not supported")
+	case typeHashMetadataPreGA, typeHashMetadata:
+		base.Encoding = model.HashTableEncoding
+		m, expirations, err := dec.readHashMetadata(flag == typeHashMetadataPreGA)
+		if err != nil {
+			return nil, err
+		}
+		return &model.HashObject{
+			BaseObject:       base,
+			Hash:             m,
+			FieldExpirations: expirations,
+		}, nil
+	case typeHashListPackExPreGA, typeHashListPackEx:
+		base.Encoding = model.ListPackEncoding
+		m, expirations, err := dec.readListPackExHash(flag == typeHashListPackExPreGA)
+		if err != nil {
+			return nil, err
+		}
+		return &model.HashObject{
+			BaseObject:       base,
+			Hash:             m,
+			FieldExpirations: expirations,
+		}, nil
 	}
 	return nil, fmt.Errorf("unknown type flag: %b", flag)
 }
@@ -213,11 +378,19 @@ func (dec *Decoder) readObject(flag byte, base *model.BaseObject) (model.RedisOb
 func (dec *Decoder) parse(cb func(object model.RedisObject) bool) error {
 	var dbIndex int
 	var expireMs int64
+	var idle *uint64
+	var freq *uint8
 	for {
+		if dec.arena != nil {
+			dec.arena.reset() // callback of previous object has returned
+		}
 		b, err := dec.readByte()
 		if err != nil {
 			return err
 		}
+		if dec.codeCallback != nil {
+			dec.codeCallback(b)
+		}
 		if b == opCodeEOF {
 			break
 		} else if b == opCodeSelectDB {
@@ -286,16 +459,62 @@ func (dec *Decoder) parse(cb func(object model.RedisObject) bool) error {
 				}
 			}
 			continue
+		} else if b == opCodeFunction2 {
+			code, err := dec.readString()
+			if err != nil {
+				return err
+			}
+			if dec.withSpecialOpCode {
+				obj := &model.FunctionsObject{
+					BaseObject: &model.BaseObject{},
+				}
+				obj.Code = string(code)
+				tbc := cb(obj)
+				if !tbc {
+					break
+				}
+			}
+			continue
+		} else if b == opCodeFunction {
+			// functions of pre-release redis 7.0 could not be loaded by any released redis, they are skipped
+			err = dec.skipPreGAFunction()
+			if err != nil {
+				return err
+			}
+			continue
+		} else if b == opCodeModuleAux {
+			moduleID, when, value, err := dec.readModuleAux()
+			if err != nil {
+				return err
+			}
+			if dec.withSpecialOpCode {
+				obj := &model.ModuleAuxObject{
+					BaseObject: &model.BaseObject{},
+				}
+				obj.ModuleID = moduleID
+				obj.ModuleName, _ = ModuleTypeName(moduleID)
+				obj.When = when
+				obj.Value = value
+				tbc := cb(obj)
+				if !tbc {
+					break
+				}
+			}
+			continue
 		} else if b == opCodeFreq {
-			_, err = dec.readByte()
+			f, err := dec.readByte()
 			if err != nil {
 				return err
 			}
+			freq = &f
+			continue
 		} else if b == opCodeIdle {
-			_, _, err = dec.readLength()
+			seconds, _, err := dec.readLength()
 			if err != nil {
 				return err
 			}
+			idle = &seconds
+			continue
 		}
 		begPos := dec.readCount
 		key, err := dec.readString()
@@ -312,7 +531,31 @@ func (dec *Decoder) parse(cb func(object model.RedisObject) bool) error {
 			base.Expiration = &expiration
 			expireMs = 0 // reset expire ms
 		}
+		base.Idle, base.Freq = idle, freq
+		idle, freq = nil, nil
 		begPos = dec.readCount
+		if dec.sizeOnly && isFramedType(b) {
+			obj, err := dec.readSizedObject(b, base)
+			if err != nil {
+				return err
+			}
+			base.Size = dec.readCount - begPos + keySize
+			if !cb(obj) {
+				break
+			}
+			continue
+		}
+		if dec.frameCallback != nil && isFramedType(b) {
+			raw, err := dec.readRawValue(b)
+			if err != nil {
+				return err
+			}
+			base.Size = dec.readCount - begPos + keySize
+			if !dec.frameCallback(b, base, raw) {
+				break
+			}
+			continue
+		}
 		obj, err := dec.readObject(b, base)
 		if err != nil {
 			return err
@@ -327,9 +570,36 @@ func (dec *Decoder) parse(cb func(object model.RedisObject) bool) error {
 	return nil
 }
 
+// skipPreGAFunction skips a function library of redis 7.0 rc1 and rc2, which is made up of name, engine name,
+// optional description and code
+func (dec *Decoder) skipPreGAFunction() error {
+	for i := 0; i < 2; i++ { // name and engine name
+		if err := dec.skipString(); err != nil {
+			return err
+		}
+	}
+	hasDesc, _, err := dec.readLength()
+	if err != nil {
+		return err
+	}
+	if hasDesc != 0 {
+		if err := dec.skipString(); err != nil {
+			return err
+		}
+	}
+	return dec.skipString()
+}
+
 // Parse parses rdb and callback
 // cb returns true to continue, returns false to stop the iteration
 func (dec *Decoder) Parse(cb func(object model.RedisObject) bool) (err error) {
+	if dec.workers > 1 && !dec.sizeOnly {
+		return dec.ParseParallel(true, func(object model.RedisObject) interface{} {
+			return object
+		}, func(result interface{}) bool {
+			return cb(result.(model.RedisObject))
+		})
+	}
 	defer func() {
 		if err2 := recover(); err2 != nil {
 			err = fmt.Errorf("panic: %v", err2)
@@ -341,3 +611,40 @@ func (dec *Decoder) Parse(cb func(object model.RedisObject) bool) (err error) {
 	}
 	return dec.parse(cb)
 }
+
+// DecodeObject decodes a key and its value from input beginning with the type flag of key, such as a section of rdb
+// located by Offset. version is rdb version of the file, DB and expiration of the result are not set.
+// Integer-encoded strings are always formatted into Value, as if WithRawIntegers is not set
+func DecodeObject(input io.Reader, version int) (obj model.RedisObject, err error) {
+	defer func() {
+		if err2 := recover(); err2 != nil {
+			err = fmt.Errorf("panic: %v", err2)
+		}
+	}()
+	if version < minVersion || version > maxVersion {
+		return nil, fmt.Errorf("cannot parse version: %d", version)
+	}
+	dec := NewDecoder(input)
+	dec.version = version
+	flag, err := dec.readByte()
+	if err != nil {
+		return nil, err
+	}
+	if !IsTypeCode(flag) {
+		return nil, fmt.Errorf("unknown type flag: %d", flag)
+	}
+	key, err := dec.readString()
+	if err != nil {
+		return nil, err
+	}
+	base := &model.BaseObject{
+		Key: unsafeBytes2Str(key),
+	}
+	obj, err = dec.readObject(flag, base)
+	if err != nil {
+		return nil, err
+	}
+	base.Size = dec.readCount - 1
+	base.Type = obj.GetType()
+	return obj, nil
+}
@@ -0,0 +1,89 @@
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/hdt3213/rdb/crc64jones"
+	"github.com/hdt3213/rdb/model"
+)
+
+// DumpObject serializes object in payload format of DUMP command, which could be loaded by RESTORE command:
+// type flag and value in rdb encoding, followed by rdb version (2 bytes) and crc64 checksum (8 bytes), both in little
+// endian. Key and expiration are not included. Encodings are chosen for the rdb version like Encoder.SetVersion
+func DumpObject(object model.RedisObject, version int) ([]byte, error) {
+	err := CheckTargetVersion(version)
+	if err != nil {
+		return nil, err
+	}
+	buf := bytes.NewBuffer(nil)
+	enc := NewEncoder(buf).SetVersion(version)
+	enc.dump = true
+	enc.state = writtenDBHeaderState
+	err = enc.writeObjectValue(object)
+	if err != nil {
+		return nil, err
+	}
+	payload := buf.Bytes()
+	payload = append(payload, byte(version), byte(version>>8))
+	checkSum := make([]byte, 8)
+	binary.LittleEndian.PutUint64(checkSum, crc64jones.Checksum(payload))
+	return append(payload, checkSum...), nil
+}
+
+// DecodeDump decodes payload of DUMP command into object, after validating its rdb version and crc64 checksum.
+// Payload does not contain key and expiration, set them on the result if needed
+func DecodeDump(payload []byte) (obj model.RedisObject, err error) {
+	if len(payload) < 11 {
+		return nil, errors.New("payload is too short")
+	}
+	n := len(payload) - 8
+	if binary.LittleEndian.Uint64(payload[n:]) != crc64jones.Checksum(payload[:n]) {
+		return nil, errors.New("payload checksum mismatch")
+	}
+	version := int(binary.LittleEndian.Uint16(payload[n-2 : n]))
+	if version < minVersion || version > maxVersion {
+		return nil, fmt.Errorf("cannot parse version: %d", version)
+	}
+	defer func() {
+		if err2 := recover(); err2 != nil {
+			err = fmt.Errorf("panic: %v", err2)
+		}
+	}()
+	value := payload[:n-2]
+	dec := NewDecoder(bytes.NewReader(value[1:]))
+	dec.version = version
+	base := &model.BaseObject{}
+	obj, err = dec.readObject(value[0], base)
+	if err != nil {
+		return nil, err
+	}
+	if dec.readCount != len(value)-1 {
+		return nil, fmt.Errorf("%d unexpected bytes after value", len(value)-1-dec.readCount)
+	}
+	base.Size = len(value) - 1
+	base.Type = obj.GetType()
+	return obj, nil
+}
+
+// countWriter counts bytes written into it
+type countWriter int
+
+func (w *countWriter) Write(p []byte) (int, error) {
+	*w += countWriter(len(p))
+	return len(p), nil
+}
+
+// EncodedSize returns rdb encoded size of key and value of object like model.BaseObject.Size, for objects which
+// are not read from rdb. Encodings are chosen like Encoder does
+func EncodedSize(object model.RedisObject) (int, error) {
+	var counter countWriter
+	enc := NewEncoder(&counter)
+	enc.state = writtenDBHeaderState
+	err := enc.writeObjectValue(object)
+	if err != nil {
+		return 0, err
+	}
+	return int(counter) - 1, nil // type flag is not included
+}
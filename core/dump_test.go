@@ -0,0 +1,68 @@
+package core
+
+import (
+	"bytes"
+	"github.com/hdt3213/rdb/model"
+	"testing"
+)
+
+func TestDumpObject(t *testing.T) {
+	// output of `SET mykey 10` and `DUMP mykey` in redis 6.2
+	expect := []byte("\x00\xc0\n\t\x00\xbem\x06\x89Z(\x00\n")
+	payload, err := DumpObject(&model.StringObject{
+		BaseObject: &model.BaseObject{Key: "mykey"},
+		Value:      []byte("10"),
+	}, 9)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !bytes.Equal(payload, expect) {
+		t.Errorf("wrong payload: %q", payload)
+	}
+	_, err = DumpObject(&model.StringObject{BaseObject: &model.BaseObject{}}, 99)
+	if err == nil {
+		t.Error("expect error of unsupported version")
+	}
+}
+
+func TestDecodeDump(t *testing.T) {
+	obj, err := DecodeDump([]byte("\x00\xc0\n\t\x00\xbem\x06\x89Z(\x00\n"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if str, ok := obj.(*model.StringObject); !ok || string(str.Value) != "10" {
+		t.Errorf("wrong object: %#v", obj)
+	}
+	objects := []model.RedisObject{
+		&model.ListObject{BaseObject: &model.BaseObject{}, Values: [][]byte{[]byte("a"), []byte("1")}},
+		&model.SetObject{BaseObject: &model.BaseObject{}, Members: [][]byte{[]byte("a"), []byte("b")}},
+		&model.HashObject{BaseObject: &model.BaseObject{}, Hash: map[string][]byte{"a": []byte("1")}},
+		&model.ZSetObject{BaseObject: &model.BaseObject{}, Entries: []*model.ZSetEntry{{Member: "a", Score: 1.5}}},
+	}
+	for _, version := range []int{7, 9, 11} {
+		for _, object := range objects {
+			payload, err := DumpObject(object, version)
+			if err != nil {
+				t.Error(err)
+				continue
+			}
+			decoded, err := DecodeDump(payload)
+			if err != nil {
+				t.Errorf("decode %s of version %d failed: %v", object.GetType(), version, err)
+				continue
+			}
+			if decoded.GetType() != object.GetType() || decoded.GetElemCount() != object.GetElemCount() {
+				t.Errorf("wrong %s of version %d", object.GetType(), version)
+			}
+		}
+	}
+	payload := []byte("\x00\xc0\n\t\x00\xbem\x06\x89Z(\x00\x0b")
+	if _, err = DecodeDump(payload); err == nil {
+		t.Error("expect checksum error")
+	}
+	if _, err = DecodeDump(payload[:5]); err == nil {
+		t.Error("expect error of short payload")
+	}
+}
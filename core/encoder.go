@@ -3,9 +3,11 @@ package core
 import (
 	"encoding/binary"
 	"fmt"
+	"github.com/hdt3213/rdb/crc64jones"
+	"github.com/hdt3213/rdb/model"
 	"hash"
-	"hash/crc64"
 	"io"
+	"time"
 )
 
 // Encoder is used to generate RDB file
@@ -16,6 +18,8 @@ type Encoder struct {
 	existDB  map[uint]struct{} // store exist db size to avoid duplicate db
 	compress bool
 	state    string
+	version  int  // target rdb version, 0 means the legacy header without any restriction
+	dump     bool // writing DUMP payload, keys are omitted
 
 	listZipListOpt  *zipListOpt
 	hashZipListOpt  *zipListOpt
@@ -48,13 +52,16 @@ func (zop *zipListOpt) getMaxEntries() int {
 }
 
 const (
-	startState           = "Start"
-	writtenHeaderState   = "WrittenHeader"
-	writtenDBHeaderState = "writtenHeader"
-	writtenAuxState      = "WrittenAux"
-	writtenTTLState      = "WrittenTTL"
-	writtenObjectState   = "WrittenObject"
-	writtenEndState      = "WritingEnd"
+	startState            = "Start"
+	writtenHeaderState    = "WrittenHeader"
+	writtenDBHeaderState  = "writtenHeader"
+	writtenAuxState       = "WrittenAux"
+	writtenFunctionState  = "WrittenFunction"
+	writtenModuleAuxState = "WrittenModuleAux"
+	writtenTTLState       = "WrittenTTL"
+	writtenObjectState    = "WrittenObject"
+	writingObjectState    = "WritingObject"
+	writtenEndState       = "WritingEnd"
 )
 
 var placeholder = struct{}{}
@@ -64,37 +71,57 @@ var stateChanges = map[string]map[string]struct{}{ // state -> allow next states
 		writtenHeaderState: placeholder,
 	},
 	writtenHeaderState: {
-		writtenAuxState:      placeholder,
-		writtenDBHeaderState: placeholder,
-		writtenEndState:      placeholder,
+		writtenAuxState:       placeholder,
+		writtenFunctionState:  placeholder,
+		writtenModuleAuxState: placeholder,
+		writtenDBHeaderState:  placeholder,
+		writtenEndState:       placeholder,
 	},
 	writtenAuxState: {
-		writtenAuxState:      placeholder,
-		writtenDBHeaderState: placeholder,
-		writtenEndState:      placeholder,
+		writtenAuxState:       placeholder,
+		writtenFunctionState:  placeholder,
+		writtenModuleAuxState: placeholder,
+		writtenDBHeaderState:  placeholder,
+		writtenEndState:       placeholder,
+	},
+	writtenFunctionState: { // functions are written before the first db
+		writtenFunctionState:  placeholder,
+		writtenModuleAuxState: placeholder,
+		writtenDBHeaderState:  placeholder,
+		writtenEndState:       placeholder,
+	},
+	writtenModuleAuxState: {
+		writtenModuleAuxState: placeholder,
+		writtenFunctionState:  placeholder,
+		writtenDBHeaderState:  placeholder,
+		writtenEndState:       placeholder,
 	},
 	writtenDBHeaderState: { // do not allow empty db
 		writtenTTLState:    placeholder,
 		writtenObjectState: placeholder,
+		writingObjectState: placeholder,
 	},
 	writtenTTLState: {
 		writtenObjectState: placeholder,
+		writingObjectState: placeholder,
 	},
 	writtenObjectState: {
-		writtenTTLState:      placeholder,
-		writtenObjectState:   placeholder,
-		writtenDBHeaderState: placeholder, // start another db
-		writtenEndState:      placeholder,
+		writtenTTLState:       placeholder,
+		writtenObjectState:    placeholder,
+		writingObjectState:    placeholder,
+		writtenModuleAuxState: placeholder, // module aux data loaded after keyspace
+		writtenDBHeaderState:  placeholder, // start another db
+		writtenEndState:       placeholder,
 	},
-	writtenEndState: {},
+	writingObjectState: {}, // a streaming writer is open, only its End could change state
+	writtenEndState:    {},
 }
 
 // NewEncoder creates an encoder instance
 func NewEncoder(writer io.Writer) *Encoder {
-	crcTab := crc64.MakeTable(crc64.ISO)
 	return &Encoder{
 		writer:          writer,
-		crc:             crc64.New(crcTab),
+		crc:             crc64jones.New(),
 		buffer:          make([]byte, 8),
 		state:           startState,
 		existDB:         make(map[uint]struct{}),
@@ -129,6 +156,55 @@ func (enc *Encoder) SetZSetZipListOpt(maxValue, maxEntries int) *Encoder {
 	return enc
 }
 
+// MinTargetVersion is the lowest rdb version encoder could write, aux fields and resize db hint require version 7
+const MinTargetVersion = 7
+
+// MaxTargetVersion is the highest rdb version encoder could write
+const MaxTargetVersion = maxVersion
+
+// CheckTargetVersion returns error if encoder could not write rdb of the version
+func CheckTargetVersion(version int) error {
+	if version < MinTargetVersion || version > maxVersion {
+		return fmt.Errorf("rdb version must be in [%d, %d]", MinTargetVersion, maxVersion)
+	}
+	return nil
+}
+
+// SetVersion sets rdb version of header. Encodings unknown to the version are avoided, and objects which could not
+// be represented in the version are refused with *VersionError. Version must be in [7, 12]
+func (enc *Encoder) SetVersion(version int) *Encoder {
+	enc.version = version
+	return enc
+}
+
+// VersionError is returned when an object could not be represented in the target rdb version of encoder
+type VersionError struct {
+	Feature    string
+	MinVersion int // minimal rdb version supporting Feature
+	Version    int // target rdb version
+}
+
+func (e *VersionError) Error() string {
+	return fmt.Sprintf("%s requires rdb version %d, but target version is %d", e.Feature, e.MinVersion, e.Version)
+}
+
+// requireVersion returns *VersionError if target version is lower than minVersion
+func (enc *Encoder) requireVersion(feature string, minVersion int) error {
+	if enc.version > 0 && enc.version < minVersion {
+		return &VersionError{
+			Feature:    feature,
+			MinVersion: minVersion,
+			Version:    enc.version,
+		}
+	}
+	return nil
+}
+
+// useListPack returns whether compact encodings of redis 7.0 (listpack and quicklist2) should be used
+func (enc *Encoder) useListPack() bool {
+	return enc.version >= 10
+}
+
 // remain unfixed bugs, don't open
 func (enc *Encoder) EnableCompress() *Encoder {
 	enc.compress = true
@@ -158,7 +234,15 @@ func (enc *Encoder) WriteHeader() error {
 	if !enc.validateStateChange(writtenHeaderState) {
 		return fmt.Errorf("cannot writing header at state: %s", enc.state)
 	}
-	err := enc.write(rdbHeader)
+	header := rdbHeader
+	if enc.version > 0 {
+		err := CheckTargetVersion(enc.version)
+		if err != nil {
+			return err
+		}
+		header = []byte(fmt.Sprintf("REDIS%04d", enc.version))
+	}
+	err := enc.write(header)
 	if err != nil {
 		return err
 	}
@@ -229,7 +313,9 @@ func (enc *Encoder) WriteEnd() error {
 	if err != nil {
 		return err
 	}
-	checkSum := enc.crc.Sum(nil)
+	// redis stores crc64 checksum in little endian
+	checkSum := make([]byte, 8)
+	binary.LittleEndian.PutUint64(checkSum, enc.crc.Sum64())
 	_, err = enc.writer.Write(checkSum)
 	if err != nil {
 		return fmt.Errorf("write crc sum failed: %v", err)
@@ -264,6 +350,83 @@ func WithTTL(expirationMs uint64) TTLOption {
 	return TTLOption(expirationMs)
 }
 
+// EncodingOption specific rdb encoding for object, such as model.ZipListEncoding
+type EncodingOption string
+
+// WithEncoding specific rdb encoding for object, the encoder chooses encoding by itself if not specified.
+// Compact encodings (ziplist, intset) are used without checking size limits if specified
+func WithEncoding(encoding string) EncodingOption {
+	return EncodingOption(encoding)
+}
+
+func getEncoding(options []interface{}) string {
+	for _, opt := range options {
+		if o, ok := opt.(EncodingOption); ok {
+			return string(o)
+		}
+	}
+	return ""
+}
+
+// WriteObject writes an object decoded by Decoder, its expiration and encoding are kept where possible
+func (enc *Encoder) WriteObject(object model.RedisObject, options ...interface{}) error {
+	if expiration := object.GetExpiration(); expiration != nil {
+		options = append(options, WithTTL(uint64(expiration.UnixNano()/int64(time.Millisecond))))
+	}
+	return enc.writeObjectValue(object, options...)
+}
+
+// CheckObject returns *VersionError if object could not be represented in target version of encoder, like WriteObject
+// does, but nothing is written. It helps invoker to skip the object before writing anything else for it
+func (enc *Encoder) CheckObject(object model.RedisObject) error {
+	switch o := object.(type) {
+	case *model.StreamObject:
+		return enc.requireVersion("streams", 9)
+	case *model.ModuleObject:
+		return enc.requireVersion("module values", 8)
+	case *model.HashObject:
+		if len(o.FieldExpirations) > 0 {
+			return enc.requireVersion("hash field expiration", 12)
+		}
+	}
+	return nil
+}
+
+// writeObjectValue writes type, key and value of object
+func (enc *Encoder) writeObjectValue(object model.RedisObject, options ...interface{}) error {
+	if holder, ok := object.(model.BaseObjectHolder); ok && holder.GetEncoding() != "" {
+		options = append(options, WithEncoding(holder.GetEncoding()))
+	}
+	switch o := object.(type) {
+	case *model.StringObject:
+		return enc.WriteStringObject(o.GetKey(), o.Bytes(), options...)
+	case *model.ListObject:
+		return enc.WriteListObject(o.GetKey(), o.Values, options...)
+	case *model.SetObject:
+		return enc.WriteSetObject(o.GetKey(), o.Members, options...)
+	case *model.HashObject:
+		if len(o.FieldExpirations) > 0 {
+			return enc.WriteHashMetadataObject(o.GetKey(), o.Hash, o.FieldExpirations, options...)
+		}
+		return enc.WriteHashMapObject(o.GetKey(), o.Hash, options...)
+	case *model.ZSetObject:
+		return enc.WriteZSetObject(o.GetKey(), o.Entries, options...)
+	case *model.StreamObject:
+		return enc.WriteStreamObject(o.GetKey(), o, options...)
+	case *model.ModuleObject:
+		return enc.WriteModuleObject(o.GetKey(), o.ModuleID, o.Value, options...)
+	}
+	return fmt.Errorf("cannot write object of type %s", object.GetType())
+}
+
+// writeKey writes key of object, it is omitted in DUMP payload
+func (enc *Encoder) writeKey(key string) error {
+	if enc.dump {
+		return nil
+	}
+	return enc.writeString(key)
+}
+
 func (enc *Encoder) beforeWriteObject(options ...interface{}) error {
 	if !enc.validateStateChange(writtenObjectState) {
 		return fmt.Errorf("cannot write object at state: %s", enc.state)
@@ -279,3 +442,75 @@ func (enc *Encoder) beforeWriteObject(options ...interface{}) error {
 	}
 	return nil
 }
+
+// beginCollection validates state, writes ttl and object header for streaming writers. Length is written except for
+// quicklists, whose number of nodes is known in the end. State is changed only after all of them are written,
+// so the encoder is not stuck in writing object on error
+func (enc *Encoder) beginCollection(typ byte, key string, n uint64, options ...interface{}) (*collectionWriter, error) {
+	if !enc.validateStateChange(writingObjectState) {
+		return nil, fmt.Errorf("cannot begin object at state: %s", enc.state)
+	}
+	if n == 0 {
+		return nil, fmt.Errorf("%s is empty, redis does not allow empty collection", key)
+	}
+	err := enc.beforeWriteObject(options...)
+	if err != nil {
+		return nil, err
+	}
+	err = enc.write([]byte{typ})
+	if err != nil {
+		return nil, err
+	}
+	err = enc.writeKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if typ != typeListQuickList && typ != typeListQuickList2 {
+		err = enc.writeLength(n)
+		if err != nil {
+			return nil, err
+		}
+	}
+	enc.state = writingObjectState
+	return &collectionWriter{
+		enc:      enc,
+		key:      key,
+		expected: n,
+	}, nil
+}
+
+// collectionWriter is the common part of ListWriter, SetWriter, HashWriter and ZSetWriter.
+// It guarantees the number of appended elements equals the length declared in header
+type collectionWriter struct {
+	enc      *Encoder
+	key      string
+	expected uint64
+	written  uint64
+	closed   bool
+}
+
+func (w *collectionWriter) beforeAppend() error {
+	if w.closed {
+		return fmt.Errorf("writer of %s has been closed", w.key)
+	}
+	if w.written >= w.expected {
+		return fmt.Errorf("%s declared %d elements, cannot append more", w.key, w.expected)
+	}
+	w.written++
+	return nil
+}
+
+func (w *collectionWriter) beforeEnd() error {
+	if w.closed {
+		return fmt.Errorf("writer of %s has been closed", w.key)
+	}
+	if w.written != w.expected {
+		return fmt.Errorf("%s declared %d elements, but %d appended", w.key, w.expected, w.written)
+	}
+	return nil
+}
+
+func (w *collectionWriter) end() {
+	w.closed = true
+	w.enc.state = writtenObjectState
+}
@@ -2,7 +2,13 @@ package core
 
 import (
 	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/hdt3213/rdb/crc64jones"
 	"github.com/hdt3213/rdb/model"
+	"io/ioutil"
+	"path/filepath"
 	"testing"
 	"time"
 )
@@ -109,3 +115,262 @@ func TestEncode(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestWriteObject(t *testing.T) {
+	filenames, err := filepath.Glob(filepath.Join("..", "cases", "*.rdb"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	for _, filename := range filenames {
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		var expect []model.RedisObject
+		err = NewDecoder(bytes.NewReader(data)).Parse(func(object model.RedisObject) bool {
+			expect = append(expect, object)
+			return true
+		})
+		if err != nil {
+			t.Errorf("parse %s failed: %v", filename, err)
+			continue
+		}
+		buf := bytes.NewBuffer(nil)
+		enc := NewEncoder(buf)
+		err = enc.WriteHeader()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		db := -1
+		for _, object := range expect {
+			if object.GetDBIndex() != db {
+				db = object.GetDBIndex()
+				err = enc.WriteDBHeader(uint(db), 0, 0)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+			}
+			err = enc.WriteObject(object)
+			if err != nil {
+				t.Errorf("write %s of %s failed: %v", object.GetKey(), filename, err)
+				return
+			}
+		}
+		err = enc.WriteEnd()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		i := 0
+		err = NewDecoder(buf).Parse(func(object model.RedisObject) bool {
+			if i >= len(expect) {
+				t.Errorf("%s: too many objects", filename)
+				return false
+			}
+			e := expect[i]
+			i++
+			if object.GetKey() != e.GetKey() || object.GetDBIndex() != e.GetDBIndex() ||
+				object.GetType() != e.GetType() || object.GetElemCount() != e.GetElemCount() {
+				t.Errorf("%s: object %s mismatch", filename, e.GetKey())
+				return true
+			}
+			if (object.GetExpiration() == nil) != (e.GetExpiration() == nil) ||
+				e.GetExpiration() != nil && !e.GetExpiration().Equal(*object.GetExpiration()) {
+				t.Errorf("%s: object %s has wrong expiration", filename, e.GetKey())
+			}
+			expectEncoding := e.(model.BaseObjectHolder).GetEncoding()
+			if expectEncoding == model.ZipMapEncoding {
+				expectEncoding = model.ZipListEncoding
+			}
+			if object.(model.BaseObjectHolder).GetEncoding() != expectEncoding {
+				t.Errorf("%s: object %s has encoding %s, expect %s", filename, e.GetKey(), object.(model.BaseObjectHolder).GetEncoding(), expectEncoding)
+			}
+			return true
+		})
+		if err != nil {
+			t.Errorf("parse output of %s failed: %v", filename, err)
+		}
+		if i != len(expect) {
+			t.Errorf("%s: wrong object count", filename)
+		}
+	}
+}
+
+func TestEncoderVersion(t *testing.T) {
+	hash := map[string][]byte{"a": []byte("1"), "b": []byte("foo")}
+	list := [][]byte{[]byte("1"), []byte("foo"), []byte(RandString(100))}
+	set := [][]byte{[]byte("a"), []byte("b")}
+	zset := []*model.ZSetEntry{{Member: "a", Score: 1.5}, {Member: "b", Score: -3}}
+	write := func(version int, zsetEncoding string) ([]byte, error) {
+		buf := bytes.NewBuffer(nil)
+		enc := NewEncoder(buf).SetVersion(version)
+		err := enc.WriteHeader()
+		if err != nil {
+			return nil, err
+		}
+		err = enc.WriteDBHeader(0, 0, 0)
+		if err != nil {
+			return nil, err
+		}
+		err = enc.WriteHashMapObject("hash", hash)
+		if err != nil {
+			return nil, err
+		}
+		err = enc.WriteListObject("list", list)
+		if err != nil {
+			return nil, err
+		}
+		err = enc.WriteSetObject("set", set, WithEncoding(model.ListPackEncoding))
+		if err != nil {
+			return nil, err
+		}
+		err = enc.WriteZSetObject("zset", zset, WithEncoding(zsetEncoding))
+		if err != nil {
+			return nil, err
+		}
+		err = enc.WriteEnd()
+		if err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+	expectEncodings := map[int]map[string]string{
+		11: {
+			"hash": model.ListPackEncoding,
+			"list": model.QuickList2Encoding,
+			"set":  model.ListPackEncoding,
+			"zset": model.ListPackEncoding,
+		},
+		9: {
+			"hash": model.ZipListEncoding,
+			"list": model.QuickListEncoding,
+			"set":  model.HashTableEncoding,
+			"zset": model.ZipListEncoding,
+		},
+		7: {
+			"hash": model.ZipListEncoding,
+			"list": model.QuickListEncoding,
+			"set":  model.HashTableEncoding,
+			"zset": model.SkipListEncoding,
+		},
+	}
+	for version, encodings := range expectEncodings {
+		zsetEncoding := model.ListPackEncoding
+		if version == 7 {
+			zsetEncoding = model.SkipListEncoding // scores are stored as string in RDB_TYPE_ZSET
+		}
+		data, err := write(version, zsetEncoding)
+		if err != nil {
+			t.Errorf("write version %d failed: %v", version, err)
+			continue
+		}
+		if string(data[:9]) != fmt.Sprintf("REDIS%04d", version) {
+			t.Errorf("wrong header: %s", data[:9])
+		}
+		count := 0
+		err = NewDecoder(bytes.NewReader(data)).Parse(func(object model.RedisObject) bool {
+			count++
+			if object.(model.BaseObjectHolder).GetEncoding() != encodings[object.GetKey()] {
+				t.Errorf("version %d: %s has encoding %s, expect %s", version, object.GetKey(),
+					object.(model.BaseObjectHolder).GetEncoding(), encodings[object.GetKey()])
+			}
+			switch o := object.(type) {
+			case *model.HashObject:
+				if len(o.Hash) != len(hash) || string(o.Hash["b"]) != "foo" {
+					t.Errorf("version %d: wrong hash", version)
+				}
+			case *model.ListObject:
+				if len(o.Values) != len(list) || string(o.Values[2]) != string(list[2]) {
+					t.Errorf("version %d: wrong list", version)
+				}
+			case *model.SetObject:
+				if len(o.Members) != len(set) {
+					t.Errorf("version %d: wrong set", version)
+				}
+			case *model.ZSetObject:
+				if len(o.Entries) != len(zset) || o.Entries[0].Score != 1.5 || o.Entries[1].Score != -3 {
+					t.Errorf("version %d: wrong sorted set", version)
+				}
+			}
+			return true
+		})
+		if err != nil {
+			t.Errorf("parse version %d failed: %v", version, err)
+		}
+		if count != 4 {
+			t.Errorf("version %d: wrong object count %d", version, count)
+		}
+	}
+
+	buf := bytes.NewBuffer(nil)
+	enc := NewEncoder(buf).SetVersion(9)
+	err := enc.WriteHeader()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = enc.WriteFunction("#!lua name=mylib\nredis.register_function('f', function() return 1 end)")
+	var versionErr *VersionError
+	if !errors.As(err, &versionErr) || versionErr.MinVersion != 10 {
+		t.Errorf("expect version error of functions, got %v", err)
+	}
+	err = enc.WriteDBHeader(0, 0, 0)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	enc.SetVersion(8)
+	err = enc.WriteStreamObject("stream", &model.StreamObject{})
+	if !errors.As(err, &versionErr) || versionErr.MinVersion != 9 {
+		t.Errorf("expect version error of streams, got %v", err)
+	}
+	// refused objects write nothing
+	err = enc.WriteStringObject("a", []byte("1"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if NewEncoder(bytes.NewBuffer(nil)).SetVersion(6).WriteHeader() == nil {
+		t.Error("expect error of unsupported version")
+	}
+}
+
+func TestEncoderChecksum(t *testing.T) {
+	// redis stores crc64 jones of all bytes before checksum in little endian
+	for _, name := range []string{"rdb_version_5_with_checksum.rdb", "memory.rdb"} {
+		data, err := ioutil.ReadFile(filepath.Join("../cases", name))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		n := len(data) - 8
+		if crc64jones.Checksum(data[:n]) != binary.LittleEndian.Uint64(data[n:]) {
+			t.Errorf("checksum of %s does not match crc64 jones", name)
+		}
+	}
+
+	buf := bytes.NewBuffer(nil)
+	enc := NewEncoder(buf)
+	errs := []error{
+		enc.WriteHeader(),
+		enc.WriteAux("redis-ver", "7.0.0"),
+		enc.WriteDBHeader(0, 1, 0),
+		enc.WriteStringObject("a", []byte("b")),
+		enc.WriteEnd(),
+	}
+	for _, err := range errs {
+		if err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	data := bytes.TrimSuffix(buf.Bytes(), []byte{'\n'})
+	n := len(data) - 8
+	if crc64jones.Checksum(data[:n]) != binary.LittleEndian.Uint64(data[n:]) {
+		t.Error("encoder writes wrong checksum")
+	}
+}
@@ -3,6 +3,11 @@ package core
 import (
 	"encoding/binary"
 	"errors"
+	"fmt"
+	"github.com/hdt3213/rdb/model"
+	"math"
+	"strconv"
+	"time"
 )
 
 /*
@@ -150,12 +155,137 @@ func (dec *Decoder) readZipListHash() (map[string][]byte, error) {
 	return m, nil
 }
 
+func (dec *Decoder) readListPackHash() (map[string][]byte, error) {
+	entries, err := dec.readListPack()
+	if err != nil {
+		return nil, err
+	}
+	if len(entries)%2 != 0 {
+		return nil, fmt.Errorf("listpack of hash has odd number of entries: %d", len(entries))
+	}
+	m := make(map[string][]byte, len(entries)/2)
+	for i := 0; i < len(entries); i += 2 {
+		m[unsafeBytes2Str(entries[i])] = entries[i+1]
+	}
+	return m, nil
+}
+
+// readHashMetadata reads hash with field expiration in hashtable encoding. Since redis 7.4 GA, the minimal expiration
+// time is stored before fields and expiration of each field is stored relative to it. 0 means field never expires
+func (dec *Decoder) readHashMetadata(preGA bool) (map[string][]byte, map[string]time.Time, error) {
+	var minExpire uint64
+	var err error
+	if !preGA {
+		minExpire, err = dec.readMillisecondTime()
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	size, _, err := dec.readLength()
+	if err != nil {
+		return nil, nil, err
+	}
+	m := make(map[string][]byte)
+	var expirations map[string]time.Time
+	for i := 0; i < int(size); i++ {
+		ttl, _, err := dec.readLength()
+		if err != nil {
+			return nil, nil, err
+		}
+		field, err := dec.readString()
+		if err != nil {
+			return nil, nil, err
+		}
+		value, err := dec.readString()
+		if err != nil {
+			return nil, nil, err
+		}
+		m[unsafeBytes2Str(field)] = value
+		if ttl == 0 {
+			continue
+		}
+		if !preGA {
+			ttl += minExpire - 1
+		}
+		if expirations == nil {
+			expirations = make(map[string]time.Time)
+		}
+		expirations[unsafeBytes2Str(field)] = time.Unix(0, int64(ttl)*int64(time.Millisecond))
+	}
+	return m, expirations, nil
+}
+
+// readListPackExHash reads hash with field expiration in listpack encoding, entries of listpack are triplets of
+// field, value and expiration time. Since redis 7.4 GA, the minimal expiration time is stored before listpack
+func (dec *Decoder) readListPackExHash(preGA bool) (map[string][]byte, map[string]time.Time, error) {
+	if !preGA {
+		_, err := dec.readMillisecondTime()
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	buf, err := dec.readString()
+	if err != nil {
+		return nil, nil, err
+	}
+	cursor := 0
+	size, err := readListPackLength(buf, &cursor)
+	if err != nil {
+		return nil, nil, err
+	}
+	if size%3 != 0 {
+		return nil, nil, fmt.Errorf("listpack of hash with field expiration has %d entries", size)
+	}
+	m := make(map[string][]byte, size/3)
+	var expirations map[string]time.Time
+	for i := 0; i < size; i += 3 {
+		var entries [2][]byte
+		for j := range entries {
+			entry, val, isInt, err := readListPackValue(buf, &cursor)
+			if err != nil {
+				return nil, nil, err
+			}
+			if isInt {
+				entry = dec.formatInt(val)
+			}
+			entries[j] = entry
+		}
+		entry, ttl, isInt, err := readListPackValue(buf, &cursor)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !isInt {
+			ttl, err = strconv.ParseInt(unsafeBytes2Str(entry), 10, 64)
+			if err != nil {
+				return nil, nil, fmt.Errorf("illegal field expiration: %s", entry)
+			}
+		}
+		field := unsafeBytes2Str(entries[0])
+		m[field] = entries[1]
+		if ttl == 0 {
+			continue
+		}
+		if expirations == nil {
+			expirations = make(map[string]time.Time)
+		}
+		expirations[field] = time.Unix(0, ttl*int64(time.Millisecond))
+	}
+	return m, expirations, nil
+}
+
 func (enc *Encoder) WriteHashMapObject(key string, hash map[string][]byte, options ...interface{}) error {
 	err := enc.beforeWriteObject(options...)
 	if err != nil {
 		return err
 	}
-	ok, err := enc.tryWriteZipListHashMap(key, hash, options...)
+	ok := false
+	switch getEncoding(options) {
+	case model.HashTableEncoding:
+	case model.ZipListEncoding, model.ZipMapEncoding, model.ListPackEncoding:
+		ok, err = enc.tryWriteZipListHashMap(key, hash, true)
+	default:
+		ok, err = enc.tryWriteZipListHashMap(key, hash, false)
+	}
 	if err != nil {
 		return err
 	}
@@ -174,7 +304,7 @@ func (enc *Encoder) writeHashEncoding(key string, hash map[string][]byte, option
 	if err != nil {
 		return err
 	}
-	err = enc.writeString(key)
+	err = enc.writeKey(key)
 	if err != nil {
 		return err
 	}
@@ -195,21 +325,27 @@ func (enc *Encoder) writeHashEncoding(key string, hash map[string][]byte, option
 	return nil
 }
 
-func (enc *Encoder) tryWriteZipListHashMap(key string, hash map[string][]byte, options ...interface{}) (bool, error) {
-	if len(hash) > enc.hashZipListOpt.getMaxEntries() {
+// tryWriteZipListHashMap writes hash in ziplist encoding, it checks hash-max-ziplist-value and
+// hash-max-ziplist-entries unless force is true
+func (enc *Encoder) tryWriteZipListHashMap(key string, hash map[string][]byte, force bool) (bool, error) {
+	if len(hash)*2 >= math.MaxUint16 || !force && len(hash) > enc.hashZipListOpt.getMaxEntries() {
 		return false, nil
 	}
 	maxValue := enc.hashZipListOpt.getMaxValue()
 	for _, v := range hash {
-		if len(v) > maxValue {
+		if !force && len(v) > maxValue {
 			return false, nil
 		}
 	}
-	err := enc.write([]byte{typeHashZipList})
+	typ := byte(typeHashZipList)
+	if enc.useListPack() {
+		typ = typeHashListPack
+	}
+	err := enc.write([]byte{typ})
 	if err != nil {
 		return true, err
 	}
-	err = enc.writeString(key)
+	err = enc.writeKey(key)
 	if err != nil {
 		return true, err
 	}
@@ -217,9 +353,109 @@ func (enc *Encoder) tryWriteZipListHashMap(key string, hash map[string][]byte, o
 	for k, v := range hash {
 		entries = append(entries, k, unsafeBytes2Str(v))
 	}
-	err = enc.writeZipList(entries)
+	if enc.useListPack() {
+		err = enc.writeNanString(unsafeBytes2Str(encodeListPack(entries)))
+	} else {
+		err = enc.writeZipList(entries)
+	}
 	if err != nil {
 		return true, err
 	}
 	return true, nil
 }
+
+// WriteHashMetadataObject writes hash whose fields have expiration time in RDB_TYPE_HASH_METADATA encoding of
+// redis 7.4, it requires rdb version 12. Fields not found in expirations never expire
+func (enc *Encoder) WriteHashMetadataObject(key string, hash map[string][]byte, expirations map[string]time.Time,
+	options ...interface{}) error {
+	err := enc.requireVersion("hash field expiration", 12)
+	if err != nil {
+		return err
+	}
+	err = enc.beforeWriteObject(options...)
+	if err != nil {
+		return err
+	}
+	var minExpire uint64
+	for field, expiration := range expirations {
+		ms := uint64(expiration.UnixNano() / int64(time.Millisecond))
+		if _, ok := hash[field]; ok && (minExpire == 0 || ms < minExpire) {
+			minExpire = ms
+		}
+	}
+	err = enc.write([]byte{typeHashMetadata})
+	if err != nil {
+		return err
+	}
+	err = enc.writeKey(key)
+	if err != nil {
+		return err
+	}
+	err = enc.writeMillisecondTime(minExpire)
+	if err != nil {
+		return err
+	}
+	err = enc.writeLength(uint64(len(hash)))
+	if err != nil {
+		return err
+	}
+	for field, value := range hash {
+		var ttl uint64 // relative to minExpire, 0 means never expire
+		if expiration, ok := expirations[field]; ok {
+			ttl = uint64(expiration.UnixNano()/int64(time.Millisecond)) - minExpire + 1
+		}
+		err = enc.writeLength(ttl)
+		if err != nil {
+			return err
+		}
+		err = enc.writeString(field)
+		if err != nil {
+			return err
+		}
+		err = enc.writeString(unsafeBytes2Str(value))
+		if err != nil {
+			return err
+		}
+	}
+	enc.state = writtenObjectState
+	return nil
+}
+
+// HashWriter writes a large hash field by field in hashtable encoding.
+// Create it by Encoder.BeginHash
+type HashWriter struct {
+	*collectionWriter
+}
+
+// BeginHash starts writing a hash object with n fields.
+// Append exactly n fields then call End, no other object could be written before End
+func (enc *Encoder) BeginHash(key string, n uint64, options ...interface{}) (*HashWriter, error) {
+	cw, err := enc.beginCollection(typeHash, key, n, options...)
+	if err != nil {
+		return nil, err
+	}
+	return &HashWriter{collectionWriter: cw}, nil
+}
+
+// Append writes a field-value pair into hash, the invoker should guarantee fields are unique
+func (w *HashWriter) Append(field string, value []byte) error {
+	err := w.beforeAppend()
+	if err != nil {
+		return err
+	}
+	err = w.enc.writeString(field)
+	if err != nil {
+		return err
+	}
+	return w.enc.writeString(unsafeBytes2Str(value))
+}
+
+// End finishes the hash, it returns error if number of appended fields is not equal to declared
+func (w *HashWriter) End() error {
+	err := w.beforeEnd()
+	if err != nil {
+		return err
+	}
+	w.end()
+	return nil
+}
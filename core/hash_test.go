@@ -2,8 +2,10 @@ package core
 
 import (
 	"bytes"
+	"errors"
 	"github.com/hdt3213/rdb/model"
 	"testing"
+	"time"
 )
 
 func TestHashEncoding(t *testing.T) {
@@ -71,3 +73,146 @@ func TestHashEncoding(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestHashWriter(t *testing.T) {
+	m := make(map[string][]byte)
+	for i := 0; i < 1000; i++ {
+		m[RandString(32)] = []byte(RandString(64))
+	}
+	buf := bytes.NewBuffer(nil)
+	enc := NewEncoder(buf)
+	err := enc.WriteHeader()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = enc.WriteDBHeader(0, 1, 0)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	hw, err := enc.BeginHash("hash", uint64(len(m)))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	for field, value := range m {
+		err = hw.Append(field, value)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	err = hw.End()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = enc.WriteEnd()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	dec := NewDecoder(buf)
+	err = dec.Parse(func(object model.RedisObject) bool {
+		o := object.(*model.HashObject)
+		if o.GetElemCount() != len(m) {
+			t.Errorf("hash has wrong element count: %d", o.GetElemCount())
+			return true
+		}
+		for field, expectV := range m {
+			if !bytes.Equal(expectV, o.Hash[field]) {
+				t.Errorf("hash has wrong value at field %s", field)
+				return true
+			}
+		}
+		return true
+	})
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestHashFieldExpiration(t *testing.T) {
+	hash := map[string][]byte{"a": []byte("1"), "b": []byte("foo"), "c": []byte("bar")}
+	expireA := time.Unix(1700000000, 0)
+	expireC := time.Unix(1700000100, 5*int64(time.Millisecond))
+	expirations := map[string]time.Time{"a": expireA, "c": expireC}
+	lpEntries := []string{"a", "1", "1700000000000", "b", "foo", "0", "c", "bar", "1700000100005"}
+	buf := bytes.NewBuffer(nil)
+	enc := NewEncoder(buf).SetVersion(12)
+	errs := []error{
+		enc.WriteHeader(),
+		enc.WriteDBHeader(0, 5, 0),
+		enc.WriteHashMetadataObject("metadata", hash, expirations),
+		// pre-release formats store expiration time of each field directly
+		enc.write([]byte{typeHashMetadataPreGA}),
+		enc.writeString("metadata-pre-ga"),
+		enc.writeLength(3),
+		enc.writeLength(1700000000000), enc.writeString("a"), enc.writeString("1"),
+		enc.writeLength(0), enc.writeString("b"), enc.writeString("foo"),
+		enc.writeLength(1700000100005), enc.writeString("c"), enc.writeString("bar"),
+		enc.write([]byte{typeHashListPackEx}),
+		enc.writeString("listpack-ex"),
+		enc.writeMillisecondTime(1700000000000),
+		enc.writeNanString(unsafeBytes2Str(encodeListPack(lpEntries))),
+		enc.write([]byte{typeHashListPackExPreGA}),
+		enc.writeString("listpack-ex-pre-ga"),
+		enc.writeNanString(unsafeBytes2Str(encodeListPack(lpEntries))),
+		// functions of pre-release redis 7.0 are skipped
+		enc.write([]byte{opCodeFunction}),
+		enc.writeString("mylib"), enc.writeString("LUA"), enc.writeLength(1), enc.writeString("desc"),
+		enc.writeString("return 1"),
+	}
+	enc.state = writtenObjectState
+	errs = append(errs, enc.WriteHashMapObject("plain", hash), enc.WriteEnd())
+	for _, err := range errs {
+		if err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	var keys []string
+	err := NewDecoder(buf).Parse(func(object model.RedisObject) bool {
+		o := object.(*model.HashObject)
+		keys = append(keys, o.GetKey())
+		if len(o.Hash) != len(hash) || string(o.Hash["b"]) != "foo" {
+			t.Errorf("wrong hash %s: %v", o.GetKey(), o.Hash)
+		}
+		if o.GetKey() == "plain" {
+			if o.FieldExpirations != nil {
+				t.Errorf("hash %s should have no field expiration", o.GetKey())
+			}
+			return true
+		}
+		if len(o.FieldExpirations) != 2 || !o.FieldExpirations["a"].Equal(expireA) ||
+			!o.FieldExpirations["c"].Equal(expireC) {
+			t.Errorf("wrong field expirations of %s: %v", o.GetKey(), o.FieldExpirations)
+		}
+		return true
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(keys) != 5 {
+		t.Errorf("wrong keys: %v", keys)
+	}
+
+	object := &model.HashObject{
+		BaseObject:       &model.BaseObject{Key: "metadata"},
+		Hash:             hash,
+		FieldExpirations: expirations,
+	}
+	enc = NewEncoder(bytes.NewBuffer(nil)).SetVersion(11)
+	var versionErr *VersionError
+	if err := enc.CheckObject(object); !errors.As(err, &versionErr) {
+		t.Errorf("expect version error, actual: %v", err)
+	}
+	if err := enc.CheckObject(&model.HashObject{BaseObject: object.BaseObject, Hash: hash}); err != nil {
+		t.Error(err)
+	}
+	if err := enc.WriteObject(object); !errors.As(err, &versionErr) {
+		t.Errorf("expect version error, actual: %v", err)
+	}
+}
@@ -1,10 +1,15 @@
 package core
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"github.com/hdt3213/rdb/crc64jones"
+	"github.com/hdt3213/rdb/model"
+	"io"
 	"math"
+	"os"
 	"strconv"
 )
 
@@ -23,6 +28,11 @@ const (
 	zipBigPrevLen = 0xfe
 )
 
+const (
+	quickListNodePlain  = 1 // node of quicklist2 contains a single large element
+	quickListNodePacked = 2 // node of quicklist2 is a listpack
+)
+
 func (dec *Decoder) readList() ([][]byte, error) {
 	size64, _, err := dec.readLength()
 	if err != nil {
@@ -95,7 +105,7 @@ func (dec *Decoder) readZipListEntry(buf []byte, cursor *int) (result []byte, er
 		if err != nil {
 			return
 		}
-		result = []byte(strconv.FormatInt(int64(int8(b)), 10))
+		result = dec.formatInt(int64(int8(b)))
 		return
 	case zipInt16B:
 		var bs []byte
@@ -103,7 +113,7 @@ func (dec *Decoder) readZipListEntry(buf []byte, cursor *int) (result []byte, er
 		if err != nil {
 			return
 		}
-		result = []byte(strconv.FormatInt(int64(int16(binary.LittleEndian.Uint16(bs))), 10))
+		result = dec.formatInt(int64(int16(binary.LittleEndian.Uint16(bs))))
 		return
 	case zipInt32B:
 		var bs []byte
@@ -111,7 +121,7 @@ func (dec *Decoder) readZipListEntry(buf []byte, cursor *int) (result []byte, er
 		if err != nil {
 			return
 		}
-		result = []byte(strconv.FormatInt(int64(int32(binary.LittleEndian.Uint32(bs))), 10))
+		result = dec.formatInt(int64(int32(binary.LittleEndian.Uint32(bs))))
 		return
 	case zipInt64B:
 		var bs []byte
@@ -119,7 +129,7 @@ func (dec *Decoder) readZipListEntry(buf []byte, cursor *int) (result []byte, er
 		if err != nil {
 			return
 		}
-		result = []byte(strconv.FormatInt(int64(binary.LittleEndian.Uint64(bs)), 10))
+		result = dec.formatInt(int64(binary.LittleEndian.Uint64(bs)))
 		return
 	case zipInt24B:
 		var bs []byte
@@ -127,12 +137,12 @@ func (dec *Decoder) readZipListEntry(buf []byte, cursor *int) (result []byte, er
 		if err != nil {
 			return
 		}
-		bs = append([]byte{0}, bs...)
-		result = []byte(strconv.FormatInt(int64(int32(binary.LittleEndian.Uint32(bs))>>8), 10))
+		u := uint32(bs[0])<<8 | uint32(bs[1])<<16 | uint32(bs[2])<<24
+		result = dec.formatInt(int64(int32(u) >> 8))
 		return
 	}
 	if header>>4 == zipInt04B {
-		result = []byte(strconv.FormatInt(int64(header&0x0f)-1, 10))
+		result = dec.formatInt(int64(header&0x0f) - 1)
 		return
 	}
 	return nil, fmt.Errorf("unknown entry header")
@@ -154,33 +164,98 @@ func (dec *Decoder) readQuickList() ([][]byte, error) {
 	return entries, nil
 }
 
+func (dec *Decoder) readQuickList2() ([][]byte, error) {
+	size, _, err := dec.readLength()
+	if err != nil {
+		return nil, err
+	}
+	entries := make([][]byte, 0)
+	for i := 0; i < int(size); i++ {
+		container, _, err := dec.readLength()
+		if err != nil {
+			return nil, err
+		}
+		switch container {
+		case quickListNodePlain:
+			elem, err := dec.readString()
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, elem)
+		case quickListNodePacked:
+			page, err := dec.readListPack()
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, page...)
+		default:
+			return nil, fmt.Errorf("unknown quicklist node container: %d", container)
+		}
+	}
+	return entries, nil
+}
+
 func (enc *Encoder) WriteListObject(key string, values [][]byte, options ...interface{}) error {
 	err := enc.beforeWriteObject(options...)
 	if err != nil {
 		return err
 	}
-	ok, err := enc.tryWriteListZipList(key, values, options...)
+	var ok bool
+	encoding := getEncoding(options)
+	switch {
+	case encoding == model.LinkedListEncoding:
+		err = enc.writeListEncoding(key, values)
+	case enc.useListPack():
+		err = enc.writeQuickList2(key, values)
+	case encoding == model.QuickListEncoding || encoding == model.QuickList2Encoding:
+		err = enc.writeQuickList(key, values, options...)
+	case encoding == model.ZipListEncoding:
+		_, err = enc.tryWriteListZipList(key, values, true)
+	default:
+		ok, err = enc.tryWriteListZipList(key, values, false)
+		if err == nil && !ok {
+			err = enc.writeQuickList(key, values, options...)
+		}
+	}
 	if err != nil {
 		return err
 	}
-	if !ok {
-		err = enc.writeQuickList(key, values, options...)
+	enc.state = writtenObjectState
+	return nil
+}
+
+func (enc *Encoder) writeListEncoding(key string, values [][]byte) error {
+	err := enc.write([]byte{typeList})
+	if err != nil {
+		return err
+	}
+	err = enc.writeKey(key)
+	if err != nil {
+		return err
+	}
+	err = enc.writeLength(uint64(len(values)))
+	if err != nil {
+		return err
+	}
+	for _, value := range values {
+		err = enc.writeString(unsafeBytes2Str(value))
 		if err != nil {
 			return err
 		}
 	}
-	enc.state = writtenObjectState
 	return nil
 }
 
-func (enc *Encoder) tryWriteListZipList(key string, values [][]byte, options ...interface{}) (bool, error) {
-	if len(values) > enc.listZipListOpt.getMaxEntries() {
+// tryWriteListZipList writes list in ziplist encoding, it checks list-max-ziplist-value and list-max-ziplist-entries
+// unless force is true
+func (enc *Encoder) tryWriteListZipList(key string, values [][]byte, force bool) (bool, error) {
+	if len(values) >= math.MaxUint16 || !force && len(values) > enc.listZipListOpt.getMaxEntries() {
 		return false, nil
 	}
 	strList := make([]string, 0, len(values))
 	maxValue := enc.listZipListOpt.getMaxValue()
 	for _, v := range values {
-		if len(v) > maxValue {
+		if !force && len(v) > maxValue {
 			return false, nil
 		}
 		strList = append(strList, unsafeBytes2Str(v))
@@ -189,7 +264,7 @@ func (enc *Encoder) tryWriteListZipList(key string, values [][]byte, options ...
 	if err != nil {
 		return true, err
 	}
-	err = enc.writeString(key)
+	err = enc.writeKey(key)
 	if err != nil {
 		return true, err
 	}
@@ -200,7 +275,8 @@ func (enc *Encoder) tryWriteListZipList(key string, values [][]byte, options ...
 	return true, nil
 }
 
-func (enc *Encoder) writeQuickList(key string, values [][]byte, options ...interface{}) error {
+// quickListPages divides values into quicklist nodes by listZipListSize
+func (enc *Encoder) quickListPages(values [][]byte) [][]string {
 	var pages [][]string
 	pageSize := 0
 	var curPage []string
@@ -216,11 +292,16 @@ func (enc *Encoder) writeQuickList(key string, values [][]byte, options ...inter
 	if len(curPage) > 0 {
 		pages = append(pages, curPage)
 	}
+	return pages
+}
+
+func (enc *Encoder) writeQuickList(key string, values [][]byte, options ...interface{}) error {
+	pages := enc.quickListPages(values)
 	err := enc.write([]byte{typeListQuickList})
 	if err != nil {
 		return err
 	}
-	err = enc.writeString(key)
+	err = enc.writeKey(key)
 	if err != nil {
 		return err
 	}
@@ -237,6 +318,38 @@ func (enc *Encoder) writeQuickList(key string, values [][]byte, options ...inter
 	return nil
 }
 
+// writeQuickList2 writes list in quicklist2 encoding of redis 7.0, all nodes are listpacks
+func (enc *Encoder) writeQuickList2(key string, values [][]byte) error {
+	pages := enc.quickListPages(values)
+	err := enc.write([]byte{typeListQuickList2})
+	if err != nil {
+		return err
+	}
+	err = enc.writeKey(key)
+	if err != nil {
+		return err
+	}
+	err = enc.writeLength(uint64(len(pages)))
+	if err != nil {
+		return err
+	}
+	for _, page := range pages {
+		err = enc.writeQuickList2Node(page)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (enc *Encoder) writeQuickList2Node(page []string) error {
+	err := enc.writeLength(quickListNodePacked)
+	if err != nil {
+		return err
+	}
+	return enc.writeNanString(unsafeBytes2Str(encodeListPack(page)))
+}
+
 func encodeZipListEntry(prevLen uint32, val string) []byte {
 	buf := bytes.NewBuffer(nil)
 	// encode prevLen
@@ -281,8 +394,8 @@ func encodeZipListEntry(prevLen uint32, val string) []byte {
 	} else if len(val) <= maxUint14 {
 		buf.Write([]byte{byte(len(val)>>8) | len14BitMask, byte(len(val))})
 	} else if len(val) <= math.MaxUint32 {
-		buffer := make([]byte, 8)
-		binary.LittleEndian.PutUint32(buffer, uint32(len(val)))
+		buffer := make([]byte, 4)
+		binary.BigEndian.PutUint32(buffer, uint32(len(val)))
 		buf.Write([]byte{0x80})
 		buf.Write(buffer)
 	} else {
@@ -312,3 +425,126 @@ func (enc *Encoder) writeZipList(values []string) error {
 	binary.LittleEndian.PutUint16(buf[8:10], uint16(len(values)))
 	return enc.writeNanString(unsafeBytes2Str(buf))
 }
+
+// ListWriter writes a large list element by element, elements are packed into quicklist nodes on the fly.
+// Nodes are limited by both list-max-ziplist-entries and bytes like WriteListObject, so the number of nodes, which
+// precedes nodes in rdb, is known in the end. Nodes are spooled into a temporary file until End.
+// Create it by Encoder.BeginList
+type ListWriter struct {
+	*collectionWriter
+	nodeSize  int      // max entries in a quicklist node
+	node      []string // pending entries of current node
+	nodeBytes int      // bytes of pending entries
+	nodeCount uint64
+	spool     *os.File
+	spoolBuf  *bufio.Writer
+	spoolEnc  *Encoder // encodes nodes into spool
+}
+
+// BeginList starts writing a list object with n elements in quicklist encoding (quicklist2 if target version of
+// encoder is 10 or higher). Append exactly n elements then call End, no other object could be written before End
+func (enc *Encoder) BeginList(key string, n uint64, options ...interface{}) (*ListWriter, error) {
+	typ := byte(typeListQuickList)
+	if enc.useListPack() {
+		typ = typeListQuickList2
+	}
+	spool, err := os.CreateTemp("", "rdb-list-")
+	if err != nil {
+		return nil, fmt.Errorf("create spool of %s failed: %v", key, err)
+	}
+	cw, err := enc.beginCollection(typ, key, n, options...)
+	if err != nil {
+		_ = spool.Close()
+		_ = os.Remove(spool.Name())
+		return nil, err
+	}
+	nodeSize := enc.listZipListOpt.getMaxEntries()
+	spoolBuf := bufio.NewWriter(spool)
+	return &ListWriter{
+		collectionWriter: cw,
+		nodeSize:         nodeSize,
+		node:             make([]string, 0, nodeSize),
+		spool:            spool,
+		spoolBuf:         spoolBuf,
+		spoolEnc: &Encoder{
+			writer:   spoolBuf,
+			crc:      crc64jones.New(), // crc of rdb is updated when spool is copied
+			buffer:   make([]byte, 8),
+			compress: enc.compress,
+			version:  enc.version,
+		},
+	}, nil
+}
+
+// Append writes an element to the tail of list
+func (w *ListWriter) Append(elem []byte) error {
+	err := w.beforeAppend()
+	if err != nil {
+		return err
+	}
+	w.node = append(w.node, string(elem)) // copy elem, invoker may reuse it
+	w.nodeBytes += len(elem)
+	if len(w.node) < w.nodeSize && w.nodeBytes < w.enc.listZipListSize && w.written < w.expected {
+		return nil
+	}
+	if w.enc.useListPack() {
+		err = w.spoolEnc.writeQuickList2Node(w.node)
+	} else {
+		err = w.spoolEnc.writeZipList(w.node)
+	}
+	if err != nil {
+		return err
+	}
+	w.nodeCount++
+	w.node = w.node[:0]
+	w.nodeBytes = 0
+	return nil
+}
+
+// spoolWriter copies spooled nodes into rdb through encoder, so that crc is updated
+type spoolWriter struct {
+	enc *Encoder
+}
+
+func (w spoolWriter) Write(p []byte) (int, error) {
+	err := w.enc.write(p)
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// End finishes the list, it returns error if number of appended elements is not equal to declared.
+// Temporary file of nodes is removed by End even if it fails, the writer could not be used any more
+func (w *ListWriter) End() error {
+	err := w.beforeEnd()
+	if err != nil && w.closed {
+		return err
+	}
+	w.closed = true
+	defer func() {
+		_ = w.spool.Close()
+		_ = os.Remove(w.spool.Name())
+	}()
+	if err != nil {
+		return err
+	}
+	err = w.spoolBuf.Flush()
+	if err != nil {
+		return fmt.Errorf("write spool of %s failed: %v", w.key, err)
+	}
+	_, err = w.spool.Seek(0, io.SeekStart)
+	if err != nil {
+		return fmt.Errorf("read spool of %s failed: %v", w.key, err)
+	}
+	err = w.enc.writeLength(w.nodeCount)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(spoolWriter{enc: w.enc}, w.spool)
+	if err != nil {
+		return err
+	}
+	w.end()
+	return nil
+}
@@ -108,3 +108,148 @@ func TestZipListEncoding(t *testing.T) {
 		}
 	}
 }
+
+func TestListWriter(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	enc := NewEncoder(buf).SetListZipListOpt(64, 64)
+	err := enc.WriteHeader()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = enc.WriteDBHeader(0, 2, 0)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	n := 1021 // not a multiple of node size
+	var expect [][]byte
+	lw, err := enc.BeginList("large", uint64(n))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	for i := 0; i < n; i++ {
+		val := []byte(RandString(16))
+		expect = append(expect, val)
+		err = lw.Append(val)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	err = enc.WriteStringObject("a", []byte("a"))
+	if err == nil {
+		t.Error("expect error when writing object before list end")
+	}
+	err = lw.Append([]byte("overflow"))
+	if err == nil {
+		t.Error("expect error when appending too many elements")
+	}
+	err = lw.End()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = lw.Append([]byte("closed"))
+	if err == nil {
+		t.Error("expect error when appending to closed writer")
+	}
+	err = enc.WriteListObject("small", [][]byte{[]byte("1")})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = enc.WriteEnd()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	dec := NewDecoder(buf)
+	found := false
+	err = dec.Parse(func(object model.RedisObject) bool {
+		if object.GetKey() != "large" {
+			return true
+		}
+		found = true
+		o := object.(*model.ListObject)
+		if o.GetElemCount() != n {
+			t.Errorf("list has wrong element count: %d", o.GetElemCount())
+			return true
+		}
+		for i, expectV := range expect {
+			if !bytes.Equal(expectV, o.Values[i]) {
+				t.Errorf("list has wrong element at index %d", i)
+				return true
+			}
+		}
+		return true
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	if !found {
+		t.Error("list not found")
+	}
+
+	enc = NewEncoder(bytes.NewBuffer(nil))
+	_ = enc.WriteHeader()
+	_ = enc.WriteDBHeader(0, 1, 0)
+	_, err = enc.BeginList("empty", 0)
+	if err == nil {
+		t.Error("expect error for empty list")
+	}
+	lw, err = enc.BeginList("short", 2)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_ = lw.Append([]byte("a"))
+	err = lw.End()
+	if err == nil {
+		t.Error("expect error when appended elements is less than declared")
+	}
+}
+
+func TestListWriterNodeBytes(t *testing.T) {
+	var values [][]byte
+	for i := 0; i < 37; i++ {
+		values = append(values, []byte(RandString(1000)))
+	}
+	// nodes of large elements are limited by bytes, output should be the same as WriteListObject
+	for _, version := range []int{9, 11} {
+		expect := bytes.NewBuffer(nil)
+		enc := NewEncoder(expect).SetVersion(version)
+		_ = enc.WriteHeader()
+		_ = enc.WriteDBHeader(0, 1, 0)
+		err := enc.WriteListObject("l", values, WithEncoding(model.QuickListEncoding))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		actual := bytes.NewBuffer(nil)
+		enc = NewEncoder(actual).SetVersion(version)
+		_ = enc.WriteHeader()
+		_ = enc.WriteDBHeader(0, 1, 0)
+		lw, err := enc.BeginList("l", uint64(len(values)))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		for _, value := range values {
+			err = lw.Append(value)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+		}
+		err = lw.End()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if !bytes.Equal(expect.Bytes(), actual.Bytes()) {
+			t.Errorf("list writer of version %d differs from WriteListObject", version)
+		}
+	}
+}
@@ -0,0 +1,248 @@
+package core
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// listpack format: https://github.com/antirez/listpack/blob/master/listpack.md
+
+const (
+	lpEncoding7BitUintMask = 0x80 // 0xxxxxxx
+	lpEncoding6BitStr      = 0x80 // 10xxxxxx
+	lpEncoding6BitStrMask  = 0xc0
+	lpEncoding13BitInt     = 0xc0 // 110xxxxx
+	lpEncoding13BitIntMask = 0xe0
+	lpEncoding12BitStr     = 0xe0 // 1110xxxx
+	lpEncoding12BitStrMask = 0xf0
+	lpEncoding32BitStr     = 0xf0
+	lpEncoding16BitInt     = 0xf1
+	lpEncoding24BitInt     = 0xf2
+	lpEncoding32BitInt     = 0xf3
+	lpEncoding64BitInt     = 0xf4
+	lpEOF                  = 0xff
+
+	lpHeaderSize = 6 // 4 bytes total bytes + 2 bytes number of elements
+)
+
+// readListPackLength reads listpack header and moves cursor to the first entry
+func readListPackLength(buf []byte, cursor *int) (int, error) {
+	if len(buf) < lpHeaderSize+1 {
+		return 0, errors.New("listpack is too short")
+	}
+	size := int(binary.LittleEndian.Uint16(buf[4:6]))
+	*cursor = lpHeaderSize
+	if size == math.MaxUint16 {
+		// number of elements is unknown, count them
+		size = 0
+		c := lpHeaderSize
+		for c < len(buf) && buf[c] != lpEOF {
+			_, _, _, err := readListPackValue(buf, &c)
+			if err != nil {
+				return 0, err
+			}
+			size++
+		}
+	}
+	return size, nil
+}
+
+// readListPack reads a listpack string and returns all its entries
+func (dec *Decoder) readListPack() ([][]byte, error) {
+	buf, err := dec.readString()
+	if err != nil {
+		return nil, err
+	}
+	cursor := 0
+	size, err := readListPackLength(buf, &cursor)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([][]byte, 0, size)
+	for i := 0; i < size; i++ {
+		entry, val, isInt, err := readListPackValue(buf, &cursor)
+		if err != nil {
+			return nil, err
+		}
+		if isInt {
+			entry = dec.formatInt(val)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func lpBackLenSize(entryLen int) int {
+	if entryLen <= 127 {
+		return 1
+	} else if entryLen < 16383 {
+		return 2
+	} else if entryLen < 2097151 {
+		return 3
+	} else if entryLen < 268435455 {
+		return 4
+	}
+	return 5
+}
+
+// readListPackEntry reads an entry and its back-len, integers are formatted as decimal strings
+func readListPackEntry(buf []byte, cursor *int) ([]byte, error) {
+	result, val, isInt, err := readListPackValue(buf, cursor)
+	if err != nil {
+		return nil, err
+	}
+	if isInt {
+		return strconv.AppendInt(nil, val, 10), nil
+	}
+	return result, nil
+}
+
+// readListPackValue reads an entry and its back-len, integers are returned without formatting
+func readListPackValue(buf []byte, cursor *int) (result []byte, val int64, isInt bool, err error) {
+	start := *cursor
+	header, err := readByte(buf, cursor)
+	if err != nil {
+		return nil, 0, false, err
+	}
+	if header&lpEncoding7BitUintMask == 0 {
+		val, isInt = int64(header), true
+	} else if header&lpEncoding6BitStrMask == lpEncoding6BitStr {
+		length := int(header & 0x3f)
+		result, err = readBytes(buf, cursor, length)
+	} else if header&lpEncoding13BitIntMask == lpEncoding13BitInt {
+		var next byte
+		next, err = readByte(buf, cursor)
+		if err != nil {
+			return nil, 0, false, err
+		}
+		val = int64(header&0x1f)<<8 | int64(next)
+		if val >= 1<<12 {
+			val -= 1 << 13 // negative
+		}
+		isInt = true
+	} else if header&lpEncoding12BitStrMask == lpEncoding12BitStr {
+		var next byte
+		next, err = readByte(buf, cursor)
+		if err != nil {
+			return nil, 0, false, err
+		}
+		length := int(header&0x0f)<<8 | int(next)
+		result, err = readBytes(buf, cursor, length)
+	} else {
+		var bs []byte
+		switch header {
+		case lpEncoding16BitInt:
+			bs, err = readBytes(buf, cursor, 2)
+			if err == nil {
+				val, isInt = int64(int16(binary.LittleEndian.Uint16(bs))), true
+			}
+		case lpEncoding24BitInt:
+			bs, err = readBytes(buf, cursor, 3)
+			if err == nil {
+				u := uint32(bs[0])<<8 | uint32(bs[1])<<16 | uint32(bs[2])<<24
+				val, isInt = int64(int32(u)>>8), true
+			}
+		case lpEncoding32BitInt:
+			bs, err = readBytes(buf, cursor, 4)
+			if err == nil {
+				val, isInt = int64(int32(binary.LittleEndian.Uint32(bs))), true
+			}
+		case lpEncoding64BitInt:
+			bs, err = readBytes(buf, cursor, 8)
+			if err == nil {
+				val, isInt = int64(binary.LittleEndian.Uint64(bs)), true
+			}
+		case lpEncoding32BitStr:
+			bs, err = readBytes(buf, cursor, 4)
+			if err == nil {
+				length := int(binary.LittleEndian.Uint32(bs))
+				result, err = readBytes(buf, cursor, length)
+			}
+		default:
+			return nil, 0, false, fmt.Errorf("unknown listpack entry header: %x", header)
+		}
+	}
+	if err != nil {
+		return nil, 0, false, err
+	}
+	*cursor += lpBackLenSize(*cursor - start) // skip back-len
+	if *cursor > len(buf) {
+		return nil, 0, false, errors.New("cursor out of range")
+	}
+	return result, val, isInt, nil
+}
+
+func lpEncodeBackLen(buf []byte, entryLen int) []byte {
+	switch lpBackLenSize(entryLen) {
+	case 1:
+		return append(buf, byte(entryLen))
+	case 2:
+		return append(buf, byte(entryLen>>7), byte(entryLen&127)|128)
+	case 3:
+		return append(buf, byte(entryLen>>14), byte((entryLen>>7)&127)|128, byte(entryLen&127)|128)
+	case 4:
+		return append(buf, byte(entryLen>>21), byte((entryLen>>14)&127)|128,
+			byte((entryLen>>7)&127)|128, byte(entryLen&127)|128)
+	default:
+		return append(buf, byte(entryLen>>28), byte((entryLen>>21)&127)|128, byte((entryLen>>14)&127)|128,
+			byte((entryLen>>7)&127)|128, byte(entryLen&127)|128)
+	}
+}
+
+// appendListPackEntry encodes val and appends it to buf
+func appendListPackEntry(buf []byte, val string) []byte {
+	start := len(buf)
+	intVal, err := strconv.ParseInt(val, 10, 64)
+	if err == nil && strconv.FormatInt(intVal, 10) == val { // "007" or "+1" should be kept as string
+		if intVal >= 0 && intVal <= 127 {
+			buf = append(buf, byte(intVal))
+		} else if intVal >= -4096 && intVal <= 4095 {
+			u := uint64(intVal) & 0x1fff
+			buf = append(buf, lpEncoding13BitInt|byte(u>>8), byte(u))
+		} else if intVal >= math.MinInt16 && intVal <= math.MaxInt16 {
+			buf = append(buf, lpEncoding16BitInt, byte(intVal), byte(intVal>>8))
+		} else if intVal >= minInt24 && intVal <= maxInt24 {
+			buf = append(buf, lpEncoding24BitInt, byte(intVal), byte(intVal>>8), byte(intVal>>16))
+		} else if intVal >= math.MinInt32 && intVal <= math.MaxInt32 {
+			buf = append(buf, lpEncoding32BitInt)
+			buf = append(buf, make([]byte, 4)...)
+			binary.LittleEndian.PutUint32(buf[len(buf)-4:], uint32(intVal))
+		} else {
+			buf = append(buf, lpEncoding64BitInt)
+			buf = append(buf, make([]byte, 8)...)
+			binary.LittleEndian.PutUint64(buf[len(buf)-8:], uint64(intVal))
+		}
+	} else {
+		length := len(val)
+		if length < 64 {
+			buf = append(buf, lpEncoding6BitStr|byte(length))
+		} else if length < 4096 {
+			buf = append(buf, lpEncoding12BitStr|byte(length>>8), byte(length))
+		} else {
+			buf = append(buf, lpEncoding32BitStr)
+			buf = append(buf, make([]byte, 4)...)
+			binary.LittleEndian.PutUint32(buf[len(buf)-4:], uint32(length))
+		}
+		buf = append(buf, val...)
+	}
+	return lpEncodeBackLen(buf, len(buf)-start)
+}
+
+// encodeListPack returns a serialized listpack of values
+func encodeListPack(values []string) []byte {
+	buf := make([]byte, lpHeaderSize)
+	for _, val := range values {
+		buf = appendListPackEntry(buf, val)
+	}
+	buf = append(buf, lpEOF)
+	binary.LittleEndian.PutUint32(buf[0:4], uint32(len(buf)))
+	size := len(values)
+	if size > math.MaxUint16 {
+		size = math.MaxUint16 // unknown size
+	}
+	binary.LittleEndian.PutUint16(buf[4:6], uint16(size))
+	return buf
+}
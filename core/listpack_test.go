@@ -0,0 +1,56 @@
+package core
+
+import (
+	"testing"
+)
+
+func TestListPackEncoding(t *testing.T) {
+	values := []string{
+		"0",
+		"127",
+		"128",
+		"-1",
+		"4095",
+		"-4096",
+		"4096",
+		"32767",
+		"-32768",
+		"8388607",
+		"-8388608",
+		"2147483647",
+		"-2147483648",
+		"9223372036854775807",
+		"007",
+		"+1",
+		"",
+		"a",
+		RandString(63),
+		RandString(64),
+		RandString(4095),
+		RandString(4096),
+	}
+	buf := encodeListPack(values)
+	cursor := 0
+	size, err := readListPackLength(buf, &cursor)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if size != len(values) {
+		t.Errorf("wrong size: %d", size)
+		return
+	}
+	for i, expect := range values {
+		actual, err := readListPackEntry(buf, &cursor)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if string(actual) != expect {
+			t.Errorf("illegal value at %d", i)
+		}
+	}
+	if buf[cursor] != lpEOF {
+		t.Error("expect listpack end")
+	}
+}
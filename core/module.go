@@ -0,0 +1,194 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	moduleOpCodeEOF    = 0 /* End of module value. */
+	moduleOpCodeSInt   = 1
+	moduleOpCodeUInt   = 2
+	moduleOpCodeFloat  = 3
+	moduleOpCodeDouble = 4
+	moduleOpCodeString = 5
+
+	// ModuleAuxBeforeRDB means module aux data is loaded before keyspace (REDISMODULE_AUX_BEFORE_RDB)
+	ModuleAuxBeforeRDB = 1 << 0
+	// ModuleAuxAfterRDB means module aux data is loaded after keyspace (REDISMODULE_AUX_AFTER_RDB)
+	ModuleAuxAfterRDB = 1 << 1
+)
+
+// moduleTypeNameCharSet is used to encode module type name into 64 bit module id
+const moduleTypeNameCharSet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-_"
+
+// ModuleTypeName decodes module id into 9 characters module type name and encoding version
+func ModuleTypeName(moduleID uint64) (string, int) {
+	name := make([]byte, 9)
+	id := moduleID >> 10
+	for i := 8; i >= 0; i-- {
+		name[i] = moduleTypeNameCharSet[id&63]
+		id >>= 6
+	}
+	return string(name), int(moduleID & 1023)
+}
+
+// ModuleTypeID encodes 9 characters module type name and encoding version into module id
+func ModuleTypeID(name string, encVer int) (uint64, error) {
+	if len(name) != 9 {
+		return 0, errors.New("module type name must be 9 characters")
+	}
+	if encVer < 0 || encVer > 1023 {
+		return 0, errors.New("module encoding version must be in [0, 1023]")
+	}
+	var id uint64
+	for i := 0; i < len(name); i++ {
+		pos := strings.IndexByte(moduleTypeNameCharSet, name[i])
+		if pos < 0 {
+			return 0, fmt.Errorf("illegal character in module type name: %c", name[i])
+		}
+		id = id<<6 | uint64(pos)
+	}
+	return id<<10 | uint64(encVer), nil
+}
+
+// readModuleValue reads module serialized value as an opaque blob by walking its opcodes
+func (dec *Decoder) readModuleValue() ([]byte, error) {
+	dec.startCapture()
+	defer dec.stopCapture()
+	for {
+		opCode, _, err := dec.readLength()
+		if err != nil {
+			return nil, err
+		}
+		switch opCode {
+		case moduleOpCodeEOF:
+			return dec.captured, nil
+		case moduleOpCodeSInt, moduleOpCodeUInt:
+			_, _, err = dec.readLength()
+		case moduleOpCodeFloat:
+			err = dec.readFull(dec.buffer[:4])
+		case moduleOpCodeDouble:
+			err = dec.readFull(dec.buffer)
+		case moduleOpCodeString:
+			_, err = dec.readString()
+		default:
+			return nil, fmt.Errorf("unknown module opcode: %d", opCode)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// readModuleAux reads RDB_OPCODE_MODULE_AUX, returns module id, when and value
+func (dec *Decoder) readModuleAux() (uint64, uint64, []byte, error) {
+	moduleID, _, err := dec.readLength()
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	whenOpCode, _, err := dec.readLength()
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	if whenOpCode != moduleOpCodeUInt {
+		return 0, 0, nil, errors.New("bad when opcode of module aux")
+	}
+	when, _, err := dec.readLength()
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	value, err := dec.readModuleValue()
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	return moduleID, when, value, nil
+}
+
+// WriteModuleObject writes value of a module type (RDB_TYPE_MODULE_2).
+// value is serialized by module in RDB module opcode format, including the trailing RDB_MODULE_OPCODE_EOF
+func (enc *Encoder) WriteModuleObject(key string, moduleID uint64, value []byte, options ...interface{}) error {
+	err := enc.requireVersion("module values", 8)
+	if err != nil {
+		return err
+	}
+	err = enc.beforeWriteObject(options...)
+	if err != nil {
+		return err
+	}
+	err = enc.write([]byte{typeModule2})
+	if err != nil {
+		return err
+	}
+	err = enc.writeKey(key)
+	if err != nil {
+		return err
+	}
+	err = enc.writeLength(moduleID)
+	if err != nil {
+		return err
+	}
+	err = enc.write(value)
+	if err != nil {
+		return err
+	}
+	enc.state = writtenObjectState
+	return nil
+}
+
+// WriteModuleAux writes module auxiliary data, when is ModuleAuxBeforeRDB or ModuleAuxAfterRDB.
+// value is serialized by module in RDB module opcode format, including the trailing RDB_MODULE_OPCODE_EOF
+func (enc *Encoder) WriteModuleAux(moduleID uint64, when uint64, value []byte) error {
+	if !enc.validateStateChange(writtenModuleAuxState) {
+		return fmt.Errorf("cannot writing module aux at state: %s", enc.state)
+	}
+	err := enc.requireVersion("module aux data", 9)
+	if err != nil {
+		return err
+	}
+	err = enc.write([]byte{opCodeModuleAux})
+	if err != nil {
+		return err
+	}
+	err = enc.writeLength(moduleID)
+	if err != nil {
+		return err
+	}
+	err = enc.writeLength(moduleOpCodeUInt)
+	if err != nil {
+		return err
+	}
+	err = enc.writeLength(when)
+	if err != nil {
+		return err
+	}
+	err = enc.write(value)
+	if err != nil {
+		return err
+	}
+	enc.state = writtenModuleAuxState
+	return nil
+}
+
+// WriteFunction writes a function library (RDB_OPCODE_FUNCTION2), code is the library source code
+// starts with shebang, such as "#!lua name=mylib ...". Functions must be written before the first db
+func (enc *Encoder) WriteFunction(code string) error {
+	if !enc.validateStateChange(writtenFunctionState) || len(enc.existDB) > 0 {
+		return fmt.Errorf("cannot writing function at state: %s", enc.state)
+	}
+	err := enc.requireVersion("functions", 10)
+	if err != nil {
+		return err
+	}
+	err = enc.write([]byte{opCodeFunction2})
+	if err != nil {
+		return err
+	}
+	err = enc.writeNanString(code)
+	if err != nil {
+		return err
+	}
+	enc.state = writtenFunctionState
+	return nil
+}
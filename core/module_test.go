@@ -0,0 +1,110 @@
+package core
+
+import (
+	"bytes"
+	"github.com/hdt3213/rdb/model"
+	"testing"
+)
+
+func TestModuleTypeID(t *testing.T) {
+	id, err := ModuleTypeID("ReJSON-RL", 3)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	name, encVer := ModuleTypeName(id)
+	if name != "ReJSON-RL" || encVer != 3 {
+		t.Errorf("wrong module type: %s %d", name, encVer)
+	}
+	_, err = ModuleTypeID("short", 0)
+	if err == nil {
+		t.Error("expect error for short name")
+	}
+	_, err = ModuleTypeID("ReJSON+RL", 0)
+	if err == nil {
+		t.Error("expect error for illegal character")
+	}
+}
+
+func TestModuleAndFunctionEncoding(t *testing.T) {
+	moduleID, _ := ModuleTypeID("testmodul", 1)
+	// uint 42, string "hello", double, eof
+	moduleValue := []byte{moduleOpCodeUInt, 42, moduleOpCodeString, 5, 'h', 'e', 'l', 'l', 'o',
+		moduleOpCodeDouble, 0, 0, 0, 0, 0, 0, 0xf0, 0x3f, moduleOpCodeEOF}
+	auxValue := []byte{moduleOpCodeSInt, 1, moduleOpCodeEOF}
+	code := "#!lua name=mylib\nredis.register_function('hello', function() return 'hello' end)"
+	buf := bytes.NewBuffer(nil)
+	enc := NewEncoder(buf)
+	err := enc.WriteHeader()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = enc.WriteAux("redis-ver", "7.2.0")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = enc.WriteModuleAux(moduleID, ModuleAuxBeforeRDB, auxValue)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = enc.WriteFunction(code)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = enc.WriteDBHeader(0, 1, 0)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = enc.WriteModuleObject("mod", moduleID, moduleValue)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = enc.WriteFunction(code)
+	if err == nil {
+		t.Error("expect error when writing function after db")
+	}
+	err = enc.WriteModuleAux(moduleID, ModuleAuxAfterRDB, auxValue)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = enc.WriteEnd()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	dec := NewDecoder(buf).WithSpecialOpCode()
+	var functionCount, moduleCount, moduleAuxCount int
+	err = dec.Parse(func(object model.RedisObject) bool {
+		switch o := object.(type) {
+		case *model.FunctionsObject:
+			functionCount++
+			if o.Code != code {
+				t.Error("wrong function code")
+			}
+		case *model.ModuleAuxObject:
+			moduleAuxCount++
+			if o.ModuleID != moduleID || o.ModuleName != "testmodul" || !bytes.Equal(o.Value, auxValue) {
+				t.Error("wrong module aux")
+			}
+		case *model.ModuleObject:
+			moduleCount++
+			if o.GetKey() != "mod" || o.ModuleID != moduleID || !bytes.Equal(o.Value, moduleValue) {
+				t.Error("wrong module value")
+			}
+		}
+		return true
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	if functionCount != 1 || moduleCount != 1 || moduleAuxCount != 2 {
+		t.Errorf("wrong object count: %d %d %d", functionCount, moduleCount, moduleAuxCount)
+	}
+}
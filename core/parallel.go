@@ -0,0 +1,321 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/hdt3213/rdb/model"
+	"io"
+	"runtime"
+	"sync"
+)
+
+// WithWorkers makes Parse read rdb in one goroutine and decode values in n goroutines, callback of Parse is still
+// called in file order by the goroutine calling Parse. n <= 1 disables parallel decoding, see ParseParallel
+func (dec *Decoder) WithWorkers(n int) *Decoder {
+	dec.workers = n
+	return dec
+}
+
+// Workers returns number of goroutines decoding values set by WithWorkers
+func (dec *Decoder) Workers() int {
+	return dec.workers
+}
+
+// parallelJob is a key passed from reader to workers, and its result passed from workers to output
+type parallelJob struct {
+	flag   byte
+	base   *model.BaseObject
+	raw    []byte            // value read but not decoded
+	object model.RedisObject // value decoded by reader, such as stream, or special objects
+	result interface{}
+	err    error
+	done   chan struct{} // closed when result is ready, only for ordered output
+}
+
+// ParseParallel parses rdb in a pipeline: one goroutine reads rdb and splits it into keys, workers decode values,
+// such as ziplists and LZF compressed strings, and call process concurrently, then output receives results of process
+// in the goroutine calling ParseParallel. Results are received in file order if ordered is true, otherwise as soon as
+// they are ready. Number of workers is set by WithWorkers, runtime.NumCPU() by default.
+// process must be safe for concurrent use, output returns false to stop the iteration.
+// Streams and module values are decoded by the reading goroutine, callback of WithCodeCallback is called by it too
+func (dec *Decoder) ParseParallel(ordered bool, process func(object model.RedisObject) interface{},
+	output func(result interface{}) bool) error {
+	workers := dec.workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	jobs := make(chan *parallelJob, workers*4)
+	queue := make(chan *parallelJob, workers*4) // jobs in file order for ordered output
+	results := make(chan *parallelJob, workers*4)
+	stop := make(chan struct{})
+
+	var readErr error
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(jobs)
+		defer close(queue)
+		readErr = dec.readJobs(func(job *parallelJob) bool {
+			if ordered {
+				job.done = make(chan struct{})
+				select {
+				case queue <- job:
+				case <-stop:
+					return false
+				}
+			}
+			select {
+			case jobs <- job:
+				return true
+			case <-stop:
+				return false
+			}
+		})
+	}()
+	var workerWG sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		workerWG.Add(1)
+		go func() {
+			defer workerWG.Done()
+			for job := range jobs {
+				select {
+				case <-stop: // remaining jobs are dropped
+				default:
+					dec.runJob(job, process)
+				}
+				if ordered {
+					close(job.done)
+					continue
+				}
+				select {
+				case results <- job:
+				case <-stop:
+				}
+			}
+		}()
+	}
+	go func() {
+		workerWG.Wait()
+		close(results)
+	}()
+
+	var err error
+	stopped := false
+	consume := func(job *parallelJob) {
+		if job.err != nil {
+			err = job.err
+		} else if output(job.result) {
+			return
+		}
+		stopped = true
+		close(stop)
+	}
+	if ordered {
+		for job := range queue {
+			<-job.done
+			consume(job)
+			if stopped {
+				break
+			}
+		}
+	} else {
+		for job := range results {
+			consume(job)
+			if stopped {
+				break
+			}
+		}
+	}
+	// wait for goroutines to exit, so that decoder could be reused
+	wg.Wait()
+	workerWG.Wait()
+	if stopped {
+		return err
+	}
+	return readErr
+}
+
+// readJobs reads rdb and passes keys to cb, values of strings and collections of strings are not decoded
+func (dec *Decoder) readJobs(cb func(job *parallelJob) bool) (err error) {
+	defer func() {
+		if err2 := recover(); err2 != nil {
+			err = fmt.Errorf("panic: %v", err2)
+		}
+	}()
+	// keys are used by workers after the reader moves on, so they must not be allocated from arena
+	reused := dec.arena
+	dec.arena = nil
+	defer func() {
+		dec.frameCallback = nil
+		dec.arena = reused
+	}()
+	dec.frameCallback = func(flag byte, base *model.BaseObject, raw []byte) bool {
+		return cb(&parallelJob{
+			flag: flag,
+			base: base,
+			raw:  raw,
+		})
+	}
+	err = dec.checkHeader()
+	if err != nil {
+		return err
+	}
+	return dec.parse(func(object model.RedisObject) bool {
+		return cb(&parallelJob{object: object})
+	})
+}
+
+// runJob decodes value of job and calls process
+func (dec *Decoder) runJob(job *parallelJob, process func(object model.RedisObject) interface{}) {
+	defer func() {
+		if err := recover(); err != nil {
+			job.err = fmt.Errorf("panic: %v", err)
+		}
+	}()
+	object := job.object
+	if object == nil {
+		valueDec := NewDecoder(bytes.NewReader(job.raw))
+		valueDec.version = dec.version
+		valueDec.rawIntegers = dec.rawIntegers
+		var err error
+		object, err = valueDec.readObject(job.flag, job.base)
+		if err != nil {
+			job.err = err
+			return
+		}
+		job.base.Type = object.GetType()
+	}
+	job.raw = nil
+	job.result = process(object)
+}
+
+// isFramedType returns whether value of the type is read by readRawValue and decoded by workers during parallel parsing
+func isFramedType(flag byte) bool {
+	switch flag {
+	case typeString, typeList, typeSet, typeZset, typeHash, typeZset2, typeHashZipMap, typeListZipList,
+		typeSetIntSet, typeZsetZipList, typeHashZipList, typeListQuickList, typeHashListPack, typeZsetListPack,
+		typeListQuickList2, typeSetListPack:
+		return true
+	}
+	return false
+}
+
+// readRawValue reads raw bytes of value without decoding it, flag must be a framed type
+func (dec *Decoder) readRawValue(flag byte) ([]byte, error) {
+	dec.startCapture()
+	err := dec.skipValue(flag)
+	raw := dec.stopCapture()
+	return raw, err
+}
+
+func (dec *Decoder) skipValue(flag byte) error {
+	switch flag {
+	case typeList, typeSet, typeListQuickList, typeHash, typeZset, typeZset2, typeListQuickList2:
+	default: // a string, or a ziplist, listpack, intset or zipmap stored as string
+		return dec.skipString()
+	}
+	n, _, err := dec.readLength() // number of elements
+	if err != nil {
+		return err
+	}
+	return dec.skipElements(flag, n)
+}
+
+// skipElements skips n elements of list, set, hash or sorted set, or n nodes of quicklist
+func (dec *Decoder) skipElements(flag byte, n uint64) error {
+	switch flag {
+	case typeList, typeSet, typeListQuickList:
+		for i := uint64(0); i < n; i++ {
+			if err := dec.skipString(); err != nil {
+				return err
+			}
+		}
+	case typeHash:
+		for i := uint64(0); i < n*2; i++ {
+			if err := dec.skipString(); err != nil {
+				return err
+			}
+		}
+	case typeZset, typeZset2:
+		for i := uint64(0); i < n; i++ {
+			if err := dec.skipString(); err != nil {
+				return err
+			}
+			if flag == typeZset2 {
+				if err := dec.skipBytes(8); err != nil {
+					return err
+				}
+				continue
+			}
+			size, err := dec.readByte()
+			if err != nil {
+				return err
+			}
+			if size < 0xfd { // 0xfd, 0xfe and 0xff are NaN and infinities
+				if err := dec.skipBytes(int(size)); err != nil {
+					return err
+				}
+			}
+		}
+	case typeListQuickList2:
+		for i := uint64(0); i < n; i++ {
+			if _, _, err := dec.readLength(); err != nil { // container
+				return err
+			}
+			if err := dec.skipString(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// skipString reads a string without decompressing it
+func (dec *Decoder) skipString() error {
+	length, special, err := dec.readLength()
+	if err != nil {
+		return err
+	}
+	if !special {
+		return dec.skipBytes(int(length))
+	}
+	switch length {
+	case encodeInt8:
+		return dec.skipBytes(1)
+	case encodeInt16:
+		return dec.skipBytes(2)
+	case encodeInt32:
+		return dec.skipBytes(4)
+	case encodeLZF:
+		inLen, _, err := dec.readLength()
+		if err != nil {
+			return err
+		}
+		_, _, err = dec.readLength() // outLen
+		if err != nil {
+			return err
+		}
+		return dec.skipBytes(int(inLen))
+	}
+	return fmt.Errorf("unknown string encode type: %d", length)
+}
+
+// skipBytes reads n bytes, they are kept only if decoder is capturing
+func (dec *Decoder) skipBytes(n int) error {
+	if !dec.capturing {
+		discarded, err := dec.input.Discard(n)
+		dec.readCount += discarded
+		return err
+	}
+	start := len(dec.captured)
+	if cap(dec.captured)-start < n {
+		captured := make([]byte, start, start+n)
+		copy(captured, dec.captured)
+		dec.captured = captured
+	}
+	dec.captured = dec.captured[:start+n]
+	read, err := io.ReadFull(dec.input, dec.captured[start:])
+	dec.readCount += read
+	return err
+}
@@ -0,0 +1,104 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/hdt3213/rdb/model"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestParseParallel(t *testing.T) {
+	filenames, err := filepath.Glob(filepath.Join("..", "cases", "*.rdb"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	marshal := func(object model.RedisObject) interface{} {
+		data, _ := json.Marshal(object)
+		return string(data)
+	}
+	for _, filename := range filenames {
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		var expect []string
+		err = NewDecoder(bytes.NewReader(data)).WithSpecialOpCode().Parse(func(object model.RedisObject) bool {
+			expect = append(expect, marshal(object).(string))
+			return true
+		})
+		if err != nil {
+			t.Errorf("%s: %v", filename, err)
+			continue
+		}
+
+		var actual []string
+		dec := NewDecoder(bytes.NewReader(data)).WithSpecialOpCode().WithWorkers(4)
+		err = dec.Parse(func(object model.RedisObject) bool {
+			actual = append(actual, marshal(object).(string))
+			return true
+		})
+		if err != nil {
+			t.Errorf("%s: %v", filename, err)
+			continue
+		}
+		if strings.Join(actual, "\n") != strings.Join(expect, "\n") {
+			t.Errorf("%s: wrong result of parallel parsing", filename)
+		}
+
+		actual = nil
+		dec = NewDecoder(bytes.NewReader(data)).WithSpecialOpCode().WithWorkers(3)
+		err = dec.ParseParallel(false, marshal, func(result interface{}) bool {
+			actual = append(actual, result.(string))
+			return true
+		})
+		if err != nil {
+			t.Errorf("%s: %v", filename, err)
+			continue
+		}
+		sort.Strings(actual)
+		sorted := append([]string{}, expect...)
+		sort.Strings(sorted)
+		if strings.Join(actual, "\n") != strings.Join(sorted, "\n") {
+			t.Errorf("%s: wrong result of unordered parallel parsing", filename)
+		}
+	}
+}
+
+func TestParseParallelStop(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("..", "cases", "memory.rdb"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	for _, ordered := range []bool{true, false} {
+		count := 0
+		err = NewDecoder(bytes.NewReader(data)).WithWorkers(2).ParseParallel(ordered, func(object model.RedisObject) interface{} {
+			return object
+		}, func(result interface{}) bool {
+			count++
+			return count < 3
+		})
+		if err != nil {
+			t.Error(err)
+		}
+		if count != 3 {
+			t.Errorf("expect stop after 3 objects, actual %d", count)
+		}
+	}
+
+	// truncated rdb reports error after keys before it
+	count := 0
+	err = NewDecoder(bytes.NewReader(data[:len(data)/2])).WithWorkers(2).Parse(func(object model.RedisObject) bool {
+		count++
+		return true
+	})
+	if err == nil || count == 0 {
+		t.Errorf("expect error of truncated rdb after %d keys, actual %v", count, err)
+	}
+}
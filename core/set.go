@@ -3,6 +3,7 @@ package core
 import (
 	"encoding/binary"
 	"fmt"
+	"github.com/hdt3213/rdb/model"
 	"math"
 	"sort"
 	"strconv"
@@ -46,16 +47,16 @@ func (dec *Decoder) readIntSet() (result [][]byte, err error) {
 		if err != nil {
 			return
 		}
-		var intString string
+		var val int64
 		switch intSize {
 		case 2:
-			intString = strconv.FormatInt(int64(int16(binary.LittleEndian.Uint16(intBytes))), 10)
+			val = int64(int16(binary.LittleEndian.Uint16(intBytes)))
 		case 4:
-			intString = strconv.FormatInt(int64(int32(binary.LittleEndian.Uint32(intBytes))), 10)
+			val = int64(int32(binary.LittleEndian.Uint32(intBytes)))
 		case 8:
-			intString = strconv.FormatInt(int64(int64(binary.LittleEndian.Uint64(intBytes))), 10)
+			val = int64(binary.LittleEndian.Uint64(intBytes))
 		}
-		result = append(result, []byte(intString))
+		result = append(result, dec.formatInt(val))
 	}
 	return
 }
@@ -65,17 +66,23 @@ func (enc *Encoder) WriteSetObject(key string, values [][]byte, options ...inter
 	if err != nil {
 		return err
 	}
-	ok, err := enc.tryWriteIntSetEncoding(key, values)
-	if err != nil {
-		return err
-	}
-	if ok {
-		return nil
+	ok := false
+	switch encoding := getEncoding(options); {
+	case encoding == model.ListPackEncoding && enc.version >= 11:
+		err = enc.writeListPackSet(key, values)
+		ok = true
+	case encoding != model.HashTableEncoding:
+		ok, err = enc.tryWriteIntSetEncoding(key, values)
 	}
-	err = enc.writeSetEncoding(key, values)
 	if err != nil {
 		return err
 	}
+	if !ok {
+		err = enc.writeSetEncoding(key, values)
+		if err != nil {
+			return err
+		}
+	}
 	enc.state = writtenObjectState
 	return nil
 }
@@ -85,7 +92,7 @@ func (enc *Encoder) writeSetEncoding(key string, values [][]byte) error {
 	if err != nil {
 		return err
 	}
-	err = enc.writeString(key)
+	err = enc.writeKey(key)
 	if err != nil {
 		return err
 	}
@@ -102,6 +109,23 @@ func (enc *Encoder) writeSetEncoding(key string, values [][]byte) error {
 	return nil
 }
 
+// writeListPackSet writes set in listpack encoding of redis 7.2
+func (enc *Encoder) writeListPackSet(key string, values [][]byte) error {
+	err := enc.write([]byte{typeSetListPack})
+	if err != nil {
+		return err
+	}
+	err = enc.writeKey(key)
+	if err != nil {
+		return err
+	}
+	members := make([]string, 0, len(values))
+	for _, v := range values {
+		members = append(members, unsafeBytes2Str(v))
+	}
+	return enc.writeNanString(unsafeBytes2Str(encodeListPack(members)))
+}
+
 func (enc *Encoder) tryWriteIntSetEncoding(key string, values [][]byte) (bool, error) {
 	max := int64(math.MinInt64)
 	min := int64(math.MaxInt64)
@@ -133,7 +157,7 @@ func (enc *Encoder) tryWriteIntSetEncoding(key string, values [][]byte) (bool, e
 	if err != nil {
 		return true, err
 	}
-	err = enc.writeString(key)
+	err = enc.writeKey(key)
 	if err != nil {
 		return true, err
 	}
@@ -160,3 +184,38 @@ func (enc *Encoder) tryWriteIntSetEncoding(key string, values [][]byte) (bool, e
 	}
 	return true, nil
 }
+
+// SetWriter writes a large set member by member in hashtable encoding.
+// Create it by Encoder.BeginSet
+type SetWriter struct {
+	*collectionWriter
+}
+
+// BeginSet starts writing a set object with n members.
+// Append exactly n members then call End, no other object could be written before End
+func (enc *Encoder) BeginSet(key string, n uint64, options ...interface{}) (*SetWriter, error) {
+	cw, err := enc.beginCollection(typeSet, key, n, options...)
+	if err != nil {
+		return nil, err
+	}
+	return &SetWriter{collectionWriter: cw}, nil
+}
+
+// Append writes a member into set, the invoker should guarantee members are unique
+func (w *SetWriter) Append(member []byte) error {
+	err := w.beforeAppend()
+	if err != nil {
+		return err
+	}
+	return w.enc.writeString(unsafeBytes2Str(member))
+}
+
+// End finishes the set, it returns error if number of appended members is not equal to declared
+func (w *SetWriter) End() error {
+	err := w.beforeEnd()
+	if err != nil {
+		return err
+	}
+	w.end()
+	return nil
+}
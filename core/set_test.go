@@ -2,8 +2,10 @@ package core
 
 import (
 	"bytes"
+	"errors"
 	"github.com/hdt3213/rdb/model"
 	"testing"
+	"time"
 )
 
 func TestSetEncoding(t *testing.T) {
@@ -71,3 +73,98 @@ func TestSetEncoding(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestSetWriter(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	enc := NewEncoder(buf)
+	err := enc.WriteHeader()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = enc.WriteDBHeader(0, 1, 1)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	expireAt := uint64(time.Now().Add(time.Hour).UnixNano() / int64(time.Millisecond))
+	members := make(map[string]struct{})
+	for len(members) < 1000 {
+		members[RandString(32)] = struct{}{}
+	}
+	sw, err := enc.BeginSet("set", uint64(len(members)), WithTTL(expireAt))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	for m := range members {
+		err = sw.Append([]byte(m))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	err = sw.End()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = enc.WriteEnd()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	dec := NewDecoder(buf)
+	err = dec.Parse(func(object model.RedisObject) bool {
+		o := object.(*model.SetObject)
+		if o.GetElemCount() != len(members) {
+			t.Errorf("set has wrong element count: %d", o.GetElemCount())
+			return true
+		}
+		for _, m := range o.Members {
+			if _, ok := members[string(m)]; !ok {
+				t.Errorf("unexpected member: %s", m)
+				return true
+			}
+		}
+		if o.GetExpiration() == nil || uint64(o.GetExpiration().UnixNano()/int64(time.Millisecond)) != expireAt {
+			t.Error("set has wrong ttl")
+		}
+		return true
+	})
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+// failOnceWriter fails on the first write of data equal to fail
+type failOnceWriter struct {
+	buf  bytes.Buffer
+	fail []byte
+}
+
+func (w *failOnceWriter) Write(p []byte) (int, error) {
+	if w.fail != nil && bytes.Equal(p, w.fail) {
+		w.fail = nil
+		return 0, errors.New("write failed")
+	}
+	return w.buf.Write(p)
+}
+
+func TestBeginCollectionError(t *testing.T) {
+	writer := &failOnceWriter{}
+	enc := NewEncoder(writer)
+	_ = enc.WriteHeader()
+	_ = enc.WriteDBHeader(0, 1, 0)
+	writer.fail = []byte{13} // length of set
+	_, err := enc.BeginSet("s", 13)
+	if err == nil {
+		t.Error("expect write error")
+		return
+	}
+	// encoder is not stuck in writing object
+	err = enc.WriteStringObject("a", []byte("a"))
+	if err != nil {
+		t.Error(err)
+	}
+}
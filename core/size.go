@@ -0,0 +1,187 @@
+package core
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/hdt3213/rdb/model"
+)
+
+// WithSizeOnly makes Parse skip values of strings, lists, sets, hashes and sorted sets without decoding them, such keys
+// are passed to callback as model.SizedObject which only knows type, encoding, size and number of elements.
+// Compressed ziplists and listpacks are still read to count their elements, but they are dropped at once.
+// Streams and module values are decoded as usual. Parse ignores workers set by WithWorkers in this mode
+func (dec *Decoder) WithSizeOnly() *Decoder {
+	dec.sizeOnly = true
+	return dec
+}
+
+// readSizedObject skips value and counts its elements, flag must be a framed type, see isFramedType
+func (dec *Decoder) readSizedObject(flag byte, base *model.BaseObject) (model.RedisObject, error) {
+	if dec.arena == nil {
+		// ziplists and listpacks are dropped after counting, so they are always read into an arena
+		if dec.scratch == nil {
+			dec.scratch = &arena{}
+		}
+		dec.arena = dec.scratch
+		defer func() {
+			dec.arena = nil
+			dec.scratch.reset()
+		}()
+	}
+	var n int
+	var err error
+	switch flag {
+	case typeString:
+		base.Type = model.StringType
+		err = dec.skipString()
+	case typeList, typeSet, typeHash, typeZset, typeZset2:
+		base.Type, base.Encoding = model.ListType, model.LinkedListEncoding
+		switch flag {
+		case typeSet:
+			base.Type, base.Encoding = model.SetType, model.HashTableEncoding
+		case typeHash:
+			base.Type, base.Encoding = model.HashType, model.HashTableEncoding
+		case typeZset, typeZset2:
+			base.Type, base.Encoding = model.ZSetType, model.SkipListEncoding
+		}
+		var length uint64
+		length, _, err = dec.readLength()
+		if err == nil {
+			n = int(length)
+			err = dec.skipElements(flag, length)
+		}
+	case typeListZipList:
+		base.Type, base.Encoding = model.ListType, model.ZipListEncoding
+		n, err = dec.countZipList()
+	case typeHashZipList:
+		base.Type, base.Encoding = model.HashType, model.ZipListEncoding
+		n, err = dec.countZipList()
+		n /= 2
+	case typeZsetZipList:
+		base.Type, base.Encoding = model.ZSetType, model.ZipListEncoding
+		n, err = dec.countZipList()
+		n /= 2
+	case typeSetListPack:
+		base.Type, base.Encoding = model.SetType, model.ListPackEncoding
+		n, err = dec.countListPack()
+	case typeHashListPack:
+		base.Type, base.Encoding = model.HashType, model.ListPackEncoding
+		n, err = dec.countListPack()
+		n /= 2
+	case typeZsetListPack:
+		base.Type, base.Encoding = model.ZSetType, model.ListPackEncoding
+		n, err = dec.countListPack()
+		n /= 2
+	case typeSetIntSet:
+		base.Type, base.Encoding = model.SetType, model.IntSetEncoding
+		n, err = dec.countIntSet()
+	case typeHashZipMap:
+		base.Type, base.Encoding = model.HashType, model.ZipMapEncoding
+		n, err = dec.countZipMap()
+	case typeListQuickList:
+		base.Type, base.Encoding = model.ListType, model.QuickListEncoding
+		n, err = dec.countQuickList(false)
+	case typeListQuickList2:
+		base.Type, base.Encoding = model.ListType, model.QuickList2Encoding
+		n, err = dec.countQuickList(true)
+	default:
+		return nil, fmt.Errorf("unknown type flag: %b", flag)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &model.SizedObject{
+		BaseObject: base,
+		ElemCount:  n,
+	}, nil
+}
+
+func (dec *Decoder) countZipList() (int, error) {
+	buf, err := dec.readString()
+	if err != nil {
+		return 0, err
+	}
+	if len(buf) < 10 {
+		return 0, errors.New("ziplist is too short")
+	}
+	cursor := 0
+	return readZipListLength(buf, &cursor), nil
+}
+
+func (dec *Decoder) countListPack() (int, error) {
+	buf, err := dec.readString()
+	if err != nil {
+		return 0, err
+	}
+	cursor := 0
+	return readListPackLength(buf, &cursor)
+}
+
+func (dec *Decoder) countIntSet() (int, error) {
+	buf, err := dec.readString()
+	if err != nil {
+		return 0, err
+	}
+	if len(buf) < 8 {
+		return 0, errors.New("intset is too short")
+	}
+	return int(binary.LittleEndian.Uint32(buf[4:8])), nil
+}
+
+func (dec *Decoder) countZipMap() (int, error) {
+	buf, err := dec.readString()
+	if err != nil {
+		return 0, err
+	}
+	cursor := 0
+	bLen, err := readByte(buf, &cursor)
+	if err != nil {
+		return 0, err
+	}
+	if bLen <= 254 {
+		return int(bLen), nil
+	}
+	n, err := countZipMapEntries(buf, &cursor)
+	return n / 2, err
+}
+
+// countQuickList counts elements in all nodes of quicklist, nodes are listpacks if quickList2
+func (dec *Decoder) countQuickList(quickList2 bool) (int, error) {
+	size, _, err := dec.readLength()
+	if err != nil {
+		return 0, err
+	}
+	total := 0
+	for i := uint64(0); i < size; i++ {
+		if !quickList2 {
+			n, err := dec.countZipList()
+			if err != nil {
+				return 0, err
+			}
+			total += n
+			continue
+		}
+		container, _, err := dec.readLength()
+		if err != nil {
+			return 0, err
+		}
+		switch container {
+		case quickListNodePlain:
+			err = dec.skipString()
+			if err != nil {
+				return 0, err
+			}
+			total++
+		case quickListNodePacked:
+			n, err := dec.countListPack()
+			if err != nil {
+				return 0, err
+			}
+			total += n
+		default:
+			return 0, fmt.Errorf("unknown quicklist node container: %d", container)
+		}
+	}
+	return total, nil
+}
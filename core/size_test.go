@@ -0,0 +1,67 @@
+package core
+
+import (
+	"bytes"
+	"github.com/hdt3213/rdb/model"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSizeOnly(t *testing.T) {
+	filenames, err := filepath.Glob(filepath.Join("..", "cases", "*.rdb"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	for _, filename := range filenames {
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		var expect []model.RedisObject
+		err = NewDecoder(bytes.NewReader(data)).Parse(func(object model.RedisObject) bool {
+			expect = append(expect, object)
+			return true
+		})
+		if err != nil {
+			t.Errorf("%s: %v", filename, err)
+			continue
+		}
+		i := 0
+		err = NewDecoder(bytes.NewReader(data)).WithSizeOnly().WithWorkers(2).Parse(func(object model.RedisObject) bool {
+			if i >= len(expect) {
+				t.Errorf("%s: too many objects", filename)
+				return false
+			}
+			e := expect[i]
+			i++
+			switch e.GetType() {
+			case model.StreamType, model.ModuleType:
+				if object.GetType() != e.GetType() {
+					t.Errorf("%s: %s should be decoded", filename, e.GetKey())
+				}
+				return true
+			}
+			if _, ok := object.(*model.SizedObject); !ok {
+				t.Errorf("%s: %s is not a sized object", filename, e.GetKey())
+				return true
+			}
+			if object.GetKey() != e.GetKey() || object.GetDBIndex() != e.GetDBIndex() ||
+				object.GetType() != e.GetType() || object.(model.BaseObjectHolder).GetEncoding() != e.(model.BaseObjectHolder).GetEncoding() ||
+				object.GetSize() != e.GetSize() || object.GetElemCount() != e.GetElemCount() ||
+				(object.GetExpiration() == nil) != (e.GetExpiration() == nil) {
+				t.Errorf("%s: wrong sized object of %s", filename, e.GetKey())
+			}
+			return true
+		})
+		if err != nil {
+			t.Errorf("%s: %v", filename, err)
+			continue
+		}
+		if i != len(expect) {
+			t.Errorf("%s: expect %d objects, actual %d", filename, len(expect), i)
+		}
+	}
+}
@@ -0,0 +1,527 @@
+package core
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/hdt3213/rdb/model"
+	"strconv"
+)
+
+// stream format: https://github.com/redis/redis/blob/7.2/src/t_stream.c
+
+const (
+	streamItemFlagDeleted    = 1 << 0 /* Entry is deleted. Skip it. */
+	streamItemFlagSameFields = 1 << 1 /* Same fields as master entry. */
+
+	streamIDSize = 16
+
+	// streamNodeMaxEntries is default value of stream-node-max-entries
+	streamNodeMaxEntries = 100
+)
+
+func decodeStreamID(buf []byte) model.StreamID {
+	return model.StreamID{
+		Ms:  binary.BigEndian.Uint64(buf[0:8]),
+		Seq: binary.BigEndian.Uint64(buf[8:16]),
+	}
+}
+
+func encodeStreamID(buf []byte, id model.StreamID) []byte {
+	buf = append(buf, make([]byte, streamIDSize)...)
+	binary.BigEndian.PutUint64(buf[len(buf)-16:], id.Ms)
+	binary.BigEndian.PutUint64(buf[len(buf)-8:], id.Seq)
+	return buf
+}
+
+func (dec *Decoder) readStreamID() (model.StreamID, error) {
+	ms, _, err := dec.readLength()
+	if err != nil {
+		return model.StreamID{}, err
+	}
+	seq, _, err := dec.readLength()
+	if err != nil {
+		return model.StreamID{}, err
+	}
+	return model.StreamID{Ms: ms, Seq: seq}, nil
+}
+
+func (dec *Decoder) readRawStreamID() (model.StreamID, error) {
+	buf := make([]byte, streamIDSize)
+	err := dec.readFull(buf)
+	if err != nil {
+		return model.StreamID{}, err
+	}
+	return decodeStreamID(buf), nil
+}
+
+func (dec *Decoder) readMillisecondTime() (uint64, error) {
+	err := dec.readFull(dec.buffer)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint64(dec.buffer), nil
+}
+
+func readListPackInt(buf []byte, cursor *int) (int64, error) {
+	entry, err := readListPackEntry(buf, cursor)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(unsafeBytes2Str(entry), 10, 64)
+}
+
+// readStreamListPack reads messages in a listpack node of stream
+func readStreamListPack(masterID model.StreamID, buf []byte) ([]*model.StreamMessage, error) {
+	cursor := 0
+	_, err := readListPackLength(buf, &cursor)
+	if err != nil {
+		return nil, err
+	}
+	// master entry: count, deleted, master fields count, master fields..., 0
+	count, err := readListPackInt(buf, &cursor)
+	if err != nil {
+		return nil, err
+	}
+	deleted, err := readListPackInt(buf, &cursor)
+	if err != nil {
+		return nil, err
+	}
+	masterFieldCount, err := readListPackInt(buf, &cursor)
+	if err != nil {
+		return nil, err
+	}
+	masterFields := make([]string, 0, masterFieldCount)
+	for i := int64(0); i < masterFieldCount; i++ {
+		field, err := readListPackEntry(buf, &cursor)
+		if err != nil {
+			return nil, err
+		}
+		masterFields = append(masterFields, string(field))
+	}
+	_, err = readListPackEntry(buf, &cursor) // master entry terminator
+	if err != nil {
+		return nil, err
+	}
+
+	messages := make([]*model.StreamMessage, 0, count)
+	for i := int64(0); i < count+deleted; i++ {
+		flags, err := readListPackInt(buf, &cursor)
+		if err != nil {
+			return nil, err
+		}
+		msDiff, err := readListPackInt(buf, &cursor)
+		if err != nil {
+			return nil, err
+		}
+		seqDiff, err := readListPackInt(buf, &cursor)
+		if err != nil {
+			return nil, err
+		}
+		msg := &model.StreamMessage{
+			ID: model.StreamID{
+				Ms:  masterID.Ms + uint64(msDiff),
+				Seq: masterID.Seq + uint64(seqDiff),
+			},
+		}
+		if flags&streamItemFlagSameFields != 0 {
+			msg.Fields = masterFields
+			msg.Values = make([]string, 0, len(masterFields))
+			for range masterFields {
+				value, err := readListPackEntry(buf, &cursor)
+				if err != nil {
+					return nil, err
+				}
+				msg.Values = append(msg.Values, string(value))
+			}
+		} else {
+			fieldCount, err := readListPackInt(buf, &cursor)
+			if err != nil {
+				return nil, err
+			}
+			msg.Fields = make([]string, 0, fieldCount)
+			msg.Values = make([]string, 0, fieldCount)
+			for j := int64(0); j < fieldCount; j++ {
+				field, err := readListPackEntry(buf, &cursor)
+				if err != nil {
+					return nil, err
+				}
+				value, err := readListPackEntry(buf, &cursor)
+				if err != nil {
+					return nil, err
+				}
+				msg.Fields = append(msg.Fields, string(field))
+				msg.Values = append(msg.Values, string(value))
+			}
+		}
+		_, err = readListPackEntry(buf, &cursor) // lp-count
+		if err != nil {
+			return nil, err
+		}
+		if flags&streamItemFlagDeleted == 0 {
+			messages = append(messages, msg)
+		}
+	}
+	return messages, nil
+}
+
+// readStream reads RDB_TYPE_STREAM_LISTPACKS, version is 1 for RDB_TYPE_STREAM_LISTPACKS,
+// 2 for RDB_TYPE_STREAM_LISTPACKS_2 and 3 for RDB_TYPE_STREAM_LISTPACKS_3
+func (dec *Decoder) readStream(version int) (*model.StreamObject, error) {
+	stream := &model.StreamObject{}
+	nodeCount, _, err := dec.readLength()
+	if err != nil {
+		return nil, err
+	}
+	for i := uint64(0); i < nodeCount; i++ {
+		nodeKey, err := dec.readString()
+		if err != nil {
+			return nil, err
+		}
+		if len(nodeKey) != streamIDSize {
+			return nil, errors.New("stream node key entry is not the size of a stream ID")
+		}
+		lp, err := dec.readString()
+		if err != nil {
+			return nil, err
+		}
+		messages, err := readStreamListPack(decodeStreamID(nodeKey), lp)
+		if err != nil {
+			return nil, fmt.Errorf("read stream listpack failed: %v", err)
+		}
+		stream.Messages = append(stream.Messages, messages...)
+	}
+	_, _, err = dec.readLength() // number of messages, same as len(stream.Messages)
+	if err != nil {
+		return nil, err
+	}
+	stream.LastID, err = dec.readStreamID()
+	if err != nil {
+		return nil, err
+	}
+	if version >= 2 {
+		stream.FirstID, err = dec.readStreamID()
+		if err != nil {
+			return nil, err
+		}
+		stream.MaxDeletedID, err = dec.readStreamID()
+		if err != nil {
+			return nil, err
+		}
+		stream.EntriesAdded, _, err = dec.readLength()
+		if err != nil {
+			return nil, err
+		}
+	}
+	groupCount, _, err := dec.readLength()
+	if err != nil {
+		return nil, err
+	}
+	for i := uint64(0); i < groupCount; i++ {
+		group, err := dec.readStreamGroup(version)
+		if err != nil {
+			return nil, err
+		}
+		stream.Groups = append(stream.Groups, group)
+	}
+	return stream, nil
+}
+
+func (dec *Decoder) readStreamGroup(version int) (*model.StreamGroup, error) {
+	name, err := dec.readString()
+	if err != nil {
+		return nil, err
+	}
+	group := &model.StreamGroup{
+		Name: string(name),
+	}
+	group.LastID, err = dec.readStreamID()
+	if err != nil {
+		return nil, err
+	}
+	if version >= 2 {
+		group.EntriesRead, _, err = dec.readLength()
+		if err != nil {
+			return nil, err
+		}
+	}
+	pelSize, _, err := dec.readLength()
+	if err != nil {
+		return nil, err
+	}
+	for i := uint64(0); i < pelSize; i++ {
+		entry := &model.StreamPendingEntry{}
+		entry.ID, err = dec.readRawStreamID()
+		if err != nil {
+			return nil, err
+		}
+		entry.DeliveryTime, err = dec.readMillisecondTime()
+		if err != nil {
+			return nil, err
+		}
+		entry.DeliveryCount, _, err = dec.readLength()
+		if err != nil {
+			return nil, err
+		}
+		group.Pending = append(group.Pending, entry)
+	}
+	consumerCount, _, err := dec.readLength()
+	if err != nil {
+		return nil, err
+	}
+	for i := uint64(0); i < consumerCount; i++ {
+		name, err := dec.readString()
+		if err != nil {
+			return nil, err
+		}
+		consumer := &model.StreamConsumer{
+			Name: string(name),
+		}
+		consumer.SeenTime, err = dec.readMillisecondTime()
+		if err != nil {
+			return nil, err
+		}
+		if version >= 3 {
+			consumer.ActiveTime, err = dec.readMillisecondTime()
+			if err != nil {
+				return nil, err
+			}
+		}
+		pelSize, _, err := dec.readLength()
+		if err != nil {
+			return nil, err
+		}
+		for j := uint64(0); j < pelSize; j++ {
+			id, err := dec.readRawStreamID()
+			if err != nil {
+				return nil, err
+			}
+			consumer.Pending = append(consumer.Pending, id)
+		}
+		group.Consumers = append(group.Consumers, consumer)
+	}
+	return group, nil
+}
+
+func sameFields(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// encodeStreamListPack encodes messages into a stream listpack node, the first message is the master entry
+func encodeStreamListPack(messages []*model.StreamMessage) []byte {
+	master := messages[0]
+	values := make([]string, 0, 4+len(master.Fields)+len(messages)*(5+len(master.Fields)))
+	values = append(values,
+		strconv.Itoa(len(messages)), // count
+		"0",                         // deleted
+		strconv.Itoa(len(master.Fields)),
+	)
+	values = append(values, master.Fields...)
+	values = append(values, "0") // master entry terminator
+	for _, msg := range messages {
+		msDiff := strconv.FormatInt(int64(msg.ID.Ms-master.ID.Ms), 10)
+		seqDiff := strconv.FormatInt(int64(msg.ID.Seq-master.ID.Seq), 10)
+		if sameFields(msg.Fields, master.Fields) {
+			values = append(values, strconv.Itoa(streamItemFlagSameFields), msDiff, seqDiff)
+			values = append(values, msg.Values...)
+			values = append(values, strconv.Itoa(len(msg.Fields)+3))
+		} else {
+			values = append(values, "0", msDiff, seqDiff, strconv.Itoa(len(msg.Fields)))
+			for i, field := range msg.Fields {
+				values = append(values, field, msg.Values[i])
+			}
+			values = append(values, strconv.Itoa(len(msg.Fields)*2+4))
+		}
+	}
+	return encodeListPack(values)
+}
+
+func (enc *Encoder) writeStreamID(id model.StreamID) error {
+	err := enc.writeLength(id.Ms)
+	if err != nil {
+		return err
+	}
+	return enc.writeLength(id.Seq)
+}
+
+func (enc *Encoder) writeMillisecondTime(t uint64) error {
+	binary.LittleEndian.PutUint64(enc.buffer, t)
+	return enc.write(enc.buffer)
+}
+
+// WriteStreamObject writes stream in RDB_TYPE_STREAM_LISTPACKS_3 encoding, or older encodings if target version of
+// encoder is lower than 11, fields unknown to older encodings such as entries read of groups are dropped.
+// Messages must be ordered by id, and pending ids of consumers must be found in pending entries of their group
+func (enc *Encoder) WriteStreamObject(key string, stream *model.StreamObject, options ...interface{}) error {
+	err := enc.requireVersion("streams", 9)
+	if err != nil {
+		return err
+	}
+	typ, version := byte(typeStreamListPacks3), 3
+	if enc.version > 0 && enc.version < 10 {
+		typ, version = typeStreamListPacks, 1
+	} else if enc.version > 0 && enc.version < 11 {
+		typ, version = typeStreamListPacks2, 2
+	}
+	for i, msg := range stream.Messages {
+		if len(msg.Fields) != len(msg.Values) {
+			return fmt.Errorf("message %s of %s has %d fields but %d values",
+				msg.ID, key, len(msg.Fields), len(msg.Values))
+		}
+		if i > 0 {
+			prev := stream.Messages[i-1].ID
+			if msg.ID.Ms < prev.Ms || (msg.ID.Ms == prev.Ms && msg.ID.Seq <= prev.Seq) {
+				return fmt.Errorf("messages of %s are not ordered by id", key)
+			}
+		}
+	}
+	err = enc.beforeWriteObject(options...)
+	if err != nil {
+		return err
+	}
+	err = enc.write([]byte{typ})
+	if err != nil {
+		return err
+	}
+	err = enc.writeKey(key)
+	if err != nil {
+		return err
+	}
+	err = enc.writeStreamValue(stream, version)
+	if err != nil {
+		return err
+	}
+	enc.state = writtenObjectState
+	return nil
+}
+
+func (enc *Encoder) writeStreamValue(stream *model.StreamObject, version int) error {
+	nodeCount := (len(stream.Messages) + streamNodeMaxEntries - 1) / streamNodeMaxEntries
+	err := enc.writeLength(uint64(nodeCount))
+	if err != nil {
+		return err
+	}
+	for i := 0; i < len(stream.Messages); i += streamNodeMaxEntries {
+		end := i + streamNodeMaxEntries
+		if end > len(stream.Messages) {
+			end = len(stream.Messages)
+		}
+		nodeKey := encodeStreamID(nil, stream.Messages[i].ID)
+		err = enc.writeNanString(unsafeBytes2Str(nodeKey))
+		if err != nil {
+			return err
+		}
+		lp := encodeStreamListPack(stream.Messages[i:end])
+		err = enc.writeNanString(unsafeBytes2Str(lp))
+		if err != nil {
+			return err
+		}
+	}
+	err = enc.writeLength(uint64(len(stream.Messages)))
+	if err != nil {
+		return err
+	}
+	err = enc.writeStreamID(stream.LastID)
+	if err != nil {
+		return err
+	}
+	if version >= 2 {
+		err = enc.writeStreamID(stream.FirstID)
+		if err != nil {
+			return err
+		}
+		err = enc.writeStreamID(stream.MaxDeletedID)
+		if err != nil {
+			return err
+		}
+		err = enc.writeLength(stream.EntriesAdded)
+		if err != nil {
+			return err
+		}
+	}
+	err = enc.writeLength(uint64(len(stream.Groups)))
+	if err != nil {
+		return err
+	}
+	for _, group := range stream.Groups {
+		err = enc.writeStreamGroup(group, version)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (enc *Encoder) writeStreamGroup(group *model.StreamGroup, version int) error {
+	err := enc.writeString(group.Name)
+	if err != nil {
+		return err
+	}
+	err = enc.writeStreamID(group.LastID)
+	if err != nil {
+		return err
+	}
+	if version >= 2 {
+		err = enc.writeLength(group.EntriesRead)
+		if err != nil {
+			return err
+		}
+	}
+	err = enc.writeLength(uint64(len(group.Pending)))
+	if err != nil {
+		return err
+	}
+	for _, entry := range group.Pending {
+		err = enc.write(encodeStreamID(nil, entry.ID))
+		if err != nil {
+			return err
+		}
+		err = enc.writeMillisecondTime(entry.DeliveryTime)
+		if err != nil {
+			return err
+		}
+		err = enc.writeLength(entry.DeliveryCount)
+		if err != nil {
+			return err
+		}
+	}
+	err = enc.writeLength(uint64(len(group.Consumers)))
+	if err != nil {
+		return err
+	}
+	for _, consumer := range group.Consumers {
+		err = enc.writeString(consumer.Name)
+		if err != nil {
+			return err
+		}
+		err = enc.writeMillisecondTime(consumer.SeenTime)
+		if err != nil {
+			return err
+		}
+		if version >= 3 {
+			err = enc.writeMillisecondTime(consumer.ActiveTime)
+			if err != nil {
+				return err
+			}
+		}
+		err = enc.writeLength(uint64(len(consumer.Pending)))
+		if err != nil {
+			return err
+		}
+		for _, id := range consumer.Pending {
+			err = enc.write(encodeStreamID(nil, id))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package core
+
+import (
+	"bytes"
+	"github.com/hdt3213/rdb/model"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestStreamEncoding(t *testing.T) {
+	stream := &model.StreamObject{
+		FirstID:      model.StreamID{Ms: 1000, Seq: 0},
+		MaxDeletedID: model.StreamID{Ms: 999, Seq: 3},
+	}
+	for i := 0; i < 250; i++ { // cross several listpack nodes
+		msg := &model.StreamMessage{
+			ID:     model.StreamID{Ms: 1000 + uint64(i/3), Seq: uint64(i % 3)},
+			Fields: []string{"name", "age"},
+			Values: []string{RandString(8), strconv.Itoa(i)},
+		}
+		if i%7 == 0 {
+			msg.Fields = []string{"other"}
+			msg.Values = []string{RandString(100)}
+		}
+		stream.Messages = append(stream.Messages, msg)
+	}
+	stream.LastID = stream.Messages[len(stream.Messages)-1].ID
+	stream.EntriesAdded = uint64(len(stream.Messages)) + 1
+	stream.Groups = []*model.StreamGroup{
+		{
+			Name:        "group",
+			LastID:      stream.Messages[10].ID,
+			EntriesRead: 11,
+			Pending: []*model.StreamPendingEntry{
+				{ID: stream.Messages[9].ID, DeliveryTime: 1700000000000, DeliveryCount: 2},
+				{ID: stream.Messages[10].ID, DeliveryTime: 1700000001000, DeliveryCount: 1},
+			},
+			Consumers: []*model.StreamConsumer{
+				{
+					Name:       "consumer",
+					SeenTime:   1700000001000,
+					ActiveTime: 1700000000500,
+					Pending:    []model.StreamID{stream.Messages[9].ID, stream.Messages[10].ID},
+				},
+			},
+		},
+	}
+	buf := bytes.NewBuffer(nil)
+	enc := NewEncoder(buf)
+	err := enc.WriteHeader()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = enc.WriteDBHeader(0, 2, 0)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = enc.WriteStreamObject("stream", stream)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = enc.WriteStreamObject("empty", &model.StreamObject{})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = enc.WriteEnd()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	dec := NewDecoder(buf)
+	count := 0
+	err = dec.Parse(func(object model.RedisObject) bool {
+		count++
+		actual := object.(*model.StreamObject)
+		if actual.GetKey() == "empty" {
+			if actual.GetElemCount() != 0 {
+				t.Error("expect empty stream")
+			}
+			return true
+		}
+		if !reflect.DeepEqual(actual.Messages, stream.Messages) {
+			t.Error("wrong stream messages")
+		}
+		if !reflect.DeepEqual(actual.Groups, stream.Groups) {
+			t.Error("wrong stream groups")
+		}
+		if actual.LastID != stream.LastID || actual.FirstID != stream.FirstID ||
+			actual.MaxDeletedID != stream.MaxDeletedID || actual.EntriesAdded != stream.EntriesAdded {
+			t.Error("wrong stream metadata")
+		}
+		return true
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	if count != 2 {
+		t.Errorf("wrong object count: %d", count)
+	}
+
+	enc = NewEncoder(bytes.NewBuffer(nil))
+	_ = enc.WriteHeader()
+	_ = enc.WriteDBHeader(0, 1, 0)
+	err = enc.WriteStreamObject("disordered", &model.StreamObject{
+		Messages: []*model.StreamMessage{
+			{ID: model.StreamID{Ms: 2}},
+			{ID: model.StreamID{Ms: 1}},
+		},
+	})
+	if err == nil {
+		t.Error("expect error for disordered messages")
+	}
+}
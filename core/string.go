@@ -77,32 +77,45 @@ func (dec *Decoder) readLength() (uint64, bool, error) {
 }
 
 func (dec *Decoder) readString() ([]byte, error) {
-	length, special, err := dec.readLength()
+	res, val, isInt, err := dec.readStringOrInt()
 	if err != nil {
 		return nil, err
 	}
+	if isInt {
+		return dec.formatInt(val), nil
+	}
+	return res, nil
+}
+
+// readStringOrInt reads a string, integer-encoded string is returned as integer without formatting
+func (dec *Decoder) readStringOrInt() ([]byte, int64, bool, error) {
+	length, special, err := dec.readLength()
+	if err != nil {
+		return nil, 0, false, err
+	}
 
 	if special {
 		switch length {
 		case encodeInt8:
 			b, err := dec.readByte()
-			return []byte(strconv.Itoa(int(int8(b)))), err
+			return nil, int64(int8(b)), true, err
 		case encodeInt16:
 			b, err := dec.readUint16()
-			return []byte(strconv.Itoa(int(int16(b)))), err
+			return nil, int64(int16(b)), true, err
 		case encodeInt32:
 			b, err := dec.readUint32()
-			return []byte(strconv.Itoa(int(int32(b)))), err
+			return nil, int64(int32(b)), true, err
 		case encodeLZF:
-			return dec.readLZF()
+			res, err := dec.readLZF()
+			return res, 0, false, err
 		default:
-			return []byte{}, errors.New("Unknown string encode type ")
+			return []byte{}, 0, false, errors.New("Unknown string encode type ")
 		}
 	}
 
-	res := make([]byte, length)
+	res := dec.alloc(int(length))
 	err = dec.readFull(res)
-	return res, err
+	return res, 0, false, err
 }
 
 func (dec *Decoder) readUint16() (uint16, error) {
@@ -137,7 +150,7 @@ func (dec *Decoder) readLiteralFloat() (float64, error) {
 	} else if first == 0xfd {
 		return math.NaN(), nil
 	}
-	buf := make([]byte, first)
+	buf := dec.alloc(int(first))
 	err = dec.readFull(buf)
 	if err != nil {
 		return 0, err
@@ -168,12 +181,12 @@ func (dec *Decoder) readLZF() ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
-	val := make([]byte, inLen)
+	val := dec.alloc(int(inLen))
 	err = dec.readFull(val)
 	if err != nil {
 		return nil, err
 	}
-	return lzf.Decompress(val, int(inLen), int(outLen))
+	return lzf.DecompressTo(dec.alloc(int(outLen)), val)
 }
 
 func (enc *Encoder) writeLength(value uint64) error {
@@ -292,7 +305,7 @@ func (enc *Encoder) WriteStringObject(key string, value []byte, options ...inter
 	if err != nil {
 		return err
 	}
-	err = enc.writeString(key)
+	err = enc.writeKey(key)
 	if err != nil {
 		return err
 	}
@@ -309,3 +322,16 @@ func (enc *Encoder) writeFloat64(f float64) error {
 	binary.LittleEndian.PutUint64(enc.buffer, bin)
 	return enc.write(enc.buffer)
 }
+
+// writeLiteralFloat writes float as a string, used by RDB_TYPE_ZSET
+func (enc *Encoder) writeLiteralFloat(f float64) error {
+	if math.IsNaN(f) {
+		return enc.write([]byte{0xfd})
+	} else if math.IsInf(f, 1) {
+		return enc.write([]byte{0xfe})
+	} else if math.IsInf(f, -1) {
+		return enc.write([]byte{0xff})
+	}
+	str := strconv.FormatFloat(f, 'g', -1, 64)
+	return enc.write(append([]byte{byte(len(str))}, str...))
+}
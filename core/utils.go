@@ -48,6 +48,9 @@ func (dec *Decoder) readByte() (byte, error) {
 		return 0, err
 	}
 	dec.readCount++
+	if dec.capturing {
+		dec.captured = append(dec.captured, b)
+	}
 	return b, nil
 }
 
@@ -57,9 +60,26 @@ func (dec *Decoder) readFull(buf []byte) error {
 		return err
 	}
 	dec.readCount += n
+	if dec.capturing {
+		dec.captured = append(dec.captured, buf...)
+	}
 	return nil
 }
 
+// startCapture makes decoder record raw bytes it read until stopCapture
+func (dec *Decoder) startCapture() {
+	dec.capturing = true
+	dec.captured = nil
+}
+
+// stopCapture returns raw bytes read since startCapture
+func (dec *Decoder) stopCapture() []byte {
+	dec.capturing = false
+	captured := dec.captured
+	dec.captured = nil
+	return captured
+}
+
 var letters = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789")
 
 // RandString create a random string no longer than n
@@ -1,7 +1,9 @@
 package core
 
 import (
+	"fmt"
 	"github.com/hdt3213/rdb/model"
+	"math"
 	"strconv"
 )
 
@@ -62,12 +64,41 @@ func (dec *Decoder) readZipListZSet() ([]*model.ZSetEntry, error) {
 	return entries, nil
 }
 
+func (dec *Decoder) readListPackZSet() ([]*model.ZSetEntry, error) {
+	elements, err := dec.readListPack()
+	if err != nil {
+		return nil, err
+	}
+	if len(elements)%2 != 0 {
+		return nil, fmt.Errorf("listpack of sorted set has odd number of entries: %d", len(elements))
+	}
+	entries := make([]*model.ZSetEntry, 0, len(elements)/2)
+	for i := 0; i < len(elements); i += 2 {
+		score, err := strconv.ParseFloat(unsafeBytes2Str(elements[i+1]), 64)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, &model.ZSetEntry{
+			Member: unsafeBytes2Str(elements[i]),
+			Score:  score,
+		})
+	}
+	return entries, nil
+}
+
 func (enc *Encoder) WriteZSetObject(key string, entries []*model.ZSetEntry, options ...interface{}) error {
 	err := enc.beforeWriteObject(options...)
 	if err != nil {
 		return err
 	}
-	ok, err := enc.tryWriteZipListZSet(key, entries)
+	ok := false
+	switch getEncoding(options) {
+	case model.SkipListEncoding:
+	case model.ZipListEncoding, model.ListPackEncoding:
+		ok, err = enc.tryWriteZipListZSet(key, entries, true)
+	default:
+		ok, err = enc.tryWriteZipListZSet(key, entries, false)
+	}
 	if err != nil {
 		return err
 	}
@@ -81,12 +112,29 @@ func (enc *Encoder) WriteZSetObject(key string, entries []*model.ZSetEntry, opti
 	return nil
 }
 
+// useZSet2 returns whether scores could be stored in binary (RDB_TYPE_ZSET_2), which requires rdb version 8
+func (enc *Encoder) useZSet2() bool {
+	return enc.version == 0 || enc.version >= 8
+}
+
+// writeZSetScore writes score in binary or as a string depending on target version
+func (enc *Encoder) writeZSetScore(score float64) error {
+	if enc.useZSet2() {
+		return enc.writeFloat64(score)
+	}
+	return enc.writeLiteralFloat(score)
+}
+
 func (enc *Encoder) writeZSet2Encoding(key string, entries []*model.ZSetEntry) error {
-	err := enc.write([]byte{typeZset2})
+	typ := byte(typeZset2)
+	if !enc.useZSet2() {
+		typ = typeZset
+	}
+	err := enc.write([]byte{typ})
 	if err != nil {
 		return err
 	}
-	err = enc.writeString(key)
+	err = enc.writeKey(key)
 	if err != nil {
 		return err
 	}
@@ -99,7 +147,7 @@ func (enc *Encoder) writeZSet2Encoding(key string, entries []*model.ZSetEntry) e
 		if err != nil {
 			return err
 		}
-		err = enc.writeFloat64(entry.Score)
+		err = enc.writeZSetScore(entry.Score)
 		if err != nil {
 			return err
 		}
@@ -107,21 +155,27 @@ func (enc *Encoder) writeZSet2Encoding(key string, entries []*model.ZSetEntry) e
 	return nil
 }
 
-func (enc *Encoder) tryWriteZipListZSet(key string, entries []*model.ZSetEntry) (bool, error) {
-	if len(entries) > enc.zsetZipListOpt.getMaxEntries() {
+// tryWriteZipListZSet writes sorted set in ziplist encoding, it checks zset-max-ziplist-value and
+// zset-max-ziplist-entries unless force is true
+func (enc *Encoder) tryWriteZipListZSet(key string, entries []*model.ZSetEntry, force bool) (bool, error) {
+	if len(entries)*2 >= math.MaxUint16 || !force && len(entries) > enc.zsetZipListOpt.getMaxEntries() {
 		return false, nil
 	}
 	maxValue := enc.zsetZipListOpt.getMaxValue()
 	for _, entry := range entries {
-		if len(entry.Member) > maxValue {
+		if !force && len(entry.Member) > maxValue {
 			return false, nil
 		}
 	}
-	err := enc.write([]byte{typeZsetZipList})
+	typ := byte(typeZsetZipList)
+	if enc.useListPack() {
+		typ = typeZsetListPack
+	}
+	err := enc.write([]byte{typ})
 	if err != nil {
 		return true, err
 	}
-	err = enc.writeString(key)
+	err = enc.writeKey(key)
 	if err != nil {
 		return true, err
 	}
@@ -130,9 +184,56 @@ func (enc *Encoder) tryWriteZipListZSet(key string, entries []*model.ZSetEntry)
 		scoreStr := strconv.FormatFloat(entry.Score, 'f', -1, 64)
 		zlElements = append(zlElements, entry.Member, scoreStr)
 	}
-	err = enc.writeZipList(zlElements)
+	if enc.useListPack() {
+		err = enc.writeNanString(unsafeBytes2Str(encodeListPack(zlElements)))
+	} else {
+		err = enc.writeZipList(zlElements)
+	}
 	if err != nil {
 		return true, err
 	}
 	return true, nil
 }
+
+// ZSetWriter writes a large sorted set entry by entry in skiplist encoding (RDB_TYPE_ZSET_2, or RDB_TYPE_ZSET if
+// target version of encoder is lower than 8). Create it by Encoder.BeginZSet
+type ZSetWriter struct {
+	*collectionWriter
+}
+
+// BeginZSet starts writing a sorted set object with n entries.
+// Append exactly n entries then call End, no other object could be written before End
+func (enc *Encoder) BeginZSet(key string, n uint64, options ...interface{}) (*ZSetWriter, error) {
+	typ := byte(typeZset2)
+	if !enc.useZSet2() {
+		typ = typeZset
+	}
+	cw, err := enc.beginCollection(typ, key, n, options...)
+	if err != nil {
+		return nil, err
+	}
+	return &ZSetWriter{collectionWriter: cw}, nil
+}
+
+// Append writes a member with score into sorted set, the invoker should guarantee members are unique
+func (w *ZSetWriter) Append(member string, score float64) error {
+	err := w.beforeAppend()
+	if err != nil {
+		return err
+	}
+	err = w.enc.writeString(member)
+	if err != nil {
+		return err
+	}
+	return w.enc.writeZSetScore(score)
+}
+
+// End finishes the sorted set, it returns error if number of appended entries is not equal to declared
+func (w *ZSetWriter) End() error {
+	err := w.beforeEnd()
+	if err != nil {
+		return err
+	}
+	w.end()
+	return nil
+}
@@ -3,6 +3,7 @@ package core
 import (
 	"bytes"
 	"github.com/hdt3213/rdb/model"
+	"strconv"
 	"testing"
 )
 
@@ -88,3 +89,65 @@ func TestZSetEncoding(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestZSetWriter(t *testing.T) {
+	var entries []*model.ZSetEntry
+	for i := 0; i < 1000; i++ {
+		entries = append(entries, &model.ZSetEntry{
+			Member: strconv.Itoa(i),
+			Score:  float64(i) / 3,
+		})
+	}
+	buf := bytes.NewBuffer(nil)
+	enc := NewEncoder(buf)
+	err := enc.WriteHeader()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = enc.WriteDBHeader(0, 1, 0)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	zw, err := enc.BeginZSet("zset", uint64(len(entries)))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	for _, e := range entries {
+		err = zw.Append(e.Member, e.Score)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	err = zw.End()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = enc.WriteEnd()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	dec := NewDecoder(buf)
+	err = dec.Parse(func(object model.RedisObject) bool {
+		o := object.(*model.ZSetObject)
+		if o.GetElemCount() != len(entries) {
+			t.Errorf("zset has wrong element count: %d", o.GetElemCount())
+			return true
+		}
+		for i, e := range entries {
+			if o.Entries[i].Member != e.Member || o.Entries[i].Score != e.Score {
+				t.Errorf("zset has wrong entry at index %d", i)
+				return true
+			}
+		}
+		return true
+	})
+	if err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,36 @@
+// Package crc16 implements the CRC16-CCITT (XMODEM) checksum used by Redis Cluster to map keys into hash slots.
+// It uses polynomial 0x1021, zero initial value and no final xor.
+package crc16
+
+const poly = 0x1021
+
+var table = makeTable()
+
+func makeTable() *[256]uint16 {
+	t := new([256]uint16)
+	for i := 0; i < 256; i++ {
+		crc := uint16(i) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ poly
+			} else {
+				crc <<= 1
+			}
+		}
+		t[i] = crc
+	}
+	return t
+}
+
+// Update returns the result of adding the bytes in p to the crc
+func Update(crc uint16, p []byte) uint16 {
+	for _, b := range p {
+		crc = crc<<8 ^ table[byte(crc>>8)^b]
+	}
+	return crc
+}
+
+// Checksum returns the CRC16 checksum of data
+func Checksum(data []byte) uint16 {
+	return Update(0, data)
+}
@@ -0,0 +1,13 @@
+package crc16
+
+import "testing"
+
+func TestChecksum(t *testing.T) {
+	// test vector from crc16.c of redis
+	if sum := Checksum([]byte("123456789")); sum != 0x31c3 {
+		t.Errorf("wrong checksum: %x", sum)
+	}
+	if sum := Update(Checksum([]byte("1234")), []byte("56789")); sum != 0x31c3 {
+		t.Errorf("wrong checksum: %x", sum)
+	}
+}
@@ -0,0 +1,70 @@
+// Package crc64jones implements the CRC-64-Jones checksum used by Redis for RDB files and DUMP payloads.
+// It is reflected with polynomial 0xad93d23594c935a9, zero initial value and no final xor,
+// so the result is different from hash/crc64 of standard library.
+package crc64jones
+
+import "hash"
+
+// Size of a CRC-64 checksum in bytes
+const Size = 8
+
+const reflectedPoly = 0x95ac9329ac4bc9b5 // reversed 0xad93d23594c935a9
+
+var table = makeTable()
+
+func makeTable() *[256]uint64 {
+	t := new([256]uint64)
+	for i := 0; i < 256; i++ {
+		crc := uint64(i)
+		for j := 0; j < 8; j++ {
+			if crc&1 == 1 {
+				crc = crc>>1 ^ reflectedPoly
+			} else {
+				crc >>= 1
+			}
+		}
+		t[i] = crc
+	}
+	return t
+}
+
+// Update returns the result of adding the bytes in p to the crc
+func Update(crc uint64, p []byte) uint64 {
+	for _, b := range p {
+		crc = table[byte(crc)^b] ^ crc>>8
+	}
+	return crc
+}
+
+// Checksum returns the CRC-64-Jones checksum of data
+func Checksum(data []byte) uint64 {
+	return Update(0, data)
+}
+
+type digest struct {
+	crc uint64
+}
+
+// New creates a new hash.Hash64 computing the CRC-64-Jones checksum
+func New() hash.Hash64 {
+	return &digest{}
+}
+
+func (d *digest) Size() int { return Size }
+
+func (d *digest) BlockSize() int { return 1 }
+
+func (d *digest) Reset() { d.crc = 0 }
+
+func (d *digest) Write(p []byte) (n int, err error) {
+	d.crc = Update(d.crc, p)
+	return len(p), nil
+}
+
+func (d *digest) Sum64() uint64 { return d.crc }
+
+// Sum appends checksum in big endian like hash/crc64, notice that redis stores checksum in little endian
+func (d *digest) Sum(in []byte) []byte {
+	s := d.Sum64()
+	return append(in, byte(s>>56), byte(s>>48), byte(s>>40), byte(s>>32), byte(s>>24), byte(s>>16), byte(s>>8), byte(s))
+}
@@ -0,0 +1,20 @@
+package crc64jones
+
+import "testing"
+
+func TestChecksum(t *testing.T) {
+	// test vector from crc64.c of redis
+	if sum := Checksum([]byte("123456789")); sum != 0xe9c6d914c4b8d9ca {
+		t.Errorf("wrong checksum: %x", sum)
+	}
+	h := New()
+	_, _ = h.Write([]byte("1234"))
+	_, _ = h.Write([]byte("56789"))
+	if h.Sum64() != 0xe9c6d914c4b8d9ca {
+		t.Errorf("wrong checksum: %x", h.Sum64())
+	}
+	h.Reset()
+	if h.Sum64() != 0 {
+		t.Error("expect zero after reset")
+	}
+}
@@ -130,12 +130,18 @@ const html = `
 		// Example of how to set fixed chart height
 		// flameGraph.height(540);
 	
+		{{if .Stacks}}
+		d3.select("#chart")
+			.datum({{.Stacks}})
+			.call(flameGraph);
+		{{else}}
 		d3.json("stacks.json", function(error, data) {
 		  if (error) return console.warn(error);
 		  d3.select("#chart")
 			  .datum(data)
 			  .call(flameGraph);
 		});
+		{{end}}
 	
 		document.getElementById("form").addEventListener("submit", function(event){
 		  event.preventDefault();
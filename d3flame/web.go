@@ -4,17 +4,22 @@ import (
 	"encoding/json"
 	"fmt"
 	"html/template"
+	"io"
+	"net"
 	"net/http"
 	"strconv"
 )
 
-var flameTmplData = &struct {
+type flameTmpl struct {
 	D3Css        template.CSS
 	D3Js         template.JS
 	D3Flame      template.JS
 	D3Tip        template.JS
 	BootstrapCSS template.CSS
-}{
+	Stacks       template.JS // embedded data, stacks.json is requested if it is empty
+}
+
+var flameTmplData = &flameTmpl{
 	D3Css:        template.CSS(d3Css),
 	D3Js:         template.JS(d3Js),
 	D3Flame:      template.JS(d3FlameGraphJs),
@@ -31,6 +36,17 @@ func flamegraph(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// Render writes a standalone flamegraph page with data embedded, data is json of FlameItem
+func Render(w io.Writer, data []byte) error {
+	tmpl, err := template.New("flamegraph").Parse(html)
+	if err != nil {
+		return err
+	}
+	tmplData := *flameTmplData
+	tmplData.Stacks = template.JS(data)
+	return tmpl.Execute(w, &tmplData)
+}
+
 // FlameItem is an Element in flamegraph
 type FlameItem struct {
 	Name     string   `json:"n"`
@@ -53,8 +69,18 @@ func (node *FlameItem) AddChild(n *FlameItem) {
 
 type children map[string]*FlameItem
 
-// Web starts a web server to render flamegraph
+// Web starts a web server to render flamegraph, it panics if port is not available
 func Web(data []byte, port int) chan<- struct{} {
+	stop, err := Serve(data, port)
+	if err != nil {
+		panic(err)
+	}
+	return stop
+}
+
+// Serve starts a web server to render flamegraph, server is listening when it returns.
+// Sending to the returned channel stops the server
+func Serve(data []byte, port int) (chan<- struct{}, error) {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/flamegraph", flamegraph)
 	mux.HandleFunc("/stacks.json", func(w http.ResponseWriter, r *http.Request) {
@@ -65,6 +91,10 @@ func Web(data []byte, port int) chan<- struct{} {
 		Addr:    ":" + strconv.Itoa(port),
 		Handler: mux,
 	}
+	listener, err := net.Listen("tcp", server.Addr)
+	if err != nil {
+		return nil, err
+	}
 	fmt.Printf("see http://localhost:%d/flamegraph\n", port)
 	stop := make(chan struct{})
 	go func() {
@@ -72,10 +102,10 @@ func Web(data []byte, port int) chan<- struct{} {
 		_ = server.Close()
 	}()
 	go func() {
-		err := server.ListenAndServe()
+		err := server.Serve(listener)
 		if err != nil && err != http.ErrServerClosed {
 			panic(err)
 		}
 	}()
-	return stop
+	return stop, nil
 }
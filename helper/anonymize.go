@@ -0,0 +1,337 @@
+package helper
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/hdt3213/rdb/model"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// AnonymizeHash replaces data with keyed hash of the same length, integers are replaced with integers
+	AnonymizeHash = "hash"
+	// AnonymizeFake replaces characters with fake ones of the same class (digit, lower case, upper case, etc.),
+	// so that length and integer-ness are kept. Integers and other data are faked separately, so different inputs
+	// never get the same fake
+	AnonymizeFake = "fake"
+)
+
+// AnonymizeRule decides how to anonymize keys matching Pattern
+type AnonymizeRule struct {
+	Pattern    string // regex of key, capture groups are variable segments of key to anonymize, others are kept
+	Mode       string // AnonymizeHash or AnonymizeFake
+	KeepValue  bool   // do not anonymize values, elements and members
+	KeepFields bool   // do not anonymize hash fields and stream fields
+}
+
+// ParseAnonymizeRules reads rules file, each line is a rule and the first rule matching a key is used:
+//
+//	<hash|fake> <regex> [keep-value] [keep-fields]
+//
+// empty lines and lines starting with '#' are ignored
+func ParseAnonymizeRules(reader io.Reader) ([]*AnonymizeRule, error) {
+	var rules []*AnonymizeRule
+	scanner := bufio.NewScanner(reader)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("line %d: mode and regex are required", lineNum)
+		}
+		rule := &AnonymizeRule{
+			Mode:    fields[0],
+			Pattern: fields[1],
+		}
+		for _, flag := range fields[2:] {
+			switch flag {
+			case "keep-value":
+				rule.KeepValue = true
+			case "keep-fields":
+				rule.KeepFields = true
+			default:
+				return nil, fmt.Errorf("line %d: unknown flag %s", lineNum, flag)
+			}
+		}
+		rules = append(rules, rule)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// AnonymizeOption anonymizes keys and values by rules, keys matching no rule are kept as is.
+// Same data is always anonymized into the same result with the same secret
+type AnonymizeOption struct {
+	Secret string
+	Rules  []*AnonymizeRule
+}
+
+// WithAnonymizeOption creates an AnonymizeOption
+func WithAnonymizeOption(secret string, rules ...*AnonymizeRule) AnonymizeOption {
+	return AnonymizeOption{
+		Secret: secret,
+		Rules:  rules,
+	}
+}
+
+type anonymizeRule struct {
+	*AnonymizeRule
+	pattern *regexp.Regexp
+	fn      func(data []byte) []byte
+}
+
+type anonymizer struct {
+	rules  []*anonymizeRule
+	secret []byte
+	seed   uint64 // initial state of fake
+}
+
+func newAnonymizer(opt AnonymizeOption) (*anonymizer, error) {
+	if opt.Secret == "" {
+		return nil, errors.New("secret of anonymizer is required")
+	}
+	a := &anonymizer{
+		secret: []byte(opt.Secret),
+	}
+	a.seed = binary.BigEndian.Uint64(a.sum([]byte("fake"), 8))
+	for _, rule := range opt.Rules {
+		pattern, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("illegal regex expression: %v", err)
+		}
+		r := &anonymizeRule{
+			AnonymizeRule: rule,
+			pattern:       pattern,
+		}
+		switch rule.Mode {
+		case AnonymizeHash:
+			r.fn = a.hash
+		case AnonymizeFake:
+			r.fn = a.fake
+		default:
+			return nil, fmt.Errorf("unknown anonymize mode: %s", rule.Mode)
+		}
+		a.rules = append(a.rules, r)
+	}
+	return a, nil
+}
+
+// sum returns n bytes of keyed hash of data, blocks of hmac are chained by counter for large n.
+// Hmac is created for each call, since anonymizer is shared by workers of parallel pipeline
+func (a *anonymizer) sum(data []byte, n int) []byte {
+	mac := hmac.New(sha256.New, a.secret)
+	result := make([]byte, 0, n+mac.Size())
+	counter := make([]byte, 4)
+	for i := uint32(0); len(result) < n; i++ {
+		mac.Reset()
+		_, _ = mac.Write(data)
+		binary.BigEndian.PutUint32(counter, i)
+		_, _ = mac.Write(counter)
+		result = mac.Sum(result)
+	}
+	return result[:n]
+}
+
+// isCanonicalInt returns whether data would be stored as an integer by redis
+func isCanonicalInt(data []byte) bool {
+	intVal, err := strconv.ParseInt(string(data), 10, 64)
+	return err == nil && strconv.FormatInt(intVal, 10) == string(data)
+}
+
+// hash replaces data with hex digits of keyed hash in the same length. Integers are replaced with integers of
+// the same number of digits, and other data never becomes an integer
+func (a *anonymizer) hash(data []byte) []byte {
+	if isCanonicalInt(data) {
+		sum := a.sum(data, len(data))
+		result := make([]byte, len(data))
+		for i, c := range data {
+			switch {
+			case c == '-':
+				result[i] = c
+			case len(data) > 1 && (i == 0 || i == 1 && data[0] == '-'):
+				// leading digit must not be zero, and 19 digits must not overflow int64
+				size := byte(9)
+				if len(data)-i == 19 {
+					size = 8
+				}
+				result[i] = '1' + sum[i]%size
+			default:
+				result[i] = '0' + sum[i]%10
+			}
+		}
+		return result
+	}
+	result := []byte(hex.EncodeToString(a.sum(data, (len(data)+1)/2))[:len(data)])
+	if isCanonicalInt(result) {
+		result[0] = 'a' + result[0]%6
+	}
+	return result
+}
+
+func splitMix64(x uint64) uint64 {
+	x += 0x9e3779b97f4a7c15
+	x = (x ^ x>>30) * 0xbf58476d1ce4e5b9
+	x = (x ^ x>>27) * 0x94d049bb133111eb
+	return x ^ x>>31
+}
+
+// fake shifts each character within its class by an offset derived from secret and the preceding characters.
+// It is a bijection, because the class of every character is kept and offsets could be recovered one by one.
+// The last byte of multi-byte UTF-8 characters is shifted within continuation bytes, so UTF-8 is kept valid.
+// Integers are mapped to integers and other data to non-integers by cycle walking, which keeps it a bijection
+func (a *anonymizer) fake(data []byte) []byte {
+	isInt := isCanonicalInt(data)
+	result := a.fakeOnce(data, isInt)
+	for isCanonicalInt(result) != isInt {
+		// out of int64 range for integers, or looks like an integer such as "15" faked from "05"
+		result = a.fakeOnce(result, isInt)
+	}
+	return result
+}
+
+func (a *anonymizer) fakeOnce(data []byte, isInt bool) []byte {
+	result := make([]byte, len(data))
+	state := a.seed
+	for i, c := range data {
+		state = splitMix64(state)
+		var base, size byte
+		switch {
+		case isInt && len(data) > 1 && (i == 0 && c != '-' || i == 1 && data[0] == '-'):
+			base, size = '1', 9 // leading digit of integer must not be zero
+		case c >= '0' && c <= '9':
+			base, size = '0', 10
+		case c >= 'a' && c <= 'z':
+			base, size = 'a', 26
+		case c >= 'A' && c <= 'Z':
+			base, size = 'A', 26
+		case c >= 0x80 && c < 0xc0 && (i+1 == len(data) || data[i+1] < 0x80 || data[i+1] >= 0xc0):
+			base, size = 0x80, 64
+		default:
+			result[i] = c
+			state ^= uint64(c)
+			continue
+		}
+		result[i] = base + byte((uint64(c-base)+state%uint64(size))%uint64(size))
+		state ^= uint64(c)
+	}
+	return result
+}
+
+func (a *anonymizer) match(key string) *anonymizeRule {
+	for _, rule := range a.rules {
+		if rule.pattern.MatchString(key) {
+			return rule
+		}
+	}
+	return nil
+}
+
+// anonymizeKey anonymizes capture groups of pattern in key
+func (r *anonymizeRule) anonymizeKey(key string) string {
+	loc := r.pattern.FindStringSubmatchIndex(key)
+	if len(loc) <= 2 {
+		return key
+	}
+	var builder strings.Builder
+	last := 0
+	for i := 2; i+1 < len(loc); i += 2 {
+		start, end := loc[i], loc[i+1]
+		if start < last { // group not matched or nested in previous one
+			continue
+		}
+		builder.WriteString(key[last:start])
+		builder.Write(r.fn([]byte(key[start:end])))
+		last = end
+	}
+	builder.WriteString(key[last:])
+	return builder.String()
+}
+
+func (r *anonymizeRule) anonymizeValue(object model.RedisObject) {
+	switch o := object.(type) {
+	case *model.StringObject:
+		o.Value = r.fn(o.Bytes())
+		o.IsInt, o.IntValue = false, 0
+	case *model.ListObject:
+		for i, v := range o.Values {
+			o.Values[i] = r.fn(v)
+		}
+	case *model.SetObject:
+		for i, v := range o.Members {
+			o.Members[i] = r.fn(v)
+		}
+	case *model.HashObject:
+		hash := make(map[string][]byte, len(o.Hash))
+		var expirations map[string]time.Time
+		if o.FieldExpirations != nil {
+			expirations = make(map[string]time.Time, len(o.FieldExpirations))
+		}
+		for field, v := range o.Hash {
+			expiration, expires := o.FieldExpirations[field]
+			if !r.KeepFields {
+				field = string(r.fn([]byte(field)))
+			}
+			hash[field] = r.fn(v)
+			if expires {
+				expirations[field] = expiration
+			}
+		}
+		o.Hash, o.FieldExpirations = hash, expirations
+	case *model.ZSetObject:
+		for _, e := range o.Entries {
+			e.Member = string(r.fn([]byte(e.Member)))
+		}
+	case *model.StreamObject:
+		for _, msg := range o.Messages {
+			if !r.KeepFields {
+				// messages flagged SAMEFIELDS share fields with the master entry, so fields are copied before changing
+				fields := make([]string, len(msg.Fields))
+				for i, field := range msg.Fields {
+					fields[i] = string(r.fn([]byte(field)))
+				}
+				msg.Fields = fields
+			}
+			for i := range msg.Values {
+				msg.Values[i] = string(r.fn([]byte(msg.Values[i])))
+			}
+		}
+	}
+}
+
+// anonymizeDecoder anonymizes keys and values before passing them to callback
+type anonymizeDecoder struct {
+	dec decoder
+	a   *anonymizer
+}
+
+func (d *anonymizeDecoder) Parse(cb func(object model.RedisObject) bool) error {
+	return d.dec.Parse(func(object model.RedisObject) bool {
+		if isSpecialObject(object) {
+			return cb(object)
+		}
+		rule := d.a.match(object.GetKey())
+		if rule != nil {
+			base := baseObject(object)
+			base.Key = rule.anonymizeKey(base.Key)
+			if !rule.KeepValue {
+				rule.anonymizeValue(object)
+			}
+		}
+		return cb(object)
+	})
+}
@@ -0,0 +1,206 @@
+package helper
+
+import (
+	"fmt"
+	"github.com/hdt3213/rdb/model"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func charClass(c byte) byte {
+	switch {
+	case c >= '0' && c <= '9':
+		return '0'
+	case c >= 'a' && c <= 'z':
+		return 'a'
+	case c >= 'A' && c <= 'Z':
+		return 'A'
+	}
+	return c
+}
+
+func TestAnonymizerFake(t *testing.T) {
+	a, err := newAnonymizer(WithAnonymizeOption("secret"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	inputs := []string{"hello World-42", "13800138000", "-7", "0", "user@example.com", "9223372036854775807", "-9223372036854775808"}
+	for _, input := range inputs {
+		output := string(a.fake([]byte(input)))
+		if output == input && len(input) > 3 {
+			t.Errorf("%s is not anonymized", input)
+		}
+		if len(output) != len(input) {
+			t.Errorf("length of %s is changed: %s", input, output)
+			continue
+		}
+		for i := 0; i < len(input); i++ {
+			if charClass(input[i]) != charClass(output[i]) {
+				t.Errorf("class of characters in %s is changed: %s", input, output)
+				break
+			}
+		}
+		if isCanonicalInt([]byte(input)) != isCanonicalInt([]byte(output)) {
+			t.Errorf("integer-ness of %s is changed: %s", input, output)
+		}
+		if again := string(a.fake([]byte(input))); again != output {
+			t.Errorf("fake of %s is not deterministic", input)
+		}
+	}
+	if output := a.fake([]byte("张三")); !utf8.Valid(output) || string(output) == "张三" {
+		t.Errorf("wrong fake of utf8 string: %s", output)
+	}
+	// fake is a bijection
+	seen := make(map[string]string)
+	for i := -1000; i < 10000; i++ {
+		input := strconv.Itoa(i)
+		output := string(a.fake([]byte(input)))
+		if prev, ok := seen[output]; ok {
+			t.Errorf("%s and %s are both anonymized into %s", prev, input, output)
+		}
+		seen[output] = input
+	}
+	// integers and other data, such as "15" and "05", never get the same fake
+	seen = make(map[string]string)
+	for i := 0; i < 100; i++ {
+		for _, input := range []string{strconv.Itoa(i), fmt.Sprintf("%02d", i)} {
+			output := string(a.fake([]byte(input)))
+			if prev, ok := seen[output]; ok && prev != input {
+				t.Errorf("%s and %s are both anonymized into %s", prev, input, output)
+			}
+			seen[output] = input
+		}
+	}
+	other, _ := newAnonymizer(WithAnonymizeOption("other"))
+	if string(other.fake([]byte("13800138000"))) == string(a.fake([]byte("13800138000"))) {
+		t.Error("fake should depend on secret")
+	}
+}
+
+func TestAnonymizerHash(t *testing.T) {
+	a, err := newAnonymizer(WithAnonymizeOption("secret"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	inputs := []string{"", "a", "1", "-7", "alice", "1001", "-9223372036854775808", "9223372036854775807",
+		strings.Repeat("x", 100), "0"}
+	results := make(chan []byte, len(inputs))
+	for _, input := range inputs {
+		go func(input string) {
+			// anonymizer is shared by workers of parallel pipeline
+			results <- a.hash([]byte(input))
+		}(input)
+	}
+	for range inputs {
+		<-results
+	}
+	for _, input := range inputs {
+		result := a.hash([]byte(input))
+		if len(result) != len(input) {
+			t.Errorf("length of %q changed: %q", input, result)
+		}
+		if isCanonicalInt(result) != isCanonicalInt([]byte(input)) {
+			t.Errorf("integer-ness of %q changed: %q", input, result)
+		}
+		if string(a.hash([]byte(input))) != string(result) {
+			t.Errorf("hash of %q is not deterministic", input)
+		}
+	}
+	if string(a.hash([]byte("alice"))) == string(a.hash([]byte("alicf"))) {
+		t.Error("expect different hashes")
+	}
+}
+
+func TestAnonymizerRules(t *testing.T) {
+	rules, err := ParseAnonymizeRules(strings.NewReader(`
+# comment
+fake ^user:(\d+):(\w+)$
+hash ^session:(.*)$ keep-fields
+fake ^config:.*$ keep-value
+`))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(rules) != 3 || rules[1].Mode != AnonymizeHash || !rules[1].KeepFields || !rules[2].KeepValue {
+		t.Errorf("wrong rules: %+v", rules)
+		return
+	}
+	a, err := newAnonymizer(WithAnonymizeOption("secret", rules...))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	key := a.match("user:1001:profile").anonymizeKey("user:1001:profile")
+	if !strings.HasPrefix(key, "user:") || len(key) != len("user:1001:profile") || key == "user:1001:profile" {
+		t.Errorf("wrong anonymized key: %s", key)
+	}
+	if a.match("config:x").anonymizeKey("config:x") != "config:x" || a.match("other") != nil {
+		t.Error("keys without capture group should be kept")
+	}
+
+	hash := &model.HashObject{
+		BaseObject: &model.BaseObject{Key: "session:abc", Type: model.HashType},
+		Hash:       map[string][]byte{"uid": []byte("1001"), "name": []byte("alice")},
+	}
+	rule := a.match(hash.Key)
+	rule.anonymizeValue(hash)
+	if len(hash.Hash) != 2 || !isCanonicalInt(hash.Hash["uid"]) || string(hash.Hash["name"]) == "alice" {
+		t.Errorf("wrong anonymized hash: %v", hash.Hash)
+	}
+	expiration := time.Unix(1700000000, 0)
+	hash = &model.HashObject{
+		BaseObject:       &model.BaseObject{Key: "user:1:profile", Type: model.HashType},
+		Hash:             map[string][]byte{"name": []byte("alice")},
+		FieldExpirations: map[string]time.Time{"name": expiration},
+	}
+	a.match(hash.Key).anonymizeValue(hash)
+	faked := string(a.fake([]byte("name")))
+	if len(hash.FieldExpirations) != 1 || !hash.FieldExpirations[faked].Equal(expiration) {
+		t.Errorf("field expirations should follow anonymized fields: %v", hash.FieldExpirations)
+	}
+	if key := rule.anonymizeKey("session:abc"); len(key) != len("session:abc") || key == "session:abc" {
+		t.Errorf("wrong hashed key: %s", key)
+	}
+
+	// messages flagged SAMEFIELDS share fields with the master entry
+	fields := []string{"name"}
+	stream := &model.StreamObject{
+		BaseObject: &model.BaseObject{Key: "user:1:events", Type: model.StreamType},
+		Messages: []*model.StreamMessage{
+			{Fields: fields, Values: []string{"alice"}},
+			{Fields: fields, Values: []string{"bob"}},
+		},
+	}
+	a.match(stream.Key).anonymizeValue(stream)
+	for _, msg := range stream.Messages {
+		if msg.Fields[0] != faked {
+			t.Errorf("wrong anonymized stream field: %s, expect %s", msg.Fields[0], faked)
+		}
+	}
+	if fields[0] != "name" {
+		t.Error("shared fields should not be changed")
+	}
+
+	illegalRules := []string{"fake", "fake ^a unknown"}
+	for _, r := range illegalRules {
+		if _, err := ParseAnonymizeRules(strings.NewReader(r)); err == nil {
+			t.Errorf("expect error for %s", r)
+		}
+	}
+	illegalOptions := []AnonymizeOption{
+		WithAnonymizeOption(""),
+		WithAnonymizeOption("secret", &AnonymizeRule{Mode: "unknown", Pattern: ".*"}),
+		WithAnonymizeOption("secret", &AnonymizeRule{Mode: AnonymizeFake, Pattern: "(a"}),
+	}
+	for _, opt := range illegalOptions {
+		if _, err := newAnonymizer(opt); err == nil {
+			t.Errorf("expect error for %+v", opt)
+		}
+	}
+}
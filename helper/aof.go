@@ -0,0 +1,568 @@
+package helper
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/hdt3213/rdb/core"
+	"github.com/hdt3213/rdb/model"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// openDecoder creates decoder of input file, which could be a rdb file, an aof file or a multi-part aof directory
+// of redis 7. Aof is recognized if it is a directory, does not start with rdb magic number, or is named *.aof
+// (aof with rdb preamble), compressed file is named like *.aof.gz. Keyspace of aof is built in memory, objects are passed to callback after it is loaded
+func openDecoder(filename string) (decoder, func(), error) {
+	info, err := os.Stat(filename)
+	if err != nil {
+		return nil, nil, fmt.Errorf("open rdb %s failed, %v", filename, err)
+	}
+	if info.IsDir() {
+		return &aofDecoder{dirname: filename}, func() {}, nil
+	}
+	reader, closer, err := openInput(filename)
+	if err != nil {
+		return nil, nil, fmt.Errorf("open rdb %s failed, %v", filename, err)
+	}
+	magic, _ := reader.Peek(5)
+	if string(magic) == "REDIS" && !strings.HasSuffix(trimCodecExtension(filename), ".aof") {
+		return core.NewDecoder(reader), closer, nil
+	}
+	return &aofDecoder{reader: reader}, closer, nil
+}
+
+// newReaderDecoder creates decoder of rdb or aof in input, which is recognized by magic number like openDecoder.
+// If input is not an *os.File, input starting with rdb magic number is considered as rdb even if commands follow it
+func newReaderDecoder(input io.Reader) (decoder, func(), error) {
+	if input == nil {
+		return nil, nil, errors.New("input is required")
+	}
+	name := ""
+	if file, ok := input.(*os.File); ok {
+		name = trimCodecExtension(file.Name())
+		if info, err := file.Stat(); err == nil && info.IsDir() {
+			return &aofDecoder{dirname: file.Name()}, func() {}, nil
+		}
+	}
+	reader, closer, err := decompress(input)
+	if err != nil {
+		return nil, nil, err
+	}
+	magic, _ := reader.Peek(5)
+	if string(magic) == "REDIS" && !strings.HasSuffix(name, ".aof") {
+		return core.NewDecoder(reader), closer, nil
+	}
+	return &aofDecoder{reader: reader}, closer, nil
+}
+
+// aofDecoder loads aof file or multi-part aof directory, then passes objects of final keyspace to callback
+// ordered by database and the time they were created. Sizes of objects changed by commands are re-computed
+type aofDecoder struct {
+	reader  *bufio.Reader  // single aof file
+	dirname string         // multi-part aof directory
+	skipped *AOFSkipReport // unknown commands are skipped if not nil
+}
+
+func (d *aofDecoder) Parse(cb func(object model.RedisObject) bool) error {
+	ks := newAOFKeyspace()
+	ks.skipped = d.skipped
+	var err error
+	if d.reader != nil {
+		err = ks.load(d.reader)
+	} else {
+		err = ks.loadDir(d.dirname)
+	}
+	if err != nil {
+		return err
+	}
+	return ks.Parse(cb)
+}
+
+// AOFSkipReport collects commands skipped when loading aof, values of their keys may be stale
+type AOFSkipReport struct {
+	Commands map[string]int              // number of skipped commands by name
+	Keys     map[int]map[string]struct{} // keys written by skipped commands by database
+}
+
+// SkipUnknownOption skips commands which could not be applied to keyspace when loading aof instead of failing,
+// such as PFADD
+type SkipUnknownOption *AOFSkipReport
+
+// WithSkipUnknownOption creates a SkipUnknownOption, report will be filled after parsing, it could be nil
+func WithSkipUnknownOption(report *AOFSkipReport) SkipUnknownOption {
+	if report == nil {
+		report = &AOFSkipReport{}
+	}
+	return report
+}
+
+// skipUnknownReport returns report of SkipUnknownOption in options, nil if not found
+func skipUnknownReport(options []interface{}) *AOFSkipReport {
+	for _, opt := range options {
+		if o, ok := opt.(SkipUnknownOption); ok && o != nil {
+			return o
+		}
+	}
+	return nil
+}
+
+// add records a skipped command, args does not include command name
+func (r *AOFSkipReport) add(db int, name string, args [][]byte) {
+	if r.Commands == nil {
+		r.Commands = make(map[string]int)
+		r.Keys = make(map[int]map[string]struct{})
+	}
+	r.Commands[name]++
+	if len(args) == 0 {
+		return
+	}
+	if r.Keys[db] == nil {
+		r.Keys[db] = make(map[string]struct{})
+	}
+	r.Keys[db][string(args[0])] = struct{}{} // key is the first argument of most write commands
+}
+
+// readAOFManifest returns base file and incr files in manifest of multi-part aof directory, base is empty if not exists
+func readAOFManifest(dirname string) (string, []string, error) {
+	manifests, err := filepath.Glob(filepath.Join(dirname, "*.manifest"))
+	if err != nil {
+		return "", nil, err
+	}
+	if len(manifests) != 1 {
+		return "", nil, fmt.Errorf("expect one manifest in %s, found %d", dirname, len(manifests))
+	}
+	data, err := os.ReadFile(manifests[0])
+	if err != nil {
+		return "", nil, err
+	}
+	var base string
+	var incrs []string
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields, err := splitManifestLine(line)
+		if err != nil || len(fields)%2 != 0 {
+			return "", nil, fmt.Errorf("illegal manifest at line %d", i+1)
+		}
+		var name, typ string
+		for j := 0; j < len(fields); j += 2 {
+			switch fields[j] {
+			case "file":
+				name = fields[j+1]
+			case "type":
+				typ = fields[j+1]
+			}
+		}
+		if name == "" || strings.ContainsAny(name, "/\\") {
+			return "", nil, fmt.Errorf("illegal file name in manifest at line %d", i+1)
+		}
+		switch typ {
+		case "b":
+			base = name
+		case "i":
+			incrs = append(incrs, name)
+		case "h": // history file, not loaded
+		default:
+			return "", nil, fmt.Errorf("unknown file type in manifest at line %d", i+1)
+		}
+	}
+	return base, incrs, nil
+}
+
+// splitManifestLine splits line by spaces, file names with special characters are quoted by redis
+func splitManifestLine(line string) ([]string, error) {
+	var fields []string
+	for line != "" {
+		if line[0] == '"' {
+			end := 1
+			for ; end < len(line) && line[end] != '"'; end++ {
+				if line[end] == '\\' {
+					end++
+				}
+			}
+			if end >= len(line) {
+				return nil, errors.New("unterminated quote")
+			}
+			field, err := strconv.Unquote(line[:end+1])
+			if err != nil {
+				return nil, err
+			}
+			fields = append(fields, field)
+			line = strings.TrimLeft(line[end+1:], " ")
+			continue
+		}
+		i := strings.IndexByte(line, ' ')
+		if i < 0 {
+			fields = append(fields, line)
+			break
+		}
+		fields = append(fields, line[:i])
+		line = strings.TrimLeft(line[i+1:], " ")
+	}
+	return fields, nil
+}
+
+type aofEntry struct {
+	object   model.RedisObject
+	seq      int                 // creation order
+	resized  bool                // key or value is changed, size should be re-computed
+	members  map[string]struct{} // members of set being changed by commands
+	scores   map[string]float64  // members of sorted set being changed by commands
+	modified bool                // value is changed, encoding from rdb is no longer valid
+}
+
+// aofKeyspace is keyspace built by loading aof
+type aofKeyspace struct {
+	dbs   map[int]map[string]*aofEntry
+	db    int
+	seq   int
+	multi [][][]byte // commands queued in MULTI, discarded if EXEC is missing
+	inTx  bool
+
+	withSpecial bool                     // pass aux fields, functions and module aux data to callback
+	special     []model.RedisObject      // aux fields and module aux data of rdb
+	functions   []*model.FunctionsObject // function libraries
+	skipRDB     bool                     // rdb preamble and base file are not loaded, keyspace is from another rdb
+	until       *time.Time               // stop at the first timestamp annotation later than it
+	stopped     bool                     // commands after until are found
+	commands    int                      // number of commands applied
+	lastTime    time.Time                // last timestamp annotation applied
+	skipped     *AOFSkipReport           // unknown commands are skipped and recorded if not nil
+}
+
+func newAOFKeyspace() *aofKeyspace {
+	return &aofKeyspace{
+		dbs: make(map[int]map[string]*aofEntry),
+	}
+}
+
+// loadDir loads base file and incr files of multi-part aof directory
+func (ks *aofKeyspace) loadDir(dirname string) error {
+	base, incrs, err := readAOFManifest(dirname)
+	if err != nil {
+		return err
+	}
+	if base != "" && !ks.skipRDB {
+		incrs = append([]string{base}, incrs...)
+	}
+	for _, name := range incrs {
+		if ks.stopped {
+			break
+		}
+		err = ks.loadFile(filepath.Join(dirname, name))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ks *aofKeyspace) loadFile(filename string) error {
+	reader, closer, err := openInput(filename)
+	if err != nil {
+		return fmt.Errorf("open aof %s failed, %v", filename, err)
+	}
+	defer closer()
+	err = ks.load(reader)
+	if err != nil {
+		return fmt.Errorf("load aof %s failed, %v", filename, err)
+	}
+	return nil
+}
+
+// load reads rdb preamble if exists, then applies commands
+func (ks *aofKeyspace) load(reader *bufio.Reader) error {
+	ks.db = 0
+	ks.multi, ks.inTx = nil, false
+	magic, _ := reader.Peek(5)
+	if string(magic) == "REDIS" {
+		// decoder reads from reader directly, so the following commands are not consumed
+		dec := core.NewDecoder(reader).WithSpecialOpCode()
+		err := dec.Parse(func(object model.RedisObject) bool {
+			if !ks.skipRDB {
+				ks.addRDBObject(object)
+			}
+			return true
+		})
+		if err != nil {
+			return err
+		}
+		// checksum after EOF since version 5, encoder of older versions may also write it
+		next, _ := reader.Peek(1)
+		if dec.Version() >= 5 || len(next) > 0 && next[0] != '*' {
+			_, err = reader.Discard(8)
+			if err != nil && err != io.EOF {
+				return err
+			}
+		}
+	}
+	for {
+		args, annotation, err := readCommand(reader)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil // truncated command at the end is ignored like aof-load-truncated
+		}
+		if err != nil {
+			return err
+		}
+		if bytes.HasPrefix(annotation, []byte("TS:")) {
+			sec, err := strconv.ParseInt(string(annotation[3:]), 10, 64)
+			if err != nil {
+				return fmt.Errorf("illegal timestamp annotation: %s", annotation)
+			}
+			t := time.Unix(sec, 0)
+			if ks.until != nil && t.After(*ks.until) {
+				ks.stopped = true
+				ks.multi, ks.inTx = nil, false // incomplete transaction is discarded
+				return nil
+			}
+			ks.lastTime = t
+		}
+		if len(args) == 0 {
+			continue
+		}
+		ks.commands++
+		err = ks.exec(args)
+		if err != nil {
+			return fmt.Errorf("%s: %v", strings.ToUpper(string(args[0])), err)
+		}
+	}
+}
+
+// readCommand reads a command in RESP, or an annotation starting with '#' like #TS:1700000000
+func readCommand(reader *bufio.Reader) ([][]byte, []byte, error) {
+	line, err := readLine(reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(line) == 0 {
+		return nil, nil, nil
+	}
+	if line[0] == '#' {
+		return nil, line[1:], nil
+	}
+	if line[0] != '*' {
+		return nil, nil, fmt.Errorf("illegal aof: %q", line)
+	}
+	n, err := strconv.Atoi(string(line[1:]))
+	if err != nil || n < 0 {
+		return nil, nil, fmt.Errorf("illegal aof: %q", line)
+	}
+	args := make([][]byte, n)
+	for i := range args {
+		line, err = readLine(reader)
+		if err == io.EOF {
+			return nil, nil, io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(line) == 0 || line[0] != '$' {
+			return nil, nil, fmt.Errorf("illegal aof: %q", line)
+		}
+		size, err := strconv.Atoi(string(line[1:]))
+		if err != nil || size < 0 {
+			return nil, nil, fmt.Errorf("illegal aof: %q", line)
+		}
+		arg := make([]byte, size+2)
+		_, err = io.ReadFull(reader, arg)
+		if err == io.EOF {
+			return nil, nil, io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		args[i] = arg[:size]
+	}
+	return args, nil, nil
+}
+
+func readLine(reader *bufio.Reader) ([]byte, error) {
+	line, err := reader.ReadBytes('\n')
+	if err == io.EOF && len(line) > 0 {
+		return nil, io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, err
+	}
+	return bytes.TrimRight(line, "\r\n"), nil
+}
+
+func (ks *aofKeyspace) keys(db int) map[string]*aofEntry {
+	m := ks.dbs[db]
+	if m == nil {
+		m = make(map[string]*aofEntry)
+		ks.dbs[db] = m
+	}
+	return m
+}
+
+func (ks *aofKeyspace) add(db int, object model.RedisObject) *aofEntry {
+	ks.seq++
+	entry := &aofEntry{
+		object: object,
+		seq:    ks.seq,
+	}
+	ks.keys(db)[object.GetKey()] = entry
+	return entry
+}
+
+// addRDBObject adds key or metadata read from rdb
+func (ks *aofKeyspace) addRDBObject(object model.RedisObject) {
+	switch o := object.(type) {
+	case *model.DBSizeObject: // re-computed by encoder
+	case *model.FunctionsObject:
+		ks.loadFunction(o.Code)
+	case *model.AuxObject, *model.ModuleAuxObject:
+		ks.special = append(ks.special, object)
+	default:
+		ks.add(object.GetDBIndex(), object)
+	}
+}
+
+// loadFunction adds function library, library with the same name is replaced
+func (ks *aofKeyspace) loadFunction(code string) {
+	function := &model.FunctionsObject{
+		BaseObject: &model.BaseObject{Type: model.FunctionsType},
+		Code:       code,
+	}
+	name := functionLibraryName(code)
+	for i, f := range ks.functions {
+		if functionLibraryName(f.Code) == name {
+			ks.functions[i] = function
+			return
+		}
+	}
+	ks.functions = append(ks.functions, function)
+}
+
+// now returns time of the last timestamp annotation applied, from which relative ttl of commands is counted, so that
+// replaying is reproducible. Current time is used if no annotation is found yet
+func (ks *aofKeyspace) now() time.Time {
+	if ks.lastTime.IsZero() {
+		return time.Now()
+	}
+	return ks.lastTime
+}
+
+func (ks *aofKeyspace) get(key []byte) *aofEntry {
+	return ks.keys(ks.db)[string(key)]
+}
+
+// Parse passes objects to callback, sets and sorted sets changed by commands are written back
+func (ks *aofKeyspace) Parse(cb func(object model.RedisObject) bool) error {
+	if ks.withSpecial {
+		for _, object := range ks.special {
+			if !cb(object) {
+				return nil
+			}
+		}
+		for _, object := range ks.functions {
+			if !cb(object) {
+				return nil
+			}
+		}
+	}
+	dbs := make([]int, 0, len(ks.dbs))
+	for db := range ks.dbs {
+		dbs = append(dbs, db)
+	}
+	sort.Ints(dbs)
+	for _, db := range dbs {
+		entries := make([]*aofEntry, 0, len(ks.dbs[db]))
+		for _, entry := range ks.dbs[db] {
+			entries = append(entries, entry)
+		}
+		sort.Slice(entries, func(i, j int) bool {
+			return entries[i].seq < entries[j].seq
+		})
+		for _, entry := range entries {
+			object := entry.object
+			base := baseObject(object)
+			base.DB = db
+			entry.flush()
+			if entry.modified {
+				base.Encoding = ""
+			}
+			if entry.resized {
+				size, err := core.EncodedSize(object)
+				if err != nil {
+					return fmt.Errorf("encode %s failed: %v", base.Key, err)
+				}
+				base.Size = size
+			}
+			base.Type = object.GetType()
+			if !cb(object) {
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+// flush writes members of set or sorted set back to object
+func (entry *aofEntry) flush() {
+	if entry.members != nil {
+		o := entry.object.(*model.SetObject)
+		o.Members = make([][]byte, 0, len(entry.members))
+		for member := range entry.members {
+			o.Members = append(o.Members, []byte(member))
+		}
+		sort.Slice(o.Members, func(i, j int) bool {
+			return bytes.Compare(o.Members[i], o.Members[j]) < 0
+		})
+		entry.members = nil
+	}
+	if entry.scores != nil {
+		o := entry.object.(*model.ZSetObject)
+		o.Entries = make([]*model.ZSetEntry, 0, len(entry.scores))
+		for member, score := range entry.scores {
+			o.Entries = append(o.Entries, &model.ZSetEntry{Member: member, Score: score})
+		}
+		sortZSetEntries(o.Entries)
+		entry.scores = nil
+	}
+}
+
+func sortZSetEntries(entries []*model.ZSetEntry) {
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Score != entries[j].Score {
+			return entries[i].Score < entries[j].Score
+		}
+		return entries[i].Member < entries[j].Member
+	})
+}
+
+// exec applies command to keyspace, commands in transaction are applied at EXEC
+func (ks *aofKeyspace) exec(args [][]byte) error {
+	name := strings.ToLower(string(args[0]))
+	switch name {
+	case "multi":
+		ks.inTx = true
+		ks.multi = nil
+		return nil
+	case "exec":
+		queued := ks.multi
+		ks.inTx, ks.multi = false, nil
+		for _, cmd := range queued {
+			err := ks.apply(strings.ToLower(string(cmd[0])), cmd[1:])
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if ks.inTx {
+		ks.multi = append(ks.multi, args)
+		return nil
+	}
+	return ks.apply(name, args[1:])
+}
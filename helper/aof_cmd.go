@@ -0,0 +1,2216 @@
+package helper
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/hdt3213/rdb/core"
+	"github.com/hdt3213/rdb/model"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// aofCommand applies a command to keyspace, args does not include command name
+type aofCommand struct {
+	fn      func(ks *aofKeyspace, args [][]byte) error
+	minArgs int
+}
+
+// aofCommands are write commands could be found in aof, others are refused so that keyspace is never wrong silently,
+// unless SkipUnknownOption is given
+var aofCommands = map[string]*aofCommand{
+	// commands which do not change keyspace
+	"ping":   {fn: execNothing},
+	"script": {fn: execNothing},
+	// function libraries
+	"function": {fn: execFunction, minArgs: 1},
+	// keyspace
+	"select":    {fn: execSelect, minArgs: 1},
+	"del":       {fn: execDel, minArgs: 1},
+	"unlink":    {fn: execDel, minArgs: 1},
+	"flushdb":   {fn: execFlushDB},
+	"flushall":  {fn: execFlushAll},
+	"rename":    {fn: execRename, minArgs: 2},
+	"renamenx":  {fn: execRename, minArgs: 2},
+	"move":      {fn: execMove, minArgs: 2},
+	"swapdb":    {fn: execSwapDB, minArgs: 2},
+	"expire":    {fn: makeExpireExec(time.Second, false), minArgs: 2},
+	"pexpire":   {fn: makeExpireExec(time.Millisecond, false), minArgs: 2},
+	"expireat":  {fn: makeExpireExec(time.Second, true), minArgs: 2},
+	"pexpireat": {fn: makeExpireExec(time.Millisecond, true), minArgs: 2},
+	"persist":   {fn: execPersist, minArgs: 1},
+	"restore":   {fn: execRestore, minArgs: 3},
+	"copy":      {fn: execCopy, minArgs: 2},
+	"sort":      {fn: execSort, minArgs: 1},
+	// string
+	"set":         {fn: execSet, minArgs: 2},
+	"setnx":       {fn: execSetNX, minArgs: 2},
+	"setex":       {fn: makeSetExExec(time.Second), minArgs: 3},
+	"psetex":      {fn: makeSetExExec(time.Millisecond), minArgs: 3},
+	"getset":      {fn: execSet, minArgs: 2},
+	"mset":        {fn: execMSet, minArgs: 2},
+	"msetnx":      {fn: execMSet, minArgs: 2},
+	"getdel":      {fn: execDel, minArgs: 1},
+	"getex":       {fn: execGetEx, minArgs: 1},
+	"append":      {fn: execAppend, minArgs: 2},
+	"setrange":    {fn: execSetRange, minArgs: 3},
+	"incr":        {fn: makeIncrExec(1), minArgs: 1},
+	"decr":        {fn: makeIncrExec(-1), minArgs: 1},
+	"incrby":      {fn: makeIncrExec(1), minArgs: 2},
+	"decrby":      {fn: makeIncrExec(-1), minArgs: 2},
+	"incrbyfloat": {fn: execIncrByFloat, minArgs: 2},
+	// bitmap
+	"setbit":   {fn: execSetBit, minArgs: 3},
+	"bitop":    {fn: execBitOp, minArgs: 3},
+	"bitfield": {fn: execBitField, minArgs: 1},
+	// list
+	"rpush":     {fn: makePushExec(false, false), minArgs: 2},
+	"lpush":     {fn: makePushExec(true, false), minArgs: 2},
+	"rpushx":    {fn: makePushExec(false, true), minArgs: 2},
+	"lpushx":    {fn: makePushExec(true, true), minArgs: 2},
+	"lpop":      {fn: makePopExec(true), minArgs: 1},
+	"rpop":      {fn: makePopExec(false), minArgs: 1},
+	"lset":      {fn: execLSet, minArgs: 3},
+	"lrem":      {fn: execLRem, minArgs: 3},
+	"ltrim":     {fn: execLTrim, minArgs: 3},
+	"linsert":   {fn: execLInsert, minArgs: 4},
+	"rpoplpush": {fn: execRPopLPush, minArgs: 2},
+	"lmove":     {fn: execLMove, minArgs: 4},
+	// hash
+	"hset":         {fn: execHSet, minArgs: 3},
+	"hmset":        {fn: execHSet, minArgs: 3},
+	"hsetnx":       {fn: execHSetNX, minArgs: 3},
+	"hdel":         {fn: execHDel, minArgs: 2},
+	"hincrby":      {fn: execHIncrBy, minArgs: 3},
+	"hincrbyfloat": {fn: execHIncrByFloat, minArgs: 3},
+	"hexpire":      {fn: makeHExpireExec(time.Second, false), minArgs: 5},
+	"hpexpire":     {fn: makeHExpireExec(time.Millisecond, false), minArgs: 5},
+	"hexpireat":    {fn: makeHExpireExec(time.Second, true), minArgs: 5},
+	"hpexpireat":   {fn: makeHExpireExec(time.Millisecond, true), minArgs: 5},
+	"hpersist":     {fn: execHPersist, minArgs: 4},
+	// set
+	"sadd":        {fn: execSAdd, minArgs: 2},
+	"srem":        {fn: execSRem, minArgs: 2},
+	"smove":       {fn: execSMove, minArgs: 3},
+	"sinterstore": {fn: makeSetStoreExec("inter"), minArgs: 2},
+	"sunionstore": {fn: makeSetStoreExec("union"), minArgs: 2},
+	"sdiffstore":  {fn: makeSetStoreExec("diff"), minArgs: 2},
+	// sorted set
+	"zadd":             {fn: execZAdd, minArgs: 3},
+	"zincrby":          {fn: execZIncrBy, minArgs: 3},
+	"zrem":             {fn: execZRem, minArgs: 2},
+	"zremrangebyscore": {fn: execZRemRangeByScore, minArgs: 3},
+	"zremrangebyrank":  {fn: execZRemRangeByRank, minArgs: 3},
+	"zpopmin":          {fn: makeZPopExec(true), minArgs: 1},
+	"zpopmax":          {fn: makeZPopExec(false), minArgs: 1},
+	"zremrangebylex":   {fn: execZRemRangeByLex, minArgs: 3},
+	"zunionstore":      {fn: makeZSetStoreExec("union"), minArgs: 3},
+	"zinterstore":      {fn: makeZSetStoreExec("inter"), minArgs: 3},
+	"zdiffstore":       {fn: makeZSetStoreExec("diff"), minArgs: 3},
+	"zrangestore":      {fn: execZRangeStore, minArgs: 4},
+	"geoadd":           {fn: execGeoAdd, minArgs: 4},
+	// stream
+	"xadd":   {fn: execXAdd, minArgs: 4},
+	"xdel":   {fn: execXDel, minArgs: 2},
+	"xtrim":  {fn: execXTrim, minArgs: 3},
+	"xsetid": {fn: execXSetID, minArgs: 2},
+	"xgroup": {fn: execXGroup, minArgs: 1},
+	"xack":   {fn: execXAck, minArgs: 3},
+	"xclaim": {fn: execXClaim, minArgs: 5},
+}
+
+var errWrongType = errors.New("operation against a key holding the wrong kind of value")
+
+func (ks *aofKeyspace) apply(name string, args [][]byte) error {
+	cmd := aofCommands[name]
+	if cmd == nil {
+		if ks.skipped == nil {
+			return errors.New("unsupported command in aof")
+		}
+		ks.skipped.add(ks.db, name, args)
+		return nil
+	}
+	if len(args) < cmd.minArgs {
+		return errors.New("wrong number of arguments")
+	}
+	return cmd.fn(ks, args)
+}
+
+// touch marks value of entry is changed
+func (entry *aofEntry) touch() {
+	entry.resized = true
+	entry.modified = true
+}
+
+// lookup returns entry of key, nil if not exists. Error is returned if it is not of the given type
+func (ks *aofKeyspace) lookup(key []byte, typ string) (*aofEntry, error) {
+	entry := ks.get(key)
+	if entry != nil && typ != "" && entry.object.GetType() != typ {
+		return nil, errWrongType
+	}
+	return entry, nil
+}
+
+// put sets value of key, it replaces existing key in place
+func (ks *aofKeyspace) put(key []byte, object model.RedisObject) *aofEntry {
+	base := baseObject(object)
+	base.Key = string(key)
+	base.Type = object.GetType()
+	entry := ks.get(key)
+	if entry == nil {
+		entry = ks.add(ks.db, object)
+	} else {
+		entry.object = object
+		entry.members, entry.scores = nil, nil
+	}
+	entry.touch()
+	return entry
+}
+
+// removeIfEmpty deletes collection without any element like redis does
+func (ks *aofKeyspace) removeIfEmpty(key []byte, entry *aofEntry) {
+	var count int
+	switch o := entry.object.(type) {
+	case *model.ListObject:
+		count = len(o.Values)
+	case *model.HashObject:
+		count = len(o.Hash)
+	case *model.SetObject:
+		count = len(entry.setMembers())
+	case *model.ZSetObject:
+		count = len(entry.zsetScores())
+	default:
+		return
+	}
+	if count == 0 {
+		delete(ks.keys(ks.db), string(key))
+	}
+}
+
+func execNothing(ks *aofKeyspace, args [][]byte) error {
+	return nil
+}
+
+// execFunction applies FUNCTION LOAD, DELETE and FLUSH
+func execFunction(ks *aofKeyspace, args [][]byte) error {
+	switch strings.ToLower(string(args[0])) {
+	case "load":
+		if len(args) < 2 {
+			return errors.New("wrong number of arguments for FUNCTION LOAD")
+		}
+		ks.loadFunction(string(args[len(args)-1])) // REPLACE is implied since aof has been accepted by redis
+	case "delete":
+		if len(args) != 2 {
+			return errors.New("wrong number of arguments for FUNCTION DELETE")
+		}
+		for i, f := range ks.functions {
+			if functionLibraryName(f.Code) == string(args[1]) {
+				ks.functions = append(ks.functions[:i], ks.functions[i+1:]...)
+				break
+			}
+		}
+	case "flush":
+		ks.functions = nil
+	}
+	return nil
+}
+
+func parseInt(arg []byte) (int64, error) {
+	v, err := strconv.ParseInt(string(arg), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("value is not an integer: %s", arg)
+	}
+	return v, nil
+}
+
+func parseFloat(arg []byte) (float64, error) {
+	switch strings.ToLower(string(arg)) {
+	case "+inf", "inf":
+		return math.Inf(1), nil
+	case "-inf":
+		return math.Inf(-1), nil
+	}
+	v, err := strconv.ParseFloat(string(arg), 64)
+	if err != nil || math.IsNaN(v) {
+		return 0, fmt.Errorf("value is not a valid float: %s", arg)
+	}
+	return v, nil
+}
+
+func formatFloat(v float64) []byte {
+	return []byte(strconv.FormatFloat(v, 'f', -1, 64))
+}
+
+func execSelect(ks *aofKeyspace, args [][]byte) error {
+	db, err := parseInt(args[0])
+	if err != nil {
+		return err
+	}
+	ks.db = int(db)
+	return nil
+}
+
+func execDel(ks *aofKeyspace, args [][]byte) error {
+	for _, key := range args {
+		delete(ks.keys(ks.db), string(key))
+	}
+	return nil
+}
+
+func execFlushDB(ks *aofKeyspace, args [][]byte) error {
+	delete(ks.dbs, ks.db)
+	return nil
+}
+
+func execFlushAll(ks *aofKeyspace, args [][]byte) error {
+	for db := range ks.dbs {
+		delete(ks.dbs, db)
+	}
+	return nil
+}
+
+func execRename(ks *aofKeyspace, args [][]byte) error {
+	entry := ks.get(args[0])
+	if entry == nil {
+		return nil
+	}
+	keys := ks.keys(ks.db)
+	delete(keys, string(args[0]))
+	baseObject(entry.object).Key = string(args[1])
+	entry.resized = true
+	keys[string(args[1])] = entry
+	return nil
+}
+
+func execMove(ks *aofKeyspace, args [][]byte) error {
+	db, err := parseInt(args[1])
+	if err != nil {
+		return err
+	}
+	entry := ks.get(args[0])
+	if entry == nil {
+		return nil
+	}
+	delete(ks.keys(ks.db), string(args[0]))
+	ks.keys(int(db))[string(args[0])] = entry
+	return nil
+}
+
+func execSwapDB(ks *aofKeyspace, args [][]byte) error {
+	a, err := parseInt(args[0])
+	if err != nil {
+		return err
+	}
+	b, err := parseInt(args[1])
+	if err != nil {
+		return err
+	}
+	ks.dbs[int(a)], ks.dbs[int(b)] = ks.dbs[int(b)], ks.dbs[int(a)]
+	return nil
+}
+
+func setExpiration(entry *aofEntry, expiration *time.Time) {
+	baseObject(entry.object).Expiration = expiration
+}
+
+// makeExpireExec creates EXPIRE like commands, relative ttl is counted from ks.now()
+func makeExpireExec(unit time.Duration, absolute bool) func(ks *aofKeyspace, args [][]byte) error {
+	return func(ks *aofKeyspace, args [][]byte) error {
+		v, err := parseInt(args[1])
+		if err != nil {
+			return err
+		}
+		entry := ks.get(args[0])
+		if entry == nil {
+			return nil
+		}
+		var expiration time.Time
+		if absolute {
+			expiration = time.Unix(0, v*int64(unit))
+		} else {
+			expiration = ks.now().Add(time.Duration(v) * unit)
+		}
+		setExpiration(entry, &expiration)
+		return nil
+	}
+}
+
+func execPersist(ks *aofKeyspace, args [][]byte) error {
+	if entry := ks.get(args[0]); entry != nil {
+		setExpiration(entry, nil)
+	}
+	return nil
+}
+
+// execRestore applies RESTORE key ttl payload [REPLACE] [ABSTTL] [IDLETIME seconds] [FREQ frequency]
+func execRestore(ks *aofKeyspace, args [][]byte) error {
+	ttl, err := parseInt(args[1])
+	if err != nil || ttl < 0 {
+		return errors.New("invalid TTL value, must be >= 0")
+	}
+	var replace, absTTL bool
+	var idle *uint64
+	var freq *uint8
+	for i := 3; i < len(args); i++ {
+		opt := strings.ToLower(string(args[i]))
+		switch opt {
+		case "replace":
+			replace = true
+		case "absttl":
+			absTTL = true
+		case "idletime", "freq":
+			if i+1 >= len(args) {
+				return errors.New("syntax error")
+			}
+			i++
+			v, err := parseInt(args[i])
+			if err != nil || v < 0 || opt == "freq" && v > math.MaxUint8 {
+				return fmt.Errorf("invalid %s value: %s", strings.ToUpper(opt), args[i])
+			}
+			if opt == "freq" {
+				f := uint8(v)
+				freq = &f
+			} else {
+				t := uint64(v)
+				idle = &t
+			}
+		default:
+			return errors.New("syntax error")
+		}
+	}
+	if ks.get(args[0]) != nil && !replace {
+		return nil // BUSYKEY, not applied
+	}
+	object, err := core.DecodeDump(args[2])
+	if err != nil {
+		return err
+	}
+	var expiration *time.Time
+	if ttl > 0 {
+		t := ks.now().Add(time.Duration(ttl) * time.Millisecond)
+		if absTTL {
+			t = time.Unix(0, ttl*int64(time.Millisecond))
+		}
+		expiration = &t
+	}
+	entry := ks.put(args[0], object)
+	entry.modified = false // encoding of payload is still valid
+	setExpiration(entry, expiration)
+	base := baseObject(object)
+	base.Idle, base.Freq = idle, freq
+	return nil
+}
+
+// execCopy applies COPY source destination [DB destination-db] [REPLACE], value is deep copied through DUMP payload
+func execCopy(ks *aofKeyspace, args [][]byte) error {
+	db := ks.db
+	replace := false
+	for i := 2; i < len(args); i++ {
+		switch strings.ToLower(string(args[i])) {
+		case "replace":
+			replace = true
+		case "db":
+			if i+1 >= len(args) {
+				return errors.New("syntax error")
+			}
+			i++
+			v, err := parseInt(args[i])
+			if err != nil {
+				return err
+			}
+			db = int(v)
+		default:
+			return errors.New("syntax error")
+		}
+	}
+	src := ks.get(args[0])
+	if src == nil || db == ks.db && bytes.Equal(args[0], args[1]) {
+		return nil
+	}
+	if ks.keys(db)[string(args[1])] != nil && !replace {
+		return nil
+	}
+	src.flush()
+	payload, err := core.DumpObject(src.object, core.MaxTargetVersion)
+	if err != nil {
+		return err
+	}
+	object, err := core.DecodeDump(payload)
+	if err != nil {
+		return err
+	}
+	current := ks.db
+	ks.db = db
+	entry := ks.put(args[1], object)
+	ks.db = current
+	entry.modified = src.modified
+	if expiration := src.object.GetExpiration(); expiration != nil {
+		t := *expiration
+		setExpiration(entry, &t)
+	}
+	return nil
+}
+
+// parseExpireArg parses EX, PX, EXAT, PXAT of SET and GETEX, returns nil if arg is not one of them
+func parseExpireArg(arg []byte, value []byte, now time.Time) (*time.Time, bool, error) {
+	var unit time.Duration
+	absolute := false
+	switch strings.ToLower(string(arg)) {
+	case "ex":
+		unit = time.Second
+	case "px":
+		unit = time.Millisecond
+	case "exat":
+		unit, absolute = time.Second, true
+	case "pxat":
+		unit, absolute = time.Millisecond, true
+	default:
+		return nil, false, nil
+	}
+	if value == nil {
+		return nil, true, errors.New("syntax error")
+	}
+	v, err := parseInt(value)
+	if err != nil {
+		return nil, true, err
+	}
+	var expiration time.Time
+	if absolute {
+		expiration = time.Unix(0, v*int64(unit))
+	} else {
+		expiration = now.Add(time.Duration(v) * unit)
+	}
+	return &expiration, true, nil
+}
+
+func newStringObject(value []byte) *model.StringObject {
+	return &model.StringObject{
+		BaseObject: &model.BaseObject{},
+		Value:      value,
+	}
+}
+
+func execSet(ks *aofKeyspace, args [][]byte) error {
+	var expiration *time.Time
+	keepTTL := false
+	for i := 2; i < len(args); i++ {
+		var next []byte
+		if i+1 < len(args) {
+			next = args[i+1]
+		}
+		exp, ok, err := parseExpireArg(args[i], next, ks.now())
+		if err != nil {
+			return err
+		}
+		if ok {
+			expiration = exp
+			i++
+			continue
+		}
+		if strings.EqualFold(string(args[i]), "keepttl") {
+			keepTTL = true
+		}
+	}
+	if keepTTL {
+		if entry := ks.get(args[0]); entry != nil {
+			expiration = entry.object.GetExpiration()
+		}
+	}
+	entry := ks.put(args[0], newStringObject(args[1]))
+	setExpiration(entry, expiration)
+	return nil
+}
+
+func execSetNX(ks *aofKeyspace, args [][]byte) error {
+	if ks.get(args[0]) == nil {
+		ks.put(args[0], newStringObject(args[1]))
+	}
+	return nil
+}
+
+func makeSetExExec(unit time.Duration) func(ks *aofKeyspace, args [][]byte) error {
+	return func(ks *aofKeyspace, args [][]byte) error {
+		v, err := parseInt(args[1])
+		if err != nil {
+			return err
+		}
+		expiration := ks.now().Add(time.Duration(v) * unit)
+		entry := ks.put(args[0], newStringObject(args[2]))
+		setExpiration(entry, &expiration)
+		return nil
+	}
+}
+
+func execMSet(ks *aofKeyspace, args [][]byte) error {
+	if len(args)%2 != 0 {
+		return errors.New("wrong number of arguments")
+	}
+	for i := 0; i < len(args); i += 2 {
+		ks.put(args[i], newStringObject(args[i+1]))
+	}
+	return nil
+}
+
+func execGetEx(ks *aofKeyspace, args [][]byte) error {
+	entry := ks.get(args[0])
+	if entry == nil || len(args) < 2 {
+		return nil
+	}
+	if strings.EqualFold(string(args[1]), "persist") {
+		setExpiration(entry, nil)
+		return nil
+	}
+	var next []byte
+	if len(args) > 2 {
+		next = args[2]
+	}
+	expiration, ok, err := parseExpireArg(args[1], next, ks.now())
+	if err != nil || !ok {
+		return err
+	}
+	setExpiration(entry, expiration)
+	return nil
+}
+
+// getString returns string value of key, nil if not exists
+func (ks *aofKeyspace) getString(key []byte) (*aofEntry, *model.StringObject, error) {
+	entry, err := ks.lookup(key, model.StringType)
+	if err != nil || entry == nil {
+		return nil, nil, err
+	}
+	return entry, entry.object.(*model.StringObject), nil
+}
+
+// updateString sets value of string and keeps its expiration
+func (ks *aofKeyspace) updateString(key []byte, value []byte) error {
+	entry, obj, err := ks.getString(key)
+	if err != nil {
+		return err
+	}
+	if entry == nil {
+		ks.put(key, newStringObject(value))
+		return nil
+	}
+	obj.Value = value
+	entry.touch()
+	return nil
+}
+
+func execAppend(ks *aofKeyspace, args [][]byte) error {
+	_, obj, err := ks.getString(args[0])
+	if err != nil {
+		return err
+	}
+	var value []byte
+	if obj != nil {
+		value = append(value, obj.Value...)
+	}
+	return ks.updateString(args[0], append(value, args[1]...))
+}
+
+func execSetRange(ks *aofKeyspace, args [][]byte) error {
+	offset, err := parseInt(args[1])
+	if err != nil || offset < 0 {
+		return errors.New("offset is out of range")
+	}
+	_, obj, err := ks.getString(args[0])
+	if err != nil {
+		return err
+	}
+	var value []byte
+	if obj != nil {
+		value = append(value, obj.Value...)
+	}
+	if end := int(offset) + len(args[2]); end > len(value) {
+		value = append(value, make([]byte, end-len(value))...)
+	}
+	copy(value[offset:], args[2])
+	return ks.updateString(args[0], value)
+}
+
+func makeIncrExec(sign int64) func(ks *aofKeyspace, args [][]byte) error {
+	return func(ks *aofKeyspace, args [][]byte) error {
+		delta := int64(1)
+		if len(args) > 1 {
+			var err error
+			delta, err = parseInt(args[1])
+			if err != nil {
+				return err
+			}
+		}
+		_, obj, err := ks.getString(args[0])
+		if err != nil {
+			return err
+		}
+		var v int64
+		if obj != nil {
+			v, err = parseInt(obj.Value)
+			if err != nil {
+				return err
+			}
+		}
+		return ks.updateString(args[0], []byte(strconv.FormatInt(v+sign*delta, 10)))
+	}
+}
+
+func execIncrByFloat(ks *aofKeyspace, args [][]byte) error {
+	delta, err := parseFloat(args[1])
+	if err != nil {
+		return err
+	}
+	_, obj, err := ks.getString(args[0])
+	if err != nil {
+		return err
+	}
+	var v float64
+	if obj != nil {
+		v, err = parseFloat(obj.Value)
+		if err != nil {
+			return err
+		}
+	}
+	return ks.updateString(args[0], formatFloat(v+delta))
+}
+
+// getBit returns bit at offset of value, bits out of value are 0
+func getBit(value []byte, offset uint64) uint64 {
+	if offset>>3 >= uint64(len(value)) {
+		return 0
+	}
+	return uint64(value[offset>>3]>>(7-offset&7)) & 1
+}
+
+// setBit sets bit at offset of value, value must be long enough
+func setBit(value []byte, offset uint64, bit uint64) {
+	mask := byte(1) << (7 - offset&7)
+	if bit == 0 {
+		value[offset>>3] &^= mask
+	} else {
+		value[offset>>3] |= mask
+	}
+}
+
+// growString returns copy of value which has at least n bytes
+func growString(value []byte, n uint64) []byte {
+	size := uint64(len(value))
+	if n < size {
+		n = size
+	}
+	result := make([]byte, n)
+	copy(result, value)
+	return result
+}
+
+func execSetBit(ks *aofKeyspace, args [][]byte) error {
+	offset, err := parseInt(args[1])
+	if err != nil || offset < 0 || offset >= 1<<32 {
+		return errors.New("bit offset is not an integer or out of range")
+	}
+	bit := string(args[2])
+	if bit != "0" && bit != "1" {
+		return errors.New("bit is not an integer or out of range")
+	}
+	_, obj, err := ks.getString(args[0])
+	if err != nil {
+		return err
+	}
+	var value []byte
+	if obj != nil {
+		value = obj.Value
+	}
+	value = growString(value, uint64(offset>>3)+1)
+	setBit(value, uint64(offset), uint64(bit[0]-'0'))
+	return ks.updateString(args[0], value)
+}
+
+// execBitOp applies BITOP AND|OR|XOR|NOT destkey key [key ...], missing keys are treated as empty strings
+func execBitOp(ks *aofKeyspace, args [][]byte) error {
+	op := strings.ToLower(string(args[0]))
+	switch op {
+	case "and", "or", "xor":
+	case "not":
+		if len(args) != 3 {
+			return errors.New("BITOP NOT must be called with a single source key")
+		}
+	default:
+		return errors.New("syntax error")
+	}
+	var values [][]byte
+	maxLen := 0
+	for _, key := range args[2:] {
+		_, obj, err := ks.getString(key)
+		if err != nil {
+			return err
+		}
+		var value []byte
+		if obj != nil {
+			value = obj.Value
+		}
+		values = append(values, value)
+		if len(value) > maxLen {
+			maxLen = len(value)
+		}
+	}
+	if maxLen == 0 {
+		delete(ks.keys(ks.db), string(args[1]))
+		return nil
+	}
+	result := make([]byte, maxLen)
+	for i := range result {
+		var b byte
+		for j, value := range values {
+			var v byte
+			if i < len(value) {
+				v = value[i]
+			}
+			switch {
+			case op == "not":
+				b = ^v
+			case j == 0:
+				b = v
+			case op == "and":
+				b &= v
+			case op == "or":
+				b |= v
+			case op == "xor":
+				b ^= v
+			}
+		}
+		result[i] = b
+	}
+	ks.put(args[1], newStringObject(result))
+	return nil
+}
+
+// parseBitFieldType parses type of BITFIELD like i8 or u16
+func parseBitFieldType(arg []byte) (bool, int, error) {
+	if len(arg) > 1 && (arg[0] == 'i' || arg[0] == 'u') {
+		signed := arg[0] == 'i'
+		bits, err := strconv.Atoi(string(arg[1:]))
+		if err == nil && bits > 0 && (signed && bits <= 64 || !signed && bits <= 63) {
+			return signed, bits, nil
+		}
+	}
+	return false, 0, errors.New("invalid bitfield type. Use something like i16 u8. Note that u64 is not supported but i64 is")
+}
+
+// parseBitFieldOffset parses offset of BITFIELD, offset prefixed by # is multiplied by bits of type
+func parseBitFieldOffset(arg []byte, bits int) (uint64, error) {
+	multiply := len(arg) > 0 && arg[0] == '#'
+	if multiply {
+		arg = arg[1:]
+	}
+	v, err := parseInt(arg)
+	if multiply {
+		v *= int64(bits)
+	}
+	if err != nil || v < 0 || v+int64(bits) > 1<<32 {
+		return 0, errors.New("bit offset is not an integer or out of range")
+	}
+	return uint64(v), nil
+}
+
+// signedBitField returns value+incr in the given bits according to overflow policy, false if it fails by FAIL policy
+func signedBitField(value, incr int64, bits int, overflow string) (int64, bool) {
+	max := int64(math.MaxInt64)
+	if bits != 64 {
+		max = 1<<(bits-1) - 1
+	}
+	min := -max - 1
+	maxIncr, minIncr := uint64(max)-uint64(value), uint64(min)-uint64(value)
+	over := value > max || bits != 64 && incr > int64(maxIncr) || value >= 0 && incr > 0 && incr > int64(maxIncr)
+	under := !over && (value < min || bits != 64 && incr < int64(minIncr) || value < 0 && incr < 0 && incr < int64(minIncr))
+	if !over && !under {
+		return value + incr, true
+	}
+	switch overflow {
+	case "sat":
+		if over {
+			return max, true
+		}
+		return min, true
+	case "fail":
+		return 0, false
+	}
+	c := uint64(value) + uint64(incr)
+	if bits < 64 {
+		mask := ^uint64(0) << uint(bits)
+		if c&(1<<uint(bits-1)) != 0 {
+			c |= mask
+		} else {
+			c &^= mask
+		}
+	}
+	return int64(c), true
+}
+
+// unsignedBitField is like signedBitField for unsigned types
+func unsignedBitField(value uint64, incr int64, bits int, overflow string) (uint64, bool) {
+	max := uint64(1)<<uint(bits) - 1
+	maxIncr, minIncr := max-value, -int64(value)
+	over := value > max || incr > 0 && uint64(incr) > maxIncr
+	under := !over && incr < 0 && incr < minIncr
+	if !over && !under {
+		return value + uint64(incr), true
+	}
+	switch overflow {
+	case "sat":
+		if over {
+			return max, true
+		}
+		return 0, true
+	case "fail":
+		return 0, false
+	}
+	return (value + uint64(incr)) & max, true
+}
+
+// execBitField applies SET and INCRBY of BITFIELD with OVERFLOW WRAP|SAT|FAIL, GET is ignored
+func execBitField(ks *aofKeyspace, args [][]byte) error {
+	overflow := "wrap"
+	var value []byte
+	loaded := false
+	for i := 1; i < len(args); i++ {
+		op := strings.ToLower(string(args[i]))
+		switch op {
+		case "overflow":
+			if i+1 >= len(args) {
+				return errors.New("syntax error")
+			}
+			i++
+			overflow = strings.ToLower(string(args[i]))
+			if overflow != "wrap" && overflow != "sat" && overflow != "fail" {
+				return errors.New("invalid OVERFLOW type specified")
+			}
+			continue
+		case "get":
+			i += 2
+			continue
+		case "set", "incrby":
+		default:
+			return errors.New("syntax error")
+		}
+		if i+3 >= len(args) {
+			return errors.New("syntax error")
+		}
+		signed, bits, err := parseBitFieldType(args[i+1])
+		if err != nil {
+			return err
+		}
+		offset, err := parseBitFieldOffset(args[i+2], bits)
+		if err != nil {
+			return err
+		}
+		arg, err := parseInt(args[i+3])
+		if err != nil {
+			return err
+		}
+		i += 3
+		if !loaded {
+			_, obj, err := ks.getString(args[0])
+			if err != nil {
+				return err
+			}
+			if obj != nil {
+				value = obj.Value
+			}
+			loaded = true
+		}
+		var old uint64
+		for j := 0; j < bits; j++ {
+			old = old<<1 | getBit(value, offset+uint64(j))
+		}
+		var result uint64
+		var ok bool
+		if signed {
+			v := int64(old<<uint(64-bits)) >> uint(64-bits) // sign extension
+			var r int64
+			if op == "set" {
+				r, ok = signedBitField(arg, 0, bits, overflow)
+			} else {
+				r, ok = signedBitField(v, arg, bits, overflow)
+			}
+			result = uint64(r)
+		} else if op == "set" {
+			result, ok = unsignedBitField(uint64(arg), 0, bits, overflow)
+		} else {
+			result, ok = unsignedBitField(old, arg, bits, overflow)
+		}
+		value = growString(value, (offset+uint64(bits)+7)>>3) // string is grown even if it fails
+		if !ok {
+			continue
+		}
+		for j := 0; j < bits; j++ {
+			setBit(value, offset+uint64(j), result>>uint(bits-1-j)&1)
+		}
+	}
+	if !loaded {
+		return nil
+	}
+	return ks.updateString(args[0], value)
+}
+
+// getList returns list of key, a new list is created if create is true and key does not exist
+func (ks *aofKeyspace) getList(key []byte, create bool) (*aofEntry, *model.ListObject, error) {
+	entry, err := ks.lookup(key, model.ListType)
+	if err != nil {
+		return nil, nil, err
+	}
+	if entry == nil {
+		if !create {
+			return nil, nil, nil
+		}
+		entry = ks.put(key, &model.ListObject{BaseObject: &model.BaseObject{}})
+	}
+	entry.touch()
+	return entry, entry.object.(*model.ListObject), nil
+}
+
+func pushList(obj *model.ListObject, left bool, values ...[]byte) {
+	if !left {
+		obj.Values = append(obj.Values, values...)
+		return
+	}
+	list := make([][]byte, 0, len(obj.Values)+len(values))
+	for i := len(values) - 1; i >= 0; i-- {
+		list = append(list, values[i])
+	}
+	obj.Values = append(list, obj.Values...)
+}
+
+func makePushExec(left bool, exists bool) func(ks *aofKeyspace, args [][]byte) error {
+	return func(ks *aofKeyspace, args [][]byte) error {
+		_, obj, err := ks.getList(args[0], !exists)
+		if err != nil || obj == nil {
+			return err
+		}
+		pushList(obj, left, args[1:]...)
+		return nil
+	}
+}
+
+// popList removes count elements from list, returns removed elements
+func (ks *aofKeyspace) popList(key []byte, left bool, count int) ([][]byte, error) {
+	entry, obj, err := ks.getList(key, false)
+	if err != nil || obj == nil {
+		return nil, err
+	}
+	if count > len(obj.Values) {
+		count = len(obj.Values)
+	}
+	var popped [][]byte
+	if left {
+		popped = obj.Values[:count]
+		obj.Values = obj.Values[count:]
+	} else {
+		popped = make([][]byte, 0, count)
+		for i := len(obj.Values) - 1; i >= len(obj.Values)-count; i-- {
+			popped = append(popped, obj.Values[i])
+		}
+		obj.Values = obj.Values[:len(obj.Values)-count]
+	}
+	ks.removeIfEmpty(key, entry)
+	return popped, nil
+}
+
+func makePopExec(left bool) func(ks *aofKeyspace, args [][]byte) error {
+	return func(ks *aofKeyspace, args [][]byte) error {
+		count := int64(1)
+		if len(args) > 1 {
+			var err error
+			count, err = parseInt(args[1])
+			if err != nil || count < 0 {
+				return errors.New("value is out of range, must be positive")
+			}
+		}
+		_, err := ks.popList(args[0], left, int(count))
+		return err
+	}
+}
+
+// listIndex converts negative index to positive one
+func listIndex(index int64, size int) int {
+	if index < 0 {
+		index += int64(size)
+	}
+	return int(index)
+}
+
+func execLSet(ks *aofKeyspace, args [][]byte) error {
+	index, err := parseInt(args[1])
+	if err != nil {
+		return err
+	}
+	_, obj, err := ks.getList(args[0], false)
+	if err != nil || obj == nil {
+		return err
+	}
+	i := listIndex(index, len(obj.Values))
+	if i < 0 || i >= len(obj.Values) {
+		return errors.New("index out of range")
+	}
+	obj.Values[i] = args[2]
+	return nil
+}
+
+func execLRem(ks *aofKeyspace, args [][]byte) error {
+	count, err := parseInt(args[1])
+	if err != nil {
+		return err
+	}
+	entry, obj, err := ks.getList(args[0], false)
+	if err != nil || obj == nil {
+		return err
+	}
+	removed := int64(0)
+	matches := func(v []byte) bool {
+		if !bytes.Equal(v, args[2]) || count != 0 && removed >= count && removed >= -count {
+			return false
+		}
+		removed++
+		return true
+	}
+	values := make([][]byte, 0, len(obj.Values))
+	if count >= 0 {
+		for _, v := range obj.Values {
+			if !matches(v) {
+				values = append(values, v)
+			}
+		}
+	} else {
+		for i := len(obj.Values) - 1; i >= 0; i-- {
+			if !matches(obj.Values[i]) {
+				values = append(values, obj.Values[i])
+			}
+		}
+		for i, j := 0, len(values)-1; i < j; i, j = i+1, j-1 {
+			values[i], values[j] = values[j], values[i]
+		}
+	}
+	obj.Values = values
+	ks.removeIfEmpty(args[0], entry)
+	return nil
+}
+
+func execLTrim(ks *aofKeyspace, args [][]byte) error {
+	start, err := parseInt(args[1])
+	if err != nil {
+		return err
+	}
+	stop, err := parseInt(args[2])
+	if err != nil {
+		return err
+	}
+	entry, obj, err := ks.getList(args[0], false)
+	if err != nil || obj == nil {
+		return err
+	}
+	size := len(obj.Values)
+	begin, end := listIndex(start, size), listIndex(stop, size)
+	if begin < 0 {
+		begin = 0
+	}
+	if end >= size {
+		end = size - 1
+	}
+	if begin > end {
+		obj.Values = nil
+	} else {
+		obj.Values = obj.Values[begin : end+1]
+	}
+	ks.removeIfEmpty(args[0], entry)
+	return nil
+}
+
+func execLInsert(ks *aofKeyspace, args [][]byte) error {
+	before := strings.EqualFold(string(args[1]), "before")
+	if !before && !strings.EqualFold(string(args[1]), "after") {
+		return errors.New("syntax error")
+	}
+	_, obj, err := ks.getList(args[0], false)
+	if err != nil || obj == nil {
+		return err
+	}
+	for i, v := range obj.Values {
+		if !bytes.Equal(v, args[2]) {
+			continue
+		}
+		if !before {
+			i++
+		}
+		values := make([][]byte, 0, len(obj.Values)+1)
+		values = append(values, obj.Values[:i]...)
+		values = append(values, args[3])
+		obj.Values = append(values, obj.Values[i:]...)
+		break
+	}
+	return nil
+}
+
+// moveList pops an element from src and pushes it into dst
+func (ks *aofKeyspace) moveList(src, dst []byte, fromLeft, toLeft bool) error {
+	if _, err := ks.lookup(dst, model.ListType); err != nil {
+		return err
+	}
+	popped, err := ks.popList(src, fromLeft, 1)
+	if err != nil || len(popped) == 0 {
+		return err
+	}
+	_, obj, err := ks.getList(dst, true)
+	if err != nil {
+		return err
+	}
+	pushList(obj, toLeft, popped[0])
+	return nil
+}
+
+func execRPopLPush(ks *aofKeyspace, args [][]byte) error {
+	return ks.moveList(args[0], args[1], false, true)
+}
+
+func parseListSide(arg []byte) (bool, error) {
+	switch strings.ToLower(string(arg)) {
+	case "left":
+		return true, nil
+	case "right":
+		return false, nil
+	}
+	return false, errors.New("syntax error")
+}
+
+func execLMove(ks *aofKeyspace, args [][]byte) error {
+	fromLeft, err := parseListSide(args[2])
+	if err != nil {
+		return err
+	}
+	toLeft, err := parseListSide(args[3])
+	if err != nil {
+		return err
+	}
+	return ks.moveList(args[0], args[1], fromLeft, toLeft)
+}
+
+// getHash returns hash of key, a new hash is created if create is true and key does not exist
+func (ks *aofKeyspace) getHash(key []byte, create bool) (*aofEntry, *model.HashObject, error) {
+	entry, err := ks.lookup(key, model.HashType)
+	if err != nil {
+		return nil, nil, err
+	}
+	if entry == nil {
+		if !create {
+			return nil, nil, nil
+		}
+		entry = ks.put(key, &model.HashObject{BaseObject: &model.BaseObject{}, Hash: make(map[string][]byte)})
+	}
+	entry.touch()
+	return entry, entry.object.(*model.HashObject), nil
+}
+
+func execHSet(ks *aofKeyspace, args [][]byte) error {
+	if len(args)%2 != 1 {
+		return errors.New("wrong number of arguments")
+	}
+	_, obj, err := ks.getHash(args[0], true)
+	if err != nil {
+		return err
+	}
+	for i := 1; i < len(args); i += 2 {
+		obj.Hash[string(args[i])] = args[i+1]
+		persistField(obj, string(args[i])) // overwriting field clears its ttl
+	}
+	return nil
+}
+
+func execHSetNX(ks *aofKeyspace, args [][]byte) error {
+	_, obj, err := ks.getHash(args[0], true)
+	if err != nil {
+		return err
+	}
+	if _, ok := obj.Hash[string(args[1])]; !ok {
+		obj.Hash[string(args[1])] = args[2]
+	}
+	return nil
+}
+
+func execHDel(ks *aofKeyspace, args [][]byte) error {
+	entry, obj, err := ks.getHash(args[0], false)
+	if err != nil || obj == nil {
+		return err
+	}
+	for _, field := range args[1:] {
+		delete(obj.Hash, string(field))
+		persistField(obj, string(field))
+	}
+	ks.removeIfEmpty(args[0], entry)
+	return nil
+}
+
+func execHIncrBy(ks *aofKeyspace, args [][]byte) error {
+	delta, err := parseInt(args[2])
+	if err != nil {
+		return err
+	}
+	_, obj, err := ks.getHash(args[0], true)
+	if err != nil {
+		return err
+	}
+	var v int64
+	if value, ok := obj.Hash[string(args[1])]; ok {
+		v, err = parseInt(value)
+		if err != nil {
+			return err
+		}
+	}
+	obj.Hash[string(args[1])] = []byte(strconv.FormatInt(v+delta, 10))
+	return nil
+}
+
+func execHIncrByFloat(ks *aofKeyspace, args [][]byte) error {
+	delta, err := parseFloat(args[2])
+	if err != nil {
+		return err
+	}
+	_, obj, err := ks.getHash(args[0], true)
+	if err != nil {
+		return err
+	}
+	var v float64
+	if value, ok := obj.Hash[string(args[1])]; ok {
+		v, err = parseFloat(value)
+		if err != nil {
+			return err
+		}
+	}
+	obj.Hash[string(args[1])] = formatFloat(v + delta)
+	return nil
+}
+
+// persistField removes expiration of hash field
+func persistField(obj *model.HashObject, field string) {
+	if obj.FieldExpirations == nil {
+		return
+	}
+	delete(obj.FieldExpirations, field)
+	if len(obj.FieldExpirations) == 0 {
+		obj.FieldExpirations = nil
+	}
+}
+
+// parseHashFields parses FIELDS numfields field [field ...] of hash field ttl commands
+func parseHashFields(args [][]byte) ([][]byte, error) {
+	if len(args) < 2 || !strings.EqualFold(string(args[0]), "fields") {
+		return nil, errors.New("mandatory argument FIELDS is missing or not at the right position")
+	}
+	n, err := parseInt(args[1])
+	if err != nil || n <= 0 || int(n) != len(args)-2 {
+		return nil, errors.New("the numfields parameter must match the number of arguments")
+	}
+	return args[2:], nil
+}
+
+// makeHExpireExec creates HEXPIRE like commands: key ttl [NX|XX|GT|LT] FIELDS numfields field [field ...].
+// Expiration in the past is kept like redis does when loading aof
+func makeHExpireExec(unit time.Duration, absolute bool) func(ks *aofKeyspace, args [][]byte) error {
+	return func(ks *aofKeyspace, args [][]byte) error {
+		v, err := parseInt(args[1])
+		if err != nil {
+			return err
+		}
+		i := 2
+		cond := strings.ToLower(string(args[2]))
+		switch cond {
+		case "nx", "xx", "gt", "lt":
+			i++
+		default:
+			cond = ""
+		}
+		fields, err := parseHashFields(args[i:])
+		if err != nil {
+			return err
+		}
+		_, obj, err := ks.getHash(args[0], false)
+		if err != nil || obj == nil {
+			return err
+		}
+		var expiration time.Time
+		if absolute {
+			expiration = time.Unix(0, v*int64(unit))
+		} else {
+			expiration = ks.now().Add(time.Duration(v) * unit)
+		}
+		for _, f := range fields {
+			field := string(f)
+			if _, ok := obj.Hash[field]; !ok {
+				continue
+			}
+			current, hasTTL := obj.FieldExpirations[field]
+			if cond == "nx" && hasTTL || cond == "xx" && !hasTTL ||
+				cond == "gt" && (!hasTTL || !expiration.After(current)) ||
+				cond == "lt" && hasTTL && !expiration.Before(current) {
+				continue
+			}
+			if obj.FieldExpirations == nil {
+				obj.FieldExpirations = make(map[string]time.Time)
+			}
+			obj.FieldExpirations[field] = expiration
+		}
+		return nil
+	}
+}
+
+func execHPersist(ks *aofKeyspace, args [][]byte) error {
+	fields, err := parseHashFields(args[1:])
+	if err != nil {
+		return err
+	}
+	_, obj, err := ks.getHash(args[0], false)
+	if err != nil || obj == nil {
+		return err
+	}
+	for _, field := range fields {
+		persistField(obj, string(field))
+	}
+	return nil
+}
+
+// setMembers returns members of set, which are collected into map at the first change
+func (entry *aofEntry) setMembers() map[string]struct{} {
+	if entry.members == nil {
+		obj := entry.object.(*model.SetObject)
+		entry.members = make(map[string]struct{}, len(obj.Members))
+		for _, member := range obj.Members {
+			entry.members[string(member)] = struct{}{}
+		}
+	}
+	return entry.members
+}
+
+// getSet returns members of set, a new set is created if create is true and key does not exist
+func (ks *aofKeyspace) getSet(key []byte, create bool) (*aofEntry, map[string]struct{}, error) {
+	entry, err := ks.lookup(key, model.SetType)
+	if err != nil {
+		return nil, nil, err
+	}
+	if entry == nil {
+		if !create {
+			return nil, nil, nil
+		}
+		entry = ks.put(key, &model.SetObject{BaseObject: &model.BaseObject{}})
+	}
+	entry.touch()
+	return entry, entry.setMembers(), nil
+}
+
+func execSAdd(ks *aofKeyspace, args [][]byte) error {
+	_, members, err := ks.getSet(args[0], true)
+	if err != nil {
+		return err
+	}
+	for _, member := range args[1:] {
+		members[string(member)] = struct{}{}
+	}
+	return nil
+}
+
+func execSRem(ks *aofKeyspace, args [][]byte) error {
+	entry, members, err := ks.getSet(args[0], false)
+	if err != nil || members == nil {
+		return err
+	}
+	for _, member := range args[1:] {
+		delete(members, string(member))
+	}
+	ks.removeIfEmpty(args[0], entry)
+	return nil
+}
+
+func execSMove(ks *aofKeyspace, args [][]byte) error {
+	if _, err := ks.lookup(args[1], model.SetType); err != nil {
+		return err
+	}
+	entry, members, err := ks.getSet(args[0], false)
+	if err != nil || members == nil {
+		return err
+	}
+	if _, ok := members[string(args[2])]; !ok {
+		return nil
+	}
+	delete(members, string(args[2]))
+	ks.removeIfEmpty(args[0], entry)
+	_, members, err = ks.getSet(args[1], true)
+	if err != nil {
+		return err
+	}
+	members[string(args[2])] = struct{}{}
+	return nil
+}
+
+func makeSetStoreExec(op string) func(ks *aofKeyspace, args [][]byte) error {
+	return func(ks *aofKeyspace, args [][]byte) error {
+		var result map[string]struct{}
+		for i, key := range args[1:] {
+			entry, err := ks.lookup(key, model.SetType)
+			if err != nil {
+				return err
+			}
+			var members map[string]struct{}
+			if entry != nil {
+				members = entry.setMembers()
+			}
+			if i == 0 {
+				result = make(map[string]struct{}, len(members))
+				for member := range members {
+					result[member] = struct{}{}
+				}
+				continue
+			}
+			switch op {
+			case "inter":
+				for member := range result {
+					if _, ok := members[member]; !ok {
+						delete(result, member)
+					}
+				}
+			case "union":
+				for member := range members {
+					result[member] = struct{}{}
+				}
+			case "diff":
+				for member := range members {
+					delete(result, member)
+				}
+			}
+		}
+		if len(result) == 0 {
+			delete(ks.keys(ks.db), string(args[0]))
+			return nil
+		}
+		entry := ks.put(args[0], &model.SetObject{BaseObject: &model.BaseObject{}})
+		entry.members = result
+		return nil
+	}
+}
+
+// zsetScores returns members of sorted set, which are collected into map at the first change
+func (entry *aofEntry) zsetScores() map[string]float64 {
+	if entry.scores == nil {
+		obj := entry.object.(*model.ZSetObject)
+		entry.scores = make(map[string]float64, len(obj.Entries))
+		for _, e := range obj.Entries {
+			entry.scores[e.Member] = e.Score
+		}
+	}
+	return entry.scores
+}
+
+// getZSet returns members of sorted set, a new one is created if create is true and key does not exist
+func (ks *aofKeyspace) getZSet(key []byte, create bool) (*aofEntry, map[string]float64, error) {
+	entry, err := ks.lookup(key, model.ZSetType)
+	if err != nil {
+		return nil, nil, err
+	}
+	if entry == nil {
+		if !create {
+			return nil, nil, nil
+		}
+		entry = ks.put(key, &model.ZSetObject{BaseObject: &model.BaseObject{}})
+	}
+	entry.touch()
+	return entry, entry.zsetScores(), nil
+}
+
+func execZAdd(ks *aofKeyspace, args [][]byte) error {
+	var nx, xx, gt, lt, incr bool
+	i := 1
+flags:
+	for ; i < len(args); i++ {
+		switch strings.ToLower(string(args[i])) {
+		case "nx":
+			nx = true
+		case "xx":
+			xx = true
+		case "gt":
+			gt = true
+		case "lt":
+			lt = true
+		case "incr":
+			incr = true
+		case "ch":
+		default:
+			break flags
+		}
+	}
+	if len(args[i:]) == 0 || len(args[i:])%2 != 0 {
+		return errors.New("syntax error")
+	}
+	entry, scores, err := ks.getZSet(args[0], !xx)
+	if err != nil || scores == nil {
+		return err
+	}
+	for ; i < len(args); i += 2 {
+		score, err := parseFloat(args[i])
+		if err != nil {
+			return err
+		}
+		member := string(args[i+1])
+		old, exists := scores[member]
+		if exists && nx || !exists && xx {
+			continue
+		}
+		if incr && exists {
+			score += old
+		}
+		if exists && (gt && score <= old || lt && score >= old) {
+			continue
+		}
+		scores[member] = score
+	}
+	ks.removeIfEmpty(args[0], entry)
+	return nil
+}
+
+func execZIncrBy(ks *aofKeyspace, args [][]byte) error {
+	delta, err := parseFloat(args[1])
+	if err != nil {
+		return err
+	}
+	_, scores, err := ks.getZSet(args[0], true)
+	if err != nil {
+		return err
+	}
+	scores[string(args[2])] += delta
+	return nil
+}
+
+func execZRem(ks *aofKeyspace, args [][]byte) error {
+	entry, scores, err := ks.getZSet(args[0], false)
+	if err != nil || scores == nil {
+		return err
+	}
+	for _, member := range args[1:] {
+		delete(scores, string(member))
+	}
+	ks.removeIfEmpty(args[0], entry)
+	return nil
+}
+
+// parseScoreBound parses min or max of ZRANGEBYSCORE, such as (1.5 or -inf
+func parseScoreBound(arg []byte) (float64, bool, error) {
+	exclusive := len(arg) > 0 && arg[0] == '('
+	if exclusive {
+		arg = arg[1:]
+	}
+	v, err := parseFloat(arg)
+	return v, exclusive, err
+}
+
+func execZRemRangeByScore(ks *aofKeyspace, args [][]byte) error {
+	min, minEx, err := parseScoreBound(args[1])
+	if err != nil {
+		return err
+	}
+	max, maxEx, err := parseScoreBound(args[2])
+	if err != nil {
+		return err
+	}
+	entry, scores, err := ks.getZSet(args[0], false)
+	if err != nil || scores == nil {
+		return err
+	}
+	for member, score := range scores {
+		if (score > min || !minEx && score == min) && (score < max || !maxEx && score == max) {
+			delete(scores, member)
+		}
+	}
+	ks.removeIfEmpty(args[0], entry)
+	return nil
+}
+
+// sortedMembers returns members of sorted set ordered by score
+func sortedMembers(scores map[string]float64) []*model.ZSetEntry {
+	entries := make([]*model.ZSetEntry, 0, len(scores))
+	for member, score := range scores {
+		entries = append(entries, &model.ZSetEntry{Member: member, Score: score})
+	}
+	sortZSetEntries(entries)
+	return entries
+}
+
+func execZRemRangeByRank(ks *aofKeyspace, args [][]byte) error {
+	start, err := parseInt(args[1])
+	if err != nil {
+		return err
+	}
+	stop, err := parseInt(args[2])
+	if err != nil {
+		return err
+	}
+	entry, scores, err := ks.getZSet(args[0], false)
+	if err != nil || scores == nil {
+		return err
+	}
+	entries := sortedMembers(scores)
+	begin, end := listIndex(start, len(entries)), listIndex(stop, len(entries))
+	if begin < 0 {
+		begin = 0
+	}
+	for i := begin; i <= end && i < len(entries); i++ {
+		delete(scores, entries[i].Member)
+	}
+	ks.removeIfEmpty(args[0], entry)
+	return nil
+}
+
+func makeZPopExec(min bool) func(ks *aofKeyspace, args [][]byte) error {
+	return func(ks *aofKeyspace, args [][]byte) error {
+		count := int64(1)
+		if len(args) > 1 {
+			var err error
+			count, err = parseInt(args[1])
+			if err != nil || count < 0 {
+				return errors.New("value is out of range, must be positive")
+			}
+		}
+		entry, scores, err := ks.getZSet(args[0], false)
+		if err != nil || scores == nil {
+			return err
+		}
+		entries := sortedMembers(scores)
+		for i := 0; i < int(count) && i < len(entries); i++ {
+			e := entries[i]
+			if !min {
+				e = entries[len(entries)-1-i]
+			}
+			delete(scores, e.Member)
+		}
+		ks.removeIfEmpty(args[0], entry)
+		return nil
+	}
+}
+
+// lexBound is min or max of ZRANGEBYLEX like [a, (b, - and +
+type lexBound struct {
+	value     string
+	exclusive bool
+	inf       int // -1 for -, 1 for +
+}
+
+func parseLexBound(arg []byte) (*lexBound, error) {
+	switch {
+	case string(arg) == "-":
+		return &lexBound{inf: -1}, nil
+	case string(arg) == "+":
+		return &lexBound{inf: 1}, nil
+	case len(arg) > 0 && (arg[0] == '[' || arg[0] == '('):
+		return &lexBound{value: string(arg[1:]), exclusive: arg[0] == '('}, nil
+	}
+	return nil, errors.New("min or max not valid string range item")
+}
+
+func inLexRange(member string, min, max *lexBound) bool {
+	lower := min.inf == -1 || min.inf == 0 && (member > min.value || !min.exclusive && member == min.value)
+	upper := max.inf == 1 || max.inf == 0 && (member < max.value || !max.exclusive && member == max.value)
+	return lower && upper
+}
+
+func execZRemRangeByLex(ks *aofKeyspace, args [][]byte) error {
+	min, err := parseLexBound(args[1])
+	if err != nil {
+		return err
+	}
+	max, err := parseLexBound(args[2])
+	if err != nil {
+		return err
+	}
+	entry, scores, err := ks.getZSet(args[0], false)
+	if err != nil || scores == nil {
+		return err
+	}
+	for member := range scores {
+		if inLexRange(member, min, max) {
+			delete(scores, member)
+		}
+	}
+	ks.removeIfEmpty(args[0], entry)
+	return nil
+}
+
+// storeSourceScores returns scores of sorted set for ZUNIONSTORE like commands, members of set are scored 1
+func (ks *aofKeyspace) storeSourceScores(key []byte) (map[string]float64, error) {
+	entry := ks.get(key)
+	if entry == nil {
+		return nil, nil
+	}
+	switch entry.object.(type) {
+	case *model.ZSetObject:
+		return entry.zsetScores(), nil
+	case *model.SetObject:
+		scores := make(map[string]float64)
+		for member := range entry.setMembers() {
+			scores[member] = 1
+		}
+		return scores, nil
+	}
+	return nil, errWrongType
+}
+
+func aggregateScore(a, b float64, aggregate string) float64 {
+	switch aggregate {
+	case "min":
+		return math.Min(a, b)
+	case "max":
+		return math.Max(a, b)
+	}
+	if sum := a + b; !math.IsNaN(sum) {
+		return sum
+	}
+	return 0 // +inf plus -inf
+}
+
+// makeZSetStoreExec creates ZUNIONSTORE, ZINTERSTORE and ZDIFFSTORE:
+// destination numkeys key [key ...] [WEIGHTS weight [weight ...]] [AGGREGATE SUM|MIN|MAX]
+func makeZSetStoreExec(op string) func(ks *aofKeyspace, args [][]byte) error {
+	return func(ks *aofKeyspace, args [][]byte) error {
+		n, err := parseInt(args[1])
+		if err != nil || n <= 0 || n > int64(len(args)-2) {
+			return errors.New("at least 1 input key is needed")
+		}
+		keys := args[2 : 2+n]
+		weights := make([]float64, n)
+		for i := range weights {
+			weights[i] = 1
+		}
+		aggregate := "sum"
+		for i := 2 + int(n); i < len(args); i++ {
+			switch strings.ToLower(string(args[i])) {
+			case "weights":
+				if op == "diff" || i+int(n) >= len(args) {
+					return errors.New("syntax error")
+				}
+				for j := range weights {
+					weights[j], err = parseFloat(args[i+1+j])
+					if err != nil {
+						return errors.New("weight value is not a float")
+					}
+				}
+				i += int(n)
+			case "aggregate":
+				if op == "diff" || i+1 >= len(args) {
+					return errors.New("syntax error")
+				}
+				i++
+				aggregate = strings.ToLower(string(args[i]))
+				if aggregate != "sum" && aggregate != "min" && aggregate != "max" {
+					return errors.New("syntax error")
+				}
+			default:
+				return errors.New("syntax error")
+			}
+		}
+		var result map[string]float64
+		for i, key := range keys {
+			scores, err := ks.storeSourceScores(key)
+			if err != nil {
+				return err
+			}
+			weighted := make(map[string]float64, len(scores))
+			for member, score := range scores {
+				score *= weights[i]
+				if math.IsNaN(score) {
+					score = 0
+				}
+				weighted[member] = score
+			}
+			if i == 0 {
+				result = weighted
+				continue
+			}
+			switch op {
+			case "union":
+				for member, score := range weighted {
+					if old, ok := result[member]; ok {
+						score = aggregateScore(old, score, aggregate)
+					}
+					result[member] = score
+				}
+			case "inter":
+				for member, old := range result {
+					if score, ok := weighted[member]; ok {
+						result[member] = aggregateScore(old, score, aggregate)
+					} else {
+						delete(result, member)
+					}
+				}
+			case "diff":
+				for member := range weighted {
+					delete(result, member)
+				}
+			}
+		}
+		if len(result) == 0 {
+			delete(ks.keys(ks.db), string(args[0]))
+			return nil
+		}
+		entry := ks.put(args[0], &model.ZSetObject{BaseObject: &model.BaseObject{}})
+		entry.scores = result
+		return nil
+	}
+}
+
+// execZRangeStore applies ZRANGESTORE dst src min max [BYSCORE|BYLEX] [REV] [LIMIT offset count]
+func execZRangeStore(ks *aofKeyspace, args [][]byte) error {
+	by := "rank"
+	rev, limit := false, false
+	offset, count := int64(0), int64(-1)
+	for i := 4; i < len(args); i++ {
+		switch strings.ToLower(string(args[i])) {
+		case "byscore":
+			by = "score"
+		case "bylex":
+			by = "lex"
+		case "rev":
+			rev = true
+		case "limit":
+			if i+2 >= len(args) {
+				return errors.New("syntax error")
+			}
+			var err error
+			offset, err = parseInt(args[i+1])
+			if err != nil {
+				return err
+			}
+			count, err = parseInt(args[i+2])
+			if err != nil {
+				return err
+			}
+			limit = true
+			i += 2
+		default:
+			return errors.New("syntax error")
+		}
+	}
+	if limit && by == "rank" {
+		return errors.New("syntax error, LIMIT is only supported in combination with either BYSCORE or BYLEX")
+	}
+	src, err := ks.lookup(args[1], model.ZSetType)
+	if err != nil {
+		return err
+	}
+	var entries []*model.ZSetEntry
+	if src != nil {
+		entries = sortedMembers(src.zsetScores())
+	}
+	minArg, maxArg := args[2], args[3]
+	if rev {
+		for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+			entries[i], entries[j] = entries[j], entries[i]
+		}
+		if by != "rank" {
+			minArg, maxArg = maxArg, minArg
+		}
+	}
+	var selected []*model.ZSetEntry
+	switch by {
+	case "rank":
+		start, err := parseInt(minArg)
+		if err != nil {
+			return err
+		}
+		stop, err := parseInt(maxArg)
+		if err != nil {
+			return err
+		}
+		begin, end := listIndex(start, len(entries)), listIndex(stop, len(entries))
+		if begin < 0 {
+			begin = 0
+		}
+		if end >= len(entries) {
+			end = len(entries) - 1
+		}
+		if begin <= end {
+			selected = entries[begin : end+1]
+		}
+	case "score":
+		min, minEx, err := parseScoreBound(minArg)
+		if err != nil {
+			return err
+		}
+		max, maxEx, err := parseScoreBound(maxArg)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if (e.Score > min || !minEx && e.Score == min) && (e.Score < max || !maxEx && e.Score == max) {
+				selected = append(selected, e)
+			}
+		}
+	case "lex":
+		min, err := parseLexBound(minArg)
+		if err != nil {
+			return err
+		}
+		max, err := parseLexBound(maxArg)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if inLexRange(e.Member, min, max) {
+				selected = append(selected, e)
+			}
+		}
+	}
+	if limit {
+		if offset < 0 || offset >= int64(len(selected)) {
+			selected = nil
+		} else {
+			selected = selected[offset:]
+			if count >= 0 && count < int64(len(selected)) {
+				selected = selected[:count]
+			}
+		}
+	}
+	if len(selected) == 0 {
+		delete(ks.keys(ks.db), string(args[0]))
+		return nil
+	}
+	scores := make(map[string]float64, len(selected))
+	for _, e := range selected {
+		scores[e.Member] = e.Score
+	}
+	entry := ks.put(args[0], &model.ZSetObject{BaseObject: &model.BaseObject{}})
+	entry.scores = scores
+	return nil
+}
+
+const (
+	geoLongMin = -180
+	geoLongMax = 180
+	geoLatMin  = -85.05112878
+	geoLatMax  = 85.05112878
+	geoStep    = 26
+)
+
+// geoHashScore returns score of location in sorted set, which is 52 bits geohash interleaving latitude and longitude
+func geoHashScore(longitude, latitude float64) float64 {
+	lat := uint32((latitude - geoLatMin) / (geoLatMax - geoLatMin) * (1 << geoStep))
+	long := uint32((longitude - geoLongMin) / (geoLongMax - geoLongMin) * (1 << geoStep))
+	var hash uint64
+	for i := uint(0); i < 32; i++ {
+		hash |= uint64(lat>>i&1)<<(2*i) | uint64(long>>i&1)<<(2*i+1)
+	}
+	return float64(hash)
+}
+
+// execGeoAdd applies GEOADD key [NX|XX] [CH] longitude latitude member [...] as ZADD with geohash scores
+func execGeoAdd(ks *aofKeyspace, args [][]byte) error {
+	zaddArgs := [][]byte{args[0]}
+	i := 1
+flags:
+	for ; i < len(args); i++ {
+		switch strings.ToLower(string(args[i])) {
+		case "nx", "xx":
+			zaddArgs = append(zaddArgs, args[i])
+		case "ch":
+		default:
+			break flags
+		}
+	}
+	if len(args[i:]) == 0 || len(args[i:])%3 != 0 {
+		return errors.New("syntax error")
+	}
+	for ; i < len(args); i += 3 {
+		longitude, err := parseFloat(args[i])
+		if err != nil {
+			return err
+		}
+		latitude, err := parseFloat(args[i+1])
+		if err != nil {
+			return err
+		}
+		if longitude < geoLongMin || longitude > geoLongMax || latitude < geoLatMin || latitude > geoLatMax {
+			return fmt.Errorf("invalid longitude,latitude pair %s,%s", args[i], args[i+1])
+		}
+		zaddArgs = append(zaddArgs, formatFloat(geoHashScore(longitude, latitude)), args[i+2])
+	}
+	return execZAdd(ks, zaddArgs)
+}
+
+// lookupSortPattern returns value of pattern of SORT BY or GET like weight_* or data_*->field, nil if not found
+func (ks *aofKeyspace) lookupSortPattern(pattern []byte, element string) []byte {
+	if string(pattern) == "#" {
+		return []byte(element)
+	}
+	star := bytes.IndexByte(pattern, '*')
+	if star < 0 {
+		return nil
+	}
+	keyPattern, field := pattern, ""
+	if arrow := bytes.Index(pattern[star+1:], []byte("->")); arrow >= 0 && star+1+arrow+2 < len(pattern) {
+		keyPattern, field = pattern[:star+1+arrow], string(pattern[star+1+arrow+2:])
+	}
+	key := string(keyPattern[:star]) + element + string(keyPattern[star+1:])
+	entry := ks.get([]byte(key))
+	if entry == nil {
+		return nil
+	}
+	switch o := entry.object.(type) {
+	case *model.StringObject:
+		if field == "" {
+			return o.Value
+		}
+	case *model.HashObject:
+		if field != "" {
+			return o.Hash[field]
+		}
+	}
+	return nil
+}
+
+type sortElement struct {
+	element string
+	weight  []byte
+	score   float64
+}
+
+// execSort applies SORT key [BY pattern] [LIMIT offset count] [GET pattern ...] [ASC|DESC] [ALPHA] STORE destination,
+// SORT without STORE does not change keyspace
+func execSort(ks *aofKeyspace, args [][]byte) error {
+	var by, store []byte
+	var gets [][]byte
+	desc, alpha := false, false
+	offset, count := int64(0), int64(-1)
+	for i := 1; i < len(args); i++ {
+		opt := strings.ToLower(string(args[i]))
+		hasNext := i+1 < len(args)
+		switch {
+		case opt == "asc":
+			desc = false
+		case opt == "desc":
+			desc = true
+		case opt == "alpha":
+			alpha = true
+		case opt == "by" && hasNext:
+			i++
+			by = args[i]
+		case opt == "get" && hasNext:
+			i++
+			gets = append(gets, args[i])
+		case opt == "store" && hasNext:
+			i++
+			store = args[i]
+		case opt == "limit" && i+2 < len(args):
+			var err error
+			offset, err = parseInt(args[i+1])
+			if err != nil {
+				return err
+			}
+			count, err = parseInt(args[i+2])
+			if err != nil {
+				return err
+			}
+			i += 2
+		default:
+			return errors.New("syntax error")
+		}
+	}
+	if store == nil {
+		return nil
+	}
+	noSort := by != nil && bytes.IndexByte(by, '*') < 0
+	var elements []*sortElement
+	if entry := ks.get(args[0]); entry != nil {
+		switch o := entry.object.(type) {
+		case *model.ListObject:
+			for _, v := range o.Values {
+				elements = append(elements, &sortElement{element: string(v)})
+			}
+		case *model.SetObject:
+			for member := range entry.setMembers() {
+				elements = append(elements, &sortElement{element: member})
+			}
+			if noSort {
+				noSort, alpha, by = false, true, nil // sorted for deterministic result like redis does
+			}
+		case *model.ZSetObject:
+			for _, e := range sortedMembers(entry.zsetScores()) {
+				elements = append(elements, &sortElement{element: e.Member})
+			}
+			if noSort && desc {
+				for i, j := 0, len(elements)-1; i < j; i, j = i+1, j-1 {
+					elements[i], elements[j] = elements[j], elements[i]
+				}
+			}
+		default:
+			return errWrongType
+		}
+	}
+	if !noSort {
+		for _, e := range elements {
+			e.weight = []byte(e.element)
+			if by != nil {
+				e.weight = ks.lookupSortPattern(by, e.element)
+			}
+			if alpha || e.weight == nil {
+				continue
+			}
+			score, err := parseFloat(e.weight)
+			if err != nil {
+				return errors.New("one or more scores can't be converted into double")
+			}
+			e.score = score
+		}
+		sort.SliceStable(elements, func(i, j int) bool {
+			a, b := elements[i], elements[j]
+			if desc {
+				a, b = b, a
+			}
+			cmp := 0
+			if alpha {
+				cmp = bytes.Compare(a.weight, b.weight) // missing weight is nil which is the least
+			} else if a.score != b.score {
+				cmp = -1
+				if a.score > b.score {
+					cmp = 1
+				}
+			}
+			if cmp == 0 {
+				return a.element < b.element
+			}
+			return cmp < 0
+		})
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	if offset >= int64(len(elements)) {
+		elements = nil
+	} else {
+		elements = elements[offset:]
+		if count >= 0 && count < int64(len(elements)) {
+			elements = elements[:count]
+		}
+	}
+	var values [][]byte
+	for _, e := range elements {
+		if len(gets) == 0 {
+			values = append(values, []byte(e.element))
+			continue
+		}
+		for _, get := range gets {
+			value := ks.lookupSortPattern(get, e.element)
+			if value == nil {
+				value = []byte{} // missing value is stored as empty string
+			}
+			values = append(values, value)
+		}
+	}
+	if len(values) == 0 {
+		delete(ks.keys(ks.db), string(store))
+		return nil
+	}
+	ks.put(store, &model.ListObject{BaseObject: &model.BaseObject{}, Values: values})
+	return nil
+}
@@ -0,0 +1,589 @@
+package helper
+
+import (
+	"errors"
+	"github.com/hdt3213/rdb/model"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// invalidEntriesRead is entries read of consumer group which is unknown, it is -1 in redis
+const invalidEntriesRead = math.MaxUint64
+
+// getStream returns stream of key, a new stream is created if create is true and key does not exist
+func (ks *aofKeyspace) getStream(key []byte, create bool) (*aofEntry, *model.StreamObject, error) {
+	entry, err := ks.lookup(key, model.StreamType)
+	if err != nil {
+		return nil, nil, err
+	}
+	if entry == nil {
+		if !create {
+			return nil, nil, nil
+		}
+		entry = ks.put(key, &model.StreamObject{BaseObject: &model.BaseObject{}})
+	}
+	entry.touch()
+	return entry, entry.object.(*model.StreamObject), nil
+}
+
+func (ks *aofKeyspace) nowMillis() uint64 {
+	return uint64(ks.now().UnixNano() / int64(time.Millisecond))
+}
+
+// parseStreamID parses stream id like 1526919030474-55, sequence is 0 if omitted
+func parseStreamID(arg []byte) (model.StreamID, error) {
+	ms, seq := string(arg), ""
+	if i := strings.IndexByte(ms, '-'); i >= 0 {
+		ms, seq = ms[:i], ms[i+1:]
+	}
+	var id model.StreamID
+	var err error
+	id.Ms, err = strconv.ParseUint(ms, 10, 64)
+	if err == nil && seq != "" {
+		id.Seq, err = strconv.ParseUint(seq, 10, 64)
+	}
+	if err != nil {
+		return id, errors.New("invalid stream ID specified as stream command argument")
+	}
+	return id, nil
+}
+
+func compareStreamID(a, b model.StreamID) int {
+	switch {
+	case a.Ms != b.Ms:
+		if a.Ms < b.Ms {
+			return -1
+		}
+		return 1
+	case a.Seq != b.Seq:
+		if a.Seq < b.Seq {
+			return -1
+		}
+		return 1
+	}
+	return 0
+}
+
+// searchStreamID returns index of the first id not less than id in sorted ids, and whether it equals id
+func searchStreamID(n int, get func(i int) model.StreamID, id model.StreamID) (int, bool) {
+	i := sort.Search(n, func(i int) bool {
+		return compareStreamID(get(i), id) >= 0
+	})
+	return i, i < n && get(i) == id
+}
+
+// searchMessage returns index of message in stream, see searchStreamID
+func searchMessage(obj *model.StreamObject, id model.StreamID) (int, bool) {
+	return searchStreamID(len(obj.Messages), func(i int) model.StreamID {
+		return obj.Messages[i].ID
+	}, id)
+}
+
+// updateFirstID sets FirstID to id of the first message, 0-0 if stream is empty
+func updateFirstID(obj *model.StreamObject) {
+	obj.FirstID = model.StreamID{}
+	if len(obj.Messages) > 0 {
+		obj.FirstID = obj.Messages[0].ID
+	}
+}
+
+// nextStreamID returns id of new message, arg could be *, ms-* or an explicit id greater than the last one
+func nextStreamID(obj *model.StreamObject, arg []byte, now uint64) (model.StreamID, error) {
+	last := obj.LastID
+	s := string(arg)
+	var id model.StreamID
+	if s == "*" || strings.HasSuffix(s, "-*") {
+		if s == "*" {
+			id.Ms = now
+		} else {
+			ms, err := strconv.ParseUint(s[:len(s)-2], 10, 64)
+			if err != nil {
+				return id, errors.New("invalid stream ID specified as stream command argument")
+			}
+			id.Ms = ms
+		}
+		switch {
+		case id.Ms > last.Ms:
+			return id, nil
+		case s == "*" || id.Ms == last.Ms:
+			if last.Seq < math.MaxUint64 {
+				return model.StreamID{Ms: last.Ms, Seq: last.Seq + 1}, nil
+			}
+			if s == "*" && last.Ms < math.MaxUint64 {
+				return model.StreamID{Ms: last.Ms + 1}, nil
+			}
+		}
+		return id, errors.New("the ID specified in XADD is equal or smaller than the target stream top item")
+	}
+	id, err := parseStreamID(arg)
+	if err != nil {
+		return id, err
+	}
+	if id == (model.StreamID{}) {
+		return id, errors.New("the ID specified in XADD must be greater than 0-0")
+	}
+	if compareStreamID(id, last) <= 0 {
+		return id, errors.New("the ID specified in XADD is equal or smaller than the target stream top item")
+	}
+	return id, nil
+}
+
+// streamTrim is MAXLEN or MINID of XADD and XTRIM, trimming is exact since redis propagates approximate trimming
+// as exact one
+type streamTrim struct {
+	maxLen int64
+	minID  *model.StreamID
+}
+
+// parseStreamTrim parses MAXLEN|MINID [=|~] threshold [LIMIT count] at args[i], returns index of its last argument.
+// Nil is returned if args[i] is neither MAXLEN nor MINID
+func parseStreamTrim(args [][]byte, i int) (*streamTrim, int, error) {
+	strategy := strings.ToLower(string(args[i]))
+	if strategy != "maxlen" && strategy != "minid" {
+		return nil, i, nil
+	}
+	i++
+	if i < len(args) && (string(args[i]) == "=" || string(args[i]) == "~") {
+		i++
+	}
+	if i >= len(args) {
+		return nil, i, errors.New("syntax error")
+	}
+	trim := &streamTrim{}
+	if strategy == "maxlen" {
+		v, err := parseInt(args[i])
+		if err != nil || v < 0 {
+			return nil, i, errors.New("the MAXLEN argument must be >= 0")
+		}
+		trim.maxLen = v
+	} else {
+		id, err := parseStreamID(args[i])
+		if err != nil {
+			return nil, i, err
+		}
+		trim.minID = &id
+	}
+	if i+2 < len(args) && strings.EqualFold(string(args[i+1]), "limit") {
+		i += 2
+	}
+	return trim, i, nil
+}
+
+func (t *streamTrim) apply(obj *model.StreamObject) {
+	n := 0
+	if t.minID != nil {
+		n, _ = searchMessage(obj, *t.minID)
+	} else if int64(len(obj.Messages)) > t.maxLen {
+		n = len(obj.Messages) - int(t.maxLen)
+	}
+	if n > 0 {
+		obj.Messages = obj.Messages[n:]
+		updateFirstID(obj)
+	}
+}
+
+// execXAdd applies XADD key [NOMKSTREAM] [MAXLEN|MINID [=|~] threshold [LIMIT count]] *|id field value [...]
+func execXAdd(ks *aofKeyspace, args [][]byte) error {
+	noMkStream := false
+	var trim *streamTrim
+	i := 1
+	for ; i < len(args); i++ {
+		if strings.EqualFold(string(args[i]), "nomkstream") {
+			noMkStream = true
+			continue
+		}
+		t, last, err := parseStreamTrim(args, i)
+		if err != nil {
+			return err
+		}
+		if t == nil {
+			break
+		}
+		trim, i = t, last
+	}
+	if i >= len(args) || len(args[i+1:]) == 0 || len(args[i+1:])%2 != 0 {
+		return errors.New("wrong number of arguments")
+	}
+	_, obj, err := ks.getStream(args[0], !noMkStream)
+	if err != nil || obj == nil {
+		return err
+	}
+	id, err := nextStreamID(obj, args[i], ks.nowMillis())
+	if err != nil {
+		return err
+	}
+	message := &model.StreamMessage{ID: id}
+	for i++; i < len(args); i += 2 {
+		message.Fields = append(message.Fields, string(args[i]))
+		message.Values = append(message.Values, string(args[i+1]))
+	}
+	obj.Messages = append(obj.Messages, message)
+	obj.LastID = id
+	obj.EntriesAdded++
+	if len(obj.Messages) == 1 {
+		obj.FirstID = id
+	}
+	if trim != nil {
+		trim.apply(obj)
+	}
+	return nil
+}
+
+func execXDel(ks *aofKeyspace, args [][]byte) error {
+	_, obj, err := ks.getStream(args[0], false)
+	if err != nil || obj == nil {
+		return err
+	}
+	for _, arg := range args[1:] {
+		id, err := parseStreamID(arg)
+		if err != nil {
+			return err
+		}
+		i, ok := searchMessage(obj, id)
+		if !ok {
+			continue
+		}
+		obj.Messages = append(obj.Messages[:i], obj.Messages[i+1:]...)
+		if compareStreamID(id, obj.MaxDeletedID) > 0 {
+			obj.MaxDeletedID = id
+		}
+	}
+	updateFirstID(obj)
+	return nil
+}
+
+func execXTrim(ks *aofKeyspace, args [][]byte) error {
+	trim, _, err := parseStreamTrim(args, 1)
+	if err != nil {
+		return err
+	}
+	if trim == nil {
+		return errors.New("syntax error")
+	}
+	_, obj, err := ks.getStream(args[0], false)
+	if err != nil || obj == nil {
+		return err
+	}
+	trim.apply(obj)
+	return nil
+}
+
+// execXSetID applies XSETID key last-id [ENTRIESADDED entries-added] [MAXDELETEDID max-deleted-id]
+func execXSetID(ks *aofKeyspace, args [][]byte) error {
+	lastID, err := parseStreamID(args[1])
+	if err != nil {
+		return err
+	}
+	entriesAdded := int64(-1)
+	var maxDeletedID *model.StreamID
+	for i := 2; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return errors.New("syntax error")
+		}
+		switch strings.ToLower(string(args[i])) {
+		case "entriesadded":
+			entriesAdded, err = parseInt(args[i+1])
+			if err != nil || entriesAdded < 0 {
+				return errors.New("entries_added must be positive")
+			}
+		case "maxdeletedid":
+			id, err := parseStreamID(args[i+1])
+			if err != nil {
+				return err
+			}
+			maxDeletedID = &id
+		default:
+			return errors.New("syntax error")
+		}
+	}
+	_, obj, err := ks.getStream(args[0], false)
+	if err != nil {
+		return err
+	}
+	if obj == nil {
+		return errors.New("no such key")
+	}
+	obj.LastID = lastID
+	if entriesAdded >= 0 {
+		obj.EntriesAdded = uint64(entriesAdded)
+	}
+	if maxDeletedID != nil {
+		obj.MaxDeletedID = *maxDeletedID
+	}
+	return nil
+}
+
+func findStreamGroup(obj *model.StreamObject, name string) (int, *model.StreamGroup) {
+	for i, group := range obj.Groups {
+		if group.Name == name {
+			return i, group
+		}
+	}
+	return -1, nil
+}
+
+func findStreamConsumer(group *model.StreamGroup, name string) (int, *model.StreamConsumer) {
+	for i, consumer := range group.Consumers {
+		if consumer.Name == name {
+			return i, consumer
+		}
+	}
+	return -1, nil
+}
+
+// createStreamConsumer returns consumer of group, it is created if not exists
+func createStreamConsumer(group *model.StreamGroup, name string, now uint64) *model.StreamConsumer {
+	_, consumer := findStreamConsumer(group, name)
+	if consumer == nil {
+		consumer = &model.StreamConsumer{
+			Name:       name,
+			SeenTime:   now,
+			ActiveTime: math.MaxUint64, // never active, it is -1 in redis
+		}
+		group.Consumers = append(group.Consumers, consumer)
+	}
+	return consumer
+}
+
+// removePending removes pending entry of id from group and its owner
+func removePending(group *model.StreamGroup, id model.StreamID) {
+	i, ok := searchStreamID(len(group.Pending), func(i int) model.StreamID {
+		return group.Pending[i].ID
+	}, id)
+	if !ok {
+		return
+	}
+	group.Pending = append(group.Pending[:i], group.Pending[i+1:]...)
+	for _, consumer := range group.Consumers {
+		if j, ok := searchStreamID(len(consumer.Pending), func(j int) model.StreamID {
+			return consumer.Pending[j]
+		}, id); ok {
+			consumer.Pending = append(consumer.Pending[:j], consumer.Pending[j+1:]...)
+			return
+		}
+	}
+}
+
+// parseEntriesRead parses [ENTRIESREAD entries-read] of XGROUP, other arguments in names are skipped
+func parseEntriesRead(args [][]byte, names ...string) (uint64, error) {
+	entriesRead := uint64(invalidEntriesRead)
+	for i := 0; i < len(args); i++ {
+		arg := strings.ToLower(string(args[i]))
+		if arg == "entriesread" && i+1 < len(args) {
+			v, err := parseInt(args[i+1])
+			if err != nil || v < -1 {
+				return 0, errors.New("value for ENTRIESREAD must be positive or -1")
+			}
+			entriesRead = uint64(v) // -1 is invalidEntriesRead
+			i++
+			continue
+		}
+		known := false
+		for _, name := range names {
+			known = known || arg == name
+		}
+		if !known {
+			return 0, errors.New("syntax error")
+		}
+	}
+	return entriesRead, nil
+}
+
+// execXGroup applies CREATE, SETID, DESTROY, CREATECONSUMER and DELCONSUMER of XGROUP
+func execXGroup(ks *aofKeyspace, args [][]byte) error {
+	sub := strings.ToLower(string(args[0]))
+	if sub == "help" {
+		return nil
+	}
+	if len(args) < 3 || sub != "destroy" && len(args) < 4 {
+		return errors.New("wrong number of arguments")
+	}
+	mkStream := false
+	if sub == "create" {
+		for _, arg := range args[4:] {
+			mkStream = mkStream || strings.EqualFold(string(arg), "mkstream")
+		}
+	}
+	_, obj, err := ks.getStream(args[1], mkStream)
+	if err != nil {
+		return err
+	}
+	if obj == nil {
+		return errors.New("the XGROUP subcommand requires the key to exist")
+	}
+	name := string(args[2])
+	i, group := findStreamGroup(obj, name)
+	if group == nil && sub != "create" && sub != "destroy" {
+		return errors.New("no such consumer group")
+	}
+	switch sub {
+	case "create", "setid":
+		id := obj.LastID
+		if string(args[3]) != "$" {
+			id, err = parseStreamID(args[3])
+			if err != nil {
+				return err
+			}
+		}
+		entriesRead, err := parseEntriesRead(args[4:], "mkstream")
+		if err != nil {
+			return err
+		}
+		if sub == "create" {
+			if group != nil {
+				return nil // BUSYGROUP, not applied
+			}
+			group = &model.StreamGroup{Name: name}
+			obj.Groups = append(obj.Groups, group)
+		}
+		group.LastID = id
+		group.EntriesRead = entriesRead
+	case "destroy":
+		if group != nil {
+			obj.Groups = append(obj.Groups[:i], obj.Groups[i+1:]...)
+		}
+	case "createconsumer":
+		createStreamConsumer(group, string(args[3]), ks.nowMillis())
+	case "delconsumer":
+		j, consumer := findStreamConsumer(group, string(args[3]))
+		if consumer == nil {
+			return nil
+		}
+		for _, id := range consumer.Pending {
+			removePending(group, id)
+		}
+		group.Consumers = append(group.Consumers[:j], group.Consumers[j+1:]...)
+	default:
+		return errors.New("unknown XGROUP subcommand")
+	}
+	return nil
+}
+
+func execXAck(ks *aofKeyspace, args [][]byte) error {
+	_, obj, err := ks.getStream(args[0], false)
+	if err != nil || obj == nil {
+		return err
+	}
+	_, group := findStreamGroup(obj, string(args[1]))
+	if group == nil {
+		return nil
+	}
+	for _, arg := range args[2:] {
+		id, err := parseStreamID(arg)
+		if err != nil {
+			return err
+		}
+		removePending(group, id)
+	}
+	return nil
+}
+
+// execXClaim applies XCLAIM key group consumer min-idle-time id [id ...] [IDLE ms] [TIME unix-time-milliseconds]
+// [RETRYCOUNT count] [FORCE] [JUSTID] [LASTID lastid], min-idle-time is ignored since aof is replayed later.
+// Pending entries of messages no longer in stream are removed like redis does
+func execXClaim(ks *aofKeyspace, args [][]byte) error {
+	var ids []model.StreamID
+	i := 4
+	for ; i < len(args); i++ {
+		id, err := parseStreamID(args[i])
+		if err != nil {
+			break
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		return errors.New("invalid stream ID specified as stream command argument")
+	}
+	now := ks.nowMillis()
+	deliveryTime := now
+	retryCount := int64(-1)
+	var force, justID bool
+	var lastID *model.StreamID
+	for ; i < len(args); i++ {
+		opt := strings.ToLower(string(args[i]))
+		switch {
+		case opt == "force":
+			force = true
+		case opt == "justid":
+			justID = true
+		case i+1 >= len(args):
+			return errors.New("syntax error")
+		case opt == "idle" || opt == "time" || opt == "retrycount":
+			i++
+			v, err := parseInt(args[i])
+			if err != nil || v < 0 {
+				return errors.New("invalid " + strings.ToUpper(opt) + " option argument for XCLAIM")
+			}
+			switch opt {
+			case "idle":
+				deliveryTime = now - uint64(v)
+			case "time":
+				deliveryTime = uint64(v)
+			default:
+				retryCount = v
+			}
+		case opt == "lastid":
+			i++
+			id, err := parseStreamID(args[i])
+			if err != nil {
+				return err
+			}
+			lastID = &id
+		default:
+			return errors.New("syntax error")
+		}
+	}
+	_, obj, err := ks.getStream(args[0], false)
+	if err != nil {
+		return err
+	}
+	var group *model.StreamGroup
+	if obj != nil {
+		_, group = findStreamGroup(obj, string(args[1]))
+	}
+	if group == nil {
+		return errors.New("no such key or consumer group")
+	}
+	if lastID != nil && compareStreamID(*lastID, group.LastID) > 0 {
+		group.LastID = *lastID
+	}
+	consumer := createStreamConsumer(group, string(args[2]), now)
+	consumer.SeenTime = now
+	for _, id := range ids {
+		if _, ok := searchMessage(obj, id); !ok {
+			removePending(group, id)
+			continue
+		}
+		j, ok := searchStreamID(len(group.Pending), func(j int) model.StreamID {
+			return group.Pending[j].ID
+		}, id)
+		var pending *model.StreamPendingEntry
+		if ok {
+			pending = group.Pending[j]
+			removePending(group, id)
+		} else if force {
+			pending = &model.StreamPendingEntry{ID: id, DeliveryCount: 1}
+		} else {
+			continue
+		}
+		pending.DeliveryTime = deliveryTime
+		if retryCount >= 0 {
+			pending.DeliveryCount = uint64(retryCount)
+		} else if !justID {
+			pending.DeliveryCount++
+		}
+		group.Pending = append(group.Pending, nil)
+		copy(group.Pending[j+1:], group.Pending[j:])
+		group.Pending[j] = pending
+		k, _ := searchStreamID(len(consumer.Pending), func(k int) model.StreamID {
+			return consumer.Pending[k]
+		}, id)
+		consumer.Pending = append(consumer.Pending, model.StreamID{})
+		copy(consumer.Pending[k+1:], consumer.Pending[k:])
+		consumer.Pending[k] = id
+	}
+	return nil
+}
@@ -0,0 +1,353 @@
+package helper
+
+import (
+	"bufio"
+	"bytes"
+	"github.com/hdt3213/rdb/core"
+	"github.com/hdt3213/rdb/model"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func makeAOF(cmdLines ...string) string {
+	var cmds []CmdLine
+	for _, line := range cmdLines {
+		var cmdLine CmdLine
+		for _, arg := range strings.Split(line, " ") {
+			cmdLine = append(cmdLine, []byte(arg))
+		}
+		cmds = append(cmds, cmdLine)
+	}
+	return string(CmdLinesToResp(cmds))
+}
+
+func TestAOFKeyspace(t *testing.T) {
+	aof := makeAOF(
+		"SET s 1", "INCRBY s 10", "APPEND s x", "SET tmp 1", "PEXPIREAT s 1700000000000", "DEL tmp",
+		"RPUSH l a b c d", "LPUSH l z", "LPOP l", "RPOP l 2", "LINSERT l AFTER a x",
+		"HSET h a 1 b 2", "HDEL h a", "HINCRBY h b 3",
+		"SADD set a b c", "SREM set b", "SMOVE set set2 c",
+		"ZADD z 1 a 2 b 3 c", "ZINCRBY z 5 a", "ZPOPMIN z", "ZADD z XX GT 1 c",
+		"SELECT 2", "SET s2 v", "RENAME s2 s3",
+		"RPUSH gone a", "LPOP gone",
+		"MULTI", "SET tx 1",
+	) + "#TS:1700000000\r\n" + "*3\r\n$3\r\nSET\r\n$1\r\nt" // truncated at the end
+	ks := newAOFKeyspace()
+	err := ks.load(bufio.NewReader(strings.NewReader(aof)))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	var actual []string
+	err = ks.Parse(func(object model.RedisObject) bool {
+		var value string
+		switch o := object.(type) {
+		case *model.StringObject:
+			value = string(o.Value)
+		case *model.ListObject:
+			for _, v := range o.Values {
+				value += string(v)
+			}
+		case *model.HashObject:
+			var fields []string
+			for field, v := range o.Hash {
+				fields = append(fields, field+string(v))
+			}
+			sort.Strings(fields)
+			value = strings.Join(fields, "")
+		case *model.SetObject:
+			for _, v := range o.Members {
+				value += string(v)
+			}
+		case *model.ZSetObject:
+			for _, e := range o.Entries {
+				value += e.Member + string(formatFloat(e.Score))
+			}
+		}
+		if object.GetSize() <= 0 {
+			t.Errorf("size of %s is not computed", object.GetKey())
+		}
+		actual = append(actual, string(rune('0'+object.GetDBIndex()))+":"+object.GetKey()+"="+value)
+		return true
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	expect := "0:s=11x,0:l=axb,0:h=b5,0:set=a,0:set2=c,0:z=c3a6,2:s3=v"
+	if strings.Join(actual, ",") != expect {
+		t.Errorf("expect %s, actual %s", expect, strings.Join(actual, ","))
+	}
+	if ks.keys(0)["s"].object.GetExpiration().Unix() != 1700000000 {
+		t.Error("wrong expiration")
+	}
+
+	ks = newAOFKeyspace()
+	err = ks.load(bufio.NewReader(strings.NewReader(makeAOF("PFADD s a"))))
+	if err == nil {
+		t.Error("expect error of unsupported command")
+	}
+}
+
+func loadAOF(t *testing.T, cmdLines ...string) *aofKeyspace {
+	ks := newAOFKeyspace()
+	err := ks.load(bufio.NewReader(strings.NewReader(makeAOF(cmdLines...))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ks
+}
+
+func TestAOFBitmap(t *testing.T) {
+	ks := loadAOF(t,
+		"SETBIT b 7 1", "SETBIT b 9 1", "SETBIT b 7 0",
+		"BITOP NOT n b", "BITOP OR o b n", "BITOP AND a b missing", "BITOP XOR e missing",
+		"BITFIELD f SET u8 0 200 INCRBY u8 0 100", "BITFIELD f OVERFLOW SAT INCRBY i8 #1 -200",
+		"BITFIELD f OVERFLOW FAIL INCRBY u4 16 20", "BITFIELD g GET u8 0",
+	)
+	expect := map[string][]byte{
+		"b": {0x00, 0x40},
+		"n": {0xff, 0xbf},
+		"o": {0xff, 0xff},
+		"a": {0x00, 0x00},
+		"f": {0x2c, 0x80, 0x00}, // string is grown by failed INCRBY
+	}
+	for key, value := range expect {
+		_, obj, _ := ks.getString([]byte(key))
+		if obj == nil || !bytes.Equal(obj.Value, value) {
+			t.Errorf("wrong value of %s", key)
+		}
+	}
+	if ks.get([]byte("e")) != nil || ks.get([]byte("g")) != nil {
+		t.Error("unexpected key")
+	}
+}
+
+func TestReadAOFManifest(t *testing.T) {
+	fields, err := splitManifestLine(`file "a b.aof" seq 1  type i`)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if strings.Join(fields, ",") != "file,a b.aof,seq,1,type,i" {
+		t.Errorf("wrong fields: %q", fields)
+	}
+}
+
+func TestAOFKeyspaceUntil(t *testing.T) {
+	aof := "#TS:100\r\n" + makeAOF("SET a 1", "FUNCTION LOAD #!lua_name=lib1", "FUNCTION LOAD #!lua_name=lib2",
+		"FUNCTION DELETE lib1") + "#TS:200\r\n" + makeAOF("SET b 1", "FUNCTION FLUSH")
+	aof = strings.Replace(aof, "_", " ", -1) // makeAOF splits args by space
+	until := time.Unix(150, 0)
+	ks := newAOFKeyspace()
+	ks.until = &until
+	err := ks.load(bufio.NewReader(strings.NewReader(aof)))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !ks.stopped || ks.commands != 4 || ks.lastTime.Unix() != 100 {
+		t.Errorf("wrong state, stopped: %v, commands: %d, last time: %v", ks.stopped, ks.commands, ks.lastTime)
+	}
+	if ks.keys(0)["a"] == nil || ks.keys(0)["b"] != nil {
+		t.Error("wrong keys")
+	}
+	if len(ks.functions) != 1 || functionLibraryName(ks.functions[0].Code) != "lib2" {
+		t.Error("wrong functions")
+	}
+}
+
+func zsetString(ks *aofKeyspace, key string) string {
+	entry := ks.get([]byte(key))
+	if entry == nil {
+		return ""
+	}
+	var value string
+	for _, e := range sortedMembers(entry.zsetScores()) {
+		value += e.Member + string(formatFloat(e.Score))
+	}
+	return value
+}
+
+func TestAOFSortedSetStore(t *testing.T) {
+	ks := loadAOF(t,
+		"ZADD z1 1 a 2 b 3 c", "ZADD z2 10 b 20 c 30 d", "SADD s c d",
+		"ZUNIONSTORE u 2 z1 z2 WEIGHTS 2 1", "ZINTERSTORE i 3 z1 z2 s AGGREGATE MAX", "ZDIFFSTORE d 2 z1 s",
+		"ZRANGESTORE r1 z1 0 1 REV", "ZRANGESTORE r2 z2 (10 +inf BYSCORE LIMIT 1 1", "ZRANGESTORE r3 z1 [b - BYLEX REV",
+		"ZADD l 0 a 0 b 0 c 0 d", "ZREMRANGEBYLEX l (a [c",
+		"GEOADD g 13.361389 38.115556 Palermo 15.087269 37.502669 Catania",
+		"ZINTERSTORE empty 2 z1 missing",
+	)
+	expect := map[string]string{
+		"u":     "a2b14c26d30",
+		"i":     "c20",
+		"d":     "a1b2",
+		"r1":    "b2c3",
+		"r2":    "d30",
+		"r3":    "a1b2",
+		"l":     "a0d0",
+		"g":     "Palermo3479099956230698Catania3479447370796909",
+		"empty": "",
+	}
+	for key, value := range expect {
+		if actual := zsetString(ks, key); actual != value {
+			t.Errorf("wrong value of %s: %s", key, actual)
+		}
+	}
+}
+
+func TestAOFSort(t *testing.T) {
+	ks := loadAOF(t,
+		"RPUSH l 3 1 2", "SADD s b c a", "MSET w_1 30 w_2 10 w_3 20", "HSET h_1 f x", "HSET h_3 f z",
+		"SORT l STORE r1", "SORT l BY w_* DESC LIMIT 0 2 STORE r2", "SORT l BY nosort GET # GET h_*->f STORE r3",
+		"SORT s ALPHA DESC STORE r4", "SORT s BY nosort STORE r5", "SORT l",
+	)
+	expect := map[string]string{
+		"r1": "1,2,3",
+		"r2": "1,3",
+		"r3": "3,z,1,x,2,",
+		"r4": "c,b,a",
+		"r5": "a,b,c",
+	}
+	for key, value := range expect {
+		entry := ks.get([]byte(key))
+		if entry == nil {
+			t.Errorf("%s not found", key)
+			continue
+		}
+		var values []string
+		for _, v := range entry.object.(*model.ListObject).Values {
+			values = append(values, string(v))
+		}
+		if strings.Join(values, ",") != value {
+			t.Errorf("wrong value of %s: %q", key, values)
+		}
+	}
+}
+
+func TestAOFRestoreAndCopy(t *testing.T) {
+	payload, err := core.DumpObject(&model.ListObject{
+		BaseObject: &model.BaseObject{},
+		Values:     [][]byte{[]byte("a"), []byte("b")},
+	}, 9)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ks := loadAOF(t,
+		"RESTORE l 1700000000000 "+string(payload)+" ABSTTL FREQ 5", "RPUSH l c",
+		"COPY l l2", "COPY l l3 DB 1", "RPUSH l d", "SET s 1", "COPY s l2", "COPY s l2 REPLACE",
+	)
+	l := ks.get([]byte("l")).object.(*model.ListObject)
+	if len(l.Values) != 4 || l.GetExpiration().UnixNano() != 1700000000*int64(time.Second) || *l.Freq != 5 {
+		t.Error("wrong restored key")
+	}
+	if _, obj, _ := ks.getString([]byte("l2")); obj == nil || string(obj.Value) != "1" {
+		t.Error("wrong copied key with REPLACE")
+	}
+	ks.db = 1
+	l3 := ks.get([]byte("l3")).object.(*model.ListObject)
+	if len(l3.Values) != 3 || l3.GetExpiration() == l.GetExpiration() || l3.GetKey() != "l3" {
+		t.Error("wrong copied key")
+	}
+}
+
+func TestAOFHashFieldExpiration(t *testing.T) {
+	ks := loadAOF(t,
+		"HSET h a 1 b 2 c 3", "HPEXPIREAT h 1700000000000 FIELDS 2 a b", "HPEXPIREAT h 1600000000000 NX FIELDS 1 a",
+		"HPEXPIREAT h 1600000000000 LT FIELDS 1 b", "HSET h a 4", "HPEXPIRE h 1000 XX FIELDS 1 c", "HDEL h b",
+		"HPEXPIREAT h 1700000000000 FIELDS 1 c", "HPERSIST h FIELDS 1 c",
+		"HPEXPIREAT h 1700000000000 FIELDS 1 a",
+	)
+	h := ks.get([]byte("h")).object.(*model.HashObject)
+	if len(h.FieldExpirations) != 1 || h.FieldExpirations["a"].Unix() != 1700000000 {
+		t.Errorf("wrong field expirations: %v", h.FieldExpirations)
+	}
+}
+
+func TestAOFStream(t *testing.T) {
+	ks := loadAOF(t,
+		"XADD s 1-1 a 1", "XADD s 1-* b 2", "XADD s 2 c 3", "XADD s MAXLEN = 3 3-0 d 4", "XADD s 4-0 e 5",
+		"XDEL s 2-0 9-9", "XADD missing NOMKSTREAM * a 1",
+		"XGROUP CREATE s g1 0 ENTRIESREAD 0", "XGROUP CREATE s g2 $", "XGROUP CREATECONSUMER s g1 c0",
+		"XCLAIM s g1 c1 0 1-2 3-0 4-0 TIME 1700000000000 RETRYCOUNT 1 FORCE JUSTID LASTID 4-0",
+		"XCLAIM s g1 c2 0 4-0", "XACK s g1 1-2", "XGROUP DESTROY s g2", "XTRIM s MINID 4",
+		"XSETID s 5-0 ENTRIESADDED 10 MAXDELETEDID 3-5",
+		"XGROUP CREATE t g 0 MKSTREAM", "XGROUP DELCONSUMER s g1 c0",
+	)
+	s := ks.get([]byte("s")).object.(*model.StreamObject)
+	if len(s.Messages) != 1 || s.Messages[0].ID.String() != "4-0" || s.FirstID.String() != "4-0" ||
+		s.LastID.String() != "5-0" || s.EntriesAdded != 10 || s.MaxDeletedID.String() != "3-5" {
+		t.Errorf("wrong stream: %+v", s)
+	}
+	if len(s.Groups) != 1 || s.Groups[0].LastID.String() != "4-0" || s.Groups[0].EntriesRead != 0 {
+		t.Fatal("wrong groups")
+	}
+	g := s.Groups[0]
+	if len(g.Pending) != 2 || g.Pending[0].ID.String() != "3-0" || g.Pending[0].DeliveryTime != 1700000000000 ||
+		g.Pending[1].DeliveryCount != 2 {
+		t.Errorf("wrong pending entries: %v", g.Pending)
+	}
+	if len(g.Consumers) != 2 || g.Consumers[0].Name != "c1" || len(g.Consumers[0].Pending) != 1 ||
+		len(g.Consumers[1].Pending) != 1 || g.Consumers[1].Pending[0].String() != "4-0" {
+		t.Errorf("wrong consumers")
+	}
+	if ks.get([]byte("missing")) != nil || ks.get([]byte("t")) == nil {
+		t.Error("wrong keys")
+	}
+	err := ks.Parse(func(object model.RedisObject) bool {
+		return true
+	})
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestAOFSkipUnknown(t *testing.T) {
+	aof := makeAOF("SET a 1", "PFADD h x", "SELECT 1", "MULTI", "PFMERGE h2 h", "SORT l STORE l2", "EXEC",
+		"PFADD h y")
+	report := &AOFSkipReport{}
+	dec := &aofDecoder{reader: bufio.NewReader(strings.NewReader(aof))}
+	_, err := wrapDecoder(dec, WithSkipUnknownOption(report))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	err = dec.Parse(func(object model.RedisObject) bool {
+		keys = append(keys, object.GetKey())
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(keys, ",") != "a" {
+		t.Errorf("wrong keys: %v", keys)
+	}
+	if report.Commands["pfadd"] != 2 || report.Commands["pfmerge"] != 1 || len(report.Commands) != 2 {
+		t.Errorf("wrong commands: %v", report.Commands)
+	}
+	if len(report.Keys[0]) != 1 || len(report.Keys[1]) != 2 {
+		t.Errorf("wrong keys: %v", report.Keys)
+	}
+}
+
+func TestAOFRelativeTTL(t *testing.T) {
+	aof := "#TS:1000\r\n" + makeAOF("SET a 1 EX 10", "SETEX b 20 1", "SET c 1", "PEXPIRE c 30000",
+		"HSET h f 1", "HEXPIRE h 40 FIELDS 1 f") + "#TS:2000\r\n" + makeAOF("SET d 1 PX 50000")
+	ks := newAOFKeyspace()
+	err := ks.load(bufio.NewReader(strings.NewReader(aof)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := map[string]int64{"a": 1010, "b": 1020, "c": 1030, "d": 2050}
+	for key, sec := range expect {
+		if expiration := ks.get([]byte(key)).object.GetExpiration(); expiration == nil || expiration.Unix() != sec {
+			t.Errorf("wrong expiration of %s: %v", key, expiration)
+		}
+	}
+	h := ks.get([]byte("h")).object.(*model.HashObject)
+	if h.FieldExpirations["f"].Unix() != 1040 {
+		t.Errorf("wrong field expiration: %v", h.FieldExpirations)
+	}
+}
@@ -8,7 +8,7 @@ import (
 	"github.com/hdt3213/rdb/bytefmt"
 	"github.com/hdt3213/rdb/core"
 	"github.com/hdt3213/rdb/model"
-	"os"
+	"io"
 	"strconv"
 )
 
@@ -63,33 +63,42 @@ func newRedisHeap(cap int) *redisTreeSet {
 
 // FindBiggestKeys read rdb file and find the largest N keys.
 // The invoker owns output, FindBiggestKeys won't close it
-func FindBiggestKeys(rdbFilename string, topN int, output *os.File, options ...interface{}) error {
+func FindBiggestKeys(rdbFilename string, topN int, output io.Writer, options ...interface{}) error {
 	if rdbFilename == "" {
 		return errors.New("src file path is required")
 	}
 	if topN <= 0 {
 		return errors.New("n must greater than 0")
 	}
-	rdbFile, err := os.Open(rdbFilename)
+	dec, closeInput, err := openDecoder(rdbFilename)
 	if err != nil {
-		return fmt.Errorf("open rdb %s failed, %v", rdbFilename, err)
+		return err
 	}
-	defer func() {
-		_ = rdbFile.Close()
-	}()
-	var regexOpt RegexOption
-	for _, opt := range options {
-		switch o := opt.(type) {
-		case RegexOption:
-			regexOpt = o
-		}
+	defer closeInput()
+	return writeBiggestKeys(dec, topN, output, options)
+}
+
+// FindBiggestKeysFromReader reads rdb or aof from input and writes the largest N keys in csv to output.
+// The invoker owns input and output
+func FindBiggestKeysFromReader(input io.Reader, topN int, output io.Writer, options ...interface{}) error {
+	if topN <= 0 {
+		return errors.New("n must greater than 0")
 	}
-	var dec decoder = core.NewDecoder(rdbFile)
-	if regexOpt != nil {
-		dec, err = regexWrapper(dec, *regexOpt)
-		if err != nil {
-			return err
-		}
+	dec, closeInput, err := newReaderDecoder(input)
+	if err != nil {
+		return err
+	}
+	defer closeInput()
+	return writeBiggestKeys(dec, topN, output, options)
+}
+
+func writeBiggestKeys(dec decoder, topN int, output io.Writer, options []interface{}) error {
+	if d, ok := dec.(*core.Decoder); ok {
+		d.WithSizeOnly() // biggest keys are kept without values
+	}
+	dec, err := wrapDecoder(dec, options...)
+	if err != nil {
+		return err
 	}
 	topList := newRedisHeap(topN)
 	err = dec.Parse(func(object model.RedisObject) bool {
@@ -99,12 +108,11 @@ func FindBiggestKeys(rdbFilename string, topN int, output *os.File, options ...i
 	if err != nil {
 		return err
 	}
-	_, err = output.WriteString("database,key,type,size,size_readable,element_count\n")
+	_, err = output.Write([]byte("database,key,type,size,size_readable,element_count\n"))
 	if err != nil {
 		return fmt.Errorf("write header failed: %v", err)
 	}
 	csvWriter := csv.NewWriter(output)
-	defer csvWriter.Flush()
 	iter := topList.set.Iterator()
 	for iter.Next() {
 		object := iter.Value().(model.RedisObject)
@@ -120,5 +128,6 @@ func FindBiggestKeys(rdbFilename string, topN int, output *os.File, options ...i
 			return fmt.Errorf("csv write failed: %v", err)
 		}
 	}
-	return nil
+	csvWriter.Flush()
+	return csvWriter.Error()
 }
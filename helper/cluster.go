@@ -0,0 +1,103 @@
+package helper
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// ClusterOption makes ImportRDB write keys into redis cluster, url of any node is accepted
+type ClusterOption bool
+
+// WithClusterOption creates a ClusterOption
+func WithClusterOption() ClusterOption {
+	return true
+}
+
+// maxRedirects is max times of following MOVED or ASK for a key
+const maxRedirects = 5
+
+// fetchClusterSlots reads CLUSTER SLOTS from the given node, masters are named by address
+func fetchClusterSlots(redisURL string) ([]*ClusterNode, error) {
+	u, err := url.Parse(redisURL)
+	if err != nil {
+		return nil, fmt.Errorf("illegal redis url: %v", err)
+	}
+	c, err := dialRedis(redisURL)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = c.Close()
+	}()
+	reply, err := c.do("CLUSTER", "SLOTS")
+	if err != nil {
+		return nil, fmt.Errorf("cluster slots failed, %v", err)
+	}
+	entries, ok := reply.([]interface{})
+	if !ok {
+		return nil, errors.New("illegal reply of cluster slots")
+	}
+	var nodes []*ClusterNode
+	nodeMap := make(map[string]*ClusterNode)
+	for _, entry := range entries {
+		// each entry is [start, end, [ip, port, id, ...], replicas ...]
+		fields, ok := entry.([]interface{})
+		if !ok || len(fields) < 3 {
+			return nil, errors.New("illegal reply of cluster slots")
+		}
+		start, ok1 := fields[0].(int64)
+		end, ok2 := fields[1].(int64)
+		master, ok3 := fields[2].([]interface{})
+		if !ok1 || !ok2 || !ok3 || len(master) < 2 {
+			return nil, errors.New("illegal reply of cluster slots")
+		}
+		host, ok1 := master[0].(string)
+		port, ok2 := master[1].(int64)
+		if !ok1 || !ok2 {
+			return nil, errors.New("illegal reply of cluster slots")
+		}
+		if host == "" || host == "?" {
+			// unknown endpoint means the node we are connected to
+			host = u.Hostname()
+		}
+		addr := net.JoinHostPort(host, strconv.FormatInt(port, 10))
+		node := nodeMap[addr]
+		if node == nil {
+			node = &ClusterNode{Name: addr}
+			nodeMap[addr] = node
+			nodes = append(nodes, node)
+		}
+		node.Slots = append(node.Slots, SlotRange{Start: int(start), End: int(end)})
+	}
+	if len(nodes) == 0 {
+		return nil, errors.New("no slot is served by cluster")
+	}
+	return nodes, nil
+}
+
+// nodeURL replaces address in redisURL, user and password are kept
+func nodeURL(redisURL string, addr string) (string, error) {
+	u, err := url.Parse(redisURL)
+	if err != nil {
+		return "", fmt.Errorf("illegal redis url: %v", err)
+	}
+	u.Host = addr
+	return u.String(), nil
+}
+
+// parseRedirect parses error reply like "MOVED 3999 127.0.0.1:6381" or "ASK 3999 127.0.0.1:6381"
+func parseRedirect(msg string) (ask bool, slot int, addr string, ok bool) {
+	fields := strings.Fields(msg)
+	if len(fields) != 3 || (fields[0] != "MOVED" && fields[0] != "ASK") {
+		return false, 0, "", false
+	}
+	slot, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return false, 0, "", false
+	}
+	return fields[0] == "ASK", slot, fields[2], true
+}
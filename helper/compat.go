@@ -0,0 +1,160 @@
+package helper
+
+import (
+	"errors"
+	"fmt"
+	"github.com/hdt3213/rdb/core"
+	"github.com/hdt3213/rdb/model"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// CompatFeature is an opcode or type flag found in rdb
+type CompatFeature struct {
+	Name         string // name in redis source code, such as RDB_TYPE_HASH_LISTPACK
+	RedisVersion string // minimal redis version which could load it
+	Count        int
+}
+
+// CompatIssue describes something in rdb which target redis could not load
+type CompatIssue struct {
+	DB           int
+	Key          string // key or function library name, empty for header and opcodes
+	Type         string // type of object, or "header", "opcode"
+	Feature      string
+	RedisVersion string // minimal redis version required
+}
+
+// CompatReport is result of CheckCompat
+type CompatReport struct {
+	RDBVersion      int
+	TargetVersion   string
+	MinRedisVersion string           // minimal redis version which could load the rdb
+	Features        []*CompatFeature // ordered by code
+	Modules         []string         // names of modules whose values or aux data are found, they must be loaded
+	Functions       []string         // names of function libraries
+	Issues          []*CompatIssue   // empty if target version is not given
+}
+
+// Compatible returns whether target redis could load the rdb
+func (r *CompatReport) Compatible() bool {
+	return r.TargetVersion == "" || len(r.Issues) == 0
+}
+
+// functionLibraryName returns name in shebang of library code, such as mylib of "#!lua name=mylib"
+func functionLibraryName(code string) string {
+	line := code
+	if i := strings.IndexByte(code, '\n'); i >= 0 {
+		line = code[:i]
+	}
+	for _, field := range strings.Fields(line) {
+		if strings.HasPrefix(field, "name=") {
+			return strings.TrimPrefix(field, "name=")
+		}
+	}
+	return ""
+}
+
+// CheckCompat scans header, every opcode and type flag, modules and function libraries of rdb without writing
+// anything, then reports the minimal redis version which could load it. If redisVersion is not empty,
+// keys and other data which the redis version could not load are reported in CompatReport.Issues. Functions of
+// pre-release redis 7.0 are skipped by decoder, they are reported as an opcode for any redis version
+func CheckCompat(rdbFilename string, redisVersion string) (*CompatReport, error) {
+	if rdbFilename == "" {
+		return nil, errors.New("src file path is required")
+	}
+	rdbFile, closeInput, err := openInput(rdbFilename)
+	if err != nil {
+		return nil, fmt.Errorf("open rdb %s failed, %v", rdbFilename, err)
+	}
+	defer closeInput()
+	report := &CompatReport{
+		TargetVersion: redisVersion,
+	}
+	tooNew := func(version string) bool {
+		return redisVersion != "" && core.CompareRedisVersion(version, redisVersion) > 0
+	}
+	counts := make(map[byte]int)
+	var lastCode byte // type flag of key or opcode of metadata passed to callback
+	dec := core.NewDecoder(rdbFile).WithSpecialOpCode().WithCodeCallback(func(code byte) {
+		counts[code]++
+		lastCode = code
+	})
+	modules := make(map[string]struct{})
+	itemCodes := make(map[byte]bool) // opcodes reported by each item, such as functions
+	err = dec.Parse(func(object model.RedisObject) bool {
+		key := object.GetKey()
+		switch o := object.(type) {
+		case *model.AuxObject, *model.DBSizeObject:
+			return true // reported as opcode
+		case *model.FunctionsObject:
+			key = functionLibraryName(o.Code)
+			report.Functions = append(report.Functions, key)
+			itemCodes[lastCode] = true
+		case *model.ModuleAuxObject:
+			key = o.ModuleName
+			modules[o.ModuleName] = struct{}{}
+			itemCodes[lastCode] = true
+		case *model.ModuleObject:
+			modules[o.ModuleName] = struct{}{}
+		}
+		if name, version, ok := core.CodeName(lastCode); ok && tooNew(version) {
+			report.Issues = append(report.Issues, &CompatIssue{
+				DB:           object.GetDBIndex(),
+				Key:          key,
+				Type:         object.GetType(),
+				Feature:      name,
+				RedisVersion: version,
+			})
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("parse rdb %s failed, %v", rdbFilename, err)
+	}
+
+	report.RDBVersion = dec.Version()
+	report.MinRedisVersion = core.RedisVersionOfRDB(report.RDBVersion)
+	if tooNew(report.MinRedisVersion) {
+		report.Issues = append([]*CompatIssue{{
+			Type:         "header",
+			Feature:      "RDB version " + strconv.Itoa(report.RDBVersion),
+			RedisVersion: report.MinRedisVersion,
+		}}, report.Issues...)
+	}
+	codes := make([]int, 0, len(counts))
+	for code := range counts {
+		codes = append(codes, int(code))
+	}
+	sort.Ints(codes)
+	for _, c := range codes {
+		code := byte(c)
+		name, version, ok := core.CodeName(code)
+		if !ok {
+			continue
+		}
+		report.Features = append(report.Features, &CompatFeature{
+			Name:         name,
+			RedisVersion: version,
+			Count:        counts[code],
+		})
+		if core.CompareRedisVersion(version, report.MinRedisVersion) > 0 {
+			report.MinRedisVersion = version
+		}
+		// data of pre-release redis could not be loaded by any released version
+		incompatible := tooNew(version) || redisVersion != "" && core.IsPreReleaseCode(code)
+		if !core.IsTypeCode(code) && !itemCodes[code] && incompatible {
+			report.Issues = append(report.Issues, &CompatIssue{
+				Type:         "opcode",
+				Feature:      name,
+				RedisVersion: version,
+			})
+		}
+	}
+	for name := range modules {
+		report.Modules = append(report.Modules, name)
+	}
+	sort.Strings(report.Modules)
+	return report, nil
+}
@@ -0,0 +1,190 @@
+package helper
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Codec is a compression format of input and output files
+type Codec struct {
+	Name      string
+	Magic     []byte // magic number at the beginning of compressed data, used to recognize input
+	Extension string // extension of output filename such as ".gz", used to choose codec for output
+	NewReader func(r io.Reader) (io.ReadCloser, error)
+	NewWriter func(w io.Writer) (io.WriteCloser, error) // nil if compressed output is not supported
+}
+
+var gzipCodec = &Codec{
+	Name:      "gzip",
+	Magic:     []byte{0x1f, 0x8b},
+	Extension: ".gz",
+	NewReader: func(r io.Reader) (io.ReadCloser, error) {
+		return gzip.NewReader(r)
+	},
+	NewWriter: func(w io.Writer) (io.WriteCloser, error) {
+		return gzip.NewWriter(w), nil
+	},
+}
+
+var (
+	codecs   = []*Codec{gzipCodec}
+	codecsMu sync.RWMutex
+)
+
+// knownFormats are compression formats without built-in codec, they are reported instead of being parsed as rdb
+var knownFormats = []*Codec{
+	{Name: "zstd", Magic: []byte{0x28, 0xb5, 0x2f, 0xfd}, Extension: ".zst"},
+	{Name: "lz4", Magic: []byte{0x04, 0x22, 0x4d, 0x18}, Extension: ".lz4"},
+}
+
+// RegisterCodec adds a codec for reading and writing compressed files, such as zstd or lz4 codec wrapping a third
+// party library. Codec with the same name is replaced
+func RegisterCodec(codec *Codec) error {
+	if codec == nil || codec.Name == "" || len(codec.Magic) == 0 || codec.NewReader == nil {
+		return errors.New("name, magic and reader of codec are required")
+	}
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	for i, c := range codecs {
+		if c.Name == codec.Name {
+			codecs[i] = codec
+			return nil
+		}
+	}
+	codecs = append(codecs, codec)
+	return nil
+}
+
+// sniffCodec returns codec of compressed data by magic number, or nil if data is not compressed
+func sniffCodec(reader *bufio.Reader) (*Codec, error) {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	for _, list := range [][]*Codec{codecs, knownFormats} {
+		for _, codec := range list {
+			magic, _ := reader.Peek(len(codec.Magic))
+			if !bytes.Equal(magic, codec.Magic) {
+				continue
+			}
+			if codec.NewReader == nil {
+				return nil, fmt.Errorf("%s compressed input is not supported, register a codec by helper.RegisterCodec",
+					codec.Name)
+			}
+			return codec, nil
+		}
+	}
+	return nil, nil
+}
+
+// codecOfFilename returns codec chosen by extension of filename, or nil if it is not compressed
+func codecOfFilename(filename string) *Codec {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	for _, list := range [][]*Codec{codecs, knownFormats} {
+		for _, codec := range list {
+			if codec.Extension != "" && strings.HasSuffix(filename, codec.Extension) {
+				return codec
+			}
+		}
+	}
+	return nil
+}
+
+// trimCodecExtension removes extension of compression format, such as dump.aof of dump.aof.gz
+func trimCodecExtension(filename string) string {
+	if codec := codecOfFilename(filename); codec != nil {
+		return strings.TrimSuffix(filename, codec.Extension)
+	}
+	return filename
+}
+
+// openInput opens file for reading, compressed file is decompressed transparently while reading
+func openInput(filename string) (*bufio.Reader, func(), error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	reader, closer, err := decompress(file)
+	if err != nil {
+		_ = file.Close()
+		return nil, nil, err
+	}
+	return reader, func() {
+		closer()
+		_ = file.Close()
+	}, nil
+}
+
+// decompress wraps input with decompressor if it is compressed, closer does not close input
+func decompress(input io.Reader) (*bufio.Reader, func(), error) {
+	reader, ok := input.(*bufio.Reader)
+	if !ok || reader.Size() < 4096 {
+		reader = bufio.NewReader(input)
+	}
+	codec, err := sniffCodec(reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	if codec == nil {
+		return reader, func() {}, nil
+	}
+	decompressor, err := codec.NewReader(reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("read %s header failed, %v", codec.Name, err)
+	}
+	return bufio.NewReader(decompressor), func() {
+		_ = decompressor.Close()
+	}, nil
+}
+
+// compressedFile closes compressor before the file it writes to
+type compressedFile struct {
+	io.WriteCloser
+	file   *os.File
+	closed bool
+}
+
+func (f *compressedFile) Close() error {
+	if f.closed {
+		return nil
+	}
+	f.closed = true
+	err := f.WriteCloser.Close()
+	closeErr := f.file.Close()
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
+
+// CreateOutput creates file for writing, output is compressed if filename ends with extension of a codec,
+// such as dump.json.gz. Close must be called and its error must be checked to finish compressed data
+func CreateOutput(filename string) (io.WriteCloser, error) {
+	codec := codecOfFilename(filename)
+	if codec != nil && codec.NewWriter == nil {
+		return nil, fmt.Errorf("%s compressed output is not supported, register a codec by helper.RegisterCodec",
+			codec.Name)
+	}
+	file, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+	if codec == nil {
+		return file, nil
+	}
+	compressor, err := codec.NewWriter(file)
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	return &compressedFile{
+		WriteCloser: compressor,
+		file:        file,
+	}, nil
+}
@@ -0,0 +1,108 @@
+package helper
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCompressedFile(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "test.txt.gz")
+	output, err := CreateOutput(filename)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = output.Write([]byte("hello"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = output.Close()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	reader, closer, err := openInput(filename)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	data, err := io.ReadAll(reader)
+	closer()
+	if err != nil || string(data) != "hello" {
+		t.Errorf("wrong data: %q, %v", data, err)
+	}
+
+	// zstd is recognized but not supported without registered codec
+	zstdFilename := filepath.Join(dir, "test.zst")
+	err = os.WriteFile(zstdFilename, []byte{0x28, 0xb5, 0x2f, 0xfd, 0}, 0644)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, _, err = openInput(zstdFilename)
+	if err == nil || !strings.Contains(err.Error(), "zstd") {
+		t.Errorf("expect error of zstd, actual %v", err)
+	}
+	_, err = CreateOutput(zstdFilename)
+	if err == nil {
+		t.Error("expect error of zstd output")
+	}
+
+	// register a fake codec
+	err = RegisterCodec(&Codec{
+		Name:      "zstd",
+		Magic:     []byte{0x28, 0xb5, 0x2f, 0xfd},
+		Extension: ".zst",
+		NewReader: func(r io.Reader) (io.ReadCloser, error) {
+			_, err := io.ReadFull(r, make([]byte, 4))
+			return io.NopCloser(r), err
+		},
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer func() {
+		codecs = []*Codec{gzipCodec}
+	}()
+	reader, closer, err = openInput(zstdFilename)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	data, _ = io.ReadAll(reader)
+	closer()
+	if !bytes.Equal(data, []byte{0}) {
+		t.Errorf("wrong data: %q", data)
+	}
+	if RegisterCodec(&Codec{Name: "none"}) == nil {
+		t.Error("expect error of illegal codec")
+	}
+
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	_, _ = w.Write([]byte("*1\r\n$4\r\nPING\r\n"))
+	_ = w.Close()
+	aofFilename := filepath.Join(dir, "appendonly.aof.gz")
+	err = os.WriteFile(aofFilename, buf.Bytes(), 0644)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	dec, closer, err := openDecoder(aofFilename)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer closer()
+	if _, ok := dec.(*aofDecoder); !ok {
+		t.Error("expect aof decoder")
+	}
+}
@@ -6,10 +6,10 @@ import (
 	"fmt"
 	"github.com/hdt3213/rdb/core"
 	"github.com/hdt3213/rdb/model"
-	"os"
+	"io"
 )
 
-// ToJsons read rdb file and convert to json file
+// ToJsons read rdb file and convert to json file, json file is compressed if it is named like *.json.gz
 func ToJsons(rdbFilename string, jsonFilename string, options ...interface{}) error {
 	if rdbFilename == "" {
 		return errors.New("src file path is required")
@@ -18,74 +18,108 @@ func ToJsons(rdbFilename string, jsonFilename string, options ...interface{}) er
 		return errors.New("output file path is required")
 	}
 	// open file
-	rdbFile, err := os.Open(rdbFilename)
+	dec, closeInput, err := openDecoder(rdbFilename)
 	if err != nil {
-		return fmt.Errorf("open rdb %s failed, %v", rdbFilename, err)
+		return err
 	}
-	defer func() {
-		_ = rdbFile.Close()
-	}()
-	jsonFile, err := os.Create(jsonFilename)
+	defer closeInput()
+	jsonFile, err := CreateOutput(jsonFilename)
 	if err != nil {
 		return fmt.Errorf("create json %s failed, %v", jsonFilename, err)
 	}
 	defer func() {
 		_ = jsonFile.Close()
 	}()
-	// create decoder
-	var regexOpt RegexOption
-	for _, opt := range options {
-		switch o := opt.(type) {
-		case RegexOption:
-			regexOpt = o
-		}
+	err = writeJsons(jsonFile, dec, options)
+	if err != nil {
+		return err
 	}
-	var dec decoder = core.NewDecoder(rdbFile)
-	if regexOpt != nil {
-		dec, err = regexWrapper(dec, *regexOpt)
-		if err != nil {
-			return err
-		}
+	return jsonFile.Close()
+}
+
+// ToJsonsFromReader reads rdb or aof from input and writes json to output, such as stdin and a http response.
+// Input is recognized like ToJsons, and could be compressed. The invoker owns input and output
+func ToJsonsFromReader(input io.Reader, output io.Writer, options ...interface{}) error {
+	dec, closeInput, err := newReaderDecoder(input)
+	if err != nil {
+		return err
+	}
+	defer closeInput()
+	return writeJsons(output, dec, options)
+}
+
+// writeJsons writes objects from dec as a json array, output is written sequentially without seeking
+func writeJsons(writer io.Writer, dec decoder, options []interface{}) error {
+	dec, err := wrapDecoder(dec, options...)
+	if err != nil {
+		return err
 	}
-	// parse rdb
-	_, err = jsonFile.WriteString("[\n")
+	_, err = writer.Write([]byte("[\n"))
 	if err != nil {
 		return fmt.Errorf("write json  failed, %v", err)
 	}
 	empty := true
-	err = dec.Parse(func(object model.RedisObject) bool {
-		data, err := json.Marshal(object)
-		if err != nil {
-			fmt.Printf("json marshal failed: %v", err)
-			return true
+	var writeErr error
+	write := func(data []byte) bool {
+		if !empty {
+			data = append([]byte(",\n"), data...) // separator is written before object, so there is no need to seek back
 		}
-		data = append(data, ',', '\n')
-		_, err = jsonFile.Write(data)
-		if err != nil {
-			fmt.Printf("write failed: %v", err)
-			return true
+		_, writeErr = writer.Write(data)
+		if writeErr != nil {
+			writeErr = fmt.Errorf("write json failed: %v", writeErr)
+			return false
 		}
 		empty = false
 		return true
-	})
+	}
+	if d, ok := dec.(*core.Decoder); ok && d.Workers() > 1 {
+		// json is marshalled by workers and written in file order
+		err = d.ParseParallel(true, func(object model.RedisObject) interface{} {
+			data, err := marshalJson(object)
+			if err != nil {
+				return err
+			}
+			return data
+		}, func(result interface{}) bool {
+			if err, ok := result.(error); ok {
+				writeErr = err
+				return false
+			}
+			return write(result.([]byte))
+		})
+	} else {
+		err = dec.Parse(func(object model.RedisObject) bool {
+			var data []byte
+			data, writeErr = marshalJson(object)
+			return writeErr == nil && write(data)
+		})
+	}
 	if err != nil {
 		return err
 	}
-	// finish json
-	if !empty {
-		_, err = jsonFile.Seek(-2, 2)
-		if err != nil {
-			return fmt.Errorf("error during seek in file: %v", err)
-		}
+	if writeErr != nil {
+		return writeErr
 	}
-	_, err = jsonFile.WriteString("\n]")
+	// finish json
+	_, err = writer.Write([]byte("\n]"))
 	if err != nil {
 		return fmt.Errorf("error during write in file: %v", err)
 	}
 	return nil
 }
 
-// ToAOF read rdb file and convert to aof file (Redis Serialization )
+// marshalJson returns error with key of object if it could not be marshalled
+func marshalJson(object model.RedisObject) ([]byte, error) {
+	data, err := json.Marshal(object)
+	if err != nil {
+		return nil, fmt.Errorf("marshal json of %s failed: %v", object.GetKey(), err)
+	}
+	return data, nil
+}
+
+// ToAOF read rdb file and convert to aof file (Redis Serialization ), SELECT is written when database changes.
+// BatchOption, TransactionOption and RestoreOption are accepted besides filter options.
+// Aof file is compressed if it is named like *.aof.gz
 func ToAOF(rdbFilename string, aofFilename string, options ...interface{}) error {
 	if rdbFilename == "" {
 		return errors.New("src file path is required")
@@ -93,43 +127,91 @@ func ToAOF(rdbFilename string, aofFilename string, options ...interface{}) error
 	if aofFilename == "" {
 		return errors.New("output file path is required")
 	}
-	rdbFile, err := os.Open(rdbFilename)
+	dec, closeInput, err := openDecoder(rdbFilename)
 	if err != nil {
-		return fmt.Errorf("open rdb %s failed, %v", rdbFilename, err)
+		return err
 	}
-	defer func() {
-		_ = rdbFile.Close()
-	}()
-	aofFile, err := os.Create(aofFilename)
+	defer closeInput()
+	err = checkRestoreOption(options)
+	if err != nil {
+		return err
+	}
+	aofFile, err := CreateOutput(aofFilename)
 	if err != nil {
 		return fmt.Errorf("create json %s failed, %v", aofFilename, err)
 	}
 	defer func() {
 		_ = aofFile.Close()
 	}()
+	dec, err = wrapDecoder(dec, options...)
+	if err != nil {
+		return err
+	}
+	err = writeAOF(aofFile, dec, options)
+	if err != nil {
+		return err
+	}
+	return aofFile.Close()
+}
+
+// ToAOFFromReader reads rdb or aof from input and writes aof to output, accepting the same options as ToAOF.
+// The invoker owns input and output
+func ToAOFFromReader(input io.Reader, output io.Writer, options ...interface{}) error {
+	err := checkRestoreOption(options)
+	if err != nil {
+		return err
+	}
+	dec, closeInput, err := newReaderDecoder(input)
+	if err != nil {
+		return err
+	}
+	defer closeInput()
+	dec, err = wrapDecoder(dec, options...)
+	if err != nil {
+		return err
+	}
+	return writeAOF(output, dec, options)
+}
 
-	var regexOpt RegexOption
+// checkRestoreOption returns error if rdb version of RestoreOption is not supported
+func checkRestoreOption(options []interface{}) error {
 	for _, opt := range options {
-		switch o := opt.(type) {
-		case RegexOption:
-			regexOpt = o
+		if o, ok := opt.(RestoreOption); ok {
+			err := core.CheckTargetVersion(o.Version)
+			if err != nil {
+				return err
+			}
 		}
 	}
-	var dec decoder = core.NewDecoder(rdbFile)
-	if regexOpt != nil {
-		dec, err = regexWrapper(dec, *regexOpt)
-		if err != nil {
-			return err
+	return nil
+}
+
+// writeAOF writes commands of objects from dec, SELECT is written when database changes
+func writeAOF(writer io.Writer, dec decoder, options []interface{}) error {
+	db := 0 // redis loads aof into db 0 at first
+	var writeErr, convErr error
+	err := dec.Parse(func(object model.RedisObject) bool {
+		var cmdLines []CmdLine
+		cmdLines, convErr = objectToCmd(object, options)
+		if convErr != nil {
+			return false
 		}
-	}
-	return dec.Parse(func(object model.RedisObject) bool {
-		cmdLines := ObjectToCmd(object)
-		data := CmdLinesToResp(cmdLines)
-		_, err = aofFile.Write(data)
-		if err != nil {
-			fmt.Printf("write failed: %v", err)
-			return true
+		if len(cmdLines) > 0 && !isSpecialObject(object) && object.GetDBIndex() != db {
+			db = object.GetDBIndex()
+			cmdLines = append([]CmdLine{makeSelectCmd(db)}, cmdLines...)
 		}
-		return true
+		data := CmdLinesToResp(cmdLines)
+		_, writeErr = writer.Write(data)
+		return writeErr == nil
 	})
+	if err != nil {
+		return err
+	}
+	if convErr != nil {
+		return convErr
+	}
+	if writeErr != nil {
+		return fmt.Errorf("write failed: %v", writeErr)
+	}
+	return nil
 }
@@ -0,0 +1,93 @@
+package helper
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/hdt3213/rdb/core"
+	"github.com/hdt3213/rdb/model"
+	"os"
+)
+
+// DowngradeIssue describes an object dropped by DowngradeRDB, because it could not be represented in target version
+type DowngradeIssue struct {
+	DB     int
+	Key    string // empty for functions and module aux data
+	Type   string
+	Reason string
+}
+
+// StrictOption makes DowngradeRDB fail on the first object which could not be represented, instead of dropping it
+type StrictOption bool
+
+// WithStrictOption creates a StrictOption
+func WithStrictOption() StrictOption {
+	return true
+}
+
+// DowngradeRDB decodes rdb and re-encodes it in rdb version targetVersion, so that older redis could load it,
+// such as 9 for redis 5.0 to 6.2 and 10 for redis 7.0. Encodings unknown to target version are converted, like
+// listpack to ziplist and quicklist2 to quicklist. Objects which could not be represented, like hashes with field
+// expiration before version 12, functions before version 10 and streams before version 9, are dropped and reported
+// unless StrictOption is given.
+// Filter options are also accepted.
+func DowngradeRDB(rdbFilename string, outputFilename string, targetVersion int,
+	options ...interface{}) ([]*DowngradeIssue, error) {
+	if rdbFilename == "" {
+		return nil, errors.New("src file path is required")
+	}
+	if outputFilename == "" {
+		return nil, errors.New("output file path is required")
+	}
+	strict := false
+	for _, opt := range options {
+		if o, ok := opt.(StrictOption); ok {
+			strict = bool(o)
+		}
+	}
+	outputFile, err := os.Create(outputFilename)
+	if err != nil {
+		return nil, fmt.Errorf("create output %s failed, %v", outputFilename, err)
+	}
+	defer func() {
+		_ = outputFile.Close()
+	}()
+	bufWriter := bufio.NewWriter(outputFile)
+	enc := core.NewEncoder(bufWriter).SetVersion(targetVersion)
+	err = enc.WriteHeader()
+	if err != nil {
+		return nil, err
+	}
+	writer := newRDBWriter(enc)
+	var issues []*DowngradeIssue
+	var writeErr error
+	_, err = parseRDBFile(rdbFilename, options, func(object model.RedisObject) bool {
+		err := writer.write(object)
+		var versionErr *core.VersionError
+		if errors.As(err, &versionErr) && !strict {
+			issues = append(issues, &DowngradeIssue{
+				DB:     object.GetDBIndex(),
+				Key:    object.GetKey(),
+				Type:   object.GetType(),
+				Reason: versionErr.Error(),
+			})
+			return true
+		}
+		if err != nil {
+			writeErr = fmt.Errorf("write %s failed: %v", object.GetKey(), err)
+			return false
+		}
+		return true
+	})
+	if err != nil {
+		return issues, err
+	}
+	if writeErr != nil {
+		return issues, writeErr
+	}
+	err = enc.WriteEnd()
+	if err != nil {
+		return issues, err
+	}
+	return issues, bufWriter.Flush()
+}
@@ -0,0 +1,132 @@
+package helper
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hdt3213/rdb/core"
+	"github.com/hdt3213/rdb/model"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// DumpPayload is a payload of DUMP command and the key it belongs to
+type DumpPayload struct {
+	Key     string // empty if unknown
+	Payload []byte
+}
+
+// parseDumpLine parses "[key ]payload", payload is in hex or quoted like output of `redis-cli --no-raw DUMP key`
+func parseDumpLine(line string) (*DumpPayload, error) {
+	key := ""
+	value := line
+	if i := strings.LastIndexByte(line, ' '); i >= 0 && !strings.HasSuffix(line, `"`) {
+		key, value = line[:i], line[i+1:]
+	} else if i := strings.IndexByte(line, '"'); i > 0 {
+		key, value = strings.TrimSpace(line[:i]), line[i:]
+	}
+	var payload []byte
+	var err error
+	if strings.HasPrefix(value, `"`) {
+		var s string
+		s, err = strconv.Unquote(value)
+		payload = []byte(s)
+	} else {
+		payload, err = hex.DecodeString(value)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &DumpPayload{
+		Key:     key,
+		Payload: payload,
+	}, nil
+}
+
+// ParseDumpPayloads reads payloads of DUMP command from data. Each line of data is a payload in hex, or quoted
+// like output of `redis-cli --no-raw DUMP key`, optionally preceded by its key and a space. Empty lines and lines
+// starting with '#' are ignored. If data could not be parsed as lines, it is considered as one raw payload
+func ParseDumpPayloads(data []byte) ([]*DumpPayload, error) {
+	var payloads []*DumpPayload
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, len(data)+1)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		payload, err := parseDumpLine(line)
+		if err != nil {
+			payloads = nil
+			break
+		}
+		payloads = append(payloads, payload)
+	}
+	if len(payloads) > 0 {
+		return payloads, nil
+	}
+	if len(data) == 0 {
+		return nil, errors.New("no payload found")
+	}
+	// raw payload, `redis-cli --raw DUMP key` appends a newline
+	if _, err := core.DecodeDump(data); err != nil && data[len(data)-1] == '\n' {
+		data = data[:len(data)-1]
+	}
+	return []*DumpPayload{{Payload: data}}, nil
+}
+
+// DecodeDumpFile decodes payloads of DUMP command in dumpFilename (see ParseDumpPayloads) and writes them to json
+// file in the same format as ToJsons. It fails on the first payload with wrong rdb version or checksum
+func DecodeDumpFile(dumpFilename string, jsonFilename string) error {
+	if dumpFilename == "" {
+		return errors.New("src file path is required")
+	}
+	if jsonFilename == "" {
+		return errors.New("output file path is required")
+	}
+	data, err := os.ReadFile(dumpFilename)
+	if err != nil {
+		return fmt.Errorf("read %s failed, %v", dumpFilename, err)
+	}
+	payloads, err := ParseDumpPayloads(data)
+	if err != nil {
+		return err
+	}
+	objects := make([]model.RedisObject, 0, len(payloads))
+	for i, payload := range payloads {
+		obj, err := core.DecodeDump(payload.Payload)
+		if err != nil {
+			return fmt.Errorf("decode payload %d failed: %v", i+1, err)
+		}
+		baseObject(obj).Key = payload.Key
+		objects = append(objects, obj)
+	}
+	jsonFile, err := CreateOutput(jsonFilename)
+	if err != nil {
+		return fmt.Errorf("create json %s failed, %v", jsonFilename, err)
+	}
+	defer func() {
+		_ = jsonFile.Close()
+	}()
+	buf := bytes.NewBufferString("[\n")
+	for i, obj := range objects {
+		data, err := json.Marshal(obj)
+		if err != nil {
+			return fmt.Errorf("json marshal failed: %v", err)
+		}
+		buf.Write(data)
+		if i < len(objects)-1 {
+			buf.WriteString(",\n")
+		}
+	}
+	buf.WriteString("\n]")
+	_, err = jsonFile.Write(buf.Bytes())
+	if err != nil {
+		return fmt.Errorf("error during write in file: %v", err)
+	}
+	return jsonFile.Close()
+}
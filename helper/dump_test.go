@@ -0,0 +1,45 @@
+package helper
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+func TestParseDumpPayloads(t *testing.T) {
+	// output of `SET mykey 10` and `DUMP mykey` in redis 6.2
+	raw := "\x00\xc0\n\t\x00\xbem\x06\x89Z(\x00\n"
+	text := "# comment\n" +
+		hex.EncodeToString([]byte(raw)) + "\n" +
+		"mykey " + hex.EncodeToString([]byte(raw)) + "\n\n" +
+		`"\x00\xc0\n\t\x00\xbem\x06\x89Z(\x00\n"` + "\n" +
+		`my key "\x00\xc0\n\t\x00\xbem\x06\x89Z(\x00\n"` + "\n"
+	payloads, err := ParseDumpPayloads([]byte(text))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	expectKeys := []string{"", "mykey", "", "my key"}
+	if len(payloads) != len(expectKeys) {
+		t.Errorf("expect %d payloads, actual %d", len(expectKeys), len(payloads))
+		return
+	}
+	for i, payload := range payloads {
+		if payload.Key != expectKeys[i] || string(payload.Payload) != raw {
+			t.Errorf("wrong payload %d: %s %q", i, payload.Key, payload.Payload)
+		}
+	}
+	for _, data := range []string{raw, raw + "\n"} {
+		payloads, err = ParseDumpPayloads([]byte(data))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if len(payloads) != 1 || string(payloads[0].Payload) != raw {
+			t.Errorf("wrong raw payload: %q", payloads[0].Payload)
+		}
+	}
+	_, err = ParseDumpPayloads(nil)
+	if err == nil {
+		t.Error("expect error of empty data")
+	}
+}
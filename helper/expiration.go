@@ -0,0 +1,211 @@
+package helper
+
+import (
+	"errors"
+	"fmt"
+	"github.com/hdt3213/rdb/core"
+	"github.com/hdt3213/rdb/model"
+	"hash/fnv"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// DropExpiredOption drops keys expired before the reference time
+type DropExpiredOption time.Time
+
+// WithDropExpiredOption creates a DropExpiredOption, zero reference time means now
+func WithDropExpiredOption(reference time.Time) DropExpiredOption {
+	return DropExpiredOption(reference)
+}
+
+// RebaseExpirationOption shifts all expirations by the age of snapshot, as if the snapshot was taken just now
+type RebaseExpirationOption time.Time
+
+// WithRebaseExpirationOption creates a RebaseExpirationOption, zero snapshot time means using ctime aux field of rdb
+func WithRebaseExpirationOption(snapshotTime time.Time) RebaseExpirationOption {
+	return RebaseExpirationOption(snapshotTime)
+}
+
+// MaxTTLOption clamps ttl of keys to it
+type MaxTTLOption time.Duration
+
+// WithMaxTTLOption creates a MaxTTLOption
+func WithMaxTTLOption(maxTTL time.Duration) MaxTTLOption {
+	return MaxTTLOption(maxTTL)
+}
+
+// TTLJitterOption adds a random duration in [0, jitter) to expiration of keys, so that they will not expire at the
+// same time. The duration is derived from key, so a key always gets the same jitter
+type TTLJitterOption time.Duration
+
+// WithTTLJitterOption creates a TTLJitterOption
+func WithTTLJitterOption(jitter time.Duration) TTLJitterOption {
+	return TTLJitterOption(jitter)
+}
+
+// DefaultTTLOption sets ttl of persistent keys matching the regex pattern
+type DefaultTTLOption struct {
+	Pattern string
+	TTL     time.Duration
+}
+
+// WithDefaultTTLOption creates a DefaultTTLOption
+func WithDefaultTTLOption(pattern string, ttl time.Duration) DefaultTTLOption {
+	return DefaultTTLOption{
+		Pattern: pattern,
+		TTL:     ttl,
+	}
+}
+
+// expirationDecoder rewrites expiration of keys, all the steps are applied in order:
+// rebase, default ttl, jitter, max ttl and dropping expired keys
+type expirationDecoder struct {
+	dec          decoder
+	now          time.Time
+	rebase       bool
+	snapshotTime time.Time
+	defaultTTLs  []*defaultTTL
+	jitter       time.Duration
+	maxTTL       time.Duration
+	dropExpired  bool
+	reference    time.Time
+	stripSpecial bool // special objects are enabled for ctime aux only, do not pass them to callback
+}
+
+type defaultTTL struct {
+	pattern *regexp.Regexp
+	ttl     time.Duration
+}
+
+func keyJitter(db int, key string, jitter time.Duration) time.Duration {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(strconv.Itoa(db)))
+	_, _ = h.Write([]byte(key))
+	return time.Duration(h.Sum64() % uint64(jitter))
+}
+
+// rewrite returns new expiration of object and whether to keep it
+func (d *expirationDecoder) rewrite(object model.RedisObject) (*time.Time, bool, error) {
+	expiration := object.GetExpiration()
+	if expiration != nil && d.rebase {
+		if d.snapshotTime.IsZero() {
+			return nil, false, errors.New("snapshot time is unknown, ctime aux field is not found in rdb")
+		}
+		t := expiration.Add(d.now.Sub(d.snapshotTime))
+		expiration = &t
+	}
+	if expiration == nil {
+		for _, def := range d.defaultTTLs {
+			if def.pattern.MatchString(object.GetKey()) {
+				t := d.now.Add(def.ttl)
+				expiration = &t
+				break
+			}
+		}
+	}
+	if expiration != nil && d.jitter > 0 {
+		t := expiration.Add(keyJitter(object.GetDBIndex(), object.GetKey(), d.jitter))
+		expiration = &t
+	}
+	if expiration != nil && d.maxTTL > 0 {
+		if max := d.now.Add(d.maxTTL); expiration.After(max) {
+			expiration = &max
+		}
+	}
+	if expiration != nil && d.dropExpired && !expiration.After(d.reference) {
+		return nil, false, nil
+	}
+	return expiration, true, nil
+}
+
+func (d *expirationDecoder) Parse(cb func(object model.RedisObject) bool) error {
+	var rewriteErr error
+	err := d.dec.Parse(func(object model.RedisObject) bool {
+		if isSpecialObject(object) {
+			if aux, ok := object.(*model.AuxObject); ok && aux.Key == "ctime" && d.rebase && d.snapshotTime.IsZero() {
+				ctime, err := strconv.ParseInt(aux.Value, 10, 64)
+				if err == nil {
+					d.snapshotTime = time.Unix(ctime, 0)
+				}
+			}
+			if d.stripSpecial {
+				return true
+			}
+			return cb(object)
+		}
+		expiration, keep, err := d.rewrite(object)
+		if err != nil {
+			rewriteErr = err
+			return false
+		}
+		if !keep {
+			return true
+		}
+		baseObject(object).Expiration = expiration
+		return cb(object)
+	})
+	if err != nil {
+		return err
+	}
+	return rewriteErr
+}
+
+// wrapExpirationDecoder applies expiration options to decoder, returns dec if there is no such option
+func wrapExpirationDecoder(dec decoder, options ...interface{}) (decoder, error) {
+	now := getNow(options)
+	d := &expirationDecoder{
+		now: now,
+	}
+	enabled := false
+	for _, opt := range options {
+		switch o := opt.(type) {
+		case DropExpiredOption:
+			d.dropExpired = true
+			d.reference = time.Time(o)
+			if d.reference.IsZero() {
+				d.reference = now
+			}
+		case RebaseExpirationOption:
+			d.rebase = true
+			d.snapshotTime = time.Time(o)
+		case MaxTTLOption:
+			if o <= 0 {
+				return nil, errors.New("max ttl must be positive")
+			}
+			d.maxTTL = time.Duration(o)
+		case TTLJitterOption:
+			if o < 0 {
+				return nil, errors.New("ttl jitter must not be negative")
+			}
+			d.jitter = time.Duration(o)
+		case DefaultTTLOption:
+			if o.TTL <= 0 {
+				return nil, errors.New("default ttl must be positive")
+			}
+			pattern, err := regexp.Compile(o.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("illegal regex expression: %v", err)
+			}
+			d.defaultTTLs = append(d.defaultTTLs, &defaultTTL{
+				pattern: pattern,
+				ttl:     o.TTL,
+			})
+		default:
+			continue
+		}
+		enabled = true
+	}
+	if !enabled {
+		return dec, nil
+	}
+	if d.rebase && d.snapshotTime.IsZero() {
+		// ctime aux field is required
+		if coreDec, ok := dec.(*core.Decoder); ok && !coreDec.SpecialOpCodeEnabled() {
+			coreDec.WithSpecialOpCode()
+			d.stripSpecial = true
+		}
+	}
+	d.dec = dec
+	return d, nil
+}
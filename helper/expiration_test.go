@@ -0,0 +1,158 @@
+package helper
+
+import (
+	"github.com/hdt3213/rdb/model"
+	"testing"
+	"time"
+)
+
+// sliceDecoder passes given objects to callback
+type sliceDecoder []model.RedisObject
+
+func (d sliceDecoder) Parse(cb func(object model.RedisObject) bool) error {
+	for _, object := range d {
+		if !cb(object) {
+			break
+		}
+	}
+	return nil
+}
+
+func makeStringObject(key string, expiration *time.Time) model.RedisObject {
+	return &model.StringObject{
+		BaseObject: &model.BaseObject{
+			Key:        key,
+			Type:       model.StringType,
+			Expiration: expiration,
+		},
+		Value: []byte(key),
+	}
+}
+
+func TestExpirationDecoder(t *testing.T) {
+	snapshot := time.Now().Add(-24 * time.Hour)
+	hourAfterSnapshot := snapshot.Add(time.Hour)
+	longAfterSnapshot := snapshot.Add(365 * 24 * time.Hour)
+	objects := func() sliceDecoder { // decoders modify objects
+		return sliceDecoder{
+			&model.AuxObject{
+				BaseObject: &model.BaseObject{Key: "ctime", Type: model.AuxType},
+				Value:      "1",
+			},
+			makeStringObject("expired", &hourAfterSnapshot),
+			makeStringObject("long", &longAfterSnapshot),
+			makeStringObject("session:1", nil),
+			makeStringObject("persistent", nil),
+		}
+	}
+	parse := func(options ...interface{}) map[string]*time.Time {
+		dec, err := wrapDecoder(objects(), options...)
+		if err != nil {
+			t.Error(err)
+			return nil
+		}
+		result := make(map[string]*time.Time)
+		err = dec.Parse(func(object model.RedisObject) bool {
+			if !isSpecialObject(object) {
+				result[object.GetKey()] = object.GetExpiration()
+			}
+			return true
+		})
+		if err != nil {
+			t.Error(err)
+		}
+		return result
+	}
+
+	result := parse(WithDropExpiredOption(time.Time{}))
+	if _, ok := result["expired"]; ok || len(result) != 3 {
+		t.Errorf("expired key should be dropped: %v", result)
+	}
+	result = parse(WithDropExpiredOption(snapshot))
+	if len(result) != 4 {
+		t.Errorf("no key should be dropped: %v", result)
+	}
+
+	result = parse(WithRebaseExpirationOption(snapshot), WithDropExpiredOption(time.Time{}))
+	if ttl := time.Until(*result["expired"]); ttl < 59*time.Minute || ttl > time.Hour {
+		t.Errorf("wrong ttl after rebase: %v", ttl)
+	}
+	// ctime aux is 1970-01-01T00:00:01Z, so every key is expired long ago
+	result = parse(WithRebaseExpirationOption(time.Time{}))
+	if ttl := time.Until(*result["long"]); ttl < 365*24*time.Hour {
+		t.Errorf("wrong ttl after rebase: %v", ttl)
+	}
+
+	result = parse(WithMaxTTLOption(time.Hour), WithDefaultTTLOption("^session:", 24*time.Hour))
+	if ttl := time.Until(*result["long"]); ttl > time.Hour {
+		t.Errorf("ttl should be clamped: %v", ttl)
+	}
+	if ttl := time.Until(*result["session:1"]); ttl > time.Hour || ttl < 59*time.Minute {
+		t.Errorf("ttl should be clamped: %v", ttl)
+	}
+	if result["persistent"] != nil {
+		t.Error("persistent key should not have ttl")
+	}
+
+	result = parse(WithTTLJitterOption(time.Minute))
+	jitter := result["long"].Sub(longAfterSnapshot)
+	if jitter < 0 || jitter >= time.Minute {
+		t.Errorf("wrong jitter: %v", jitter)
+	}
+	if again := parse(WithTTLJitterOption(time.Minute)); !again["long"].Equal(*result["long"]) {
+		t.Error("jitter of a key should be stable")
+	}
+
+	illegalOptions := []interface{}{
+		WithMaxTTLOption(0),
+		WithTTLJitterOption(-time.Second),
+		WithDefaultTTLOption("^a", 0),
+		WithDefaultTTLOption("(a", time.Hour),
+	}
+	for _, opt := range illegalOptions {
+		if _, err := wrapDecoder(objects(), opt); err == nil {
+			t.Errorf("expect error for %v", opt)
+		}
+	}
+	dec, _ := wrapDecoder(objects()[1:], WithRebaseExpirationOption(time.Time{}))
+	if err := dec.Parse(func(object model.RedisObject) bool { return true }); err == nil {
+		t.Error("expect error for unknown snapshot time")
+	}
+}
+
+func TestNowOption(t *testing.T) {
+	now := time.Now()
+	expiration := now.Add(time.Minute)
+	objects := sliceDecoder{
+		&model.StringObject{BaseObject: &model.BaseObject{Key: "k", Expiration: &expiration}},
+	}
+	options := []interface{}{WithExpireStateOption(ExpiredKeys)}
+	fixed := withNow(options)
+	if len(options) != 1 || len(fixed) != 2 {
+		t.Error("withNow should not modify options")
+	}
+	if !getNow(withNow(fixed)).Equal(getNow(fixed)) {
+		t.Error("time of nowOption should be kept")
+	}
+	for _, tc := range []struct {
+		now    time.Time
+		expect int
+	}{
+		{now, 0},
+		{expiration.Add(time.Second), 1}, // key is expired at the fixed time
+	} {
+		dec, err := wrapDecoder(objects, WithExpireStateOption(ExpiredKeys), nowOption(tc.now))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		count := 0
+		err = dec.Parse(func(object model.RedisObject) bool {
+			count++
+			return true
+		})
+		if err != nil || count != tc.expect {
+			t.Errorf("expect %d keys, actual %d, %v", tc.expect, count, err)
+		}
+	}
+}
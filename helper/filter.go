@@ -0,0 +1,223 @@
+package helper
+
+import (
+	"fmt"
+	"github.com/hdt3213/rdb/core"
+	"github.com/hdt3213/rdb/model"
+	"time"
+)
+
+// DBOption only keeps keys in the given databases
+type DBOption []int
+
+// WithDBOption creates a DBOption
+func WithDBOption(dbs ...int) DBOption {
+	return dbs
+}
+
+// TypeOption only keeps keys of the given types, such as model.StringType
+type TypeOption []string
+
+// WithTypeOption creates a TypeOption
+func WithTypeOption(types ...string) TypeOption {
+	return types
+}
+
+// SizeOption only keeps keys whose rdb encoded size is in [Min, Max], Max 0 means unlimited
+type SizeOption struct {
+	Min int
+	Max int
+}
+
+// WithSizeOption creates a SizeOption
+func WithSizeOption(min, max int) SizeOption {
+	return SizeOption{
+		Min: min,
+		Max: max,
+	}
+}
+
+const (
+	// PersistentKeys are keys without expiration
+	PersistentKeys = "persistent"
+	// VolatileKeys are keys with expiration which have not expired
+	VolatileKeys = "volatile"
+	// ExpiredKeys are keys expired before now
+	ExpiredKeys = "expired"
+)
+
+// ExpireStateOption only keeps keys in the given expiration states: PersistentKeys, VolatileKeys or ExpiredKeys
+type ExpireStateOption []string
+
+// WithExpireStateOption creates a ExpireStateOption
+func WithExpireStateOption(states ...string) ExpireStateOption {
+	return states
+}
+
+// nowOption fixes current time seen by options depending on it, so that every pass over the same rdb keeps same keys
+type nowOption time.Time
+
+// getNow returns time of nowOption, or time.Now() if there is no such option
+func getNow(options []interface{}) time.Time {
+	for _, opt := range options {
+		if o, ok := opt.(nowOption); ok {
+			return time.Time(o)
+		}
+	}
+	return time.Now()
+}
+
+// withNow returns options with current time fixed, the given slice is not modified
+func withNow(options []interface{}) []interface{} {
+	fixed := make([]interface{}, 0, len(options)+1)
+	fixed = append(fixed, options...)
+	return append(fixed, nowOption(getNow(options)))
+}
+
+// baseObject returns base object of objects decoded by this package, all of them embed *model.BaseObject
+func baseObject(object model.RedisObject) *model.BaseObject {
+	return object.(model.BaseObjectHolder).GetBaseObject()
+}
+
+// isSpecialObject returns whether object is metadata rather than a key
+func isSpecialObject(object model.RedisObject) bool {
+	switch object.GetType() {
+	case model.AuxType, model.DBSizeType, model.FunctionsType, model.ModuleAuxType:
+		return true
+	}
+	return false
+}
+
+// filterDecoder only passes keys matching all filters to callback, metadata objects are always passed
+type filterDecoder struct {
+	dec     decoder
+	filters []func(object model.RedisObject) bool
+}
+
+func (d *filterDecoder) Parse(cb func(object model.RedisObject) bool) error {
+	return d.dec.Parse(func(object model.RedisObject) bool {
+		if !isSpecialObject(object) {
+			for _, filter := range d.filters {
+				if !filter(object) {
+					return true
+				}
+			}
+		}
+		return cb(object)
+	})
+}
+
+func makeExpireStateFilter(states []string, now time.Time) (func(object model.RedisObject) bool, error) {
+	accepted := make(map[string]struct{})
+	for _, state := range states {
+		switch state {
+		case PersistentKeys, VolatileKeys, ExpiredKeys:
+			accepted[state] = struct{}{}
+		default:
+			return nil, fmt.Errorf("unknown expiration state: %s", state)
+		}
+	}
+	return func(object model.RedisObject) bool {
+		state := PersistentKeys
+		if expiration := object.GetExpiration(); expiration != nil {
+			if expiration.After(now) {
+				state = VolatileKeys
+			} else {
+				state = ExpiredKeys
+			}
+		}
+		_, ok := accepted[state]
+		return ok
+	}, nil
+}
+
+// wrapDecoder applies parallel option, skip unknown option, expiration options, filter options, sampling, rewrite rules and anonymizer to decoder
+func wrapDecoder(dec decoder, options ...interface{}) (decoder, error) {
+	if d, ok := dec.(*core.Decoder); ok {
+		for _, opt := range options {
+			if o, ok := opt.(ParallelOption); ok {
+				d.WithWorkers(o.workers())
+			}
+		}
+	}
+	if d, ok := dec.(*aofDecoder); ok {
+		d.skipped = skipUnknownReport(options)
+	}
+	dec, err := wrapExpirationDecoder(dec, options...) // filters see rewritten expiration
+	if err != nil {
+		return nil, err
+	}
+	var filters []func(object model.RedisObject) bool
+	for _, opt := range options {
+		switch o := opt.(type) {
+		case RegexOption:
+			if o == nil {
+				continue
+			}
+			filter, err := makeRegexFilter(*o)
+			if err != nil {
+				return nil, err
+			}
+			filters = append(filters, filter)
+		case DBOption:
+			dbs := make(map[int]struct{})
+			for _, db := range o {
+				dbs[db] = struct{}{}
+			}
+			filters = append(filters, func(object model.RedisObject) bool {
+				_, ok := dbs[object.GetDBIndex()]
+				return ok
+			})
+		case TypeOption:
+			types := make(map[string]struct{})
+			for _, typ := range o {
+				types[typ] = struct{}{}
+			}
+			filters = append(filters, func(object model.RedisObject) bool {
+				_, ok := types[object.GetType()]
+				return ok
+			})
+		case SizeOption:
+			min, max := o.Min, o.Max
+			filters = append(filters, func(object model.RedisObject) bool {
+				size := object.GetSize()
+				return size >= min && (max <= 0 || size <= max)
+			})
+		case ExpireStateOption:
+			filter, err := makeExpireStateFilter(o, getNow(options))
+			if err != nil {
+				return nil, err
+			}
+			filters = append(filters, filter)
+		}
+	}
+	if len(filters) > 0 {
+		dec = &filterDecoder{
+			dec:     dec,
+			filters: filters,
+		}
+	}
+	dec, err = wrapSampleDecoder(dec, options...) // sample from keys matching filters
+	if err != nil {
+		return nil, err
+	}
+	if rules := getRewriteRules(options); len(rules) > 0 { // filters match source keys
+		dec = &rewriteDecoder{
+			dec:   dec,
+			rules: rules,
+		}
+	}
+	for _, opt := range options {
+		if o, ok := opt.(AnonymizeOption); ok { // anonymize rules match rewritten keys
+			a, err := newAnonymizer(o)
+			if err != nil {
+				return nil, err
+			}
+			dec = &anonymizeDecoder{
+				dec: dec,
+				a:   a,
+			}
+		}
+	}
+	return dec, nil
+}
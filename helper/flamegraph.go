@@ -3,11 +3,10 @@ package helper
 import (
 	"encoding/json"
 	"errors"
-	"fmt"
 	"github.com/hdt3213/rdb/core"
 	"github.com/hdt3213/rdb/d3flame"
 	"github.com/hdt3213/rdb/model"
-	"os"
+	"io"
 	"strconv"
 	"strings"
 )
@@ -23,26 +22,41 @@ func FlameGraph(rdbFilename string, port int, separators []string, options ...in
 	if port == 0 {
 		port = 16379 // default port
 	}
-	rdbFile, err := os.Open(rdbFilename)
+	dec, closeInput, err := openDecoder(rdbFilename)
 	if err != nil {
-		return nil, fmt.Errorf("open rdb %s failed, %v", rdbFilename, err)
-	}
-	defer func() {
-		_ = rdbFile.Close()
-	}()
-	var regexOpt RegexOption
-	for _, opt := range options {
-		switch o := opt.(type) {
-		case RegexOption:
-			regexOpt = o
-		}
+		return nil, err
 	}
-	var dec decoder = core.NewDecoder(rdbFile)
-	if regexOpt != nil {
-		dec, err = regexWrapper(dec, *regexOpt)
-		if err != nil {
-			return nil, err
-		}
+	defer closeInput()
+	data, err := makeFlameGraph(dec, separators, options)
+	if err != nil {
+		return nil, err
+	}
+	return d3flame.Serve(data, port)
+}
+
+// FlameGraphFromReader reads rdb or aof from input and writes a standalone flamegraph page in html to output.
+// The invoker owns input and output
+func FlameGraphFromReader(input io.Reader, output io.Writer, separators []string, options ...interface{}) error {
+	dec, closeInput, err := newReaderDecoder(input)
+	if err != nil {
+		return err
+	}
+	defer closeInput()
+	data, err := makeFlameGraph(dec, separators, options)
+	if err != nil {
+		return err
+	}
+	return d3flame.Render(output, data)
+}
+
+// makeFlameGraph returns flamegraph data in json
+func makeFlameGraph(dec decoder, separators []string, options []interface{}) ([]byte, error) {
+	if d, ok := dec.(*core.Decoder); ok {
+		d.WithSizeOnly() // flame graph only needs sizes
+	}
+	dec, err := wrapDecoder(dec, options...)
+	if err != nil {
+		return nil, err
 	}
 	root := &d3flame.FlameItem{
 		Children: make(map[string]*d3flame.FlameItem),
@@ -59,11 +73,7 @@ func FlameGraph(rdbFilename string, port int, separators []string, options ...in
 	if count >= TrimThreshold {
 		trimData(root)
 	}
-	data, err := json.Marshal(root)
-	if err != nil {
-		return nil, err
-	}
-	return d3flame.Web(data, port), nil
+	return json.Marshal(root)
 }
 
 func split(s string, separators []string) []string {
@@ -0,0 +1,559 @@
+package helper
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/hdt3213/rdb/model"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ImportOption configures connections, pipelining, rate limit and retries of ImportRDB
+type ImportOption struct {
+	Concurrency int // number of connections, default value is 1
+	Pipeline    int // max number of commands sent before reading replies, default value is 100
+	Rate        int // max number of keys sent per second, 0 means unlimited
+	Retries     int // max times of retrying a key on transient errors, negative value means default value 3
+}
+
+// WithImportOption creates an ImportOption, zero values are replaced by default values except rate and retries.
+// Retries of 0 disables retrying, use -1 for the default value
+func WithImportOption(concurrency, pipeline, rate, retries int) ImportOption {
+	return ImportOption{
+		Concurrency: concurrency,
+		Pipeline:    pipeline,
+		Rate:        rate,
+		Retries:     retries,
+	}
+}
+
+// ImportFailure is a key which could not be imported
+type ImportFailure struct {
+	DB  int
+	Key string
+	Err string // error reply of redis
+}
+
+// ImportResult is result of ImportRDB
+type ImportResult struct {
+	Keys     int // number of keys imported
+	Commands int // number of commands sent, including retries
+	Failures []*ImportFailure
+	Nodes    map[string]int // number of keys imported into each node, by address
+}
+
+// transientErrors are prefixes of error replies which are worth retrying
+var transientErrors = map[string]bool{
+	"LOADING":    true,
+	"BUSY":       true,
+	"TRYAGAIN":   true,
+	"MASTERDOWN": true,
+}
+
+func isTransientError(msg string) bool {
+	fields := strings.Fields(msg)
+	return len(fields) > 0 && transientErrors[fields[0]]
+}
+
+// ImportRDB reads rdb or aof and writes keys into redis with url like redis://[[user]:password@]host:port.
+// Keys are converted by ObjectToCmd and sent in pipeline, every reply is checked. Keys failed on transient errors
+// such as LOADING are deleted and written again, other failures are reported in ImportResult.
+// With ClusterOption, keys are routed to masters by CLUSTER SLOTS, and MOVED or ASK redirects are followed.
+// ImportOption, ClusterOption, RestoreOption, BatchOption, TransactionOption and filter options are accepted
+func ImportRDB(rdbFilename string, redisURL string, options ...interface{}) (*ImportResult, error) {
+	if rdbFilename == "" {
+		return nil, errors.New("src file path is required")
+	}
+	dec, closeInput, err := openDecoder(rdbFilename)
+	if err != nil {
+		return nil, err
+	}
+	defer closeInput()
+	return importObjects(dec, redisURL, options)
+}
+
+// ImportFromReader reads rdb or aof from input and writes keys into redis like ImportRDB.
+// The invoker owns input
+func ImportFromReader(input io.Reader, redisURL string, options ...interface{}) (*ImportResult, error) {
+	dec, closeInput, err := newReaderDecoder(input)
+	if err != nil {
+		return nil, err
+	}
+	defer closeInput()
+	return importObjects(dec, redisURL, options)
+}
+
+func importObjects(dec decoder, redisURL string, options []interface{}) (*ImportResult, error) {
+	if redisURL == "" {
+		return nil, errors.New("redis url is required")
+	}
+	opt := ImportOption{
+		Concurrency: 1,
+		Pipeline:    100,
+		Retries:     3,
+	}
+	var cluster bool
+	for _, o := range options {
+		if _, ok := o.(ClusterOption); ok {
+			cluster = true
+		}
+		if o, ok := o.(ImportOption); ok {
+			if o.Concurrency > 0 {
+				opt.Concurrency = o.Concurrency
+			}
+			if o.Pipeline > 0 {
+				opt.Pipeline = o.Pipeline
+			}
+			if o.Retries >= 0 {
+				opt.Retries = o.Retries
+			}
+			opt.Rate = o.Rate
+		}
+	}
+	err := checkRestoreOption(options)
+	if err != nil {
+		return nil, err
+	}
+	dec, err = wrapDecoder(dec, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	imp := &importer{
+		opt:     opt,
+		options: options,
+		result: &ImportResult{
+			Nodes: make(map[string]int),
+		},
+		stop:      make(chan struct{}),
+		nodeIndex: make(map[string]int),
+	}
+	var clusterNodes []*ClusterNode
+	if cluster {
+		clusterNodes, err = fetchClusterSlots(redisURL)
+		if err != nil {
+			return nil, err
+		}
+		imp.table, err = makeSlotTable(clusterNodes)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		u, err := url.Parse(redisURL)
+		if err != nil {
+			return nil, fmt.Errorf("illegal redis url: %v", err)
+		}
+		clusterNodes = []*ClusterNode{{Name: redisAddr(u)}}
+	}
+	for i, n := range clusterNodes {
+		u, err := nodeURL(redisURL, n.Name)
+		if err != nil {
+			return nil, err
+		}
+		imp.nodes = append(imp.nodes, &importNode{
+			addr:    n.Name,
+			url:     u,
+			objects: make(chan model.RedisObject, opt.Concurrency*opt.Pipeline),
+		})
+		imp.nodeIndex[n.Name] = i
+	}
+	// connect before parsing, so that wrong url or password fails fast
+	var workers []*importWorker
+	for _, node := range imp.nodes {
+		for i := 0; i < opt.Concurrency; i++ {
+			conn, err := dialRedis(node.url)
+			if err != nil {
+				for _, w := range workers {
+					_ = w.conn.Close()
+				}
+				return nil, err
+			}
+			workers = append(workers, &importWorker{
+				importer:      imp,
+				node:          node,
+				conn:          conn,
+				redirectConns: make(map[string]*redisConn),
+			})
+		}
+	}
+	var wg sync.WaitGroup
+	for _, w := range workers {
+		wg.Add(1)
+		go func(w *importWorker) {
+			defer wg.Done()
+			w.run(w.node.objects)
+		}(w)
+	}
+	start := time.Now()
+	count := 0
+	err = dec.Parse(func(object model.RedisObject) bool {
+		if opt.Rate > 0 {
+			next := start.Add(time.Duration(count) * time.Second / time.Duration(opt.Rate))
+			if wait := time.Until(next); wait > 0 {
+				time.Sleep(wait)
+			}
+		}
+		count++
+		select {
+		case imp.route(object.GetKey()).objects <- object:
+			return true
+		case <-imp.stop:
+			return false
+		}
+	})
+	for _, node := range imp.nodes {
+		close(node.objects)
+	}
+	wg.Wait()
+	if imp.err != nil {
+		return imp.result, imp.err
+	}
+	if err != nil {
+		return imp.result, err
+	}
+	return imp.result, nil
+}
+
+// importNode is a redis or a master of cluster, keys are sent to it by its own workers
+type importNode struct {
+	addr    string
+	url     string
+	objects chan model.RedisObject
+}
+
+// importer holds state shared by workers
+type importer struct {
+	opt       ImportOption
+	options   []interface{}
+	nodes     []*importNode
+	nodeIndex map[string]int // address -> index in nodes
+
+	mu       sync.Mutex
+	table    []int // index of node serving each slot, nil if it is not a cluster
+	result   *ImportResult
+	err      error // error stopping import, such as lost connection
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// route returns the node serving key, keys of unassigned slots are sent to the first node which replies the error
+func (imp *importer) route(key string) *importNode {
+	if imp.table == nil {
+		return imp.nodes[0]
+	}
+	imp.mu.Lock()
+	i := imp.table[HashSlot(key)]
+	imp.mu.Unlock()
+	if i < 0 {
+		i = 0
+	}
+	return imp.nodes[i]
+}
+
+// moved updates slot table by MOVED redirect, so that following keys are sent to the new node directly
+func (imp *importer) moved(slot int, addr string) {
+	imp.mu.Lock()
+	defer imp.mu.Unlock()
+	if i, ok := imp.nodeIndex[addr]; ok && slot >= 0 && slot < len(imp.table) {
+		imp.table[slot] = i
+	}
+}
+
+func (imp *importer) fail(err error) {
+	imp.stopOnce.Do(func() {
+		imp.err = err
+		close(imp.stop)
+	})
+}
+
+// importEntry is a key being imported
+type importEntry struct {
+	object   model.RedisObject
+	cmds     []CmdLine
+	attempts int
+	errMsg   string
+}
+
+// retryCmds returns commands of entry preceded by DEL. Commands such as RPUSH are not idempotent,
+// so key written partially is deleted before retrying
+func (e *importEntry) retryCmds() []CmdLine {
+	return append([]CmdLine{{[]byte("DEL"), []byte(e.object.GetKey())}}, e.cmds...)
+}
+
+// importWorker sends keys through a connection to a node, keys are sent in order of arrival
+type importWorker struct {
+	*importer
+	node          *importNode
+	conn          *redisConn
+	db            int // current database of connection
+	pending       []*importEntry
+	pendCmd       int
+	redirectConns map[string]*redisConn // connections to nodes given by MOVED or ASK
+}
+
+func (w *importWorker) run(objects <-chan model.RedisObject) {
+	defer func() {
+		_ = w.conn.Close()
+		for _, conn := range w.redirectConns {
+			_ = conn.Close()
+		}
+	}()
+	for object := range objects {
+		cmds, err := objectToCmd(object, w.options)
+		if err != nil {
+			w.report(&importEntry{object: object, errMsg: err.Error()})
+			continue
+		}
+		if len(cmds) == 0 {
+			continue
+		}
+		w.pending = append(w.pending, &importEntry{
+			object: object,
+			cmds:   cmds,
+		})
+		w.pendCmd += len(cmds)
+		if w.pendCmd < w.opt.Pipeline {
+			continue
+		}
+		err = w.flush()
+		if err != nil {
+			w.fail(err)
+			return
+		}
+	}
+	err := w.flush()
+	if err != nil {
+		w.fail(err)
+	}
+}
+
+// flush sends pending keys until all of them are imported or failed
+func (w *importWorker) flush() error {
+	entries := w.pending
+	w.pending, w.pendCmd = nil, 0
+	for len(entries) > 0 {
+		// keys are sent database by database, so that keys are never written into wrong database if SELECT fails
+		n := 1
+		for n < len(entries) && entries[n].object.GetDBIndex() == entries[0].object.GetDBIndex() {
+			n++
+		}
+		batch := entries[:n]
+		entries = entries[n:]
+		for attempt := 1; len(batch) > 0; attempt++ {
+			retry, err := w.send(batch)
+			if err != nil {
+				return err
+			}
+			batch = retry
+			if len(batch) > 0 {
+				time.Sleep(time.Duration(attempt) * 100 * time.Millisecond)
+			}
+		}
+	}
+	return nil
+}
+
+// send writes commands of entries in the same database in pipeline, then returns entries to retry
+func (w *importWorker) send(entries []*importEntry) ([]*importEntry, error) {
+	var err error
+	var retry, redirects []*importEntry
+	done := 0 // number of entries whose replies are read
+	if db := entries[0].object.GetDBIndex(); db != w.db {
+		var reply string
+		reply, err = w.conn.call("SELECT", strconv.Itoa(db))
+		w.countCommands(1)
+		if err == nil && strings.HasPrefix(reply, "-") {
+			for _, e := range entries {
+				e.errMsg = reply[1:]
+				w.report(e)
+			}
+			return nil, nil
+		}
+		if err == nil {
+			w.db = db
+		}
+	}
+	if err == nil {
+		var buf bytes.Buffer
+		counts := make([]int, len(entries)) // number of commands sent for each entry
+		for i, e := range entries {
+			cmds := e.cmds
+			if e.attempts > 0 {
+				cmds = e.retryCmds()
+			}
+			counts[i] = len(cmds)
+			buf.Write(CmdLinesToResp(cmds))
+		}
+		_, err = w.conn.conn.Write(buf.Bytes())
+		for ; err == nil && done < len(entries); done++ {
+			e := entries[done]
+			e.errMsg = ""
+			for j := 0; err == nil && j < counts[done]; j++ {
+				var msg string
+				msg, err = readReply(w.conn.reader)
+				if e.errMsg == "" {
+					e.errMsg = msg
+				}
+			}
+			if err != nil {
+				break
+			}
+			w.countCommands(counts[done])
+			e.attempts++
+			if e.errMsg == "" {
+				w.succeed(w.node.addr)
+			} else if _, _, _, ok := parseRedirect(e.errMsg); ok && w.table != nil {
+				redirects = append(redirects, e)
+			} else if isTransientError(e.errMsg) && e.attempts <= w.opt.Retries {
+				retry = append(retry, e)
+			} else {
+				w.report(e)
+			}
+		}
+		for _, e := range redirects {
+			w.redirect(e)
+		}
+		if err == nil {
+			return retry, nil
+		}
+	}
+	// connection is broken, keys whose replies are not read are sent again by a new connection
+	_ = w.conn.Close()
+	conn, dialErr := dialRedis(w.node.url)
+	if dialErr != nil {
+		return nil, fmt.Errorf("connection lost, %v, reconnect failed, %v", err, dialErr)
+	}
+	w.conn, w.db = conn, 0
+	for _, e := range entries[done:] {
+		e.attempts++
+		if e.attempts > w.opt.Retries {
+			e.errMsg = err.Error()
+			w.report(e)
+			continue
+		}
+		retry = append(retry, e)
+	}
+	return retry, nil
+}
+
+// redirect sends commands of entry to the node given by MOVED or ASK, until it is imported or failed
+func (w *importWorker) redirect(e *importEntry) {
+	retried := false // whether the key may be written partially by previous commands
+	for hops := 0; hops < maxRedirects; hops++ {
+		ask, slot, addr, ok := parseRedirect(e.errMsg)
+		if !ok {
+			break
+		}
+		if strings.HasPrefix(addr, ":") {
+			// unknown endpoint means host of the node we are connected to
+			host, _, _ := net.SplitHostPort(w.node.addr)
+			addr = net.JoinHostPort(host, addr[1:])
+		}
+		if !ask {
+			w.moved(slot, addr)
+		}
+		conn, err := w.redirectConn(addr)
+		if err != nil {
+			e.errMsg = err.Error()
+			break
+		}
+		redirectMsg := e.errMsg
+		cmds := e.cmds
+		if retried {
+			cmds = e.retryCmds()
+		}
+		e.errMsg, err = w.sendAll(conn, cmds, ask)
+		if err != nil {
+			_ = conn.Close()
+			delete(w.redirectConns, addr)
+			e.errMsg = err.Error()
+			break
+		}
+		if e.errMsg == "" {
+			w.succeed(addr)
+			return
+		}
+		if isTransientError(e.errMsg) && e.attempts <= w.opt.Retries {
+			// try the same node again later
+			time.Sleep(time.Duration(e.attempts) * 100 * time.Millisecond)
+			e.attempts++
+			e.errMsg = redirectMsg
+			retried = true
+			hops--
+		}
+	}
+	w.report(e)
+}
+
+func (w *importWorker) redirectConn(addr string) (*redisConn, error) {
+	if conn := w.redirectConns[addr]; conn != nil {
+		return conn, nil
+	}
+	u, err := nodeURL(w.node.url, addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := dialRedis(u)
+	if err != nil {
+		return nil, err
+	}
+	w.redirectConns[addr] = conn
+	return conn, nil
+}
+
+// sendAll sends commands in pipeline and returns the first error reply, each command is preceded by ASKING if ask is set
+func (w *importWorker) sendAll(conn *redisConn, cmds []CmdLine, ask bool) (string, error) {
+	if ask {
+		asking := CmdLine{[]byte("ASKING")}
+		withAsking := make([]CmdLine, 0, len(cmds)*2)
+		for _, cmd := range cmds {
+			withAsking = append(withAsking, asking, cmd)
+		}
+		cmds = withAsking
+	}
+	_, err := conn.conn.Write(CmdLinesToResp(cmds))
+	if err != nil {
+		return "", err
+	}
+	var errMsg string
+	for range cmds {
+		msg, err := readReply(conn.reader)
+		if err != nil {
+			return "", err
+		}
+		if errMsg == "" {
+			errMsg = msg
+		}
+	}
+	w.countCommands(len(cmds))
+	return errMsg, nil
+}
+
+func (w *importWorker) countCommands(n int) {
+	w.mu.Lock()
+	w.result.Commands += n
+	w.mu.Unlock()
+}
+
+func (w *importWorker) succeed(addr string) {
+	w.mu.Lock()
+	w.result.Keys++
+	w.result.Nodes[addr]++
+	w.mu.Unlock()
+}
+
+func (w *importWorker) report(e *importEntry) {
+	w.mu.Lock()
+	w.result.Failures = append(w.result.Failures, &ImportFailure{
+		DB:  e.object.GetDBIndex(),
+		Key: e.object.GetKey(),
+		Err: e.errMsg,
+	})
+	w.mu.Unlock()
+}
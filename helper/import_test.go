@@ -0,0 +1,318 @@
+package helper
+
+import (
+	"bufio"
+	"github.com/hdt3213/rdb/model"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type objectsDecoder []model.RedisObject
+
+func (d objectsDecoder) Parse(cb func(object model.RedisObject) bool) error {
+	for _, object := range d {
+		if !cb(object) {
+			break
+		}
+	}
+	return nil
+}
+
+// fakeRedis records write commands by database and key, replies of keys could be injected
+type fakeRedis struct {
+	mu        sync.Mutex
+	databases int
+	data      map[string][]string // "db:key" -> commands
+	errors    map[string][]string // key -> error replies of the following writes, "close" closes connection, "" succeeds
+	cluster   string              // reply of CLUSTER SLOTS
+	redirects map[int]string      // slot -> MOVED or ASK error reply
+	importing map[int]bool        // slots accepting keys only after ASKING
+}
+
+func (f *fakeRedis) serve(conn net.Conn) {
+	defer func() {
+		_ = conn.Close()
+	}()
+	reader := bufio.NewReader(conn)
+	db := 0
+	asking := false
+	for {
+		args, _, err := readCommand(reader)
+		if err != nil {
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+		name := strings.ToUpper(string(args[0]))
+		reply := "+OK\r\n"
+		f.mu.Lock()
+		switch {
+		case name == "CLUSTER":
+			reply = f.cluster
+		case name == "ASKING":
+			asking = true
+		case name == "SELECT":
+			i, _ := strconv.Atoi(string(args[1]))
+			if i >= f.databases {
+				reply = "-ERR DB index is out of range\r\n"
+			} else {
+				db = i
+			}
+		case len(args) > 1:
+			key := string(args[1])
+			slot := HashSlot(key)
+			if r := f.redirects[slot]; r != "" {
+				reply = "-" + r + "\r\n"
+				break
+			}
+			if f.importing[slot] && !asking {
+				reply = "-ERR ASKING is required\r\n"
+				break
+			}
+			asking = false
+			if errs := f.errors[key]; len(errs) > 0 && name != "DEL" {
+				f.errors[key] = errs[1:]
+				if errs[0] == "close" {
+					f.mu.Unlock()
+					return
+				}
+				if errs[0] != "" {
+					reply = "-" + errs[0] + "\r\n"
+					break
+				}
+			}
+			id := strconv.Itoa(db) + ":" + key
+			if name == "DEL" {
+				delete(f.data, id)
+			} else {
+				f.data[id] = append(f.data[id], name)
+			}
+		}
+		f.mu.Unlock()
+		_, err = conn.Write([]byte(reply))
+		if err != nil {
+			return
+		}
+	}
+}
+
+func TestImportObjects(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer func() {
+		_ = listener.Close()
+	}()
+	server := &fakeRedis{
+		databases: 2,
+		data:      make(map[string][]string),
+		errors: map[string][]string{
+			"loading": {"LOADING Redis is loading the dataset in memory"},
+			"closed":  {"close"},
+			"wrong":   {"WRONGTYPE Operation against a key holding the wrong kind of value"},
+		},
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(conn)
+		}
+	}()
+	newString := func(db int, key string) model.RedisObject {
+		return &model.StringObject{
+			BaseObject: &model.BaseObject{DB: db, Key: key, Type: model.StringType},
+			Value:      []byte("v"),
+		}
+	}
+	dec := objectsDecoder{
+		newString(0, "a"),
+		&model.ListObject{
+			BaseObject: &model.BaseObject{Key: "list", Type: model.ListType},
+			Values:     [][]byte{[]byte("1"), []byte("2"), []byte("3")},
+		},
+		newString(0, "loading"),
+		newString(0, "closed"),
+		newString(0, "wrong"),
+		newString(1, "b"),
+		newString(5, "c"),
+		newString(1, "d"),
+	}
+	result, err := importObjects(dec, "redis://"+listener.Addr().String(),
+		[]interface{}{WithImportOption(1, 3, 0, 2), WithBatchOption(1, 0)})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if result.Keys != 6 {
+		t.Errorf("expect 6 keys imported, actual %d", result.Keys)
+	}
+	var failures []string
+	for _, f := range result.Failures {
+		failures = append(failures, strconv.Itoa(f.DB)+":"+f.Key)
+	}
+	if strings.Join(failures, ",") != "0:wrong,5:c" {
+		t.Errorf("wrong failures: %v", failures)
+	}
+	expect := map[string]string{
+		"0:a":       "SET",
+		"0:list":    "RPUSH,RPUSH,RPUSH",
+		"0:loading": "SET",
+		"0:closed":  "SET",
+		"1:b":       "SET",
+		"1:d":       "SET",
+	}
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if len(server.data) != len(expect) {
+		t.Errorf("wrong keys: %v", server.data)
+	}
+	for id, cmds := range expect {
+		if strings.Join(server.data[id], ",") != cmds {
+			t.Errorf("wrong commands of %s: %v", id, server.data[id])
+		}
+	}
+
+	server.mu.Unlock()
+
+	// retrying is disabled by 0
+	server.mu.Lock()
+	server.errors["loading"] = []string{"LOADING Redis is loading the dataset in memory"}
+	server.mu.Unlock()
+	result, err = importObjects(objectsDecoder{newString(0, "loading")}, "redis://"+listener.Addr().String(),
+		[]interface{}{WithImportOption(1, 3, 0, 0)})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if result.Keys != 0 || len(result.Failures) != 1 || result.Commands != 1 {
+		t.Errorf("expect a failure without retrying, actual %d keys, %d commands", result.Keys, result.Commands)
+	}
+	server.mu.Lock()
+
+	_, err = importObjects(dec, "redis://"+listener.Addr().String()+"x", nil)
+	if err == nil {
+		t.Error("expect error of wrong url")
+	}
+}
+
+func TestImportCluster(t *testing.T) {
+	var addrs []string
+	var servers []*fakeRedis
+	for i := 0; i < 2; i++ {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer func() {
+			_ = listener.Close()
+		}()
+		server := &fakeRedis{
+			databases: 1,
+			data:      make(map[string][]string),
+			errors:    make(map[string][]string),
+			redirects: make(map[int]string),
+			importing: make(map[int]bool),
+		}
+		go func() {
+			for {
+				conn, err := listener.Accept()
+				if err != nil {
+					return
+				}
+				go server.serve(conn)
+			}
+		}()
+		addrs = append(addrs, listener.Addr().String())
+		servers = append(servers, server)
+	}
+	_, port, _ := net.SplitHostPort(addrs[1])
+	// empty host of the second node means host of the node replying CLUSTER SLOTS
+	slots := "*2\r\n" +
+		"*3\r\n:0\r\n:8191\r\n*2\r\n$9\r\n127.0.0.1\r\n:" + strings.Split(addrs[0], ":")[1] + "\r\n" +
+		"*3\r\n:8192\r\n:16383\r\n*2\r\n$0\r\n\r\n:" + port + "\r\n"
+	servers[0].cluster = slots
+	servers[1].cluster = "-ERR This instance has cluster support disabled\r\n"
+	owner := func(key string) int {
+		if HashSlot(key) < 8192 {
+			return 0
+		}
+		return 1
+	}
+	// "moved" is served by the other node, "ask" is being migrated to the other node
+	for _, key := range []string{"moved", "ask"} {
+		slot := HashSlot(key)
+		from, to := owner(key), 1-owner(key)
+		kind := "MOVED"
+		if key == "ask" {
+			kind = "ASK"
+			servers[to].importing[slot] = true
+		}
+		servers[from].redirects[slot] = kind + " " + strconv.Itoa(slot) + " " + addrs[to]
+	}
+	// "moved" is written partially by the first try, so it is deleted before retrying
+	servers[1-owner("moved")].errors["moved"] = []string{"", "LOADING Redis is loading the dataset in memory"}
+	var dec objectsDecoder
+	expect := []map[string]bool{{}, {}}
+	for i := 0; i < 20; i++ {
+		key := "key:" + strconv.Itoa(i)
+		dec = append(dec, &model.StringObject{
+			BaseObject: &model.BaseObject{Key: key, Type: model.StringType},
+			Value:      []byte("v"),
+		})
+		expect[owner(key)]["0:"+key] = true
+	}
+	for _, key := range []string{"moved", "ask"} {
+		dec = append(dec, &model.ListObject{
+			BaseObject: &model.BaseObject{Key: key, Type: model.ListType},
+			Values:     [][]byte{[]byte("1"), []byte("2")},
+		})
+		expect[1-owner(key)]["0:"+key] = true
+	}
+	dec = append(dec, &model.StringObject{
+		BaseObject: &model.BaseObject{DB: 1, Key: "db1", Type: model.StringType},
+		Value:      []byte("v"),
+	})
+	result, err := importObjects(dec, "redis://"+addrs[0], []interface{}{
+		WithClusterOption(), WithImportOption(2, 5, 0, -1), WithBatchOption(1, 0),
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if result.Keys != 22 || len(result.Failures) != 1 || result.Failures[0].Key != "db1" {
+		t.Errorf("wrong result: %d keys, %d failures", result.Keys, len(result.Failures))
+	}
+	for i, server := range servers {
+		server.mu.Lock()
+		if len(server.data) != len(expect[i]) {
+			t.Errorf("wrong keys of node %d: %v", i, server.data)
+		}
+		for id := range expect[i] {
+			if len(server.data[id]) == 0 {
+				t.Errorf("missing %s in node %d", id, i)
+			}
+		}
+		if cmds := server.data["0:moved"]; cmds != nil && strings.Join(cmds, ",") != "RPUSH,RPUSH" {
+			t.Errorf("wrong commands of moved: %v", cmds)
+		}
+		if result.Nodes[addrs[i]] != len(expect[i]) {
+			t.Errorf("wrong key count of node %d: %d", i, result.Nodes[addrs[i]])
+		}
+		server.mu.Unlock()
+	}
+
+	_, err = importObjects(dec, "redis://"+addrs[1], []interface{}{WithClusterOption()})
+	if err == nil {
+		t.Error("expect error of cluster slots")
+	}
+}
@@ -7,11 +7,12 @@ import (
 	"github.com/hdt3213/rdb/bytefmt"
 	"github.com/hdt3213/rdb/core"
 	"github.com/hdt3213/rdb/model"
-	"os"
+	"io"
 	"strconv"
 )
 
-// MemoryProfile read rdb file and analysis memory usage then write result to csv file
+// MemoryProfile read rdb file and analysis memory usage then write result to csv file, csv file is compressed if
+// it is named like *.csv.gz
 func MemoryProfile(rdbFilename string, csvFilename string, options ...interface{}) error {
 	if rdbFilename == "" {
 		return errors.New("src file path is required")
@@ -19,44 +20,56 @@ func MemoryProfile(rdbFilename string, csvFilename string, options ...interface{
 	if csvFilename == "" {
 		return errors.New("output file path is required")
 	}
-	rdbFile, err := os.Open(rdbFilename)
+	dec, closeInput, err := openDecoder(rdbFilename)
 	if err != nil {
-		return fmt.Errorf("open rdb %s failed, %v", rdbFilename, err)
+		return err
 	}
-	defer func() {
-		_ = rdbFile.Close()
-	}()
-	csvFile, err := os.Create(csvFilename)
+	defer closeInput()
+	csvFile, err := CreateOutput(csvFilename)
 	if err != nil {
 		return fmt.Errorf("create json %s failed, %v", csvFilename, err)
 	}
 	defer func() {
 		_ = csvFile.Close()
 	}()
+	err = writeMemoryProfile(csvFile, dec, options)
+	if err != nil {
+		return err
+	}
+	return csvFile.Close()
+}
 
-	var regexOpt RegexOption
-	for _, opt := range options {
-		switch o := opt.(type) {
-		case RegexOption:
-			regexOpt = o
-		}
+// MemoryProfileFromReader reads rdb or aof from input and writes memory report in csv to output.
+// The invoker owns input and output
+func MemoryProfileFromReader(input io.Reader, output io.Writer, options ...interface{}) error {
+	dec, closeInput, err := newReaderDecoder(input)
+	if err != nil {
+		return err
 	}
-	var dec decoder = core.NewDecoder(rdbFile)
-	if regexOpt != nil {
-		dec, err = regexWrapper(dec, *regexOpt)
-		if err != nil {
-			return err
+	defer closeInput()
+	return writeMemoryProfile(output, dec, options)
+}
+
+func writeMemoryProfile(writer io.Writer, dec decoder, options []interface{}) error {
+	if d, ok := dec.(*core.Decoder); ok {
+		// report needs no value, and keys are dropped after callback unless they are kept by reservoir sampling
+		d.WithSizeOnly()
+		if !hasReservoirOption(options) {
+			d.WithBufferReuse()
 		}
 	}
-
-	_, err = csvFile.WriteString("database,key,type,size,size_readable,element_count\n")
+	dec, err := wrapDecoder(dec, options...)
+	if err != nil {
+		return err
+	}
+	_, err = writer.Write([]byte("database,key,type,size,size_readable,element_count\n"))
 	if err != nil {
 		return fmt.Errorf("write csv failed: %v", err)
 	}
-	csvWriter := csv.NewWriter(csvFile)
-	defer csvWriter.Flush()
-	return dec.Parse(func(object model.RedisObject) bool {
-		err = csvWriter.Write([]string{
+	csvWriter := csv.NewWriter(writer)
+	var writeErr error
+	err = dec.Parse(func(object model.RedisObject) bool {
+		writeErr = csvWriter.Write([]string{
 			strconv.Itoa(object.GetDBIndex()),
 			object.GetKey(),
 			object.GetType(),
@@ -64,10 +77,18 @@ func MemoryProfile(rdbFilename string, csvFilename string, options ...interface{
 			bytefmt.FormatSize(uint64(object.GetSize())),
 			strconv.Itoa(object.GetElemCount()),
 		})
-		if err != nil {
-			fmt.Printf("csv write failed: %v", err)
-			return false
-		}
-		return true
+		return writeErr == nil
 	})
+	if err != nil {
+		return err
+	}
+	if writeErr != nil {
+		return fmt.Errorf("write csv failed: %v", writeErr)
+	}
+	csvWriter.Flush()
+	err = csvWriter.Error()
+	if err != nil {
+		return fmt.Errorf("write csv failed: %v", err)
+	}
+	return nil
 }
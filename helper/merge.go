@@ -0,0 +1,340 @@
+package helper
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/hdt3213/rdb/core"
+	"github.com/hdt3213/rdb/model"
+	"os"
+	"sort"
+	"time"
+)
+
+const (
+	// FirstWins keeps the key from the first rdb file containing it
+	FirstWins = "first-wins"
+	// LastWins keeps the key from the last rdb file containing it
+	LastWins = "last-wins"
+	// NewestExpirationWins keeps the key which expires latest, keys without expiration never expire
+	NewestExpirationWins = "newest-expiration"
+	// ErrorOnConflict stops merging once a key exists in more than one rdb file
+	ErrorOnConflict = "error"
+)
+
+// ConflictPolicyOption decides which one to keep when a key exists in more than one rdb file
+type ConflictPolicyOption string
+
+// WithConflictPolicyOption creates a ConflictPolicyOption, default policy is FirstWins
+func WithConflictPolicyOption(policy string) ConflictPolicyOption {
+	return ConflictPolicyOption(policy)
+}
+
+// MergeConflict describes a key existing in more than one rdb file
+type MergeConflict struct {
+	DB     int
+	Key    string
+	Files  []string // rdb files containing the key, in the order of input
+	Winner string   // rdb file whose value is kept
+}
+
+// mergeEntry records which rdb file provides the value of a key
+type mergeEntry struct {
+	file       int
+	expiration *time.Time
+	conflict   *MergeConflict
+}
+
+// expiresLater returns whether a expires later than b, nil means never expire
+func expiresLater(a, b *time.Time) bool {
+	if a == nil {
+		return b != nil
+	}
+	return b != nil && a.After(*b)
+}
+
+// parseRDBFile decodes rdb file with special opcodes and filter options, and returns rdb version of the file
+func parseRDBFile(filename string, options []interface{}, cb func(object model.RedisObject) bool) (int, error) {
+	rdbFile, closeInput, err := openInput(filename)
+	if err != nil {
+		return 0, fmt.Errorf("open rdb %s failed, %v", filename, err)
+	}
+	defer closeInput()
+	coreDec := core.NewDecoder(rdbFile).WithSpecialOpCode()
+	dec, err := wrapDecoder(coreDec, options...)
+	if err != nil {
+		return 0, err
+	}
+	err = dec.Parse(cb)
+	if err != nil {
+		return 0, fmt.Errorf("parse rdb %s failed, %v", filename, err)
+	}
+	return coreDec.Version(), nil
+}
+
+// mergeSource reads keys of a rdb file for the second pass of MergeRDB. Redis writes keys database by database in
+// ascending order, so every file is read only once while output is written database by database
+type mergeSource struct {
+	filename string
+	objects  chan model.RedisObject
+	next     model.RedisObject // object read but not consumed yet
+	done     chan error
+	stop     chan struct{}
+	closed   bool
+}
+
+func openMergeSource(filename string, options []interface{}) *mergeSource {
+	src := &mergeSource{
+		filename: filename,
+		objects:  make(chan model.RedisObject, 64),
+		done:     make(chan error, 1),
+		stop:     make(chan struct{}),
+	}
+	go func() {
+		defer close(src.objects)
+		_, err := parseRDBFile(filename, options, func(object model.RedisObject) bool {
+			if isSpecialObject(object) {
+				return true
+			}
+			select {
+			case src.objects <- object:
+				return true
+			case <-src.stop:
+				return false
+			}
+		})
+		src.done <- err
+	}()
+	return src
+}
+
+// peek returns the next key without consuming it, nil means the end of file
+func (src *mergeSource) peek() model.RedisObject {
+	if src.next == nil {
+		src.next = <-src.objects
+	}
+	return src.next
+}
+
+// close stops reading and returns error of parsing
+func (src *mergeSource) close() error {
+	if src.closed {
+		return nil
+	}
+	src.closed = true
+	close(src.stop)
+	return <-src.done
+}
+
+// MergeRDB merges keyspaces of rdb files into one rdb file database by database, and returns keys existing in more
+// than one file. Aux fields are taken from the first file, functions are taken from all files with duplicates removed,
+// module aux data is taken from the first file providing it. Output is in rdb version of the newest file.
+// Filter options are also accepted.
+func MergeRDB(rdbFilenames []string, outputFilename string, options ...interface{}) ([]*MergeConflict, error) {
+	if len(rdbFilenames) == 0 {
+		return nil, errors.New("src file path is required")
+	}
+	if outputFilename == "" {
+		return nil, errors.New("output file path is required")
+	}
+	policy := FirstWins
+	for _, opt := range options {
+		if o, ok := opt.(ConflictPolicyOption); ok {
+			policy = string(o)
+		}
+	}
+	switch policy {
+	case FirstWins, LastWins, NewestExpirationWins, ErrorOnConflict:
+	default:
+		return nil, fmt.Errorf("unknown conflict policy: %s", policy)
+	}
+	options = withNow(options) // both passes must keep the same keys
+
+	// first pass: find out which file provides each key
+	var auxList []*model.AuxObject
+	var functions []string
+	var moduleAuxList []*model.ModuleAuxObject
+	functionSet := make(map[string]struct{})
+	moduleAuxSet := make(map[string]struct{})
+	keyspaces := make(map[int]map[string]*mergeEntry)
+	var conflicts []*MergeConflict
+	var conflictErr error
+	version := 0 // output keeps encodings of the newest source
+	for i, filename := range rdbFilenames {
+		fileVersion, err := parseRDBFile(filename, options, func(object model.RedisObject) bool {
+			switch o := object.(type) {
+			case *model.AuxObject:
+				if i == 0 {
+					auxList = append(auxList, o)
+				}
+				return true
+			case *model.DBSizeObject:
+				return true
+			case *model.FunctionsObject:
+				if _, ok := functionSet[o.Code]; !ok {
+					functionSet[o.Code] = struct{}{}
+					functions = append(functions, o.Code)
+				}
+				return true
+			case *model.ModuleAuxObject:
+				id := fmt.Sprintf("%d %d", o.ModuleID, o.When)
+				if _, ok := moduleAuxSet[id]; !ok {
+					moduleAuxSet[id] = struct{}{}
+					moduleAuxList = append(moduleAuxList, o)
+				}
+				return true
+			}
+			keyspace := keyspaces[object.GetDBIndex()]
+			if keyspace == nil {
+				keyspace = make(map[string]*mergeEntry)
+				keyspaces[object.GetDBIndex()] = keyspace
+			}
+			entry := keyspace[object.GetKey()]
+			if entry == nil {
+				keyspace[object.GetKey()] = &mergeEntry{
+					file:       i,
+					expiration: object.GetExpiration(),
+				}
+				return true
+			}
+			if entry.conflict == nil {
+				entry.conflict = &MergeConflict{
+					DB:    object.GetDBIndex(),
+					Key:   object.GetKey(),
+					Files: []string{rdbFilenames[entry.file]},
+				}
+				conflicts = append(conflicts, entry.conflict)
+			}
+			entry.conflict.Files = append(entry.conflict.Files, filename)
+			switch policy {
+			case ErrorOnConflict:
+				conflictErr = fmt.Errorf("key %s of db %d exists in both %s and %s",
+					object.GetKey(), object.GetDBIndex(), rdbFilenames[entry.file], filename)
+				return false
+			case LastWins:
+				entry.file = i
+				entry.expiration = object.GetExpiration()
+			case NewestExpirationWins:
+				if expiresLater(object.GetExpiration(), entry.expiration) {
+					entry.file = i
+					entry.expiration = object.GetExpiration()
+				}
+			}
+			return true
+		})
+		if err != nil {
+			return conflicts, err
+		}
+		if conflictErr != nil {
+			return conflicts, conflictErr
+		}
+		if fileVersion > version {
+			version = fileVersion
+		}
+	}
+	for _, keyspace := range keyspaces {
+		for _, entry := range keyspace {
+			if entry.conflict != nil {
+				entry.conflict.Winner = rdbFilenames[entry.file]
+			}
+		}
+	}
+
+	// second pass: read all files together and write kept keys database by database,
+	// so that each db header is written only once
+	outputFile, err := os.Create(outputFilename)
+	if err != nil {
+		return conflicts, fmt.Errorf("create output %s failed, %v", outputFilename, err)
+	}
+	defer func() {
+		_ = outputFile.Close()
+	}()
+	bufWriter := bufio.NewWriter(outputFile)
+	enc := core.NewEncoder(bufWriter).SetVersion(outputVersion(version))
+	err = enc.WriteHeader()
+	if err != nil {
+		return conflicts, err
+	}
+	for _, aux := range auxList {
+		err = enc.WriteAux(aux.Key, aux.Value)
+		if err != nil {
+			return conflicts, err
+		}
+	}
+	for _, code := range functions {
+		err = enc.WriteFunction(code)
+		if err != nil {
+			return conflicts, err
+		}
+	}
+	for _, aux := range moduleAuxList {
+		if aux.When == core.ModuleAuxAfterRDB {
+			continue
+		}
+		err = enc.WriteModuleAux(aux.ModuleID, aux.When, aux.Value)
+		if err != nil {
+			return conflicts, err
+		}
+	}
+	dbs := make([]int, 0, len(keyspaces))
+	for db := range keyspaces {
+		dbs = append(dbs, db)
+	}
+	sort.Ints(dbs)
+	sources := make([]*mergeSource, len(rdbFilenames))
+	for i, filename := range rdbFilenames {
+		sources[i] = openMergeSource(filename, options)
+	}
+	defer func() {
+		for _, src := range sources {
+			_ = src.close()
+		}
+	}()
+	writer := newRDBWriter(enc)
+	for _, db := range dbs {
+		keyspace := keyspaces[db]
+		size := &model.DBSizeObject{BaseObject: &model.BaseObject{DB: db}}
+		for _, entry := range keyspace {
+			size.KeyCount++
+			if entry.expiration != nil {
+				size.TTLCount++
+			}
+		}
+		writer.dbSizes[db] = size
+		for i, src := range sources {
+			for object := src.peek(); object != nil && object.GetDBIndex() <= db; object = src.peek() {
+				if object.GetDBIndex() < db {
+					return conflicts, fmt.Errorf("databases of %s are not in ascending order", src.filename)
+				}
+				src.next = nil
+				if entry := keyspace[object.GetKey()]; entry == nil || entry.file != i {
+					continue
+				}
+				err = writer.write(object)
+				if err != nil {
+					return conflicts, fmt.Errorf("write %s failed: %v", object.GetKey(), err)
+				}
+			}
+		}
+	}
+	for _, src := range sources {
+		err = src.close()
+		if err != nil {
+			return conflicts, err
+		}
+	}
+	for _, aux := range moduleAuxList {
+		if aux.When != core.ModuleAuxAfterRDB {
+			continue
+		}
+		err = enc.WriteModuleAux(aux.ModuleID, aux.When, aux.Value)
+		if err != nil {
+			return conflicts, err
+		}
+	}
+	err = enc.WriteEnd()
+	if err != nil {
+		return conflicts, err
+	}
+	return conflicts, bufWriter.Flush()
+}
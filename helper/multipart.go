@@ -0,0 +1,153 @@
+package helper
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/hdt3213/rdb/core"
+	"github.com/hdt3213/rdb/model"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// AOFFilenameOption sets appendfilename of redis, which is prefix of files in multi-part aof directory
+type AOFFilenameOption string
+
+// WithAOFFilenameOption creates an AOFFilenameOption, default value is appendonly.aof
+func WithAOFFilenameOption(name string) AOFFilenameOption {
+	return AOFFilenameOption(name)
+}
+
+// RESPBaseOption makes ToMultipartAOF write base file in RESP instead of rdb, like aof-use-rdb-preamble no
+type RESPBaseOption bool
+
+// WithRESPBaseOption creates a RESPBaseOption
+func WithRESPBaseOption() RESPBaseOption {
+	return true
+}
+
+const defaultAOFFilename = "appendonly.aof"
+
+// ToMultipartAOF converts rdb into aof directory of redis 7 (appenddirname), including a manifest, a base file and an
+// empty incr file, so that it could be put into data directory of a redis with appendonly enabled.
+// Base file is in rdb by default, RESPBaseOption writes it by commands like ToAOF, accepting the same options,
+// streams and module values are written by RESTORE then.
+// Filter options are also accepted.
+func ToMultipartAOF(rdbFilename string, dirname string, options ...interface{}) error {
+	if rdbFilename == "" {
+		return errors.New("src file path is required")
+	}
+	if dirname == "" {
+		return errors.New("output file path is required")
+	}
+	prefix := defaultAOFFilename
+	respBase := false
+	for _, opt := range options {
+		switch o := opt.(type) {
+		case AOFFilenameOption:
+			prefix = string(o)
+		case RESPBaseOption:
+			respBase = bool(o)
+		}
+	}
+	if prefix == "" || strings.ContainsAny(prefix, " \t\r\n/\\") {
+		return fmt.Errorf("illegal aof filename: %q", prefix)
+	}
+	err := checkRestoreOption(options)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(dirname, os.ModePerm)
+	if err != nil {
+		return fmt.Errorf("create aof directory %s failed, %v", dirname, err)
+	}
+	baseName := prefix + ".1.base.rdb"
+	if respBase {
+		baseName = prefix + ".1.base.aof"
+	}
+	incrName := prefix + ".1.incr.aof"
+	baseFile, err := os.Create(filepath.Join(dirname, baseName))
+	if err != nil {
+		return fmt.Errorf("create base file failed, %v", err)
+	}
+	defer func() {
+		_ = baseFile.Close()
+	}()
+	bufWriter := bufio.NewWriter(baseFile)
+	if respBase {
+		err = writeRESPBase(rdbFilename, bufWriter, options)
+	} else {
+		err = writeRDBBase(rdbFilename, bufWriter, options)
+	}
+	if err != nil {
+		return err
+	}
+	err = bufWriter.Flush()
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(filepath.Join(dirname, incrName), nil, 0644)
+	if err != nil {
+		return fmt.Errorf("create incr file failed, %v", err)
+	}
+	// write manifest at last, redis does not load a directory without manifest
+	manifest := fmt.Sprintf("file %s seq 1 type b\nfile %s seq 1 type i\n", baseName, incrName)
+	manifestName := filepath.Join(dirname, prefix+".manifest")
+	err = os.WriteFile(manifestName+".tmp", []byte(manifest), 0644)
+	if err != nil {
+		return fmt.Errorf("create manifest failed, %v", err)
+	}
+	return os.Rename(manifestName+".tmp", manifestName)
+}
+
+// writeRDBBase writes rdb base file, it is marked by aux field aof-base like redis does
+func writeRDBBase(rdbFilename string, writer io.Writer, options []interface{}) error {
+	enc := core.NewEncoder(writer)
+	err := enc.WriteHeader()
+	if err != nil {
+		return err
+	}
+	err = enc.WriteAux("aof-base", "1")
+	if err != nil {
+		return err
+	}
+	w := newRDBWriter(enc)
+	var writeErr error
+	_, err = parseRDBFile(rdbFilename, options, func(object model.RedisObject) bool {
+		if aux, ok := object.(*model.AuxObject); ok && aux.Key == "aof-base" {
+			return true
+		}
+		writeErr = w.write(object)
+		if writeErr != nil {
+			writeErr = fmt.Errorf("write %s failed: %v", object.GetKey(), writeErr)
+			return false
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	if writeErr != nil {
+		return writeErr
+	}
+	return enc.WriteEnd()
+}
+
+// writeRESPBase writes commands of objects including functions into base file. Streams and module values have no
+// plain commands, so they are written by RESTORE of rdb version 10, which redis 7.0 and later with multi-part aof load
+func writeRESPBase(rdbFilename string, writer io.Writer, options []interface{}) error {
+	rdbFile, closeInput, err := openInput(rdbFilename)
+	if err != nil {
+		return fmt.Errorf("open rdb %s failed, %v", rdbFilename, err)
+	}
+	defer closeInput()
+	var dec decoder = core.NewDecoder(rdbFile).WithSpecialOpCode()
+	dec, err = wrapDecoder(dec, options...)
+	if err != nil {
+		return err
+	}
+	options = append(options[:len(options):len(options)], restoreFallbackOption(WithRestoreOption(10, false)))
+	return writeAOF(writer, dec, options)
+}
@@ -0,0 +1,21 @@
+package helper
+
+import (
+	"runtime"
+)
+
+// ParallelOption decodes values of rdb in the given number of goroutines while one goroutine reads the file,
+// json of ToJsons is marshalled by them too if keys are not filtered or rewritten. Keys are still processed in file order
+type ParallelOption int
+
+// WithParallelOption creates a ParallelOption, workers <= 0 means runtime.NumCPU()
+func WithParallelOption(workers int) ParallelOption {
+	return ParallelOption(workers)
+}
+
+func (o ParallelOption) workers() int {
+	if o <= 0 {
+		return runtime.NumCPU()
+	}
+	return int(o)
+}
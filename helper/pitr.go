@@ -0,0 +1,115 @@
+package helper
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/hdt3213/rdb/core"
+	"github.com/hdt3213/rdb/model"
+	"os"
+	"time"
+)
+
+// PITRResult is result of PointInTimeRDB
+type PITRResult struct {
+	Commands      int       // number of aof commands replayed
+	LastTimestamp time.Time // last #TS annotation replayed, zero if aof has no annotation
+	Truncated     bool      // whether commands after until are found and skipped
+	Expired       int       // number of keys dropped since they have expired at until
+}
+
+// PointInTimeRDB loads rdb, replays commands of aof up to the first timestamp annotation (#TS, written by redis 7
+// with aof-timestamp-enabled) later than until, then writes the keyspace into a new rdb.
+// aofPath could be an aof file or a multi-part aof directory. If rdbFilename is empty, the rdb preamble or base file
+// of aof is loaded instead, otherwise they are ignored. Filter options are applied to the output.
+// Relative ttl in commands is counted from the last annotation, and keys expired at until are dropped.
+func PointInTimeRDB(rdbFilename string, aofPath string, outputFilename string, until time.Time,
+	options ...interface{}) (*PITRResult, error) {
+	if aofPath == "" {
+		return nil, errors.New("aof path is required")
+	}
+	if outputFilename == "" {
+		return nil, errors.New("output file path is required")
+	}
+	info, err := os.Stat(aofPath)
+	if err != nil {
+		return nil, fmt.Errorf("open aof %s failed, %v", aofPath, err)
+	}
+	ks := newAOFKeyspace()
+	ks.withSpecial = true
+	ks.until = &until
+	ks.skipped = skipUnknownReport(options)
+	if rdbFilename != "" {
+		err = ks.loadFile(rdbFilename)
+		if err != nil {
+			return nil, fmt.Errorf("load rdb %s failed, %v", rdbFilename, err)
+		}
+		ks.commands = 0
+		ks.skipRDB = true
+	}
+	if info.IsDir() {
+		err = ks.loadDir(aofPath)
+	} else {
+		err = ks.loadFile(aofPath)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var dec decoder = ks
+	dec, err = wrapDecoder(dec, options...)
+	if err != nil {
+		return nil, err
+	}
+	outputFile, err := os.Create(outputFilename)
+	if err != nil {
+		return nil, fmt.Errorf("create output %s failed, %v", outputFilename, err)
+	}
+	defer func() {
+		_ = outputFile.Close()
+	}()
+	bufWriter := bufio.NewWriter(outputFile)
+	enc := core.NewEncoder(bufWriter)
+	err = enc.WriteHeader()
+	if err != nil {
+		return nil, err
+	}
+	writer := newRDBWriter(enc)
+	var writeErr error
+	expired := 0
+	err = dec.Parse(func(object model.RedisObject) bool {
+		if aux, ok := object.(*model.AuxObject); ok && (aux.Key == "aof-base" || aux.Key == "aof-preamble") {
+			return true // output is not a part of aof
+		}
+		if expiration := object.GetExpiration(); expiration != nil && !expiration.After(until) {
+			expired++
+			return true
+		}
+		writeErr = writer.write(object)
+		if writeErr != nil {
+			writeErr = fmt.Errorf("write %s failed: %v", object.GetKey(), writeErr)
+			return false
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	if writeErr != nil {
+		return nil, writeErr
+	}
+	err = enc.WriteEnd()
+	if err != nil {
+		return nil, err
+	}
+	err = bufWriter.Flush()
+	if err != nil {
+		return nil, err
+	}
+	return &PITRResult{
+		Commands:      ks.commands,
+		LastTimestamp: ks.lastTime,
+		Truncated:     ks.stopped,
+		Expired:       expired,
+	}, nil
+}
@@ -0,0 +1,143 @@
+package helper
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/hdt3213/rdb/core"
+	"github.com/hdt3213/rdb/model"
+	"os"
+)
+
+// rdbWriter writes objects into rdb file, it writes db header before the first object of each db
+// so that databases without any kept key are omitted
+type rdbWriter struct {
+	enc *core.Encoder
+	// resize db hint set by callers knowing number of keys to write, hint of source rdb is dropped since keys may be
+	// filtered out. Databases without hint get zero counts, which only disables pre-sizing of redis
+	dbSizes map[int]*model.DBSizeObject
+	curDB   int
+}
+
+func newRDBWriter(enc *core.Encoder) *rdbWriter {
+	return &rdbWriter{
+		enc:     enc,
+		dbSizes: make(map[int]*model.DBSizeObject),
+		curDB:   -1,
+	}
+}
+
+// write writes metadata or key into rdb
+func (w *rdbWriter) write(object model.RedisObject) error {
+	switch o := object.(type) {
+	case *model.AuxObject:
+		return w.enc.WriteAux(o.Key, o.Value)
+	case *model.DBSizeObject:
+		return nil
+	case *model.FunctionsObject:
+		return w.enc.WriteFunction(o.Code)
+	case *model.ModuleAuxObject:
+		return w.enc.WriteModuleAux(o.ModuleID, o.When, o.Value)
+	}
+	if object.GetDBIndex() != w.curDB {
+		// database header is not written for objects refused by encoder
+		err := w.enc.CheckObject(object)
+		if err != nil {
+			return err
+		}
+		var keyCount, ttlCount uint64
+		if size := w.dbSizes[object.GetDBIndex()]; size != nil {
+			keyCount, ttlCount = size.KeyCount, size.TTLCount
+		}
+		err = w.enc.WriteDBHeader(uint(object.GetDBIndex()), keyCount, ttlCount)
+		if err != nil {
+			return err
+		}
+		w.curDB = object.GetDBIndex()
+	}
+	return w.enc.WriteObject(object)
+}
+
+// outputVersion returns rdb version of output keeping encodings of source rdb. Versions older than
+// core.MinTargetVersion could not be written, while their encodings are all known to it
+func outputVersion(sourceVersion int) int {
+	if sourceVersion < core.MinTargetVersion {
+		return core.MinTargetVersion
+	}
+	return sourceVersion
+}
+
+// FilterRDB read rdb file and write keys matching filter options into a new rdb file.
+// Expirations, aux fields, functions and encodings of source rdb are kept where possible.
+// If RewriteOption is given, it works as RewriteRDB
+func FilterRDB(rdbFilename string, outputFilename string, options ...interface{}) error {
+	if rdbFilename == "" {
+		return errors.New("src file path is required")
+	}
+	if outputFilename == "" {
+		return errors.New("output file path is required")
+	}
+	if len(getRewriteRules(options)) > 0 {
+		_, err := RewriteRDB(rdbFilename, outputFilename, options...)
+		return err
+	}
+	rdbFile, closeInput, err := openInput(rdbFilename)
+	if err != nil {
+		return fmt.Errorf("open rdb %s failed, %v", rdbFilename, err)
+	}
+	defer closeInput()
+	outputFile, err := os.Create(outputFilename)
+	if err != nil {
+		return fmt.Errorf("create output %s failed, %v", outputFilename, err)
+	}
+	defer func() {
+		_ = outputFile.Close()
+	}()
+
+	coreDec := core.NewDecoder(rdbFile).WithSpecialOpCode()
+	dec, err := wrapDecoder(coreDec, options...)
+	if err != nil {
+		return err
+	}
+	bufWriter := bufio.NewWriter(outputFile) // encoder writes lots of small pieces
+	enc := core.NewEncoder(bufWriter)
+	headerWritten := false
+	writeHeader := func() error {
+		// version of source rdb is known once its header has been parsed
+		headerWritten = true
+		return enc.SetVersion(outputVersion(coreDec.Version())).WriteHeader()
+	}
+	writer := newRDBWriter(enc)
+	var writeErr error
+	err = dec.Parse(func(object model.RedisObject) bool {
+		if !headerWritten {
+			writeErr = writeHeader()
+			if writeErr != nil {
+				return false
+			}
+		}
+		writeErr = writer.write(object)
+		if writeErr != nil {
+			writeErr = fmt.Errorf("write %s failed: %v", object.GetKey(), writeErr)
+			return false
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	if writeErr != nil {
+		return writeErr
+	}
+	if !headerWritten {
+		err = writeHeader()
+		if err != nil {
+			return err
+		}
+	}
+	err = enc.WriteEnd()
+	if err != nil {
+		return err
+	}
+	return bufWriter.Flush()
+}
@@ -0,0 +1,235 @@
+package helper
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const redisDialTimeout = 10 * time.Second
+
+// redisConn is a connection to redis sending commands in RESP
+type redisConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// dialRedis connects to redis with url like redis://[[user]:password@]host:port (rediss:// for tls),
+// and authenticates if password is given
+func dialRedis(redisURL string) (*redisConn, error) {
+	u, err := url.Parse(redisURL)
+	if err != nil {
+		return nil, fmt.Errorf("illegal redis url: %v", err)
+	}
+	if u.Scheme != "redis" && u.Scheme != "rediss" {
+		return nil, fmt.Errorf("illegal redis url: unsupported scheme %s", u.Scheme)
+	}
+	addr := redisAddr(u)
+	dialer := &net.Dialer{Timeout: redisDialTimeout}
+	var conn net.Conn
+	if u.Scheme == "rediss" {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, &tls.Config{ServerName: u.Hostname()})
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("connect %s failed, %v", addr, err)
+	}
+	c := &redisConn{
+		conn:   conn,
+		reader: bufio.NewReader(conn),
+	}
+	if u.User != nil {
+		password, ok := u.User.Password()
+		args := []string{"AUTH", u.User.Username()}
+		if ok {
+			args = []string{"AUTH", password}
+			if u.User.Username() != "" {
+				args = []string{"AUTH", u.User.Username(), password}
+			}
+		}
+		reply, err := c.call(args...)
+		if err == nil && strings.HasPrefix(reply, "-") {
+			err = errors.New(reply[1:])
+		}
+		if err != nil {
+			_ = conn.Close()
+			return nil, fmt.Errorf("auth failed, %v", err)
+		}
+	}
+	return c, nil
+}
+
+func (c *redisConn) send(args ...string) error {
+	cmdLine := make(CmdLine, len(args))
+	for i, arg := range args {
+		cmdLine[i] = []byte(arg)
+	}
+	_, err := c.conn.Write(CmdLinesToResp([]CmdLine{cmdLine}))
+	return err
+}
+
+// redisAddr returns host and port in url, port is 6379 by default
+func redisAddr(u *url.URL) string {
+	if u.Port() == "" {
+		return net.JoinHostPort(u.Hostname(), "6379")
+	}
+	return u.Host
+}
+
+// call sends a command and returns the first line of reply, newlines sent as keepalive are skipped
+func (c *redisConn) call(args ...string) (string, error) {
+	err := c.send(args...)
+	if err != nil {
+		return "", err
+	}
+	return readReplyLine(c.reader)
+}
+
+// do sends a command and returns the whole reply parsed by readValue, error reply is returned as redisError
+func (c *redisConn) do(args ...string) (interface{}, error) {
+	err := c.send(args...)
+	if err != nil {
+		return nil, err
+	}
+	value, err := readValue(c.reader)
+	if err != nil {
+		return nil, err
+	}
+	if e, ok := value.(redisError); ok {
+		return nil, e
+	}
+	return value, nil
+}
+
+func (c *redisConn) Close() error {
+	return c.conn.Close()
+}
+
+// readReplyLine reads a line of reply, newlines sent by redis as keepalive are skipped
+func readReplyLine(reader *bufio.Reader) (string, error) {
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return "", err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line != "" {
+			return line, nil
+		}
+	}
+}
+
+// readReply reads a reply and returns the first error message in it, including errors in arrays such as reply of EXEC.
+// Returned error is not nil if reply could not be read
+func readReply(reader *bufio.Reader) (string, error) {
+	line, err := readReplyLine(reader)
+	if err != nil {
+		return "", err
+	}
+	switch line[0] {
+	case '+', ':', '_', '#', ',', '(':
+		return "", nil
+	case '-':
+		return line[1:], nil
+	case '$', '=', '!':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return "", fmt.Errorf("illegal reply: %s", line)
+		}
+		if n < 0 {
+			return "", nil
+		}
+		data := make([]byte, n+2) // data and CRLF
+		_, err = io.ReadFull(reader, data)
+		if err != nil || line[0] != '!' {
+			return "", err
+		}
+		return string(data[:n]), nil // blob error of RESP3
+	case '*', '~', '>', '%':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return "", fmt.Errorf("illegal reply: %s", line)
+		}
+		if line[0] == '%' {
+			n *= 2
+		}
+		var msg string
+		for i := 0; i < n; i++ {
+			m, err := readReply(reader)
+			if err != nil {
+				return "", err
+			}
+			if msg == "" {
+				msg = m
+			}
+		}
+		return msg, nil
+	}
+	return "", fmt.Errorf("illegal reply: %s", line)
+}
+
+// redisError is an error reply of redis
+type redisError string
+
+func (e redisError) Error() string {
+	return string(e)
+}
+
+// readValue reads a reply as string, int64, []interface{} or nil, error replies are returned as redisError
+func readValue(reader *bufio.Reader) (interface{}, error) {
+	line, err := readReplyLine(reader)
+	if err != nil {
+		return nil, err
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return redisError(line[1:]), nil
+	case ':':
+		n, err := strconv.ParseInt(line[1:], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("illegal reply: %s", line)
+		}
+		return n, nil
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("illegal reply: %s", line)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		data := make([]byte, n+2) // data and CRLF
+		_, err = io.ReadFull(reader, data)
+		if err != nil {
+			return nil, err
+		}
+		return string(data[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("illegal reply: %s", line)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		values := make([]interface{}, n)
+		for i := range values {
+			values[i], err = readValue(reader)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return values, nil
+	}
+	return nil, fmt.Errorf("illegal reply: %s", line)
+}
@@ -10,29 +10,14 @@ type decoder interface {
 	Parse(cb func(object model.RedisObject) bool) error
 }
 
-type regexDecoder struct {
-	reg *regexp.Regexp
-	dec decoder
-}
-
-func (d *regexDecoder) Parse(cb func(object model.RedisObject) bool) error {
-	return d.dec.Parse(func(object model.RedisObject) bool {
-		if d.reg.MatchString(object.GetKey()) {
-			return cb(object)
-		}
-		return true
-	})
-}
-
-// regexWrapper returns
-func regexWrapper(d decoder, expr string) (*regexDecoder, error) {
+// makeRegexFilter returns a filter which only keeps keys matching expr
+func makeRegexFilter(expr string) (func(object model.RedisObject) bool, error) {
 	reg, err := regexp.Compile(expr)
 	if err != nil {
 		return nil, fmt.Errorf("illegal regex expression: %v", expr)
 	}
-	return &regexDecoder{
-		dec: d,
-		reg: reg,
+	return func(object model.RedisObject) bool {
+		return reg.MatchString(object.GetKey())
 	}, nil
 }
 
@@ -0,0 +1,168 @@
+package helper
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+const (
+	eofMarkPrefix = "EOF:"
+	eofMarkLen    = 40
+)
+
+// Snapshot is rdb transferred by redis master during full synchronization, it reads rdb without touching disk
+type Snapshot struct {
+	reader io.Reader
+	conn   net.Conn
+	ReplID string // replication id of master, empty if SYNC is used
+	Offset int64  // replication offset of snapshot, -1 if SYNC is used
+	Size   int64  // size of rdb, -1 for diskless transfer whose size is unknown
+}
+
+// Read reads rdb
+func (s *Snapshot) Read(p []byte) (int, error) {
+	return s.reader.Read(p)
+}
+
+// Close closes connection to master
+func (s *Snapshot) Close() error {
+	return s.conn.Close()
+}
+
+// FetchSnapshot connects to redis with url like redis://[[user]:password@]host:port (rediss:// for tls) as a
+// replica, then requests a full synchronization by PSYNC ? -1, falling back to SYNC for old redis.
+// Both rdb with length and diskless transfer with EOF mark are accepted. Snapshot must be closed
+func FetchSnapshot(redisURL string) (*Snapshot, error) {
+	c, err := dialRedis(redisURL)
+	if err != nil {
+		return nil, err
+	}
+	snapshot, err := requestSnapshot(c)
+	if err != nil {
+		_ = c.Close()
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+// requestSnapshot does handshake of replication, then returns snapshot positioned at the beginning of rdb
+func requestSnapshot(c *redisConn) (*Snapshot, error) {
+	// old redis does not know capabilities, error is ignored like redis does
+	_, err := c.call("REPLCONF", "capa", "eof", "capa", "psync2")
+	if err != nil {
+		return nil, err
+	}
+	snapshot := &Snapshot{
+		conn:   c.conn,
+		Offset: -1,
+	}
+	reply, err := c.call("PSYNC", "?", "-1")
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case strings.HasPrefix(reply, "+FULLRESYNC"):
+		fields := strings.Fields(reply)
+		if len(fields) == 3 {
+			snapshot.ReplID = fields[1]
+			snapshot.Offset, _ = strconv.ParseInt(fields[2], 10, 64)
+		}
+	case strings.HasPrefix(reply, "-NOMASTERLINK"), strings.HasPrefix(reply, "-LOADING"):
+		return nil, fmt.Errorf("master is not ready, %s", reply[1:])
+	case strings.HasPrefix(reply, "-"):
+		// PSYNC is not supported, payload of SYNC follows command directly
+		_, err = c.conn.Write(CmdLinesToResp([]CmdLine{{[]byte("SYNC")}}))
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unexpected reply of PSYNC: %s", reply)
+	}
+	header, err := readReplyLine(c.reader)
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasPrefix(header, "-") {
+		return nil, fmt.Errorf("sync failed, %s", header[1:])
+	}
+	if !strings.HasPrefix(header, "$") {
+		return nil, fmt.Errorf("unexpected header of rdb: %s", header)
+	}
+	if strings.HasPrefix(header[1:], eofMarkPrefix) {
+		mark := header[1+len(eofMarkPrefix):]
+		if len(mark) != eofMarkLen {
+			return nil, fmt.Errorf("illegal eof mark: %s", mark)
+		}
+		snapshot.Size = -1
+		snapshot.reader = &eofMarkReader{
+			reader: c.reader,
+			mark:   []byte(mark),
+		}
+		return snapshot, nil
+	}
+	size, err := strconv.ParseInt(header[1:], 10, 64)
+	if err != nil || size < 0 {
+		return nil, fmt.Errorf("illegal size of rdb: %s", header)
+	}
+	snapshot.Size = size
+	snapshot.reader = &sizedReader{
+		reader: io.LimitReader(c.reader, size),
+		remain: size,
+	}
+	return snapshot, nil
+}
+
+// sizedReader returns io.ErrUnexpectedEOF if connection is closed before size bytes are read
+type sizedReader struct {
+	reader io.Reader
+	remain int64
+}
+
+func (r *sizedReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.remain -= int64(n)
+	if err == io.EOF && r.remain > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// eofMarkReader reads rdb of diskless transfer, which ends with the mark given in header
+type eofMarkReader struct {
+	reader *bufio.Reader
+	mark   []byte
+	done   bool
+}
+
+func (r *eofMarkReader) Read(p []byte) (int, error) {
+	if r.done {
+		return 0, io.EOF
+	}
+	n := r.reader.Buffered()
+	if n <= len(r.mark) {
+		n = len(r.mark) + 1
+	}
+	buf, err := r.reader.Peek(n)
+	if i := bytes.Index(buf, r.mark); i == 0 {
+		r.done = true
+		_, _ = r.reader.Discard(len(r.mark))
+		return 0, io.EOF
+	} else if i > 0 {
+		buf = buf[:i]
+	} else if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, err
+	} else {
+		buf = buf[:len(buf)-len(r.mark)+1] // the rest may be beginning of mark
+	}
+	n = copy(p, buf)
+	_, _ = r.reader.Discard(n)
+	return n, nil
+}
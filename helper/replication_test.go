@@ -0,0 +1,175 @@
+package helper
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeMaster serves rdb to replicas, it accepts AUTH, REPLCONF, PSYNC and SYNC
+type fakeMaster struct {
+	listener net.Listener
+	rdb      []byte
+	password string
+	diskless bool // transfer rdb with EOF mark
+	noPSync  bool // refuse PSYNC like redis before 2.8
+	commands []string
+}
+
+func newFakeMaster(t *testing.T, master *fakeMaster) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	master.listener = listener
+	t.Cleanup(func() {
+		_ = listener.Close()
+	})
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go master.serve(conn)
+		}
+	}()
+	return "redis://" + listener.Addr().String()
+}
+
+func (m *fakeMaster) serve(conn net.Conn) {
+	defer func() {
+		_ = conn.Close()
+	}()
+	reader := bufio.NewReader(conn)
+	authed := m.password == ""
+	for {
+		args, _, err := readCommand(reader)
+		if err != nil {
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+		name := strings.ToUpper(string(args[0]))
+		m.commands = append(m.commands, name)
+		var reply string
+		switch {
+		case name == "AUTH":
+			if string(args[len(args)-1]) != m.password {
+				reply = "-WRONGPASS invalid password\r\n"
+				break
+			}
+			authed = true
+			reply = "+OK\r\n"
+		case !authed:
+			reply = "-NOAUTH Authentication required.\r\n"
+		case name == "REPLCONF":
+			reply = "+OK\r\n"
+		case name == "PSYNC" && m.noPSync:
+			reply = "-ERR unknown command 'PSYNC'\r\n"
+		case name == "PSYNC" || name == "SYNC":
+			if name == "PSYNC" {
+				reply = "+FULLRESYNC 8de1787ba490483314a4d30f1c628bc5025eb761 100\r\n"
+			}
+			reply += "\n\n" // keepalive
+			mark := strings.Repeat("a", 40)
+			if m.diskless {
+				reply += "$EOF:" + mark + "\r\n" + string(m.rdb) + mark
+			} else {
+				reply += fmt.Sprintf("$%d\r\n%s", len(m.rdb), m.rdb)
+			}
+			reply += "*1\r\n$4\r\nPING\r\n" // replication stream follows rdb
+		default:
+			reply = "-ERR unknown command\r\n"
+		}
+		_, err = conn.Write([]byte(reply))
+		if err != nil {
+			return
+		}
+	}
+}
+
+func TestFetchSnapshot(t *testing.T) {
+	rdb, err := os.ReadFile(filepath.Join("..", "cases", "memory.rdb"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	for _, master := range []*fakeMaster{
+		{rdb: rdb},
+		{rdb: rdb, diskless: true},
+		{rdb: rdb, noPSync: true, password: "secret"},
+	} {
+		url := newFakeMaster(t, master)
+		if master.password != "" {
+			url = strings.Replace(url, "//", "//:"+master.password+"@", 1)
+		}
+		snapshot, err := FetchSnapshot(url)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		data, err := io.ReadAll(snapshot)
+		_ = snapshot.Close()
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if !bytes.Equal(data, rdb) {
+			t.Errorf("wrong rdb, diskless: %v, psync: %v", master.diskless, !master.noPSync)
+		}
+		if master.noPSync {
+			if snapshot.Offset != -1 || strings.Join(master.commands, ",") != "AUTH,REPLCONF,PSYNC,SYNC" {
+				t.Errorf("wrong handshake: %v", master.commands)
+			}
+		} else if snapshot.Offset != 100 || snapshot.ReplID == "" {
+			t.Errorf("wrong replication offset: %d", snapshot.Offset)
+		}
+	}
+
+	url := newFakeMaster(t, &fakeMaster{rdb: rdb, password: "secret"})
+	_, err = FetchSnapshot(url)
+	if err == nil {
+		t.Error("expect error of auth")
+	}
+	_, err = FetchSnapshot(strings.Replace(url, "//", "//:wrong@", 1))
+	if err == nil || !strings.Contains(err.Error(), "WRONGPASS") {
+		t.Errorf("expect error of wrong password, actual %v", err)
+	}
+	_, err = FetchSnapshot("http://localhost")
+	if err == nil {
+		t.Error("expect error of scheme")
+	}
+}
+
+func TestEOFMarkReader(t *testing.T) {
+	mark := strings.Repeat("0123456789", 4)
+	data := strings.Repeat("x", 5000) + mark[:20] + "y"
+	reader := &eofMarkReader{
+		reader: bufio.NewReaderSize(strings.NewReader(data+mark+"rest"), 64),
+		mark:   []byte(mark),
+	}
+	actual, err := io.ReadAll(reader)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if string(actual) != data {
+		t.Error("wrong data")
+	}
+	reader = &eofMarkReader{
+		reader: bufio.NewReader(strings.NewReader(data)),
+		mark:   []byte(mark),
+	}
+	_, err = io.ReadAll(reader)
+	if err != io.ErrUnexpectedEOF {
+		t.Errorf("expect unexpected eof, actual %v", err)
+	}
+}
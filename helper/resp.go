@@ -2,8 +2,12 @@ package helper
 
 import (
 	"bytes"
+	"fmt"
+	"github.com/hdt3213/rdb/core"
 	"github.com/hdt3213/rdb/model"
+	"sort"
 	"strconv"
+	"time"
 )
 
 const crlf = "\r\n"
@@ -31,61 +35,132 @@ func stringToCmd(obj *model.StringObject) CmdLine {
 	cmdLine := make([][]byte, 3)
 	cmdLine[0] = setCmd
 	cmdLine[1] = []byte(obj.Key)
-	cmdLine[2] = obj.Value
+	cmdLine[2] = obj.Bytes()
 	return cmdLine
 }
 
+// BatchOption splits commands of large collections, so that each RPUSH, SADD, HSET or ZADD carries at most
+// Elements elements and about Bytes bytes of elements. Zero means unlimited, a command carries one element at least
+type BatchOption struct {
+	Elements int
+	Bytes    int
+}
+
+// WithBatchOption creates a BatchOption
+func WithBatchOption(elements int, bytes int) BatchOption {
+	return BatchOption{
+		Elements: elements,
+		Bytes:    bytes,
+	}
+}
+
+// TransactionOption wraps commands of each key in MULTI/EXEC, so that a partially loaded key is never visible.
+// Keys written by a single command are not wrapped
+type TransactionOption bool
+
+// WithTransactionOption creates a TransactionOption
+func WithTransactionOption() TransactionOption {
+	return true
+}
+
+// batchToCmd generates commands adding elements to key, each element has one or two args like member or field value
+func batchToCmd(cmd []byte, key string, elements [][][]byte, batch BatchOption) []CmdLine {
+	var cmdLines []CmdLine
+	var cmdLine CmdLine
+	count, size := 0, 0
+	for _, element := range elements {
+		elementSize := 0
+		for _, arg := range element {
+			elementSize += len(arg)
+		}
+		if cmdLine != nil && (batch.Elements > 0 && count >= batch.Elements ||
+			batch.Bytes > 0 && size+elementSize > batch.Bytes) {
+			cmdLines = append(cmdLines, cmdLine)
+			cmdLine = nil
+		}
+		if cmdLine == nil {
+			cmdLine = CmdLine{cmd, []byte(key)}
+			count, size = 0, 0
+		}
+		cmdLine = append(cmdLine, element...)
+		count++
+		size += elementSize
+	}
+	if cmdLine != nil {
+		cmdLines = append(cmdLines, cmdLine)
+	}
+	return cmdLines
+}
+
 var rPushAllCmd = []byte("RPUSH")
 
-func listToCmd(obj *model.ListObject) CmdLine {
-	cmdLine := make([][]byte, 2+obj.GetElemCount())
-	cmdLine[0] = rPushAllCmd
-	cmdLine[1] = []byte(obj.Key)
+func listToCmd(obj *model.ListObject, batch BatchOption) []CmdLine {
+	elements := make([][][]byte, len(obj.Values))
 	for i, val := range obj.Values {
-		cmdLine[2+i] = val
+		elements[i] = [][]byte{val}
 	}
-	return cmdLine
+	return batchToCmd(rPushAllCmd, obj.GetKey(), elements, batch)
 }
 
 var sAddCmd = []byte("SADD")
 
-func setToCmd(obj *model.SetObject) CmdLine {
-	cmdLine := make([][]byte, 2+obj.GetElemCount())
-	cmdLine[0] = sAddCmd
-	cmdLine[1] = []byte(obj.GetKey())
+func setToCmd(obj *model.SetObject, batch BatchOption) []CmdLine {
+	elements := make([][][]byte, len(obj.Members))
 	for i, val := range obj.Members {
-		cmdLine[2+i] = val
+		elements[i] = [][]byte{val}
 	}
-	return cmdLine
+	return batchToCmd(sAddCmd, obj.GetKey(), elements, batch)
 }
 
-var hMSetCmd = []byte("HMSET")
+var hSetCmd = []byte("HSET")
 
-func hashToCmd(obj *model.HashObject) CmdLine {
-	cmdLine := make([][]byte, 2+obj.GetElemCount()*2)
-	cmdLine[0] = hMSetCmd
-	cmdLine[1] = []byte(obj.GetKey())
-	i := 0
-	for field, val := range obj.Hash {
-		cmdLine[2+i*2] = []byte(field)
-		cmdLine[3+i*2] = val
-		i++
+// hashToCmd generates HSET commands, fields are sorted so that output is stable
+func hashToCmd(obj *model.HashObject, batch BatchOption) []CmdLine {
+	fields := make([]string, 0, len(obj.Hash))
+	for field := range obj.Hash {
+		fields = append(fields, field)
 	}
-	return cmdLine
+	sort.Strings(fields)
+	elements := make([][][]byte, len(fields))
+	for i, field := range fields {
+		elements[i] = [][]byte{[]byte(field), obj.Hash[field]}
+	}
+	return batchToCmd(hSetCmd, obj.GetKey(), elements, batch)
+}
+
+var (
+	hPExpireAtCmd = []byte("HPEXPIREAT")
+	fieldsBytes   = []byte("FIELDS")
+	oneBytes      = []byte("1")
+)
+
+// hashFieldExpireCmd generates HPEXPIREAT commands of redis 7.4 for fields with expiration, sorted by field
+func hashFieldExpireCmd(obj *model.HashObject) []CmdLine {
+	fields := make([]string, 0, len(obj.FieldExpirations))
+	for field := range obj.FieldExpirations {
+		if _, ok := obj.Hash[field]; ok {
+			fields = append(fields, field)
+		}
+	}
+	sort.Strings(fields)
+	cmdLines := make([]CmdLine, len(fields))
+	for i, field := range fields {
+		expireAt := obj.FieldExpirations[field].UnixNano() / 1e6
+		cmdLines[i] = CmdLine{hPExpireAtCmd, []byte(obj.GetKey()), []byte(strconv.FormatInt(expireAt, 10)),
+			fieldsBytes, oneBytes, []byte(field)}
+	}
+	return cmdLines
 }
 
 var zAddCmd = []byte("ZADD")
 
-func zSetToCmd(obj *model.ZSetObject) CmdLine {
-	cmdLine := make([][]byte, 2+obj.GetElemCount()*2)
-	cmdLine[0] = zAddCmd
-	cmdLine[1] = []byte(obj.GetKey())
+func zSetToCmd(obj *model.ZSetObject, batch BatchOption) []CmdLine {
+	elements := make([][][]byte, len(obj.Entries))
 	for i, e := range obj.Entries {
 		value := strconv.FormatFloat(e.Score, 'f', -1, 64)
-		cmdLine[2+i*2] = []byte(value)
-		cmdLine[3+i*2] = []byte(e.Member)
+		elements[i] = [][]byte{[]byte(value), []byte(e.Member)}
 	}
-	return cmdLine
+	return batchToCmd(zAddCmd, obj.GetKey(), elements, batch)
 }
 
 var pExpireAtBytes = []byte("PEXPIREAT")
@@ -103,10 +178,128 @@ func makeExpireCmd(obj model.RedisObject) CmdLine {
 	return args
 }
 
-// ObjectToCmd convert redis object to redis command line
-func ObjectToCmd(obj model.RedisObject) []CmdLine {
+// RestoreOption makes ObjectToCmd and ToAOF emit RESTORE commands carrying DUMP payloads instead of SET, RPUSH etc.,
+// so that large collections are restored by a compact command and their encodings, streams and module values are kept
+type RestoreOption struct {
+	Version int  // rdb version of payloads, redis refuses payloads of higher version than its own
+	Replace bool // overwrite existing keys
+}
+
+// WithRestoreOption creates a RestoreOption, version 9 payloads could be loaded by redis 5.0 and later
+func WithRestoreOption(version int, replace bool) RestoreOption {
+	return RestoreOption{
+		Version: version,
+		Replace: replace,
+	}
+}
+
+var (
+	multiCmd       = []byte("MULTI")
+	execCmd        = []byte("EXEC")
+	restoreCmd     = []byte("RESTORE")
+	replaceBytes   = []byte("REPLACE")
+	absTTLBytes    = []byte("ABSTTL")
+	idleTimeBytes  = []byte("IDLETIME")
+	freqBytes      = []byte("FREQ")
+	restoreZeroTTL = []byte("0")
+)
+
+// restoreFallbackOption makes ObjectToCmd emit RESTORE commands for objects without plain commands,
+// like streams and module values, other objects are converted as usual
+type restoreFallbackOption RestoreOption
+
+// hasPlainCmd returns whether ObjectToCmd could convert object to commands other than RESTORE
+func hasPlainCmd(obj model.RedisObject) bool {
+	return obj.GetType() != model.StreamType && obj.GetType() != model.ModuleType
+}
+
+// restoreToCmd returns RESTORE command of object, expired object returns nil if ABSTTL is unavailable.
+// ABSTTL, IDLETIME and FREQ are used since version 9 (redis 5.0), otherwise ttl is relative to now
+func restoreToCmd(obj model.RedisObject, opt RestoreOption) (CmdLine, error) {
+	payload, err := core.DumpObject(obj, opt.Version)
+	if err != nil {
+		return nil, err
+	}
+	modern := opt.Version >= 9
+	ttl := restoreZeroTTL
+	if expiration := obj.GetExpiration(); expiration != nil {
+		if modern {
+			ttl = []byte(strconv.FormatInt(expiration.UnixNano()/1e6, 10))
+		} else {
+			remain := time.Until(*expiration).Milliseconds()
+			if remain <= 0 {
+				return nil, nil
+			}
+			ttl = []byte(strconv.FormatInt(remain, 10))
+		}
+	}
+	cmdLine := CmdLine{restoreCmd, []byte(obj.GetKey()), ttl, payload}
+	if opt.Replace {
+		cmdLine = append(cmdLine, replaceBytes)
+	}
+	if !modern {
+		return cmdLine, nil
+	}
+	if obj.GetExpiration() != nil {
+		cmdLine = append(cmdLine, absTTLBytes)
+	}
+	holder, ok := obj.(model.BaseObjectHolder)
+	if !ok {
+		return cmdLine, nil
+	}
+	base := holder.GetBaseObject()
+	if base.Idle != nil {
+		cmdLine = append(cmdLine, idleTimeBytes, []byte(strconv.FormatUint(*base.Idle, 10)))
+	} else if base.Freq != nil {
+		cmdLine = append(cmdLine, freqBytes, []byte(strconv.Itoa(int(*base.Freq))))
+	}
+	return cmdLine, nil
+}
+
+// ObjectToCmd convert redis object to redis command line, options could be BatchOption and TransactionOption.
+// With RestoreOption, it returns RESTORE command unless the object could not be dumped in the rdb version.
+// Streams and module values which could not be dumped have no commands, use ToAOF or ImportRDB to get the error
+func ObjectToCmd(obj model.RedisObject, options ...interface{}) []CmdLine {
+	cmdLines, _ := objectToCmd(obj, options)
+	return cmdLines
+}
+
+// objectToCmd is ObjectToCmd which returns error if object has no plain commands and could not be dumped either
+func objectToCmd(obj model.RedisObject, options []interface{}) ([]CmdLine, error) {
 	if obj == nil {
-		return nil
+		return nil, nil
+	}
+	for _, opt := range options {
+		o, ok := opt.(RestoreOption)
+		if fallback, isFallback := opt.(restoreFallbackOption); isFallback && !hasPlainCmd(obj) {
+			o, ok = RestoreOption(fallback), true
+		}
+		if ok {
+			if isSpecialObject(obj) {
+				break
+			}
+			cmdLine, err := restoreToCmd(obj, o)
+			if err != nil && !hasPlainCmd(obj) {
+				return nil, fmt.Errorf("cannot restore key %s: %v", obj.GetKey(), err)
+			}
+			if err != nil {
+				break // use plain commands
+			}
+			if cmdLine == nil {
+				return nil, nil
+			}
+			return []CmdLine{cmdLine}, nil
+		}
+	}
+	var batch BatchOption
+	transaction := false
+	for _, opt := range options {
+		switch o := opt.(type) {
+		case BatchOption:
+			batch = o
+		case TransactionOption:
+			transaction = bool(o)
+		}
 	}
 	cmdLines := make([]CmdLine, 0)
 	switch obj.GetType() {
@@ -115,21 +308,39 @@ func ObjectToCmd(obj model.RedisObject) []CmdLine {
 		cmdLines = append(cmdLines, stringToCmd(strObj))
 	case model.ListType:
 		listObj := obj.(*model.ListObject)
-		cmdLines = append(cmdLines, listToCmd(listObj))
+		cmdLines = append(cmdLines, listToCmd(listObj, batch)...)
 	case model.HashType:
 		hashObj := obj.(*model.HashObject)
-		cmdLines = append(cmdLines, hashToCmd(hashObj))
+		cmdLines = append(cmdLines, hashToCmd(hashObj, batch)...)
+		cmdLines = append(cmdLines, hashFieldExpireCmd(hashObj)...)
 	case model.SetType:
 		setObj := obj.(*model.SetObject)
-		cmdLines = append(cmdLines, setToCmd(setObj))
+		cmdLines = append(cmdLines, setToCmd(setObj, batch)...)
 	case model.ZSetType:
 		zsetObj := obj.(*model.ZSetObject)
-		cmdLines = append(cmdLines, zSetToCmd(zsetObj))
+		cmdLines = append(cmdLines, zSetToCmd(zsetObj, batch)...)
+	case model.FunctionsType:
+		functionsObj := obj.(*model.FunctionsObject)
+		cmdLines = append(cmdLines, CmdLine{functionCmd, loadBytes, []byte(functionsObj.Code)})
 	}
-	if obj.GetExpiration() != nil {
+	if len(cmdLines) > 0 && obj.GetExpiration() != nil {
 		cmdLines = append(cmdLines, makeExpireCmd(obj))
 	}
-	return cmdLines
+	if transaction && len(cmdLines) > 1 {
+		cmdLines = append([]CmdLine{{multiCmd}}, append(cmdLines, CmdLine{execCmd})...)
+	}
+	return cmdLines, nil
+}
+
+var (
+	selectCmd   = []byte("SELECT")
+	functionCmd = []byte("FUNCTION")
+	loadBytes   = []byte("LOAD")
+)
+
+// makeSelectCmd generates command line to switch database
+func makeSelectCmd(db int) CmdLine {
+	return CmdLine{selectCmd, []byte(strconv.Itoa(db))}
 }
 
 // CmdLinesToResp convert []CmdLine to RESP bytes
@@ -0,0 +1,88 @@
+package helper
+
+import (
+	"bytes"
+	"github.com/hdt3213/rdb/model"
+	"strings"
+	"testing"
+	"time"
+)
+
+func cmdLinesToString(cmdLines []CmdLine) string {
+	lines := make([]string, len(cmdLines))
+	for i, cmdLine := range cmdLines {
+		lines[i] = string(bytes.Join(cmdLine, []byte(" ")))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func TestObjectToCmd(t *testing.T) {
+	expiration := time.Unix(1700000000, 0)
+	list := &model.ListObject{
+		BaseObject: &model.BaseObject{Key: "l", Type: model.ListType, Expiration: &expiration},
+		Values:     [][]byte{[]byte("a"), []byte("bb"), []byte("ccc"), []byte("d"), []byte("e")},
+	}
+	hash := &model.HashObject{
+		BaseObject: &model.BaseObject{Key: "h", Type: model.HashType},
+		Hash:       map[string][]byte{"c": []byte("3"), "a": []byte("1"), "b": []byte("2")},
+	}
+	fieldTTL := &model.HashObject{
+		BaseObject:       &model.BaseObject{Key: "t", Type: model.HashType},
+		Hash:             map[string][]byte{"a": []byte("1"), "b": []byte("2")},
+		FieldExpirations: map[string]time.Time{"b": expiration},
+	}
+	str := &model.StringObject{
+		BaseObject: &model.BaseObject{Key: "s", Type: model.StringType},
+		Value:      []byte("v"),
+	}
+	cases := []struct {
+		object  model.RedisObject
+		options []interface{}
+		expect  string
+	}{
+		{hash, nil, "HSET h a 1 b 2 c 3"},
+		{hash, []interface{}{WithBatchOption(2, 0)}, "HSET h a 1 b 2\nHSET h c 3"},
+		{list, []interface{}{WithBatchOption(0, 3)}, "RPUSH l a bb\nRPUSH l ccc\nRPUSH l d e\nPEXPIREAT l 1700000000000"},
+		{list, []interface{}{WithBatchOption(3, 0), WithTransactionOption()},
+			"MULTI\nRPUSH l a bb ccc\nRPUSH l d e\nPEXPIREAT l 1700000000000\nEXEC"},
+		{str, []interface{}{WithTransactionOption()}, "SET s v"},
+		{fieldTTL, nil, "HSET t a 1 b 2\nHPEXPIREAT t 1700000000000 FIELDS 1 b"},
+	}
+	for _, c := range cases {
+		actual := cmdLinesToString(ObjectToCmd(c.object, c.options...))
+		if actual != c.expect {
+			t.Errorf("expect %q, actual %q", c.expect, actual)
+		}
+	}
+}
+
+func TestObjectToCmdRawInteger(t *testing.T) {
+	obj := &model.StringObject{
+		BaseObject: &model.BaseObject{Key: "i", Type: model.StringType},
+		IsInt:      true,
+		IntValue:   -42,
+	}
+	actual := cmdLinesToString(ObjectToCmd(obj))
+	if actual != "SET i -42" {
+		t.Errorf("wrong command: %s", actual)
+	}
+}
+
+func TestObjectToCmdRestoreError(t *testing.T) {
+	stream := &model.StreamObject{
+		BaseObject: &model.BaseObject{Key: "x", Type: model.StreamType},
+	}
+	// streams could not be dumped before version 9 and have no plain commands
+	_, err := objectToCmd(stream, []interface{}{WithRestoreOption(8, false)})
+	if err == nil {
+		t.Error("expect error of stream in version 8")
+	}
+	str := &model.StringObject{
+		BaseObject: &model.BaseObject{Key: "s", Type: model.StringType},
+		Value:      []byte("v"),
+	}
+	cmdLines, err := objectToCmd(str, []interface{}{WithRestoreOption(8, false)})
+	if err != nil || len(cmdLines) != 1 {
+		t.Errorf("expect RESTORE of string, actual %d commands, err %v", len(cmdLines), err)
+	}
+}
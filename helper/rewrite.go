@@ -0,0 +1,343 @@
+package helper
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/hdt3213/rdb/core"
+	"github.com/hdt3213/rdb/model"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// RewriteRule changes key or db of an object
+type RewriteRule struct {
+	desc  string
+	apply func(db int, key string) (int, string)
+}
+
+// String returns the rule in rules file format
+func (r *RewriteRule) String() string {
+	return r.desc
+}
+
+// AddPrefixRule adds prefix to every key
+func AddPrefixRule(prefix string) *RewriteRule {
+	return &RewriteRule{
+		desc: "add-prefix " + prefix,
+		apply: func(db int, key string) (int, string) {
+			return db, prefix + key
+		},
+	}
+}
+
+// StripPrefixRule removes prefix from keys starting with it
+func StripPrefixRule(prefix string) *RewriteRule {
+	return &RewriteRule{
+		desc: "strip-prefix " + prefix,
+		apply: func(db int, key string) (int, string) {
+			return db, strings.TrimPrefix(key, prefix)
+		},
+	}
+}
+
+// RegexReplaceRule replaces matches of expr in keys with replacement, which could refer capture groups like $1
+func RegexReplaceRule(expr string, replacement string) (*RewriteRule, error) {
+	pattern, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("illegal regex expression: %v", err)
+	}
+	return &RewriteRule{
+		desc: "replace " + expr + " " + replacement,
+		apply: func(db int, key string) (int, string) {
+			return db, pattern.ReplaceAllString(key, replacement)
+		},
+	}, nil
+}
+
+// MoveDBRule moves keys in db from to db to
+func MoveDBRule(from, to int) *RewriteRule {
+	return &RewriteRule{
+		desc: "move-db " + strconv.Itoa(from) + " " + strconv.Itoa(to),
+		apply: func(db int, key string) (int, string) {
+			if db == from {
+				return to, key
+			}
+			return db, key
+		},
+	}
+}
+
+// ParseRewriteRules reads rules file, each line is a rule and rules are applied in order:
+//
+//	add-prefix <prefix>
+//	strip-prefix <prefix>
+//	replace <regex> <replacement>
+//	move-db <from> <to>
+//
+// empty lines and lines starting with '#' are ignored
+func ParseRewriteRules(reader io.Reader) ([]*RewriteRule, error) {
+	var rules []*RewriteRule
+	scanner := bufio.NewScanner(reader)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		var rule *RewriteRule
+		var err error
+		switch fields[0] {
+		case "add-prefix", "strip-prefix":
+			if len(fields) != 2 {
+				return nil, fmt.Errorf("line %d: %s requires a prefix", lineNum, fields[0])
+			}
+			if fields[0] == "add-prefix" {
+				rule = AddPrefixRule(fields[1])
+			} else {
+				rule = StripPrefixRule(fields[1])
+			}
+		case "replace":
+			if len(fields) != 3 {
+				return nil, fmt.Errorf("line %d: replace requires regex and replacement", lineNum)
+			}
+			rule, err = RegexReplaceRule(fields[1], fields[2])
+		case "move-db":
+			if len(fields) != 3 {
+				return nil, fmt.Errorf("line %d: move-db requires source and target db", lineNum)
+			}
+			var from, to int
+			from, err = strconv.Atoi(fields[1])
+			if err == nil {
+				to, err = strconv.Atoi(fields[2])
+			}
+			if err == nil && (from < 0 || to < 0) {
+				err = errors.New("db index must not be negative")
+			}
+			rule = MoveDBRule(from, to)
+		default:
+			return nil, fmt.Errorf("line %d: unknown rule %s", lineNum, fields[0])
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNum, err)
+		}
+		rules = append(rules, rule)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// RewriteOption renames keys or moves them between databases by rules
+type RewriteOption []*RewriteRule
+
+// WithRewriteOption creates a RewriteOption
+func WithRewriteOption(rules ...*RewriteRule) RewriteOption {
+	return rules
+}
+
+func applyRewriteRules(rules []*RewriteRule, db int, key string) (int, string) {
+	for _, rule := range rules {
+		db, key = rule.apply(db, key)
+	}
+	return db, key
+}
+
+// rewriteDecoder applies rewrite rules to keys before passing them to callback
+type rewriteDecoder struct {
+	dec   decoder
+	rules []*RewriteRule
+}
+
+func (d *rewriteDecoder) Parse(cb func(object model.RedisObject) bool) error {
+	return d.dec.Parse(func(object model.RedisObject) bool {
+		if !isSpecialObject(object) {
+			base := baseObject(object)
+			base.DB, base.Key = applyRewriteRules(d.rules, base.DB, base.Key)
+		}
+		return cb(object)
+	})
+}
+
+func getRewriteRules(options []interface{}) []*RewriteRule {
+	var rules []*RewriteRule
+	for _, opt := range options {
+		if o, ok := opt.(RewriteOption); ok {
+			rules = append(rules, o...)
+		}
+	}
+	return rules
+}
+
+// RewriteChange describes a key changed by rewrite rules
+type RewriteChange struct {
+	SrcDB  int
+	SrcKey string
+	DB     int
+	Key    string
+}
+
+// RewriteCollision describes several source keys rewritten into the same key
+type RewriteCollision struct {
+	DB      int
+	Key     string
+	Sources []*RewriteChange // unchanged key is included too
+}
+
+// RewritePlan is result of applying rewrite rules to keys in rdb
+type RewritePlan struct {
+	Changes    []*RewriteChange
+	Collisions []*RewriteCollision
+	dbSizes    map[int]*model.DBSizeObject // key count of target databases
+	version    int                         // rdb version of source
+}
+
+// PlanRewrite applies rewrite rules to keys in rdb without writing anything, and reports changed keys and collisions.
+// Filter options are applied to source keys before rewriting
+func PlanRewrite(rdbFilename string, options ...interface{}) (*RewritePlan, error) {
+	if rdbFilename == "" {
+		return nil, errors.New("src file path is required")
+	}
+	rules := getRewriteRules(options)
+	var filterOptions []interface{}
+	for _, opt := range options {
+		if _, ok := opt.(RewriteOption); !ok {
+			filterOptions = append(filterOptions, opt)
+		}
+	}
+	plan := &RewritePlan{
+		dbSizes: make(map[int]*model.DBSizeObject),
+	}
+	keyspaces := make(map[int]map[string]*RewriteChange) // target db -> target key -> source
+	collisions := make(map[*RewriteChange]*RewriteCollision)
+	version, err := parseRDBFile(rdbFilename, filterOptions, func(object model.RedisObject) bool {
+		if isSpecialObject(object) {
+			return true
+		}
+		change := &RewriteChange{
+			SrcDB:  object.GetDBIndex(),
+			SrcKey: object.GetKey(),
+		}
+		change.DB, change.Key = applyRewriteRules(rules, change.SrcDB, change.SrcKey)
+		if change.DB != change.SrcDB || change.Key != change.SrcKey {
+			plan.Changes = append(plan.Changes, change)
+		}
+		keyspace := keyspaces[change.DB]
+		if keyspace == nil {
+			keyspace = make(map[string]*RewriteChange)
+			keyspaces[change.DB] = keyspace
+			plan.dbSizes[change.DB] = &model.DBSizeObject{BaseObject: &model.BaseObject{DB: change.DB}}
+		}
+		size := plan.dbSizes[change.DB]
+		size.KeyCount++
+		if object.GetExpiration() != nil {
+			size.TTLCount++
+		}
+		first := keyspace[change.Key]
+		if first == nil {
+			keyspace[change.Key] = change
+			return true
+		}
+		collision := collisions[first]
+		if collision == nil {
+			collision = &RewriteCollision{
+				DB:      change.DB,
+				Key:     change.Key,
+				Sources: []*RewriteChange{first},
+			}
+			collisions[first] = collision
+			plan.Collisions = append(plan.Collisions, collision)
+		}
+		collision.Sources = append(collision.Sources, change)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	plan.version = version
+	return plan, nil
+}
+
+// RewriteRDB writes keys of rdb into a new rdb file after applying rewrite rules and filter options.
+// It refuses to write if rewriting makes several keys collide, check RewritePlan.Collisions for details
+func RewriteRDB(rdbFilename string, outputFilename string, options ...interface{}) (*RewritePlan, error) {
+	if outputFilename == "" {
+		return nil, errors.New("output file path is required")
+	}
+	options = withNow(options) // plan and writing passes must keep the same keys
+	plan, err := PlanRewrite(rdbFilename, options...)
+	if err != nil {
+		return nil, err
+	}
+	if len(plan.Collisions) > 0 {
+		c := plan.Collisions[0]
+		return plan, fmt.Errorf("%d keys collide after rewriting, such as %s of db %d", len(plan.Collisions), c.Key, c.DB)
+	}
+	outputFile, err := os.Create(outputFilename)
+	if err != nil {
+		return plan, fmt.Errorf("create output %s failed, %v", outputFilename, err)
+	}
+	defer func() {
+		_ = outputFile.Close()
+	}()
+	bufWriter := bufio.NewWriter(outputFile)
+	enc := core.NewEncoder(bufWriter).SetVersion(outputVersion(plan.version))
+	err = enc.WriteHeader()
+	if err != nil {
+		return plan, err
+	}
+	writer := newRDBWriter(enc)
+	writer.dbSizes = plan.dbSizes
+
+	// keys of a db must be written together, so write target databases one by one if keys are moved between them
+	dbs := []int{-1} // -1 means all databases in one pass
+	for _, change := range plan.Changes {
+		if change.DB != change.SrcDB {
+			dbs = make([]int, 0, len(plan.dbSizes))
+			for db := range plan.dbSizes {
+				dbs = append(dbs, db)
+			}
+			sort.Ints(dbs)
+			break
+		}
+	}
+	for i, db := range dbs {
+		var writeErr error
+		_, err = parseRDBFile(rdbFilename, options, func(object model.RedisObject) bool {
+			switch {
+			case object.GetType() == model.DBSizeType:
+				return true // use key count of target db in plan
+			case isSpecialObject(object):
+				if i > 0 {
+					return true
+				}
+			case db >= 0 && object.GetDBIndex() != db:
+				return true
+			}
+			writeErr = writer.write(object)
+			if writeErr != nil {
+				writeErr = fmt.Errorf("write %s failed: %v", object.GetKey(), writeErr)
+				return false
+			}
+			return true
+		})
+		if err != nil {
+			return plan, err
+		}
+		if writeErr != nil {
+			return plan, writeErr
+		}
+	}
+	err = enc.WriteEnd()
+	if err != nil {
+		return plan, err
+	}
+	return plan, bufWriter.Flush()
+}
@@ -0,0 +1,54 @@
+package helper
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseRewriteRules(t *testing.T) {
+	rules, err := ParseRewriteRules(strings.NewReader(`
+# tenant migration
+strip-prefix old:
+add-prefix t1:
+replace ^t1:user:(\d+)$ t1:member:$1
+move-db 3 0
+`))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(rules) != 4 || rules[2].String() != `replace ^t1:user:(\d+)$ t1:member:$1` {
+		t.Errorf("wrong rules: %v", rules)
+		return
+	}
+	cases := []struct {
+		db, expectDB   int
+		key, expectKey string
+	}{
+		{3, 0, "old:user:1", "t1:member:1"},
+		{1, 1, "user:x", "t1:user:x"},
+		{0, 0, "order", "t1:order"},
+	}
+	for _, c := range cases {
+		db, key := applyRewriteRules(rules, c.db, c.key)
+		if db != c.expectDB || key != c.expectKey {
+			t.Errorf("%d %s should be rewritten to %d %s, actual %d %s", c.db, c.key, c.expectDB, c.expectKey, db, key)
+		}
+	}
+
+	illegalRules := []string{
+		"add-prefix",
+		"strip-prefix a b",
+		"replace a",
+		"replace (a b",
+		"move-db 1",
+		"move-db a 1",
+		"move-db 1 -1",
+		"rename a b",
+	}
+	for _, rule := range illegalRules {
+		if _, err := ParseRewriteRules(strings.NewReader(rule)); err == nil {
+			t.Errorf("expect error for %s", rule)
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package helper
+
+import (
+	"errors"
+	"github.com/hdt3213/rdb/model"
+	"hash/fnv"
+	"math"
+	"math/rand"
+	"sort"
+)
+
+// SampleOption keeps a deterministic fraction of keys, a key is always kept or dropped with the same seed
+type SampleOption struct {
+	Fraction float64
+	Seed     int64
+}
+
+// WithSampleOption creates a SampleOption, fraction is in (0, 1]
+func WithSampleOption(fraction float64, seed int64) SampleOption {
+	return SampleOption{
+		Fraction: fraction,
+		Seed:     seed,
+	}
+}
+
+// ReservoirOption keeps a fixed number of randomly chosen keys, kept keys are passed to callback after parsing
+// in the order of rdb
+type ReservoirOption struct {
+	Size int
+	Seed int64
+}
+
+// WithReservoirOption creates a ReservoirOption
+func WithReservoirOption(size int, seed int64) ReservoirOption {
+	return ReservoirOption{
+		Size: size,
+		Seed: seed,
+	}
+}
+
+// SampleReport is filled during sampling, so that totals of whole rdb could be estimated from the sample
+type SampleReport struct {
+	ScannedKeys int              // number of keys before sampling
+	SampledKeys int              // number of keys kept
+	SampledSize int64            // total size of keys kept
+	TypeKeys    map[string]int   // number of keys kept by type
+	TypeSize    map[string]int64 // total size of keys kept by type
+}
+
+// SampleReportOption collects SampleReport while sampling
+type SampleReportOption *SampleReport
+
+// WithSampleReportOption creates a SampleReportOption, report will be filled after parsing
+func WithSampleReportOption(report *SampleReport) SampleReportOption {
+	return report
+}
+
+// Ratio returns fraction of keys kept
+func (r *SampleReport) Ratio() float64 {
+	if r.ScannedKeys == 0 {
+		return 1
+	}
+	return float64(r.SampledKeys) / float64(r.ScannedKeys)
+}
+
+// EstimateSize returns estimated total size of all keys of the given type, empty type means all types
+func (r *SampleReport) EstimateSize(typ string) int64 {
+	size := r.SampledSize
+	if typ != "" {
+		size = r.TypeSize[typ]
+	}
+	ratio := r.Ratio()
+	if ratio == 0 {
+		return 0
+	}
+	return int64(math.Round(float64(size) / ratio))
+}
+
+// EstimateKeys returns estimated number of keys of the given type, empty type means all types
+func (r *SampleReport) EstimateKeys(typ string) int64 {
+	count := r.SampledKeys
+	if typ != "" {
+		count = r.TypeKeys[typ]
+	}
+	ratio := r.Ratio()
+	if ratio == 0 {
+		return 0
+	}
+	return int64(math.Round(float64(count) / ratio))
+}
+
+func (r *SampleReport) add(object model.RedisObject) {
+	r.SampledKeys++
+	r.SampledSize += int64(object.GetSize())
+	r.TypeKeys[object.GetType()]++
+	r.TypeSize[object.GetType()] += int64(object.GetSize())
+}
+
+// sampleDecoder passes sampled keys to callback, metadata objects are always passed
+type sampleDecoder struct {
+	dec       decoder
+	threshold uint64 // keep keys whose hash is not greater than threshold
+	seed      uint64
+	reservoir int // reservoir size, 0 means sampling by fraction
+	rand      *rand.Rand
+	report    *SampleReport
+}
+
+func (d *sampleDecoder) keep(key string) bool {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(key))
+	return splitMix64(h.Sum64()^d.seed) <= d.threshold
+}
+
+func (d *sampleDecoder) Parse(cb func(object model.RedisObject) bool) error {
+	report := d.report
+	if report == nil {
+		report = &SampleReport{}
+	}
+	*report = SampleReport{
+		TypeKeys: make(map[string]int),
+		TypeSize: make(map[string]int64),
+	}
+	if d.reservoir == 0 {
+		return d.dec.Parse(func(object model.RedisObject) bool {
+			if isSpecialObject(object) {
+				return cb(object)
+			}
+			report.ScannedKeys++
+			if !d.keep(object.GetKey()) {
+				return true
+			}
+			report.add(object)
+			return cb(object)
+		})
+	}
+	// algorithm R, kept objects are sorted in the order of rdb at last
+	samples := make([]*sampledObject, 0, d.reservoir)
+	stopped := false
+	err := d.dec.Parse(func(object model.RedisObject) bool {
+		if isSpecialObject(object) {
+			stopped = !cb(object)
+			return !stopped
+		}
+		sample := &sampledObject{
+			index:  report.ScannedKeys,
+			object: object,
+		}
+		if len(samples) < d.reservoir {
+			samples = append(samples, sample)
+		} else if j := d.rand.Intn(report.ScannedKeys + 1); j < d.reservoir {
+			samples[j] = sample
+		}
+		report.ScannedKeys++
+		return true
+	})
+	if err != nil || stopped {
+		return err
+	}
+	sort.Slice(samples, func(i, j int) bool {
+		return samples[i].index < samples[j].index
+	})
+	for _, sample := range samples {
+		report.add(sample.object)
+		if !cb(sample.object) {
+			break
+		}
+	}
+	return nil
+}
+
+type sampledObject struct {
+	index  int // index in rdb
+	object model.RedisObject
+}
+
+// wrapSampleDecoder applies sample options to decoder, returns dec if there is no such option
+func wrapSampleDecoder(dec decoder, options ...interface{}) (decoder, error) {
+	var d *sampleDecoder
+	var report *SampleReport
+	for _, opt := range options {
+		switch o := opt.(type) {
+		case SampleOption:
+			if o.Fraction <= 0 || o.Fraction > 1 {
+				return nil, errors.New("sample fraction must be in (0, 1]")
+			}
+			d = &sampleDecoder{
+				seed:      splitMix64(uint64(o.Seed)),
+				threshold: math.MaxUint64,
+			}
+			if threshold := o.Fraction * math.MaxUint64; threshold < math.MaxUint64 {
+				d.threshold = uint64(threshold)
+			}
+		case ReservoirOption:
+			if o.Size <= 0 {
+				return nil, errors.New("reservoir size must be positive")
+			}
+			d = &sampleDecoder{
+				reservoir: o.Size,
+				rand:      rand.New(rand.NewSource(o.Seed)),
+			}
+		case SampleReportOption:
+			report = o
+		}
+	}
+	if d == nil {
+		return dec, nil
+	}
+	d.dec = dec
+	d.report = report
+	return d, nil
+}
+
+// hasReservoirOption returns whether objects are kept after callback of Parse by reservoir sampling
+func hasReservoirOption(options []interface{}) bool {
+	for _, opt := range options {
+		if _, ok := opt.(ReservoirOption); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,105 @@
+package helper
+
+import (
+	"github.com/hdt3213/rdb/model"
+	"strconv"
+	"testing"
+)
+
+func makeSampleObjects(n int) sliceDecoder {
+	objects := sliceDecoder{
+		&model.AuxObject{
+			BaseObject: &model.BaseObject{Key: "redis-ver", Type: model.AuxType},
+			Value:      "7.0.0",
+		},
+	}
+	for i := 0; i < n; i++ {
+		object := makeStringObject("key:"+strconv.Itoa(i), nil)
+		baseObject(object).Size = 10
+		objects = append(objects, object)
+	}
+	return objects
+}
+
+func TestSampleDecoder(t *testing.T) {
+	objects := makeSampleObjects(10000)
+	sample := func(options ...interface{}) ([]string, *SampleReport) {
+		report := &SampleReport{}
+		dec, err := wrapDecoder(objects, append(options, WithSampleReportOption(report))...)
+		if err != nil {
+			t.Error(err)
+			return nil, nil
+		}
+		var keys []string
+		auxCount := 0
+		err = dec.Parse(func(object model.RedisObject) bool {
+			if object.GetType() == model.AuxType {
+				auxCount++
+			} else {
+				keys = append(keys, object.GetKey())
+			}
+			return true
+		})
+		if err != nil {
+			t.Error(err)
+		}
+		if auxCount != 1 {
+			t.Error("aux object should be kept")
+		}
+		return keys, report
+	}
+
+	keys, report := sample(WithSampleOption(0.1, 1))
+	if len(keys) < 900 || len(keys) > 1100 {
+		t.Errorf("expect about 1000 keys, actual: %d", len(keys))
+	}
+	if report.ScannedKeys != 10000 || report.SampledKeys != len(keys) || report.EstimateKeys("") != 10000 {
+		t.Errorf("wrong report: %+v", report)
+	}
+	if size := report.EstimateSize(model.StringType); size != 100000 {
+		t.Errorf("wrong estimated size: %d", size)
+	}
+	again, _ := sample(WithSampleOption(0.1, 1))
+	if len(again) != len(keys) || again[0] != keys[0] {
+		t.Error("sampling should be deterministic")
+	}
+	other, _ := sample(WithSampleOption(0.1, 2))
+	if len(other) == len(keys) && other[0] == keys[0] && other[len(other)-1] == keys[len(keys)-1] {
+		t.Error("sampling should depend on seed")
+	}
+	negative, _ := sample(WithSampleOption(0.1, -1))
+	if len(negative) == 0 || len(negative) == 10000 {
+		t.Errorf("wrong sample with negative seed: %d keys", len(negative))
+	}
+	all, _ := sample(WithSampleOption(1, 0))
+	if len(all) != 10000 {
+		t.Errorf("expect all keys, actual: %d", len(all))
+	}
+
+	keys, report = sample(WithReservoirOption(100, 1))
+	if len(keys) != 100 || report.SampledKeys != 100 || report.EstimateSize("") != 100000 {
+		t.Errorf("wrong reservoir sample: %d keys, %+v", len(keys), report)
+	}
+	prev := -1
+	for _, key := range keys {
+		i, _ := strconv.Atoi(key[len("key:"):])
+		if i <= prev {
+			t.Error("reservoir sample should be in the order of rdb")
+			break
+		}
+		prev = i
+	}
+	if keys[len(keys)-1] == "key:99" {
+		t.Error("reservoir should not keep the first keys only")
+	}
+	again, _ = sample(WithReservoirOption(100, 1))
+	if again[0] != keys[0] || again[99] != keys[99] {
+		t.Error("reservoir sampling should be deterministic with the same seed")
+	}
+
+	for _, opt := range []interface{}{WithSampleOption(0, 0), WithSampleOption(1.5, 0), WithReservoirOption(0, 0)} {
+		if _, err := wrapDecoder(objects, opt); err == nil {
+			t.Errorf("expect error for %+v", opt)
+		}
+	}
+}
@@ -0,0 +1,766 @@
+package helper
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/hdt3213/rdb/core"
+	"github.com/hdt3213/rdb/model"
+	"io"
+	"math"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SnapshotServer answers read commands of redis over RESP with keys in a rdb file.
+// Only positions of keys are kept in memory, values are decoded from the file on demand
+type SnapshotServer struct {
+	file    *os.File
+	version int
+	refTime time.Time // ttl is computed against it
+	dbs     map[int]*snapshotDB
+
+	mu       sync.Mutex
+	listener net.Listener
+	conns    map[net.Conn]struct{}
+	closed   bool
+}
+
+// snapshotDB is index of keys in a database
+type snapshotDB struct {
+	keys    []*snapshotKey // in order of rdb, SCAN cursor is index of it
+	index   map[string]*snapshotKey
+	expires int
+}
+
+// snapshotKey is position of a key in rdb file
+type snapshotKey struct {
+	key        string
+	typ        string
+	offset     int64 // offset of type flag
+	size       int64 // size of type flag, key and value
+	expiration int64 // unix timestamp in milliseconds, 0 means persistent
+}
+
+// NewSnapshotServer indexes keys in an uncompressed rdb file, SnapshotServer must be closed.
+// TTL of keys is computed against ctime of rdb, or now if rdb has no ctime
+func NewSnapshotServer(rdbFilename string) (*SnapshotServer, error) {
+	if rdbFilename == "" {
+		return nil, errors.New("src file path is required")
+	}
+	file, err := os.Open(rdbFilename)
+	if err != nil {
+		return nil, err
+	}
+	s := &SnapshotServer{
+		file:  file,
+		dbs:   make(map[int]*snapshotDB),
+		conns: make(map[net.Conn]struct{}),
+	}
+	err = s.index()
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *SnapshotServer) index() error {
+	magic := make([]byte, 5)
+	_, err := io.ReadFull(s.file, magic)
+	if err != nil || string(magic) != "REDIS" {
+		return errors.New("serve requires an uncompressed rdb file")
+	}
+	_, err = s.file.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	dec := core.NewDecoder(s.file).WithSpecialOpCode()
+	err = dec.Parse(func(object model.RedisObject) bool {
+		switch o := object.(type) {
+		case *model.AuxObject:
+			if o.Key == "ctime" {
+				if ctime, err := strconv.ParseInt(o.Value, 10, 64); err == nil {
+					s.refTime = time.Unix(ctime, 0)
+				}
+			}
+			return true
+		case *model.DBSizeObject, *model.FunctionsObject, *model.ModuleAuxObject:
+			return true
+		}
+		db := s.dbs[object.GetDBIndex()]
+		if db == nil {
+			db = &snapshotDB{index: make(map[string]*snapshotKey)}
+			s.dbs[object.GetDBIndex()] = db
+		}
+		k := &snapshotKey{
+			key:    object.GetKey(),
+			typ:    object.GetType(),
+			offset: int64(dec.Offset() - object.GetSize() - 1),
+			size:   int64(object.GetSize() + 1),
+		}
+		if expiration := object.GetExpiration(); expiration != nil {
+			k.expiration = expiration.UnixNano() / int64(time.Millisecond)
+			db.expires++
+		}
+		db.keys = append(db.keys, k)
+		db.index[k.key] = k
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	s.version = dec.Version()
+	if s.refTime.IsZero() {
+		s.refTime = time.Now()
+	}
+	return nil
+}
+
+// Serve accepts connections until the listener is closed or server is closed
+func (s *SnapshotServer) Serve(listener net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return errors.New("server is closed")
+	}
+	s.listener = listener
+	s.mu.Unlock()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return nil
+			}
+			return err
+		}
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			_ = conn.Close()
+			return nil
+		}
+		s.conns[conn] = struct{}{}
+		s.mu.Unlock()
+		go s.handle(conn)
+	}
+}
+
+// Close stops serving and closes rdb file
+func (s *SnapshotServer) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	if s.listener != nil {
+		_ = s.listener.Close()
+	}
+	for conn := range s.conns {
+		_ = conn.Close()
+	}
+	return s.file.Close()
+}
+
+// snapshotSession is state of a connection
+type snapshotSession struct {
+	db     int
+	writer *bufio.Writer
+}
+
+func (s *SnapshotServer) handle(conn net.Conn) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		_ = conn.Close()
+	}()
+	reader := bufio.NewReader(conn)
+	session := &snapshotSession{writer: bufio.NewWriter(conn)}
+	for {
+		args, err := readRequest(reader)
+		if err != nil {
+			if err != io.EOF {
+				writeError(session.writer, "ERR Protocol error: "+err.Error())
+				_ = session.writer.Flush()
+			}
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+		quit := strings.ToLower(string(args[0])) == "quit"
+		if quit {
+			writeSimple(session.writer, "OK")
+		} else {
+			s.exec(session, args)
+		}
+		// replies of pipelined commands are flushed together
+		if quit || reader.Buffered() == 0 {
+			if session.writer.Flush() != nil || quit {
+				return
+			}
+		}
+	}
+}
+
+// readRequest reads a command in RESP or an inline command like "GET key"
+func readRequest(reader *bufio.Reader) ([][]byte, error) {
+	b, err := reader.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	if b[0] == '*' {
+		args, _, err := readCommand(reader)
+		return args, err
+	}
+	line, err := readLine(reader)
+	if err != nil {
+		return nil, err
+	}
+	var args [][]byte
+	for _, field := range strings.Fields(string(line)) {
+		args = append(args, []byte(field))
+	}
+	return args, nil
+}
+
+// snapshotCommand is a read command, arity is number of arguments including name, negative means at least -arity
+type snapshotCommand struct {
+	fn    func(s *SnapshotServer, session *snapshotSession, args [][]byte)
+	arity int
+}
+
+var snapshotCommands = map[string]*snapshotCommand{
+	"ping":     {fn: servePing, arity: -1},
+	"echo":     {fn: serveEcho, arity: 2},
+	"command":  {fn: serveCommand, arity: -1},
+	"select":   {fn: serveSelect, arity: 2},
+	"dbsize":   {fn: serveDBSize, arity: 1},
+	"info":     {fn: serveInfo, arity: -1},
+	"get":      {fn: serveGet, arity: 2},
+	"mget":     {fn: serveMGet, arity: -2},
+	"type":     {fn: serveType, arity: 2},
+	"ttl":      {fn: serveTTL, arity: 2},
+	"pttl":     {fn: serveTTL, arity: 2},
+	"exists":   {fn: serveExists, arity: -2},
+	"scan":     {fn: serveScan, arity: -2},
+	"keys":     {fn: serveKeys, arity: 2},
+	"hgetall":  {fn: serveHGetAll, arity: 2},
+	"hget":     {fn: serveHGet, arity: 3},
+	"lrange":   {fn: serveLRange, arity: 4},
+	"smembers": {fn: serveSMembers, arity: 2},
+	"zrange":   {fn: serveZRange, arity: -4},
+}
+
+func (s *SnapshotServer) exec(session *snapshotSession, args [][]byte) {
+	name := strings.ToLower(string(args[0]))
+	cmd := snapshotCommands[name]
+	if cmd == nil {
+		writeError(session.writer, fmt.Sprintf("ERR unknown command '%s', snapshot is read only", args[0]))
+		return
+	}
+	if (cmd.arity > 0 && len(args) != cmd.arity) || (cmd.arity < 0 && len(args) < -cmd.arity) {
+		writeError(session.writer, fmt.Sprintf("ERR wrong number of arguments for '%s' command", name))
+		return
+	}
+	cmd.fn(s, session, args)
+}
+
+func (s *SnapshotServer) lookup(session *snapshotSession, key []byte) *snapshotKey {
+	db := s.dbs[session.db]
+	if db == nil {
+		return nil
+	}
+	return db.index[string(key)]
+}
+
+// load decodes value of key from rdb file, it writes error reply and returns nil if key has a different type
+func (s *SnapshotServer) load(session *snapshotSession, k *snapshotKey, typ string) model.RedisObject {
+	if k.typ != typ {
+		writeError(session.writer, "WRONGTYPE Operation against a key holding the wrong kind of value")
+		return nil
+	}
+	obj, err := core.DecodeObject(io.NewSectionReader(s.file, k.offset, k.size), s.version)
+	if err != nil {
+		writeError(session.writer, "ERR decode "+k.key+" failed: "+err.Error())
+		return nil
+	}
+	return obj
+}
+
+func servePing(s *SnapshotServer, session *snapshotSession, args [][]byte) {
+	if len(args) > 1 {
+		writeBulk(session.writer, args[1])
+		return
+	}
+	writeSimple(session.writer, "PONG")
+}
+
+func serveEcho(s *SnapshotServer, session *snapshotSession, args [][]byte) {
+	writeBulk(session.writer, args[1])
+}
+
+// serveCommand replies empty array, so that redis-cli works without command docs
+func serveCommand(s *SnapshotServer, session *snapshotSession, args [][]byte) {
+	writeArrayHeader(session.writer, 0)
+}
+
+func serveSelect(s *SnapshotServer, session *snapshotSession, args [][]byte) {
+	db, err := strconv.Atoi(string(args[1]))
+	if err != nil {
+		writeError(session.writer, "ERR value is not an integer or out of range")
+		return
+	}
+	maxDB := 16
+	for i := range s.dbs {
+		if i >= maxDB {
+			maxDB = i + 1
+		}
+	}
+	if db < 0 || db >= maxDB {
+		writeError(session.writer, "ERR DB index is out of range")
+		return
+	}
+	session.db = db
+	writeSimple(session.writer, "OK")
+}
+
+func serveDBSize(s *SnapshotServer, session *snapshotSession, args [][]byte) {
+	count := 0
+	if db := s.dbs[session.db]; db != nil {
+		count = len(db.keys)
+	}
+	writeInt(session.writer, int64(count))
+}
+
+func serveInfo(s *SnapshotServer, session *snapshotSession, args [][]byte) {
+	var buf bytes.Buffer
+	section := "default"
+	if len(args) > 1 {
+		section = strings.ToLower(string(args[1]))
+	}
+	if section == "keyspace" || section == "default" || section == "all" || section == "everything" {
+		buf.WriteString("# Keyspace\r\n")
+		indexes := make([]int, 0, len(s.dbs))
+		for i := range s.dbs {
+			indexes = append(indexes, i)
+		}
+		sort.Ints(indexes)
+		for _, i := range indexes {
+			db := s.dbs[i]
+			_, _ = fmt.Fprintf(&buf, "db%d:keys=%d,expires=%d,avg_ttl=0\r\n", i, len(db.keys), db.expires)
+		}
+	}
+	writeBulk(session.writer, buf.Bytes())
+}
+
+func serveGet(s *SnapshotServer, session *snapshotSession, args [][]byte) {
+	k := s.lookup(session, args[1])
+	if k == nil {
+		writeNull(session.writer)
+		return
+	}
+	obj := s.load(session, k, model.StringType)
+	if obj == nil {
+		return
+	}
+	writeBulk(session.writer, obj.(*model.StringObject).Bytes())
+}
+
+func serveMGet(s *SnapshotServer, session *snapshotSession, args [][]byte) {
+	values := make([][]byte, len(args)-1)
+	for i, key := range args[1:] {
+		k := s.lookup(session, key)
+		if k == nil || k.typ != model.StringType {
+			continue
+		}
+		obj, err := core.DecodeObject(io.NewSectionReader(s.file, k.offset, k.size), s.version)
+		if err != nil {
+			writeError(session.writer, "ERR decode "+k.key+" failed: "+err.Error())
+			return
+		}
+		values[i] = obj.(*model.StringObject).Bytes()
+	}
+	writeArrayHeader(session.writer, len(values))
+	for _, value := range values {
+		if value == nil {
+			writeNull(session.writer)
+		} else {
+			writeBulk(session.writer, value)
+		}
+	}
+}
+
+func serveType(s *SnapshotServer, session *snapshotSession, args [][]byte) {
+	k := s.lookup(session, args[1])
+	if k == nil {
+		writeSimple(session.writer, "none")
+		return
+	}
+	writeSimple(session.writer, k.typ)
+}
+
+func serveTTL(s *SnapshotServer, session *snapshotSession, args [][]byte) {
+	k := s.lookup(session, args[1])
+	if k == nil {
+		writeInt(session.writer, -2)
+		return
+	}
+	if k.expiration == 0 {
+		writeInt(session.writer, -1)
+		return
+	}
+	ttl := k.expiration - s.refTime.UnixNano()/int64(time.Millisecond)
+	if ttl < 0 {
+		ttl = 0
+	}
+	if strings.ToLower(string(args[0])) == "ttl" {
+		ttl = (ttl + 500) / 1000
+	}
+	writeInt(session.writer, ttl)
+}
+
+func serveExists(s *SnapshotServer, session *snapshotSession, args [][]byte) {
+	count := 0
+	for _, key := range args[1:] {
+		if s.lookup(session, key) != nil {
+			count++
+		}
+	}
+	writeInt(session.writer, int64(count))
+}
+
+// serveScan uses index of key in rdb as cursor, COUNT is number of keys examined like redis
+func serveScan(s *SnapshotServer, session *snapshotSession, args [][]byte) {
+	cursor, err := strconv.Atoi(string(args[1]))
+	if err != nil || cursor < 0 {
+		writeError(session.writer, "ERR invalid cursor")
+		return
+	}
+	pattern, typ, count := "*", "", 10
+	for i := 2; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			writeError(session.writer, "ERR syntax error")
+			return
+		}
+		value := string(args[i+1])
+		switch strings.ToLower(string(args[i])) {
+		case "match":
+			pattern = value
+		case "type":
+			typ = strings.ToLower(value)
+		case "count":
+			count, err = strconv.Atoi(value)
+			if err != nil || count < 1 {
+				writeError(session.writer, "ERR syntax error")
+				return
+			}
+		default:
+			writeError(session.writer, "ERR syntax error")
+			return
+		}
+	}
+	var keys []*snapshotKey
+	if db := s.dbs[session.db]; db != nil {
+		keys = db.keys
+	}
+	var result []string
+	next := cursor
+	for ; next < len(keys) && next < cursor+count; next++ {
+		k := keys[next]
+		if (typ == "" || k.typ == typ) && globMatch(pattern, k.key) {
+			result = append(result, k.key)
+		}
+	}
+	if next >= len(keys) {
+		next = 0
+	}
+	writeArrayHeader(session.writer, 2)
+	writeBulk(session.writer, []byte(strconv.Itoa(next)))
+	writeArrayHeader(session.writer, len(result))
+	for _, key := range result {
+		writeBulk(session.writer, []byte(key))
+	}
+}
+
+func serveKeys(s *SnapshotServer, session *snapshotSession, args [][]byte) {
+	var result []string
+	if db := s.dbs[session.db]; db != nil {
+		for _, k := range db.keys {
+			if globMatch(string(args[1]), k.key) {
+				result = append(result, k.key)
+			}
+		}
+	}
+	writeArrayHeader(session.writer, len(result))
+	for _, key := range result {
+		writeBulk(session.writer, []byte(key))
+	}
+}
+
+func serveHGetAll(s *SnapshotServer, session *snapshotSession, args [][]byte) {
+	k := s.lookup(session, args[1])
+	if k == nil {
+		writeArrayHeader(session.writer, 0)
+		return
+	}
+	obj := s.load(session, k, model.HashType)
+	if obj == nil {
+		return
+	}
+	hash := obj.(*model.HashObject).Hash
+	writeArrayHeader(session.writer, len(hash)*2)
+	for field, value := range hash {
+		writeBulk(session.writer, []byte(field))
+		writeBulk(session.writer, value)
+	}
+}
+
+func serveHGet(s *SnapshotServer, session *snapshotSession, args [][]byte) {
+	k := s.lookup(session, args[1])
+	if k == nil {
+		writeNull(session.writer)
+		return
+	}
+	obj := s.load(session, k, model.HashType)
+	if obj == nil {
+		return
+	}
+	value, ok := obj.(*model.HashObject).Hash[string(args[2])]
+	if !ok {
+		writeNull(session.writer)
+		return
+	}
+	writeBulk(session.writer, value)
+}
+
+func serveLRange(s *SnapshotServer, session *snapshotSession, args [][]byte) {
+	start, err1 := strconv.Atoi(string(args[2]))
+	stop, err2 := strconv.Atoi(string(args[3]))
+	if err1 != nil || err2 != nil {
+		writeError(session.writer, "ERR value is not an integer or out of range")
+		return
+	}
+	k := s.lookup(session, args[1])
+	if k == nil {
+		writeArrayHeader(session.writer, 0)
+		return
+	}
+	obj := s.load(session, k, model.ListType)
+	if obj == nil {
+		return
+	}
+	values := obj.(*model.ListObject).Values
+	start, stop = rangeIndexes(start, stop, len(values))
+	writeArrayHeader(session.writer, stop-start)
+	for _, value := range values[start:stop] {
+		writeBulk(session.writer, value)
+	}
+}
+
+func serveSMembers(s *SnapshotServer, session *snapshotSession, args [][]byte) {
+	k := s.lookup(session, args[1])
+	if k == nil {
+		writeArrayHeader(session.writer, 0)
+		return
+	}
+	obj := s.load(session, k, model.SetType)
+	if obj == nil {
+		return
+	}
+	members := obj.(*model.SetObject).Members
+	writeArrayHeader(session.writer, len(members))
+	for _, member := range members {
+		writeBulk(session.writer, member)
+	}
+}
+
+// serveZRange supports ZRANGE key start stop [WITHSCORES] by index
+func serveZRange(s *SnapshotServer, session *snapshotSession, args [][]byte) {
+	start, err1 := strconv.Atoi(string(args[2]))
+	stop, err2 := strconv.Atoi(string(args[3]))
+	if err1 != nil || err2 != nil {
+		writeError(session.writer, "ERR value is not an integer or out of range")
+		return
+	}
+	withScores := false
+	for _, arg := range args[4:] {
+		if strings.ToLower(string(arg)) != "withscores" {
+			writeError(session.writer, "ERR syntax error")
+			return
+		}
+		withScores = true
+	}
+	k := s.lookup(session, args[1])
+	if k == nil {
+		writeArrayHeader(session.writer, 0)
+		return
+	}
+	obj := s.load(session, k, model.ZSetType)
+	if obj == nil {
+		return
+	}
+	entries := obj.(*model.ZSetObject).Entries
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Score != entries[j].Score {
+			return entries[i].Score < entries[j].Score
+		}
+		return entries[i].Member < entries[j].Member
+	})
+	start, stop = rangeIndexes(start, stop, len(entries))
+	n := stop - start
+	if withScores {
+		n *= 2
+	}
+	writeArrayHeader(session.writer, n)
+	for _, entry := range entries[start:stop] {
+		writeBulk(session.writer, []byte(entry.Member))
+		if withScores {
+			writeBulk(session.writer, []byte(formatScore(entry.Score)))
+		}
+	}
+}
+
+// rangeIndexes converts inclusive start and stop which may be negative like LRANGE into a slice range
+func rangeIndexes(start, stop, length int) (int, int) {
+	if start < 0 {
+		start += length
+	}
+	if stop < 0 {
+		stop += length
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= length {
+		stop = length - 1
+	}
+	if start > stop || start >= length {
+		return 0, 0
+	}
+	return start, stop + 1
+}
+
+// formatScore formats score like redis
+func formatScore(score float64) string {
+	if math.IsInf(score, 1) {
+		return "inf"
+	}
+	if math.IsInf(score, -1) {
+		return "-inf"
+	}
+	return strconv.FormatFloat(score, 'g', 17, 64)
+}
+
+// globMatch matches s with glob-style pattern supported by redis, including *, ?, [a-z], [^a] and escaping by \
+func globMatch(pattern, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 1 {
+				return true
+			}
+			for i := 0; i <= len(s); i++ {
+				if globMatch(pattern[1:], s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+			s = s[1:]
+		case '[':
+			if len(s) == 0 {
+				return false
+			}
+			pattern = pattern[1:]
+			not := len(pattern) > 0 && pattern[0] == '^'
+			if not {
+				pattern = pattern[1:]
+			}
+			match := false
+			for len(pattern) > 0 && pattern[0] != ']' {
+				if pattern[0] == '\\' && len(pattern) > 1 {
+					pattern = pattern[1:]
+					if pattern[0] == s[0] {
+						match = true
+					}
+				} else if len(pattern) > 2 && pattern[1] == '-' && pattern[2] != ']' {
+					lo, hi := pattern[0], pattern[2]
+					if lo > hi {
+						lo, hi = hi, lo
+					}
+					if s[0] >= lo && s[0] <= hi {
+						match = true
+					}
+					pattern = pattern[2:]
+				} else if pattern[0] == s[0] {
+					match = true
+				}
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 0 {
+				// unclosed bracket ends pattern
+				return match != not && len(s) == 1
+			}
+			if match == not {
+				return false
+			}
+			s = s[1:]
+		case '\\':
+			if len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if len(s) == 0 || pattern[0] != s[0] {
+				return false
+			}
+			s = s[1:]
+		}
+		pattern = pattern[1:]
+	}
+	return len(s) == 0
+}
+
+func writeSimple(w *bufio.Writer, s string) {
+	_, _ = w.WriteString("+" + s + "\r\n")
+}
+
+func writeError(w *bufio.Writer, msg string) {
+	_, _ = w.WriteString("-" + msg + "\r\n")
+}
+
+func writeInt(w *bufio.Writer, n int64) {
+	_, _ = w.WriteString(":" + strconv.FormatInt(n, 10) + "\r\n")
+}
+
+func writeBulk(w *bufio.Writer, value []byte) {
+	_, _ = w.WriteString("$" + strconv.Itoa(len(value)) + "\r\n")
+	_, _ = w.Write(value)
+	_, _ = w.WriteString("\r\n")
+}
+
+func writeNull(w *bufio.Writer) {
+	_, _ = w.WriteString("$-1\r\n")
+}
+
+func writeArrayHeader(w *bufio.Writer, n int) {
+	_, _ = w.WriteString("*" + strconv.Itoa(n) + "\r\n")
+}
@@ -0,0 +1,198 @@
+package helper
+
+import (
+	"bytes"
+	"compress/gzip"
+	"github.com/hdt3213/rdb/core"
+	"github.com/hdt3213/rdb/model"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func writeServeTestRDB(t *testing.T, filename string) {
+	file, err := os.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+	enc := core.NewEncoder(file)
+	ctime := int64(1000000000)
+	errs := []error{
+		enc.WriteHeader(),
+		enc.WriteAux("ctime", "1000000000"),
+		enc.WriteDBHeader(0, 5, 1),
+		enc.WriteStringObject("str", []byte("hello"), core.WithTTL(uint64(ctime*1000+100000))),
+		enc.WriteListObject("list", [][]byte{[]byte("a"), []byte("b"), []byte("c")}),
+		enc.WriteHashMapObject("hash", map[string][]byte{"f": []byte("v")}),
+		enc.WriteSetObject("set", [][]byte{[]byte("x")}),
+		enc.WriteZSetObject("zset", []*model.ZSetEntry{
+			{Member: "b", Score: 2},
+			{Member: "a", Score: 1},
+			{Member: "c", Score: 3},
+		}),
+		enc.WriteDBHeader(3, 1, 0),
+		enc.WriteStringObject("other", []byte("x")),
+		enc.WriteEnd(),
+	}
+	for _, err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSnapshotServer(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "dump.rdb")
+	writeServeTestRDB(t, filename)
+	server, err := NewSnapshotServer(filename)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer func() {
+		_ = server.Close()
+	}()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	c, err := dialRedis("redis://" + listener.Addr().String())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer func() {
+		_ = c.Close()
+	}()
+
+	for _, tc := range []struct {
+		cmd    string
+		expect interface{}
+	}{
+		{"GET str", "hello"},
+		{"GET missing", nil},
+		{"GET list", redisError("WRONGTYPE Operation against a key holding the wrong kind of value")},
+		{"MGET str missing list", []interface{}{"hello", nil, nil}},
+		{"TYPE list", "list"},
+		{"TYPE missing", "none"},
+		{"TTL str", int64(100)},
+		{"PTTL str", int64(100000)},
+		{"TTL list", int64(-1)},
+		{"TTL missing", int64(-2)},
+		{"EXISTS str list missing", int64(2)},
+		{"DBSIZE", int64(5)},
+		{"SCAN 0 MATCH * TYPE hash COUNT 100", []interface{}{"0", []interface{}{"hash"}}},
+		{"SCAN 0 MATCH s* COUNT 3", []interface{}{"3", []interface{}{"str"}}},
+		{"SCAN 3 MATCH s* COUNT 3", []interface{}{"0", []interface{}{"set"}}},
+		{"SCAN 0 LIMIT 1", redisError("ERR syntax error")},
+		{"HGETALL hash", []interface{}{"f", "v"}},
+		{"HGET hash f", "v"},
+		{"HGET hash g", nil},
+		{"LRANGE list 0 -1", []interface{}{"a", "b", "c"}},
+		{"LRANGE list -2 10", []interface{}{"b", "c"}},
+		{"LRANGE list 2 1", []interface{}{}},
+		{"SMEMBERS set", []interface{}{"x"}},
+		{"ZRANGE zset 0 -1 WITHSCORES", []interface{}{"a", "1", "b", "2", "c", "3"}},
+		{"ZRANGE zset 1 1", []interface{}{"b"}},
+		{"SET str v", redisError("ERR unknown command 'SET', snapshot is read only")},
+		{"GET", redisError("ERR wrong number of arguments for 'get' command")},
+		{"SELECT 99", redisError("ERR DB index is out of range")},
+		{"SELECT 3", "OK"},
+		{"DBSIZE", int64(1)},
+		{"GET other", "x"},
+		{"GET str", nil},
+		{"SELECT 1", "OK"},
+		{"KEYS *", []interface{}{}},
+	} {
+		err := c.send(strings.Fields(tc.cmd)...)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		actual, err := readValue(c.reader)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if !reflect.DeepEqual(actual, tc.expect) {
+			t.Errorf("%s: expect %#v, actual %#v", tc.cmd, tc.expect, actual)
+		}
+	}
+
+	info, err := c.do("INFO", "keyspace")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if info != "# Keyspace\r\ndb0:keys=5,expires=1,avg_ttl=0\r\ndb3:keys=1,expires=0,avg_ttl=0\r\n" {
+		t.Errorf("wrong info: %q", info)
+	}
+	// inline command
+	_, err = c.conn.Write([]byte("PING\r\n"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	reply, err := readValue(c.reader)
+	if err != nil || reply != "PONG" {
+		t.Errorf("wrong reply of inline ping: %v %v", reply, err)
+	}
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	_, _ = w.Write(data)
+	_ = w.Close()
+	err = os.WriteFile(filename+".gz", buf.Bytes(), 0644)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = NewSnapshotServer(filename + ".gz")
+	if err == nil || !strings.Contains(err.Error(), "uncompressed") {
+		t.Error("expect error of compressed file")
+	}
+}
+
+func TestGlobMatch(t *testing.T) {
+	for _, tc := range []struct {
+		pattern string
+		s       string
+		expect  bool
+	}{
+		{"*", "", true},
+		{"*", "abc", true},
+		{"a*c", "abbc", true},
+		{"a*c", "abcd", false},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"h[ae]llo", "hallo", true},
+		{"h[ae]llo", "hillo", false},
+		{"h[^e]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-b]llo", "hbllo", true},
+		{"h[a-b]llo", "hcllo", false},
+		{`h\*llo`, "h*llo", true},
+		{`h\*llo`, "hello", false},
+		{"user:*:name", "user:1:name", true},
+		{"user:*:name", "user:1:age", false},
+	} {
+		if globMatch(tc.pattern, tc.s) != tc.expect {
+			t.Errorf("globMatch(%q, %q) should be %v", tc.pattern, tc.s, tc.expect)
+		}
+	}
+}
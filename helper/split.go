@@ -0,0 +1,322 @@
+package helper
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/hdt3213/rdb/core"
+	"github.com/hdt3213/rdb/crc16"
+	"github.com/hdt3213/rdb/model"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// SlotCount is the number of hash slots in redis cluster
+const SlotCount = 16384
+
+// HashSlot returns the hash slot of key in redis cluster.
+// If key contains a non-empty hashtag like "{user1000}.following", only the hashtag is hashed
+func HashSlot(key string) int {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+	return int(crc16.Checksum([]byte(key))) & (SlotCount - 1)
+}
+
+// SlotRange is a range of hash slots, both Start and End are included
+type SlotRange struct {
+	Start int
+	End   int
+}
+
+// ClusterNode is a master node of redis cluster and the hash slots it serves
+type ClusterNode struct {
+	Name  string
+	Slots []SlotRange
+}
+
+// EqualShards divides all hash slots into n nodes named "shard-0" to "shard-<n-1>"
+func EqualShards(n int) ([]*ClusterNode, error) {
+	if n <= 0 || n > SlotCount {
+		return nil, fmt.Errorf("shard count must be in [1, %d]", SlotCount)
+	}
+	nodes := make([]*ClusterNode, n)
+	for i := 0; i < n; i++ {
+		nodes[i] = &ClusterNode{
+			Name: "shard-" + strconv.Itoa(i),
+			Slots: []SlotRange{{
+				Start: i * SlotCount / n,
+				End:   (i+1)*SlotCount/n - 1,
+			}},
+		}
+	}
+	return nodes, nil
+}
+
+// ParseSlotMap reads slot map, each line contains node name and its slot ranges, such as:
+//
+//	node-a 0-5460
+//	node-b 5461-10922,16000
+//
+// empty lines and lines starting with '#' are ignored
+func ParseSlotMap(reader io.Reader) ([]*ClusterNode, error) {
+	var nodes []*ClusterNode
+	scanner := bufio.NewScanner(reader)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("line %d: node name and slots are required", lineNum)
+		}
+		node := &ClusterNode{Name: fields[0]}
+		for _, field := range fields[1:] {
+			for _, part := range strings.Split(field, ",") {
+				if part == "" {
+					continue
+				}
+				bounds := strings.SplitN(part, "-", 2)
+				start, err := strconv.Atoi(bounds[0])
+				if err != nil {
+					return nil, fmt.Errorf("line %d: illegal slot %s", lineNum, part)
+				}
+				end := start
+				if len(bounds) == 2 {
+					end, err = strconv.Atoi(bounds[1])
+					if err != nil {
+						return nil, fmt.Errorf("line %d: illegal slot %s", lineNum, part)
+					}
+				}
+				node.Slots = append(node.Slots, SlotRange{Start: start, End: end})
+			}
+		}
+		nodes = append(nodes, node)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nodes, nil
+}
+
+// makeSlotTable returns owner node index of each slot, -1 means unassigned
+func makeSlotTable(nodes []*ClusterNode) ([]int, error) {
+	table := make([]int, SlotCount)
+	for i := range table {
+		table[i] = -1
+	}
+	names := make(map[string]struct{})
+	for i, node := range nodes {
+		if node.Name == "" || strings.ContainsAny(node.Name, `/\`) {
+			return nil, fmt.Errorf("illegal node name: %s", node.Name)
+		}
+		if _, ok := names[node.Name]; ok {
+			return nil, fmt.Errorf("duplicate node name: %s", node.Name)
+		}
+		names[node.Name] = struct{}{}
+		for _, r := range node.Slots {
+			if r.Start < 0 || r.End >= SlotCount || r.Start > r.End {
+				return nil, fmt.Errorf("illegal slot range %d-%d of node %s", r.Start, r.End, node.Name)
+			}
+			for slot := r.Start; slot <= r.End; slot++ {
+				if table[slot] >= 0 {
+					return nil, fmt.Errorf("slot %d is assigned to both %s and %s", slot, nodes[table[slot]].Name, node.Name)
+				}
+				table[slot] = i
+			}
+		}
+	}
+	return table, nil
+}
+
+// SplitStat reports the rdb file written for a node
+type SplitStat struct {
+	Name     string
+	Filename string
+	KeyCount int
+	Bytes    int64 // size of rdb file
+}
+
+// SkipOtherDBsOption makes SplitRDB skip keys of databases other than db 0 instead of failing
+type SkipOtherDBsOption bool
+
+// WithSkipOtherDBsOption creates a SkipOtherDBsOption, skipped keys are counted by SplitReportOption
+func WithSkipOtherDBsOption() SkipOtherDBsOption {
+	return true
+}
+
+// SplitReport is filled by SplitRDB with keys skipped since redis cluster only supports db 0
+type SplitReport struct {
+	SkippedKeys map[int]int // number of skipped keys by db index
+}
+
+// SplitReportOption collects SplitReport while splitting
+type SplitReportOption *SplitReport
+
+// WithSplitReportOption creates a SplitReportOption, report will be filled after splitting
+func WithSplitReportOption(report *SplitReport) SplitReportOption {
+	return report
+}
+
+// SplitRDB splits keys of rdb file by hash slot into one rdb file per node in outputDir, named <node name>.rdb.
+// Redis cluster only supports db 0, so it fails on keys of other databases unless SkipOtherDBsOption is given,
+// skipped keys are counted by SplitReportOption. Output files are in rdb version of the source.
+// Aux fields, functions and module aux data are copied into every output file. Filter options are also accepted.
+func SplitRDB(rdbFilename string, outputDir string, nodes []*ClusterNode, options ...interface{}) ([]*SplitStat, error) {
+	if rdbFilename == "" {
+		return nil, errors.New("src file path is required")
+	}
+	if outputDir == "" {
+		return nil, errors.New("output directory is required")
+	}
+	if len(nodes) == 0 {
+		return nil, errors.New("cluster nodes are required")
+	}
+	slotTable, err := makeSlotTable(nodes)
+	if err != nil {
+		return nil, err
+	}
+	report := &SplitReport{}
+	skipOtherDBs := false
+	for _, opt := range options {
+		switch o := opt.(type) {
+		case SplitReportOption:
+			if o != nil {
+				report = o
+			}
+		case SkipOtherDBsOption:
+			skipOtherDBs = bool(o)
+		}
+	}
+	report.SkippedKeys = make(map[int]int)
+	rdbFile, closeInput, err := openInput(rdbFilename)
+	if err != nil {
+		return nil, fmt.Errorf("open rdb %s failed, %v", rdbFilename, err)
+	}
+	defer closeInput()
+	err = os.MkdirAll(outputDir, os.ModePerm)
+	if err != nil {
+		return nil, fmt.Errorf("create output directory %s failed, %v", outputDir, err)
+	}
+
+	stats := make([]*SplitStat, len(nodes))
+	files := make([]*os.File, len(nodes))
+	bufWriters := make([]*bufio.Writer, len(nodes))
+	writers := make([]*rdbWriter, len(nodes))
+	defer func() {
+		for _, f := range files {
+			if f != nil {
+				_ = f.Close()
+			}
+		}
+	}()
+	for i, node := range nodes {
+		stats[i] = &SplitStat{
+			Name:     node.Name,
+			Filename: filepath.Join(outputDir, node.Name+".rdb"),
+		}
+		files[i], err = os.Create(stats[i].Filename)
+		if err != nil {
+			return nil, fmt.Errorf("create output %s failed, %v", stats[i].Filename, err)
+		}
+		bufWriters[i] = bufio.NewWriter(files[i])
+		writers[i] = newRDBWriter(core.NewEncoder(bufWriters[i]))
+	}
+
+	coreDec := core.NewDecoder(rdbFile).WithSpecialOpCode()
+	dec, err := wrapDecoder(coreDec, options...)
+	if err != nil {
+		return nil, err
+	}
+	headerWritten := false
+	writeHeaders := func() error {
+		// version of source rdb is known once its header has been parsed
+		headerWritten = true
+		for _, writer := range writers {
+			err := writer.enc.SetVersion(outputVersion(coreDec.Version())).WriteHeader()
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	var writeErr error
+	err = dec.Parse(func(object model.RedisObject) bool {
+		if !headerWritten {
+			writeErr = writeHeaders()
+			if writeErr != nil {
+				return false
+			}
+		}
+		if object.GetType() == model.DBSizeType {
+			return true // resize hint of whole db is too large for a node
+		}
+		if isSpecialObject(object) {
+			for _, writer := range writers {
+				writeErr = writer.write(object)
+				if writeErr != nil {
+					return false
+				}
+			}
+			return true
+		}
+		if object.GetDBIndex() != 0 {
+			if !skipOtherDBs {
+				writeErr = fmt.Errorf("key %s is in db %d, but redis cluster only supports db 0",
+					object.GetKey(), object.GetDBIndex())
+				return false
+			}
+			report.SkippedKeys[object.GetDBIndex()]++
+			return true
+		}
+		slot := HashSlot(object.GetKey())
+		owner := slotTable[slot]
+		if owner < 0 {
+			writeErr = fmt.Errorf("slot %d of key %s is not assigned to any node", slot, object.GetKey())
+			return false
+		}
+		writeErr = writers[owner].write(object)
+		if writeErr != nil {
+			writeErr = fmt.Errorf("write %s failed: %v", object.GetKey(), writeErr)
+			return false
+		}
+		stats[owner].KeyCount++
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	if writeErr != nil {
+		return nil, writeErr
+	}
+	if !headerWritten {
+		err = writeHeaders()
+		if err != nil {
+			return nil, err
+		}
+	}
+	for i, writer := range writers {
+		err = writer.enc.WriteEnd()
+		if err != nil {
+			return nil, err
+		}
+		err = bufWriters[i].Flush()
+		if err != nil {
+			return nil, err
+		}
+		info, err := files[i].Stat()
+		if err != nil {
+			return nil, err
+		}
+		stats[i].Bytes = info.Size()
+	}
+	return stats, nil
+}
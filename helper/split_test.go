@@ -0,0 +1,86 @@
+package helper
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHashSlot(t *testing.T) {
+	cases := map[string]int{
+		"123456789":            0x31c3,
+		"foo":                  12182,
+		"{user1000}.following": HashSlot("user1000"),
+		"{user1000}.followers": HashSlot("user1000"),
+		"foo{}{bar}":           HashSlot("foo{}{bar}"),
+		"foo{{bar}}zap":        HashSlot("{bar"),
+		"foo{bar}{zap}":        HashSlot("bar"),
+		"{}":                   15257,
+		"":                     0,
+	}
+	for key, expect := range cases {
+		if slot := HashSlot(key); slot != expect {
+			t.Errorf("wrong slot of %s, expect %d, actual %d", key, expect, slot)
+		}
+	}
+}
+
+func TestSlotMap(t *testing.T) {
+	nodes, err := EqualShards(3)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(nodes) != 3 || nodes[0].Slots[0].End != 5460 || nodes[1].Slots[0].Start != 5461 ||
+		nodes[2].Slots[0].End != SlotCount-1 {
+		t.Error("wrong equal shards")
+	}
+	_, err = EqualShards(0)
+	if err == nil {
+		t.Error("expect error")
+	}
+
+	nodes, err = ParseSlotMap(strings.NewReader(`
+# comment
+node-a 0-5460
+node-b 5461-10922,16000
+node-c 10923-15999 16001-16383
+`))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(nodes) != 3 || nodes[1].Name != "node-b" || len(nodes[1].Slots) != 2 ||
+		nodes[1].Slots[1] != (SlotRange{Start: 16000, End: 16000}) || len(nodes[2].Slots) != 2 {
+		t.Errorf("wrong slot map")
+	}
+	table, err := makeSlotTable(nodes)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if table[0] != 0 || table[16000] != 1 || table[16383] != 2 {
+		t.Error("wrong slot table")
+	}
+
+	illegalMaps := []string{
+		"node-a",
+		"node-a x-1",
+		"node-a 1-x",
+	}
+	for _, m := range illegalMaps {
+		if _, err := ParseSlotMap(strings.NewReader(m)); err == nil {
+			t.Errorf("expect error for %s", m)
+		}
+	}
+	illegalNodes := [][]*ClusterNode{
+		{{Name: "a", Slots: []SlotRange{{0, 100}}}, {Name: "b", Slots: []SlotRange{{100, 200}}}},
+		{{Name: "a", Slots: []SlotRange{{0, 100}}}, {Name: "a", Slots: []SlotRange{{101, 200}}}},
+		{{Name: "a", Slots: []SlotRange{{0, SlotCount}}}},
+		{{Name: "a/b", Slots: []SlotRange{{0, 1}}}},
+	}
+	for _, n := range illegalNodes {
+		if _, err := makeSlotTable(n); err == nil {
+			t.Error("expect error")
+		}
+	}
+}
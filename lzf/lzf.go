@@ -18,8 +18,11 @@ var (
 // using https://github.com/zhuyie/golzf according to MIT license
 // Decompress decompress lzf compressed data
 func Decompress(input []byte, inLen int, outLen int) ([]byte, error) {
-	input = input[:inLen]
-	output := make([]byte, outLen)
+	return DecompressTo(make([]byte, outLen), input[:inLen])
+}
+
+// DecompressTo decompresses input into output, returns the decompressed part of output
+func DecompressTo(output []byte, input []byte) ([]byte, error) {
 	var inputIndex, outputIndex int
 
 	inputLength := len(input)
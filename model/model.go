@@ -2,6 +2,7 @@ package model
 
 import (
 	"encoding/json"
+	"strconv"
 	"time"
 )
 
@@ -20,6 +21,36 @@ const (
 	AuxType = "aux"
 	// DBSizeType is for RDB_OPCODE_RESIZEDB
 	DBSizeType = "dbsize"
+	// StreamType is redis stream
+	StreamType = "stream"
+	// ModuleType is redis module value
+	ModuleType = "module"
+	// FunctionsType is for RDB_OPCODE_FUNCTION2, stores a function library
+	FunctionsType = "functions"
+	// ModuleAuxType is for RDB_OPCODE_MODULE_AUX
+	ModuleAuxType = "module-aux"
+)
+
+// Encodings of aggregate types in RDB
+const (
+	// ZipMapEncoding is old compact encoding of hash
+	ZipMapEncoding = "zipmap"
+	// ZipListEncoding is compact encoding of list, hash and sorted set
+	ZipListEncoding = "ziplist"
+	// ListPackEncoding is compact encoding of hash, set and sorted set since redis 7.0
+	ListPackEncoding = "listpack"
+	// QuickListEncoding is encoding of list, a linked list of ziplists
+	QuickListEncoding = "quicklist"
+	// QuickList2Encoding is encoding of list since redis 7.0, a linked list of listpacks
+	QuickList2Encoding = "quicklist2"
+	// LinkedListEncoding is old encoding of list
+	LinkedListEncoding = "linkedlist"
+	// HashTableEncoding is encoding of set and hash
+	HashTableEncoding = "hashtable"
+	// IntSetEncoding is compact encoding of set with integer members
+	IntSetEncoding = "intset"
+	// SkipListEncoding is encoding of sorted set
+	SkipListEncoding = "skiplist"
 )
 
 // CallbackFunc process redis object
@@ -41,6 +72,15 @@ type RedisObject interface {
 	GetElemCount() int
 }
 
+// BaseObjectHolder is implemented by objects embedding *BaseObject, including all objects decoded by this package.
+// It is separated from RedisObject so that other implementations of RedisObject keep working, type-assert it
+type BaseObjectHolder interface {
+	// GetEncoding returns rdb encoding of list/set/hash/zset, such as ziplist or hashtable
+	GetEncoding() string
+	// GetBaseObject returns base object, it could be modified to change key, db or expiration of object
+	GetBaseObject() *BaseObject
+}
+
 // BaseObject is basement of redis object
 type BaseObject struct {
 	DB         int        `json:"db"`                   // DB is db index of redis object
@@ -48,6 +88,9 @@ type BaseObject struct {
 	Expiration *time.Time `json:"expiration,omitempty"` // Expiration is expiration time, expiration of persistent object is nil
 	Size       int        `json:"size"`                 // Size is rdb value size in Byte
 	Type       string     `json:"type"`
+	Encoding   string     `json:"-"` // Encoding is rdb encoding of list/set/hash/zset, such as ziplist or hashtable
+	Idle       *uint64    `json:"-"` // Idle is LRU idle time in seconds, it only exists with maxmemory-policy of LRU
+	Freq       *uint8     `json:"-"` // Freq is LFU frequency counter, it only exists with maxmemory-policy of LFU
 }
 
 // GetKey returns key of object
@@ -75,10 +118,22 @@ func (o *BaseObject) GetElemCount() int {
 	return 0
 }
 
+// GetEncoding returns rdb encoding of list/set/hash/zset, such as ziplist or hashtable
+func (o *BaseObject) GetEncoding() string {
+	return o.Encoding
+}
+
+// GetBaseObject returns base object, it could be modified to change key, db or expiration of object
+func (o *BaseObject) GetBaseObject() *BaseObject {
+	return o
+}
+
 // StringObject stores a string object
 type StringObject struct {
 	*BaseObject
-	Value []byte
+	Value    []byte
+	IsInt    bool  // IsInt means value is an integer-encoded string reported by IntValue, only with core.Decoder.WithRawIntegers
+	IntValue int64 // IntValue is value of integer-encoded string, Value is nil if IsInt
 }
 
 // GetType returns redis object type
@@ -86,6 +141,14 @@ func (o *StringObject) GetType() string {
 	return StringType
 }
 
+// Bytes returns value of string, integer-encoded value reported by IntValue is formatted in decimal
+func (o *StringObject) Bytes() []byte {
+	if o.IsInt {
+		return strconv.AppendInt(nil, o.IntValue, 10)
+	}
+	return o.Value
+}
+
 // MarshalJSON marshal []byte as string
 func (o *StringObject) MarshalJSON() ([]byte, error) {
 	o2 := struct {
@@ -93,7 +156,7 @@ func (o *StringObject) MarshalJSON() ([]byte, error) {
 		Value string `json:"value"`
 	}{
 		BaseObject: o.BaseObject,
-		Value:      string(o.Value),
+		Value:      string(o.Bytes()),
 	}
 	return json.Marshal(o2)
 }
@@ -134,6 +197,8 @@ func (o *ListObject) MarshalJSON() ([]byte, error) {
 type HashObject struct {
 	*BaseObject
 	Hash map[string][]byte
+	// FieldExpirations is expiration time of fields set by commands like HEXPIRE of redis 7.4, nil if no field expires
+	FieldExpirations map[string]time.Time
 }
 
 // GetType returns redis object type
@@ -154,10 +219,12 @@ func (o *HashObject) MarshalJSON() ([]byte, error) {
 	}
 	o2 := struct {
 		*BaseObject
-		Hash map[string]string `json:"hash"`
+		Hash             map[string]string    `json:"hash"`
+		FieldExpirations map[string]time.Time `json:"fieldExpirations,omitempty"`
 	}{
-		BaseObject: o.BaseObject,
-		Hash:       m,
+		BaseObject:       o.BaseObject,
+		Hash:             m,
+		FieldExpirations: o.FieldExpirations,
 	}
 	return json.Marshal(o2)
 }
@@ -250,3 +317,128 @@ type DBSizeObject struct {
 func (o *DBSizeObject) GetType() string {
 	return DBSizeType
 }
+
+// StreamID is the id of a stream message
+type StreamID struct {
+	Ms  uint64
+	Seq uint64
+}
+
+// String returns id in <ms>-<seq> format
+func (id StreamID) String() string {
+	return strconv.FormatUint(id.Ms, 10) + "-" + strconv.FormatUint(id.Seq, 10)
+}
+
+// MarshalJSON marshal id as <ms>-<seq> string
+func (id StreamID) MarshalJSON() ([]byte, error) {
+	return json.Marshal(id.String())
+}
+
+// StreamMessage is a message in stream, Fields[i] is the field of Values[i]
+type StreamMessage struct {
+	ID     StreamID `json:"id"`
+	Fields []string `json:"fields"`
+	Values []string `json:"values"`
+}
+
+// StreamPendingEntry is a delivered but not acknowledged message of consumer group
+type StreamPendingEntry struct {
+	ID            StreamID `json:"id"`
+	DeliveryTime  uint64   `json:"deliveryTime"` // DeliveryTime is unix timestamp in millisecond of last delivery
+	DeliveryCount uint64   `json:"deliveryCount"`
+}
+
+// StreamConsumer is a consumer of consumer group
+type StreamConsumer struct {
+	Name       string     `json:"name"`
+	SeenTime   uint64     `json:"seenTime"`             // SeenTime is unix timestamp in millisecond of last interaction
+	ActiveTime uint64     `json:"activeTime,omitempty"` // ActiveTime is unix timestamp in millisecond of last successful interaction, since RDB_TYPE_STREAM_LISTPACKS_3
+	Pending    []StreamID `json:"pending"`              // Pending must be a subset of pending entries of group
+}
+
+// StreamGroup is a consumer group of stream
+type StreamGroup struct {
+	Name        string                `json:"name"`
+	LastID      StreamID              `json:"lastId"`
+	EntriesRead uint64                `json:"entriesRead,omitempty"` // since RDB_TYPE_STREAM_LISTPACKS_2
+	Pending     []*StreamPendingEntry `json:"pending"`
+	Consumers   []*StreamConsumer     `json:"consumers"`
+}
+
+// StreamObject stores a stream object
+type StreamObject struct {
+	*BaseObject
+	Messages     []*StreamMessage `json:"messages"` // Messages are ordered by id
+	LastID       StreamID         `json:"lastId"`
+	FirstID      StreamID         `json:"firstId"`      // since RDB_TYPE_STREAM_LISTPACKS_2
+	MaxDeletedID StreamID         `json:"maxDeletedId"` // since RDB_TYPE_STREAM_LISTPACKS_2
+	EntriesAdded uint64           `json:"entriesAdded"` // since RDB_TYPE_STREAM_LISTPACKS_2
+	Groups       []*StreamGroup   `json:"groups"`
+}
+
+// GetType returns redis object type
+func (o *StreamObject) GetType() string {
+	return StreamType
+}
+
+// GetElemCount returns number of messages in stream
+func (o *StreamObject) GetElemCount() int {
+	return len(o.Messages)
+}
+
+// ModuleObject stores a value of module type as an opaque blob
+type ModuleObject struct {
+	*BaseObject
+	ModuleID   uint64 `json:"moduleId"`
+	ModuleName string `json:"moduleName"`
+	// Value is serialized by module in RDB module opcode format, including the trailing RDB_MODULE_OPCODE_EOF
+	Value []byte `json:"value"`
+}
+
+// GetType returns redis object type
+func (o *ModuleObject) GetType() string {
+	return ModuleType
+}
+
+// FunctionsObject stores a function library
+type FunctionsObject struct {
+	*BaseObject
+	Code string `json:"code"`
+}
+
+// GetType returns redis object type
+func (o *FunctionsObject) GetType() string {
+	return FunctionsType
+}
+
+// ModuleAuxObject stores module auxiliary data
+type ModuleAuxObject struct {
+	*BaseObject
+	ModuleID   uint64 `json:"moduleId"`
+	ModuleName string `json:"moduleName"`
+	When       uint64 `json:"when"` // When is REDISMODULE_AUX_BEFORE_RDB or REDISMODULE_AUX_AFTER_RDB
+	// Value is serialized by module in RDB module opcode format, including the trailing RDB_MODULE_OPCODE_EOF
+	Value []byte `json:"value"`
+}
+
+// GetType returns redis object type
+func (o *ModuleAuxObject) GetType() string {
+	return ModuleAuxType
+}
+
+// SizedObject stores a key whose value is skipped without decoding, only its type, encoding, size and number of
+// elements are known, see core.Decoder.WithSizeOnly
+type SizedObject struct {
+	*BaseObject
+	ElemCount int `json:"elemCount"`
+}
+
+// GetType returns redis object type
+func (o *SizedObject) GetType() string {
+	return o.Type
+}
+
+// GetElemCount returns number of elements in list/set/hash/zset
+func (o *SizedObject) GetElemCount() int {
+	return o.ElemCount
+}
@@ -21,6 +21,14 @@ const (
 	AuxType = model.AuxType
 	// DBSizeType is for RDB_OPCODE_RESIZEDB
 	DBSizeType = model.DBSizeType
+	// StreamType is redis stream
+	StreamType = model.StreamType
+	// ModuleType is redis module value
+	ModuleType = model.ModuleType
+	// FunctionsType is for RDB_OPCODE_FUNCTION2, stores a function library
+	FunctionsType = model.FunctionsType
+	// ModuleAuxType is for RDB_OPCODE_MODULE_AUX
+	ModuleAuxType = model.ModuleAuxType
 )
 
 type (
@@ -40,6 +48,14 @@ type (
 	AuxObject = model.AuxObject
 	// DBSizeObject stores db size metadata
 	DBSizeObject = model.DBSizeObject
+	// StreamObject stores a stream object
+	StreamObject = model.StreamObject
+	// ModuleObject stores a value of module type as an opaque blob
+	ModuleObject = model.ModuleObject
+	// FunctionsObject stores a function library
+	FunctionsObject = model.FunctionsObject
+	// ModuleAuxObject stores module auxiliary data
+	ModuleAuxObject = model.ModuleAuxObject
 )
 
 var (
@@ -2,12 +2,22 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
 	"github.com/hdt3213/rdb/core"
+	"github.com/hdt3213/rdb/crc64jones"
 	"github.com/hdt3213/rdb/helper"
 	"github.com/hdt3213/rdb/model"
+	"io"
+	"math"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 )
@@ -303,6 +313,37 @@ func TestToAofWithRegex(t *testing.T) {
 	}
 }
 
+func TestToAofWithMultiDB(t *testing.T) {
+	err := os.MkdirAll("tmp", os.ModePerm)
+	if err != nil {
+		return
+	}
+	defer func() {
+		err := os.RemoveAll("tmp")
+		if err != nil {
+			t.Logf("remove tmp directory failed: %v", err)
+		}
+	}()
+	actualFile := filepath.Join("tmp", "multiple_databases.aof")
+	err = helper.ToAOF(filepath.Join("cases", "multiple_databases.rdb"), actualFile,
+		helper.WithBatchOption(100, 0), helper.WithTransactionOption())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	data, err := os.ReadFile(actualFile)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	expect := "*3\r\n$3\r\nSET\r\n$22\r\nkey_in_zeroth_database\r\n$4\r\nzero\r\n" +
+		"*2\r\n$6\r\nSELECT\r\n$1\r\n2\r\n" +
+		"*3\r\n$3\r\nSET\r\n$22\r\nkey_in_second_database\r\n$6\r\nsecond\r\n"
+	if string(data) != expect {
+		t.Errorf("wrong aof: %q", data)
+	}
+}
+
 func TestFindLargestKeys(t *testing.T) {
 	err := os.MkdirAll("tmp", os.ModePerm)
 	if err != nil {
@@ -437,7 +478,7 @@ func TestFlameGraph(t *testing.T) {
 
 func TestFlameGraphWithRegex(t *testing.T) {
 	srcRdb := filepath.Join("cases", "tree.rdb")
-	stop, err := helper.FlameGraph(srcRdb, 18888, nil, helper.WithRegexOption("^l.*"))
+	stop, err := helper.FlameGraph(srcRdb, 18889, nil, helper.WithRegexOption("^l.*"))
 	if err != nil {
 		t.Errorf("FindLargestKeys failed: %v", err)
 	}
@@ -506,3 +547,1847 @@ func TestWithSpecialObject(t *testing.T) {
 		t.Error("wrong db size object count")
 	}
 }
+
+func TestFilterRDB(t *testing.T) {
+	err := os.MkdirAll("tmp", os.ModePerm)
+	if err != nil {
+		return
+	}
+	defer func() {
+		err := os.RemoveAll("tmp")
+		if err != nil {
+			t.Logf("remove tmp directory failed: %v", err)
+		}
+	}()
+	decodeKeys := func(filename string) (map[string]model.RedisObject, int) {
+		f, err := os.Open(filename)
+		if err != nil {
+			t.Errorf("open %s failed: %v", filename, err)
+			return nil, 0
+		}
+		defer func() {
+			_ = f.Close()
+		}()
+		objects := make(map[string]model.RedisObject)
+		auxCount := 0
+		var hintCount uint64
+		err = core.NewDecoder(f).WithSpecialOpCode().Parse(func(object model.RedisObject) bool {
+			switch o := object.(type) {
+			case *model.AuxObject:
+				auxCount++
+			case *model.DBSizeObject:
+				hintCount += o.KeyCount
+			default:
+				objects[object.GetKey()] = object
+			}
+			return true
+		})
+		if err != nil {
+			t.Errorf("decode %s failed: %v", filename, err)
+		}
+		// resize db hint of source must not be copied since keys are filtered out
+		if hintCount > uint64(len(objects)) {
+			t.Errorf("resize db hint %d exceeds %d keys of %s", hintCount, len(objects), filename)
+		}
+		return objects, auxCount
+	}
+
+	srcRdb := filepath.Join("cases", "memory.rdb")
+	actualFile := filepath.Join("tmp", "memory_filter.rdb")
+	err = helper.FilterRDB(srcRdb, actualFile, helper.WithRegexOption("^l.*"))
+	if err != nil {
+		t.Errorf("error occurs during filter, err: %v", err)
+		return
+	}
+	objects, auxCount := decodeKeys(actualFile)
+	if len(objects) != 2 || objects["list"] == nil || objects["large"] == nil {
+		t.Errorf("wrong keys after filter: %v", objects)
+	}
+	if auxCount != 5 {
+		t.Errorf("expect 5 aux fields, actual: %d", auxCount)
+	}
+
+	err = helper.FilterRDB(srcRdb, actualFile, helper.WithExpireStateOption(helper.ExpiredKeys))
+	if err != nil {
+		t.Errorf("error occurs during filter, err: %v", err)
+		return
+	}
+	objects, _ = decodeKeys(actualFile)
+	if len(objects) != 1 || objects["e"] == nil || objects["e"].GetExpiration() == nil {
+		t.Errorf("wrong keys after filter: %v", objects)
+	}
+
+	err = helper.FilterRDB(srcRdb, actualFile, helper.WithTypeOption(model.HashType, model.ZSetType),
+		helper.WithSizeOption(60, 0))
+	if err != nil {
+		t.Errorf("error occurs during filter, err: %v", err)
+		return
+	}
+	objects, _ = decodeKeys(actualFile)
+	if len(objects) != 1 || objects["hash"] == nil {
+		t.Errorf("wrong keys after filter: %v", objects)
+	}
+
+	err = helper.FilterRDB(filepath.Join("cases", "multiple_databases.rdb"), actualFile, helper.WithDBOption(2))
+	if err != nil {
+		t.Errorf("error occurs during filter, err: %v", err)
+		return
+	}
+	objects, _ = decodeKeys(actualFile)
+	if len(objects) != 1 || objects["key_in_second_database"] == nil ||
+		objects["key_in_second_database"].GetDBIndex() != 2 {
+		t.Errorf("wrong keys after filter: %v", objects)
+	}
+
+	// version and compact encodings of redis 7 are kept
+	srcRdb = filepath.Join("tmp", "listpack.rdb")
+	srcFile, err := os.Create(srcRdb)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	enc := core.NewEncoder(srcFile).SetVersion(11)
+	errs := []error{
+		enc.WriteHeader(),
+		enc.WriteDBHeader(0, 4, 0),
+		enc.WriteListObject("list", [][]byte{[]byte("a"), []byte("1")}),
+		enc.WriteSetObject("set", [][]byte{[]byte("a"), []byte("b")}, core.WithEncoding(model.ListPackEncoding)),
+		enc.WriteHashMapObject("hash", map[string][]byte{"f": []byte("v")}),
+		enc.WriteZSetObject("zset", []*model.ZSetEntry{{Member: "m", Score: 1}}),
+		enc.WriteEnd(),
+		srcFile.Close(),
+	}
+	for _, err := range errs {
+		if err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	err = helper.FilterRDB(srcRdb, actualFile)
+	if err != nil {
+		t.Errorf("error occurs during filter, err: %v", err)
+		return
+	}
+	data, err := os.ReadFile(actualFile)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if string(data[:9]) != "REDIS0011" {
+		t.Errorf("expect version 11, actual %s", data[:9])
+	}
+	objects, _ = decodeKeys(actualFile)
+	for key, encoding := range map[string]string{
+		"list": model.QuickList2Encoding,
+		"set":  model.ListPackEncoding,
+		"hash": model.ListPackEncoding,
+		"zset": model.ListPackEncoding,
+	} {
+		if objects[key] == nil || objects[key].(model.BaseObjectHolder).GetEncoding() != encoding {
+			t.Errorf("%s should be kept in %s encoding", key, encoding)
+		}
+	}
+
+	err = helper.FilterRDB(srcRdb, actualFile, helper.WithExpireStateOption("unknown"))
+	if err == nil {
+		t.Error("expect error")
+	}
+	err = helper.FilterRDB(srcRdb, "")
+	if err == nil {
+		t.Error("expect error")
+	}
+	err = helper.FilterRDB("", actualFile)
+	if err == nil {
+		t.Error("expect error")
+	}
+}
+
+func TestMergeRDB(t *testing.T) {
+	err := os.MkdirAll("tmp", os.ModePerm)
+	if err != nil {
+		return
+	}
+	defer func() {
+		err := os.RemoveAll("tmp")
+		if err != nil {
+			t.Logf("remove tmp directory failed: %v", err)
+		}
+	}()
+	now := uint64(time.Now().Add(time.Hour).Unix() * 1000)
+	writeRDB := func(filename string, dbs map[uint]map[string]uint64) {
+		f, err := os.Create(filename)
+		if err != nil {
+			t.Errorf("create %s failed: %v", filename, err)
+			return
+		}
+		defer func() {
+			_ = f.Close()
+		}()
+		enc := core.NewEncoder(f)
+		if err = enc.WriteHeader(); err != nil {
+			t.Error(err)
+			return
+		}
+		if err = enc.WriteAux("origin", filename); err != nil {
+			t.Error(err)
+			return
+		}
+		for db := uint(0); db < 16; db++ {
+			keys := dbs[db]
+			if len(keys) == 0 {
+				continue
+			}
+			if err = enc.WriteDBHeader(db, uint64(len(keys)), 0); err != nil {
+				t.Error(err)
+				return
+			}
+			for key, expiration := range keys {
+				var options []interface{}
+				if expiration > 0 {
+					options = append(options, core.WithTTL(expiration))
+				}
+				if err = enc.WriteStringObject(key, []byte(filename), options...); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}
+		if err = enc.WriteEnd(); err != nil {
+			t.Error(err)
+		}
+	}
+	a := filepath.Join("tmp", "a.rdb")
+	b := filepath.Join("tmp", "b.rdb")
+	writeRDB(a, map[uint]map[string]uint64{
+		0: {"a": 0, "both": now + 1000, "persistent": 0},
+		1: {"a1": 0},
+	})
+	writeRDB(b, map[uint]map[string]uint64{
+		0: {"b": 0, "both": now, "persistent": now},
+		2: {"b2": 0},
+	})
+	readRDB := func(filename string) (map[string]string, map[string]int, []string) {
+		f, err := os.Open(filename)
+		if err != nil {
+			t.Errorf("open %s failed: %v", filename, err)
+			return nil, nil, nil
+		}
+		defer func() {
+			_ = f.Close()
+		}()
+		values := make(map[string]string)
+		dbs := make(map[string]int)
+		var aux []string
+		err = core.NewDecoder(f).WithSpecialOpCode().Parse(func(object model.RedisObject) bool {
+			switch o := object.(type) {
+			case *model.AuxObject:
+				aux = append(aux, o.Value)
+			case *model.StringObject:
+				values[o.Key] = string(o.Value)
+				dbs[o.Key] = o.DB
+			}
+			return true
+		})
+		if err != nil {
+			t.Errorf("decode %s failed: %v", filename, err)
+		}
+		return values, dbs, aux
+	}
+
+	output := filepath.Join("tmp", "merged.rdb")
+	conflicts, err := helper.MergeRDB([]string{a, b}, output)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(conflicts) != 2 {
+		t.Errorf("expect 2 conflicts, actual: %d", len(conflicts))
+	}
+	for _, conflict := range conflicts {
+		if conflict.Winner != a || len(conflict.Files) != 2 {
+			t.Errorf("wrong conflict: %+v", conflict)
+		}
+	}
+	values, dbs, aux := readRDB(output)
+	expectDBs := map[string]int{"a": 0, "b": 0, "both": 0, "persistent": 0, "a1": 1, "b2": 2}
+	if len(values) != len(expectDBs) {
+		t.Errorf("wrong keys after merge: %v", values)
+	}
+	for key, db := range expectDBs {
+		if dbs[key] != db {
+			t.Errorf("key %s should be in db %d", key, db)
+		}
+	}
+	if values["both"] != a || values["persistent"] != a || values["b2"] != b {
+		t.Errorf("wrong values after merge: %v", values)
+	}
+	if len(aux) != 1 || aux[0] != a {
+		t.Errorf("wrong aux after merge: %v", aux)
+	}
+
+	_, err = helper.MergeRDB([]string{a, b}, output, helper.WithConflictPolicyOption(helper.LastWins))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	values, _, _ = readRDB(output)
+	if values["both"] != b || values["persistent"] != b {
+		t.Errorf("wrong values after merge: %v", values)
+	}
+
+	_, err = helper.MergeRDB([]string{b, a}, output, helper.WithConflictPolicyOption(helper.NewestExpirationWins))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	values, _, _ = readRDB(output)
+	if values["both"] != a || values["persistent"] != a {
+		t.Errorf("wrong values after merge: %v", values)
+	}
+
+	conflicts, err = helper.MergeRDB([]string{a, b}, output, helper.WithConflictPolicyOption(helper.ErrorOnConflict))
+	if err == nil || len(conflicts) != 1 {
+		t.Error("expect conflict error")
+	}
+	_, err = helper.MergeRDB([]string{a, b}, output, helper.WithConflictPolicyOption("unknown"))
+	if err == nil {
+		t.Error("expect error")
+	}
+	_, err = helper.MergeRDB(nil, output)
+	if err == nil {
+		t.Error("expect error")
+	}
+	_, err = helper.MergeRDB([]string{a, filepath.Join("tmp", "none.rdb")}, output)
+	if err == nil {
+		t.Error("expect error")
+	}
+	// module aux data loaded after keyspace stays after keyspace
+	c := filepath.Join("tmp", "c.rdb")
+	f, err := os.Create(c)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	enc := core.NewEncoder(f).SetVersion(11)
+	errs := []error{
+		enc.WriteHeader(),
+		enc.WriteModuleAux(1, core.ModuleAuxBeforeRDB, []byte{0}),
+		enc.WriteDBHeader(3, 1, 0),
+		enc.WriteStringObject("c3", []byte(c)),
+		enc.WriteModuleAux(1, core.ModuleAuxAfterRDB, []byte{0}),
+		enc.WriteEnd(),
+		f.Close(),
+	}
+	for _, err := range errs {
+		if err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	_, err = helper.MergeRDB([]string{a, c}, output)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	f, err = os.Open(output)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	var order []string
+	dec := core.NewDecoder(f).WithSpecialOpCode()
+	err = dec.Parse(func(object model.RedisObject) bool {
+		switch o := object.(type) {
+		case *model.ModuleAuxObject:
+			order = append(order, fmt.Sprintf("aux-%d", o.When))
+		case *model.StringObject:
+			if len(order) == 0 || order[len(order)-1] != "keys" {
+				order = append(order, "keys")
+			}
+		}
+		return true
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	expect := fmt.Sprintf("aux-%d keys aux-%d", core.ModuleAuxBeforeRDB, core.ModuleAuxAfterRDB)
+	if strings.Join(order, " ") != expect {
+		t.Errorf("expect %s, actual %s", expect, strings.Join(order, " "))
+	}
+	// output keeps version of the newest source
+	if dec.Version() != 11 {
+		t.Errorf("expect version 11, actual %d", dec.Version())
+	}
+}
+
+func TestSplitRDB(t *testing.T) {
+	err := os.MkdirAll("tmp", os.ModePerm)
+	if err != nil {
+		return
+	}
+	defer func() {
+		err := os.RemoveAll("tmp")
+		if err != nil {
+			t.Logf("remove tmp directory failed: %v", err)
+		}
+	}()
+	nodes, err := helper.EqualShards(3)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	outputDir := filepath.Join("tmp", "split")
+	stats, err := helper.SplitRDB(filepath.Join("cases", "memory.rdb"), outputDir, nodes)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	keyCount := 0
+	for i, stat := range stats {
+		f, err := os.Open(stat.Filename)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		auxCount, count := 0, 0
+		dec := core.NewDecoder(f).WithSpecialOpCode()
+		err = dec.Parse(func(object model.RedisObject) bool {
+			switch object.GetType() {
+			case model.AuxType:
+				auxCount++
+			case model.DBSizeType:
+			default:
+				count++
+				slot := helper.HashSlot(object.GetKey())
+				if slot < nodes[i].Slots[0].Start || slot > nodes[i].Slots[0].End {
+					t.Errorf("key %s should not be in %s", object.GetKey(), stat.Name)
+				}
+			}
+			return true
+		})
+		_ = f.Close()
+		if err != nil {
+			t.Error(err)
+		}
+		if count != stat.KeyCount {
+			t.Errorf("wrong key count of %s", stat.Name)
+		}
+		if dec.Version() != 9 {
+			t.Errorf("expect version 9 of %s, actual %d", stat.Name, dec.Version())
+		}
+		if auxCount != 5 {
+			t.Errorf("expect 5 aux fields in %s", stat.Name)
+		}
+		if info, _ := os.Stat(stat.Filename); info == nil || info.Size() != stat.Bytes {
+			t.Errorf("wrong size of %s", stat.Name)
+		}
+		keyCount += count
+	}
+	if keyCount != 7 {
+		t.Errorf("expect 7 keys, actual: %d", keyCount)
+	}
+
+	multiDB := filepath.Join("cases", "multiple_databases.rdb")
+	_, err = helper.SplitRDB(multiDB, outputDir, nodes)
+	if err == nil {
+		t.Error("expect error for keys out of db 0")
+	}
+	report := &helper.SplitReport{}
+	stats, err = helper.SplitRDB(multiDB, outputDir, nodes, helper.WithSkipOtherDBsOption(),
+		helper.WithSplitReportOption(report))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if stats[0].KeyCount+stats[1].KeyCount+stats[2].KeyCount != 1 {
+		t.Error("keys in db 0 should be kept")
+	}
+	if len(report.SkippedKeys) != 1 || report.SkippedKeys[2] != 1 {
+		t.Errorf("wrong skipped keys: %v", report.SkippedKeys)
+	}
+	stats, err = helper.SplitRDB(multiDB, outputDir, nodes, helper.WithDBOption(0))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if stats[0].KeyCount+stats[1].KeyCount+stats[2].KeyCount != 1 {
+		t.Error("wrong key count")
+	}
+	partial := []*helper.ClusterNode{{Name: "a", Slots: []helper.SlotRange{{Start: 0, End: 100}}}}
+	_, err = helper.SplitRDB(filepath.Join("cases", "memory.rdb"), outputDir, partial)
+	if err == nil {
+		t.Error("expect error for unassigned slot")
+	}
+}
+
+func TestRewriteRDB(t *testing.T) {
+	err := os.MkdirAll("tmp", os.ModePerm)
+	if err != nil {
+		return
+	}
+	defer func() {
+		err := os.RemoveAll("tmp")
+		if err != nil {
+			t.Logf("remove tmp directory failed: %v", err)
+		}
+	}()
+	decodeKeys := func(filename string) map[string]int {
+		f, err := os.Open(filename)
+		if err != nil {
+			t.Errorf("open %s failed: %v", filename, err)
+			return nil
+		}
+		defer func() {
+			_ = f.Close()
+		}()
+		keys := make(map[string]int)
+		err = core.NewDecoder(f).Parse(func(object model.RedisObject) bool {
+			keys[object.GetKey()] = object.GetDBIndex()
+			return true
+		})
+		if err != nil {
+			t.Errorf("decode %s failed: %v", filename, err)
+		}
+		return keys
+	}
+
+	srcRdb := filepath.Join("cases", "memory.rdb")
+	actualFile := filepath.Join("tmp", "rewrite.rdb")
+	replaceRule, err := helper.RegexReplaceRule("^l(.*)$", "L:$1")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	plan, err := helper.RewriteRDB(srcRdb, actualFile, helper.WithRewriteOption(replaceRule, helper.AddPrefixRule("t1:")))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(plan.Changes) != 7 || len(plan.Collisions) != 0 {
+		t.Errorf("wrong plan: %+v", plan)
+	}
+	keys := decodeKeys(actualFile)
+	for _, key := range []string{"t1:hash", "t1:s", "t1:e", "t1:L:ist", "t1:zset", "t1:L:arge", "t1:set"} {
+		if _, ok := keys[key]; !ok {
+			t.Errorf("key %s not found", key)
+		}
+	}
+
+	multiDB := filepath.Join("cases", "multiple_databases.rdb")
+	plan, err = helper.RewriteRDB(multiDB, actualFile, helper.WithRewriteOption(helper.MoveDBRule(0, 1), helper.MoveDBRule(2, 0)))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	keys = decodeKeys(actualFile)
+	if len(keys) != 2 || keys["key_in_zeroth_database"] != 1 || keys["key_in_second_database"] != 0 {
+		t.Errorf("wrong keys after rewrite: %v", keys)
+	}
+
+	// filter command works as rewrite with rules
+	err = helper.FilterRDB(multiDB, actualFile, helper.WithDBOption(2), helper.WithRewriteOption(helper.MoveDBRule(2, 0)))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	keys = decodeKeys(actualFile)
+	if len(keys) != 1 || keys["key_in_second_database"] != 0 {
+		t.Errorf("wrong keys after rewrite: %v", keys)
+	}
+
+	collideRule, _ := helper.RegexReplaceRule("^[a-z]$", "s")
+	plan, err = helper.PlanRewrite(srcRdb, helper.WithRewriteOption(collideRule))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(plan.Collisions) != 1 || plan.Collisions[0].Key != "s" || len(plan.Collisions[0].Sources) != 2 {
+		t.Errorf("wrong collisions: %+v", plan.Collisions)
+	}
+	_, err = helper.RewriteRDB(srcRdb, actualFile, helper.WithRewriteOption(collideRule))
+	if err == nil {
+		t.Error("expect collision error")
+	}
+
+	aofFile := filepath.Join("tmp", "rewrite.aof")
+	err = helper.ToAOF(srcRdb, aofFile, helper.WithRegexOption("^s$"), helper.WithRewriteOption(helper.AddPrefixRule("t1:")))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	aof, err := os.ReadFile(aofFile)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !strings.Contains(string(aof), "t1:s") {
+		t.Errorf("key is not renamed in aof: %s", aof)
+	}
+}
+
+func TestRewriteExpiration(t *testing.T) {
+	err := os.MkdirAll("tmp", os.ModePerm)
+	if err != nil {
+		return
+	}
+	defer func() {
+		err := os.RemoveAll("tmp")
+		if err != nil {
+			t.Logf("remove tmp directory failed: %v", err)
+		}
+	}()
+	srcRdb := filepath.Join("cases", "memory.rdb")
+	actualFile := filepath.Join("tmp", "expiration.rdb")
+	err = helper.FilterRDB(srcRdb, actualFile, helper.WithDropExpiredOption(time.Time{}))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	f, err := os.Open(actualFile)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	keyCount := 0
+	err = core.NewDecoder(f).Parse(func(object model.RedisObject) bool {
+		keyCount++
+		if object.GetKey() == "e" {
+			t.Error("expired key should be dropped")
+		}
+		return true
+	})
+	_ = f.Close()
+	if err != nil {
+		t.Error(err)
+	}
+	if keyCount != 6 {
+		t.Errorf("expect 6 keys, actual: %d", keyCount)
+	}
+
+	// rebase by ctime aux field, key e expires about 12 days after snapshot
+	jsonFile := filepath.Join("tmp", "expiration.json")
+	err = helper.ToJsons(srcRdb, jsonFile, helper.WithRebaseExpirationOption(time.Time{}),
+		helper.WithDropExpiredOption(time.Time{}), helper.WithRegexOption("^e$"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	data, err := os.ReadFile(jsonFile)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	var objects []map[string]interface{}
+	err = json.Unmarshal(data, &objects)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(objects) != 1 || objects[0]["key"] != "e" {
+		t.Errorf("wrong json: %s", data)
+		return
+	}
+	expiration, err := time.Parse(time.RFC3339, objects[0]["expiration"].(string))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if ttl := time.Until(expiration); ttl < 11*24*time.Hour || ttl > 13*24*time.Hour {
+		t.Errorf("wrong ttl after rebase: %v", ttl)
+	}
+}
+
+func TestAnonymize(t *testing.T) {
+	err := os.MkdirAll("tmp", os.ModePerm)
+	if err != nil {
+		return
+	}
+	defer func() {
+		err := os.RemoveAll("tmp")
+		if err != nil {
+			t.Logf("remove tmp directory failed: %v", err)
+		}
+	}()
+	// describe returns type, encoding and element count of each key, in order of rdb
+	describe := func(filename string) []string {
+		f, err := os.Open(filename)
+		if err != nil {
+			t.Errorf("open %s failed: %v", filename, err)
+			return nil
+		}
+		defer func() {
+			_ = f.Close()
+		}()
+		var result []string
+		err = core.NewDecoder(f).Parse(func(object model.RedisObject) bool {
+			result = append(result, fmt.Sprintf("%s %s %d %d", object.GetType(), object.(model.BaseObjectHolder).GetEncoding(),
+				object.GetElemCount(), len(object.GetKey())))
+			return true
+		})
+		if err != nil {
+			t.Errorf("decode %s failed: %v", filename, err)
+		}
+		return result
+	}
+	rule := &helper.AnonymizeRule{Pattern: "^(.*)$", Mode: helper.AnonymizeFake}
+	for _, name := range []string{"memory", "intset_64", "ziplist_with_integers", "non_ascii_values", "hash_as_ziplist"} {
+		srcRdb := filepath.Join("cases", name+".rdb")
+		actualFile := filepath.Join("tmp", name+".rdb")
+		err = helper.FilterRDB(srcRdb, actualFile, helper.WithAnonymizeOption("secret", rule))
+		if err != nil {
+			t.Errorf("anonymize %s failed: %v", name, err)
+			continue
+		}
+		expect := describe(srcRdb)
+		actual := describe(actualFile)
+		if strings.Join(expect, "\n") != strings.Join(actual, "\n") {
+			t.Errorf("structure of %s is changed, expect:\n%v\nactual:\n%v", name, expect, actual)
+		}
+	}
+
+	jsonFile := filepath.Join("tmp", "anonymize.json")
+	err = helper.ToJsons(filepath.Join("cases", "memory.rdb"), jsonFile, helper.WithRegexOption("^s$"),
+		helper.WithAnonymizeOption("secret", rule))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	data, err := os.ReadFile(jsonFile)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if strings.Contains(string(data), "aaaaaaa") || strings.Contains(string(data), `"key":"s"`) {
+		t.Errorf("json is not anonymized: %s", data)
+	}
+	err = helper.ToAOF(filepath.Join("cases", "memory.rdb"), filepath.Join("tmp", "anonymize.aof"),
+		helper.WithAnonymizeOption(""))
+	if err == nil {
+		t.Error("expect error for empty secret")
+	}
+}
+
+func TestSample(t *testing.T) {
+	err := os.MkdirAll("tmp", os.ModePerm)
+	if err != nil {
+		return
+	}
+	defer func() {
+		err := os.RemoveAll("tmp")
+		if err != nil {
+			t.Logf("remove tmp directory failed: %v", err)
+		}
+	}()
+	srcRdb := filepath.Join("cases", "memory.rdb")
+	report := &helper.SampleReport{}
+	csvFile := filepath.Join("tmp", "memory_sample.csv")
+	err = helper.MemoryProfile(srcRdb, csvFile, helper.WithReservoirOption(3, 1), helper.WithSampleReportOption(report))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	data, err := os.ReadFile(csvFile)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if lines := strings.Count(string(data), "\n"); lines != 4 {
+		t.Errorf("expect header and 3 keys, actual: %s", data)
+	}
+	if report.ScannedKeys != 7 || report.SampledKeys != 3 || report.EstimateKeys("") != 7 {
+		t.Errorf("wrong report: %+v", report)
+	}
+
+	rdbFile := filepath.Join("tmp", "sample.rdb")
+	err = helper.FilterRDB(srcRdb, rdbFile, helper.WithSampleOption(0.5, 1))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = helper.FindBiggestKeys(srcRdb, 3, os.Stdout, helper.WithSampleOption(0.5, 1), helper.WithSampleReportOption(report))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if report.ScannedKeys != 7 {
+		t.Errorf("wrong report: %+v", report)
+	}
+}
+
+func TestDowngradeRDB(t *testing.T) {
+	err := os.MkdirAll("tmp", os.ModePerm)
+	if err != nil {
+		return
+	}
+	defer func() {
+		err := os.RemoveAll("tmp")
+		if err != nil {
+			t.Logf("remove tmp directory failed: %v", err)
+		}
+	}()
+	src := filepath.Join("tmp", "v11.rdb")
+	f, err := os.Create(src)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	enc := core.NewEncoder(f).SetVersion(11)
+	err = enc.WriteHeader()
+	if err == nil {
+		err = enc.WriteFunction("#!lua name=mylib\nredis.register_function('f', function() return 1 end)")
+	}
+	if err == nil {
+		err = enc.WriteDBHeader(0, 4, 0)
+	}
+	if err == nil {
+		err = enc.WriteHashMapObject("hash", map[string][]byte{"a": []byte("1")})
+	}
+	if err == nil {
+		err = enc.WriteListObject("list", [][]byte{[]byte("a"), []byte("1")})
+	}
+	if err == nil {
+		err = enc.WriteZSetObject("zset", []*model.ZSetEntry{{Member: "a", Score: 1}})
+	}
+	if err == nil {
+		err = enc.WriteStreamObject("stream", &model.StreamObject{
+			LastID: model.StreamID{Ms: 1, Seq: 0},
+			Messages: []*model.StreamMessage{
+				{ID: model.StreamID{Ms: 1}, Fields: []string{"k"}, Values: []string{"v"}},
+			},
+		})
+	}
+	if err == nil {
+		err = enc.WriteEnd()
+	}
+	_ = f.Close()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	readRDB := func(filename string) (string, map[string]string) {
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			t.Error(err)
+			return "", nil
+		}
+		encodings := make(map[string]string)
+		err = core.NewDecoder(strings.NewReader(string(data))).Parse(func(object model.RedisObject) bool {
+			encodings[object.GetKey()] = object.(model.BaseObjectHolder).GetEncoding()
+			return true
+		})
+		if err != nil {
+			t.Errorf("decode %s failed: %v", filename, err)
+		}
+		return string(data[:9]), encodings
+	}
+	header, encodings := readRDB(src)
+	if header != "REDIS0011" || encodings["hash"] != model.ListPackEncoding ||
+		encodings["list"] != model.QuickList2Encoding || encodings["zset"] != model.ListPackEncoding {
+		t.Errorf("unexpected source rdb %s: %v", header, encodings)
+	}
+
+	output := filepath.Join("tmp", "v9.rdb")
+	issues, err := helper.DowngradeRDB(src, output, 9)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(issues) != 1 || issues[0].Type != model.FunctionsType {
+		t.Errorf("expect functions to be dropped, got %d issues", len(issues))
+	}
+	header, encodings = readRDB(output)
+	if header != "REDIS0009" || len(encodings) != 4 || encodings["hash"] != model.ZipListEncoding ||
+		encodings["list"] != model.QuickListEncoding || encodings["zset"] != model.ZipListEncoding {
+		t.Errorf("unexpected output rdb %s: %v", header, encodings)
+	}
+
+	output = filepath.Join("tmp", "v8.rdb")
+	issues, err = helper.DowngradeRDB(src, output, 8)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(issues) != 2 || issues[1].Key != "stream" {
+		t.Errorf("expect functions and stream to be dropped, got %d issues", len(issues))
+	}
+	_, encodings = readRDB(output)
+	if len(encodings) != 3 {
+		t.Errorf("expect 3 keys, actual: %d", len(encodings))
+	}
+	_, err = helper.DowngradeRDB(src, output, 8, helper.WithStrictOption())
+	if err == nil {
+		t.Error("expect error in strict mode")
+	}
+
+	// database whose keys are all dropped
+	src = filepath.Join("tmp", "dbs.rdb")
+	f, err = os.Create(src)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	enc = core.NewEncoder(f).SetVersion(12)
+	errs := []error{
+		enc.WriteHeader(),
+		enc.WriteDBHeader(0, 1, 0),
+		enc.WriteStringObject("a", []byte("1")),
+		enc.WriteDBHeader(1, 1, 0),
+		enc.WriteStreamObject("stream", &model.StreamObject{
+			LastID: model.StreamID{Ms: 1, Seq: 0},
+			Messages: []*model.StreamMessage{
+				{ID: model.StreamID{Ms: 1}, Fields: []string{"k"}, Values: []string{"v"}},
+			},
+		}),
+		enc.WriteDBHeader(2, 2, 0),
+		enc.WriteStringObject("b", []byte("2")),
+		enc.WriteHashMetadataObject("ttl", map[string][]byte{"f": []byte("v"), "g": []byte("w")},
+			map[string]time.Time{"f": time.Unix(1700000000, 0)}),
+		enc.WriteEnd(),
+	}
+	_ = f.Close()
+	for _, err := range errs {
+		if err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	issues, err = helper.DowngradeRDB(src, output, 8)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(issues) != 2 || issues[0].Key != "stream" || issues[1].Key != "ttl" {
+		t.Errorf("expect stream and hash with field expiration to be dropped, got %d issues", len(issues))
+	}
+	dbs := make(map[string]int)
+	data, err := os.ReadFile(output)
+	if err == nil {
+		err = core.NewDecoder(strings.NewReader(string(data))).Parse(func(object model.RedisObject) bool {
+			dbs[object.GetKey()] = object.GetDBIndex()
+			return true
+		})
+	}
+	if err != nil {
+		t.Error(err)
+	}
+	if len(dbs) != 2 || dbs["a"] != 0 || dbs["b"] != 2 {
+		t.Errorf("wrong keys: %v", dbs)
+	}
+	issues, err = helper.DowngradeRDB(src, output, 12)
+	if err != nil || len(issues) != 0 {
+		t.Errorf("expect no issue, actual %d issues, error: %v", len(issues), err)
+	}
+}
+
+func TestCheckCompat(t *testing.T) {
+	report, err := helper.CheckCompat(filepath.Join("cases", "memory.rdb"), "6.2")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if report.RDBVersion != 9 || report.MinRedisVersion != "5.0" || !report.Compatible() || len(report.Issues) != 0 {
+		t.Errorf("unexpected report: %d %s %d", report.RDBVersion, report.MinRedisVersion, len(report.Issues))
+	}
+	report, err = helper.CheckCompat(filepath.Join("cases", "memory.rdb"), "3.2")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if report.Compatible() || len(report.Issues) != 1 || report.Issues[0].Type != "header" {
+		t.Error("expect header to be incompatible")
+	}
+
+	err = os.MkdirAll("tmp", os.ModePerm)
+	if err != nil {
+		return
+	}
+	defer func() {
+		err := os.RemoveAll("tmp")
+		if err != nil {
+			t.Logf("remove tmp directory failed: %v", err)
+		}
+	}()
+	src := filepath.Join("tmp", "v11.rdb")
+	f, err := os.Create(src)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	enc := core.NewEncoder(f).SetVersion(11)
+	err = enc.WriteHeader()
+	if err == nil {
+		err = enc.WriteFunction("#!lua name=mylib\nredis.register_function('f', function() return 1 end)")
+	}
+	if err == nil {
+		err = enc.WriteDBHeader(0, 2, 0)
+	}
+	if err == nil {
+		err = enc.WriteStringObject("string", []byte("a"))
+	}
+	if err == nil {
+		err = enc.WriteHashMapObject("hash", map[string][]byte{"a": []byte("1")})
+	}
+	if err == nil {
+		err = enc.WriteEnd()
+	}
+	_ = f.Close()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	report, err = helper.CheckCompat(src, "6.2")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if report.MinRedisVersion != "7.2" || report.Compatible() {
+		t.Errorf("expect minimal redis version 7.2, actual %s", report.MinRedisVersion)
+	}
+	if len(report.Functions) != 1 || report.Functions[0] != "mylib" {
+		t.Errorf("wrong function libraries: %v", report.Functions)
+	}
+	var keys []string
+	for _, issue := range report.Issues {
+		keys = append(keys, issue.Type+":"+issue.Key)
+	}
+	if strings.Join(keys, ",") != "header:,functions:mylib,hash:hash" {
+		t.Errorf("wrong issues: %v", keys)
+	}
+
+	// hash with field expiration and functions of pre-release redis 7.0 are reported
+	src = filepath.Join("tmp", "v12.rdb")
+	f, err = os.Create(src)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	enc = core.NewEncoder(f).SetVersion(12)
+	err = enc.WriteHeader()
+	if err == nil {
+		// RDB_OPCODE_FUNCTION_PRE_GA with library name, engine name, no description and code
+		_, err = f.Write([]byte("\xf6\x06oldlib\x03LUA\x00\x08return 1"))
+	}
+	if err == nil {
+		err = enc.WriteDBHeader(0, 2, 0)
+	}
+	if err == nil {
+		err = enc.WriteHashMetadataObject("ttl", map[string][]byte{"f": []byte("v")},
+			map[string]time.Time{"f": time.Unix(1700000000, 0)})
+	}
+	if err == nil {
+		err = enc.WriteStringObject("string", []byte("a"))
+	}
+	if err == nil {
+		err = enc.WriteEnd()
+	}
+	_ = f.Close()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	report, err = helper.CheckCompat(src, "7.2")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if report.MinRedisVersion != "7.4" || report.Compatible() {
+		t.Errorf("expect minimal redis version 7.4, actual %s", report.MinRedisVersion)
+	}
+	keys = keys[:0]
+	for _, issue := range report.Issues {
+		keys = append(keys, issue.Type+":"+issue.Key+":"+issue.Feature)
+	}
+	expect := "header::RDB version 12,hash:ttl:RDB_TYPE_HASH_METADATA,opcode::RDB_OPCODE_FUNCTION_PRE_GA"
+	if strings.Join(keys, ",") != expect {
+		t.Errorf("wrong issues: %v", keys)
+	}
+	report, err = helper.CheckCompat(src, "7.4")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if report.Compatible() || len(report.Issues) != 1 {
+		t.Errorf("expect pre-release functions to be incompatible with any redis, got %d issues", len(report.Issues))
+	}
+}
+
+func TestRestoreCmd(t *testing.T) {
+	rdbFile, err := os.Open(filepath.Join("cases", "memory.rdb"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer func() {
+		_ = rdbFile.Close()
+	}()
+	count := 0
+	err = core.NewDecoder(rdbFile).Parse(func(object model.RedisObject) bool {
+		cmdLines := helper.ObjectToCmd(object, helper.WithRestoreOption(9, true))
+		if len(cmdLines) != 1 {
+			t.Errorf("expect one command for %s", object.GetKey())
+			return true
+		}
+		cmdLine := cmdLines[0]
+		if string(cmdLine[0]) != "RESTORE" || string(cmdLine[1]) != object.GetKey() || string(cmdLine[4]) != "REPLACE" {
+			t.Errorf("wrong command for %s", object.GetKey())
+			return true
+		}
+		payload := cmdLine[3]
+		n := len(payload) - 8
+		if payload[n-2] != 9 || payload[n-1] != 0 ||
+			binary.LittleEndian.Uint64(payload[n:]) != crc64jones.Checksum(payload[:n]) {
+			t.Errorf("wrong payload for %s", object.GetKey())
+		}
+		count++
+		return true
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if count == 0 {
+		t.Error("expect RESTORE commands")
+	}
+
+	idle := uint64(100)
+	expiration := time.Unix(1700000000, 0)
+	object := &model.StringObject{
+		BaseObject: &model.BaseObject{Key: "a", Expiration: &expiration, Idle: &idle},
+		Value:      []byte("1"),
+	}
+	cmdLine := helper.ObjectToCmd(object, helper.WithRestoreOption(9, false))[0]
+	actual := fmt.Sprintf("%s %s %s %s", cmdLine[1], cmdLine[2], cmdLine[4], bytes.Join(cmdLine[5:], []byte(" ")))
+	if actual != "a 1700000000000 ABSTTL IDLETIME 100" {
+		t.Errorf("wrong command: %s", actual)
+	}
+	if cmdLines := helper.ObjectToCmd(object, helper.WithRestoreOption(8, false)); len(cmdLines) != 0 {
+		t.Error("expect expired key to be skipped without ABSTTL")
+	}
+	err = helper.ToAOF(filepath.Join("cases", "memory.rdb"), filepath.Join("tmp", "restore.aof"),
+		helper.WithRestoreOption(99, false))
+	if err == nil {
+		t.Error("expect error of unsupported version")
+	}
+}
+
+func TestDecodeDumpFile(t *testing.T) {
+	err := os.MkdirAll("tmp", os.ModePerm)
+	if err != nil {
+		return
+	}
+	defer func() {
+		err := os.RemoveAll("tmp")
+		if err != nil {
+			t.Logf("remove tmp directory failed: %v", err)
+		}
+	}()
+	rdbFile, err := os.Open(filepath.Join("cases", "memory.rdb"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer func() {
+		_ = rdbFile.Close()
+	}()
+	var lines []string
+	var types []string
+	err = core.NewDecoder(rdbFile).Parse(func(object model.RedisObject) bool {
+		payload, err := core.DumpObject(object, 9)
+		if err != nil {
+			t.Error(err)
+			return false
+		}
+		lines = append(lines, object.GetKey()+" "+hex.EncodeToString(payload))
+		types = append(types, object.GetKey()+":"+object.GetType())
+		return true
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	src := filepath.Join("tmp", "payloads.txt")
+	err = os.WriteFile(src, []byte(strings.Join(lines, "\n")), 0644)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	output := filepath.Join("tmp", "payloads.json")
+	err = helper.DecodeDumpFile(src, output)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	var objects []*model.BaseObject
+	err = json.Unmarshal(data, &objects)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	var actual []string
+	for _, object := range objects {
+		actual = append(actual, object.Key+":"+object.Type)
+	}
+	if strings.Join(actual, ",") != strings.Join(types, ",") {
+		t.Errorf("wrong objects: %v", actual)
+	}
+
+	lines[0] = lines[0][:len(lines[0])-2] + "00"
+	err = os.WriteFile(src, []byte(strings.Join(lines, "\n")), 0644)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = helper.DecodeDumpFile(src, output)
+	if err == nil {
+		t.Error("expect checksum error")
+	}
+}
+
+func TestToMultipartAOF(t *testing.T) {
+	err := os.MkdirAll("tmp", os.ModePerm)
+	if err != nil {
+		return
+	}
+	defer func() {
+		err := os.RemoveAll("tmp")
+		if err != nil {
+			t.Logf("remove tmp directory failed: %v", err)
+		}
+	}()
+	src := filepath.Join("cases", "memory.rdb")
+	dir := filepath.Join("tmp", "appendonlydir")
+	err = helper.ToMultipartAOF(src, dir)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	manifest, err := os.ReadFile(filepath.Join(dir, "appendonly.aof.manifest"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	expect := "file appendonly.aof.1.base.rdb seq 1 type b\nfile appendonly.aof.1.incr.aof seq 1 type i\n"
+	if string(manifest) != expect {
+		t.Errorf("wrong manifest: %s", manifest)
+	}
+	if f, _ := os.Stat(filepath.Join(dir, "appendonly.aof.1.incr.aof")); f == nil || f.Size() != 0 {
+		t.Error("expect empty incr file")
+	}
+	srcData, err := os.ReadFile(src)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	baseData, err := os.ReadFile(filepath.Join(dir, "appendonly.aof.1.base.rdb"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	srcKeys := make(map[string]struct{})
+	err = core.NewDecoder(bytes.NewReader(srcData)).Parse(func(object model.RedisObject) bool {
+		srcKeys[object.GetKey()] = struct{}{}
+		return true
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	aofBase := false
+	keyCount := 0
+	err = core.NewDecoder(bytes.NewReader(baseData)).WithSpecialOpCode().Parse(func(object model.RedisObject) bool {
+		if aux, ok := object.(*model.AuxObject); ok && aux.Key == "aof-base" {
+			aofBase = true
+		} else if _, ok := srcKeys[object.GetKey()]; ok {
+			keyCount++
+		}
+		return true
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !aofBase || keyCount != len(srcKeys) {
+		t.Errorf("wrong rdb base file, aof-base %v, %d keys", aofBase, keyCount)
+	}
+
+	dir = filepath.Join("tmp", "respdir")
+	err = helper.ToMultipartAOF(src, dir, helper.WithRESPBaseOption(), helper.WithAOFFilenameOption("a.aof"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	equals, err := compareFileByLine(t, filepath.Join(dir, "a.aof.1.base.aof"), filepath.Join("cases", "memory.aof"))
+	if err != nil || !equals {
+		t.Errorf("wrong resp base file: %v", err)
+	}
+	manifest, _ = os.ReadFile(filepath.Join(dir, "a.aof.manifest"))
+	if string(manifest) != "file a.aof.1.base.aof seq 1 type b\nfile a.aof.1.incr.aof seq 1 type i\n" {
+		t.Errorf("wrong manifest: %s", manifest)
+	}
+	err = helper.ToMultipartAOF(src, dir, helper.WithAOFFilenameOption("a b"))
+	if err == nil {
+		t.Error("expect error of illegal aof filename")
+	}
+
+	// streams have no plain commands, they are written by RESTORE
+	src = filepath.Join("tmp", "stream.rdb")
+	f, err := os.Create(src)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	enc := core.NewEncoder(f)
+	err = enc.WriteHeader()
+	if err == nil {
+		err = enc.WriteDBHeader(0, 2, 0)
+	}
+	if err == nil {
+		err = enc.WriteStringObject("s", []byte("v"))
+	}
+	if err == nil {
+		err = enc.WriteStreamObject("stream", &model.StreamObject{
+			LastID: model.StreamID{Ms: 1, Seq: 0},
+			Messages: []*model.StreamMessage{
+				{ID: model.StreamID{Ms: 1}, Fields: []string{"k"}, Values: []string{"v"}},
+			},
+		})
+	}
+	if err == nil {
+		err = enc.WriteEnd()
+	}
+	_ = f.Close()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	dir = filepath.Join("tmp", "streamdir")
+	err = helper.ToMultipartAOF(src, dir, helper.WithRESPBaseOption())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	base, err := os.ReadFile(filepath.Join(dir, "appendonly.aof.1.base.aof"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !strings.Contains(string(base), "$3\r\nSET\r\n$1\r\ns\r\n") ||
+		!strings.Contains(string(base), "$7\r\nRESTORE\r\n$6\r\nstream\r\n") {
+		t.Errorf("wrong resp base file: %q", base)
+	}
+}
+
+func TestAOFInput(t *testing.T) {
+	err := os.MkdirAll("tmp", os.ModePerm)
+	if err != nil {
+		return
+	}
+	defer func() {
+		err := os.RemoveAll("tmp")
+		if err != nil {
+			t.Logf("remove tmp directory failed: %v", err)
+		}
+	}()
+	preamble, err := os.ReadFile(filepath.Join("cases", "memory.rdb"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	commands := helper.CmdLinesToResp([]helper.CmdLine{
+		{[]byte("SET"), []byte("s"), []byte("changed")},
+		{[]byte("DEL"), []byte("list")},
+		{[]byte("SELECT"), []byte("3")},
+		{[]byte("SET"), []byte("new"), []byte("1")},
+	})
+	readJSON := func(src string) map[string]string {
+		output := filepath.Join("tmp", "output.json")
+		err := helper.ToJsons(src, output)
+		if err != nil {
+			t.Error(err)
+			return nil
+		}
+		data, err := os.ReadFile(output)
+		if err != nil {
+			t.Error(err)
+			return nil
+		}
+		var objects []map[string]interface{}
+		err = json.Unmarshal(data, &objects)
+		if err != nil {
+			t.Error(err)
+			return nil
+		}
+		result := make(map[string]string)
+		for _, object := range objects {
+			result[fmt.Sprintf("%v:%v", object["db"], object["key"])] = fmt.Sprint(object["value"])
+		}
+		return result
+	}
+	check := func(src string) {
+		objects := readJSON(src)
+		if objects == nil {
+			return
+		}
+		if objects["0:s"] != "changed" || objects["3:new"] != "1" {
+			t.Errorf("commands of %s are not applied", src)
+		}
+		if _, ok := objects["0:list"]; ok {
+			t.Errorf("deleted key is found in %s", src)
+		}
+		if _, ok := objects["0:hash"]; !ok {
+			t.Errorf("key of preamble is not found in %s", src)
+		}
+	}
+
+	src := filepath.Join("tmp", "appendonly.aof")
+	err = os.WriteFile(src, append(append([]byte{}, preamble...), commands...), 0644)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	check(src)
+
+	dir := filepath.Join("tmp", "appendonlydir")
+	err = helper.ToMultipartAOF(filepath.Join("cases", "memory.rdb"), dir)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = os.WriteFile(filepath.Join(dir, "appendonly.aof.1.incr.aof"), commands, 0644)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	check(dir)
+	err = helper.MemoryProfile(dir, filepath.Join("tmp", "memory.csv"))
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPointInTimeRDB(t *testing.T) {
+	err := os.MkdirAll("tmp", os.ModePerm)
+	if err != nil {
+		return
+	}
+	defer func() {
+		err := os.RemoveAll("tmp")
+		if err != nil {
+			t.Logf("remove tmp directory failed: %v", err)
+		}
+	}()
+	dir := filepath.Join("tmp", "appendonlydir")
+	err = helper.ToMultipartAOF(filepath.Join("cases", "memory.rdb"), dir)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	incr := "#TS:1790000000\r\n" + string(helper.CmdLinesToResp([]helper.CmdLine{
+		{[]byte("SET"), []byte("s"), []byte("before")},
+		{[]byte("SET"), []byte("expired"), []byte("1"), []byte("EX"), []byte("30")},
+		{[]byte("SET"), []byte("volatile"), []byte("1"), []byte("EX"), []byte("600")},
+		{[]byte("FUNCTION"), []byte("LOAD"), []byte("#!lua name=mylib\nredis.register_function('f', function() end)")},
+	})) + "#TS:1790000060\r\n" + string(helper.CmdLinesToResp([]helper.CmdLine{
+		{[]byte("MULTI")},
+		{[]byte("DEL"), []byte("hash")},
+	})) + "#TS:1790000120\r\n" + string(helper.CmdLinesToResp([]helper.CmdLine{
+		{[]byte("SET"), []byte("s"), []byte("after")},
+	}))
+	err = os.WriteFile(filepath.Join(dir, "appendonly.aof.1.incr.aof"), []byte(incr), 0644)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	output := filepath.Join("tmp", "pitr.rdb")
+	result, err := helper.PointInTimeRDB("", dir, output, time.Unix(1790000100, 0))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if result.Commands != 6 || !result.Truncated || result.LastTimestamp.Unix() != 1790000060 {
+		t.Errorf("wrong result: %+v", result)
+	}
+	rdbFile, err := os.Open(output)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer func() {
+		_ = rdbFile.Close()
+	}()
+	objects := make(map[string]model.RedisObject)
+	var functions []string
+	err = core.NewDecoder(rdbFile).WithSpecialOpCode().Parse(func(object model.RedisObject) bool {
+		switch o := object.(type) {
+		case *model.FunctionsObject:
+			functions = append(functions, o.Code)
+		case *model.AuxObject:
+			if o.Key == "aof-base" {
+				t.Error("aof-base should be removed")
+			}
+		default:
+			objects[object.GetKey()] = object
+		}
+		return true
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if s, ok := objects["s"].(*model.StringObject); !ok || string(s.Value) != "before" {
+		t.Errorf("wrong value of s: %v", objects["s"])
+	}
+	if objects["hash"] == nil {
+		t.Error("incomplete transaction should be discarded")
+	}
+	// ttl is counted from #TS:1790000000 rather than current time
+	if objects["expired"] != nil || result.Expired == 0 {
+		t.Error("key expired at until should be dropped")
+	}
+	if v := objects["volatile"]; v == nil || v.GetExpiration().Unix() != 1790000600 {
+		t.Errorf("wrong expiration of volatile key: %v", v)
+	}
+	if len(functions) != 1 {
+		t.Errorf("expect 1 function, actual %d", len(functions))
+	}
+
+	// rdb given, base file of aof is ignored
+	base := filepath.Join("tmp", "base.rdb")
+	err = helper.FilterRDB(filepath.Join("cases", "memory.rdb"), base, helper.WithRegexOption("^s$"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	result, err = helper.PointInTimeRDB(base, dir, output, time.Unix(1790000000, 0))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if result.Commands != 4 {
+		t.Errorf("expect 4 commands, actual %d", result.Commands)
+	}
+	err = helper.ToJsons(output, filepath.Join("tmp", "pitr.json"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	data, err := os.ReadFile(filepath.Join("tmp", "pitr.json"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !strings.Contains(string(data), `"before"`) || strings.Contains(string(data), `"hash"`) {
+		t.Errorf("wrong keys: %s", data)
+	}
+}
+
+func TestCompressedInputOutput(t *testing.T) {
+	err := os.MkdirAll("tmp", os.ModePerm)
+	if err != nil {
+		return
+	}
+	defer func() {
+		err := os.RemoveAll("tmp")
+		if err != nil {
+			t.Logf("remove tmp directory failed: %v", err)
+		}
+	}()
+	data, err := os.ReadFile(filepath.Join("cases", "memory.rdb"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	var buf bytes.Buffer
+	gzWriter := gzip.NewWriter(&buf)
+	_, err = gzWriter.Write(data)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = gzWriter.Close()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	src := filepath.Join("tmp", "memory.rdb.gz")
+	err = os.WriteFile(src, buf.Bytes(), 0644)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	readGzip := func(filename string) []byte {
+		file, err := os.Open(filename)
+		if err != nil {
+			t.Error(err)
+			return nil
+		}
+		defer func() {
+			_ = file.Close()
+		}()
+		reader, err := gzip.NewReader(file)
+		if err != nil {
+			t.Error(err)
+			return nil
+		}
+		data, err := io.ReadAll(reader)
+		if err != nil {
+			t.Error(err)
+		}
+		return data
+	}
+
+	err = helper.ToJsons(filepath.Join("cases", "memory.rdb"), filepath.Join("tmp", "memory.json"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = helper.ToJsons(src, filepath.Join("tmp", "memory.json.gz"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	expect, err := os.ReadFile(filepath.Join("tmp", "memory.json"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !bytes.Equal(expect, readGzip(filepath.Join("tmp", "memory.json.gz"))) {
+		t.Error("wrong json of compressed rdb")
+	}
+
+	err = helper.ToAOF(src, filepath.Join("tmp", "memory.aof.gz"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	expect, err = os.ReadFile(filepath.Join("cases", "memory.aof"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !bytes.Equal(expect, readGzip(filepath.Join("tmp", "memory.aof.gz"))) {
+		t.Error("wrong aof of compressed rdb")
+	}
+	// compressed aof is also accepted as input
+	err = helper.MemoryProfile(filepath.Join("tmp", "memory.aof.gz"), filepath.Join("tmp", "memory.csv.gz"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !strings.HasPrefix(string(readGzip(filepath.Join("tmp", "memory.csv.gz"))), "database,key,type") {
+		t.Error("wrong csv")
+	}
+}
+
+func TestReaderWriterAPIs(t *testing.T) {
+	err := os.MkdirAll("tmp", os.ModePerm)
+	if err != nil {
+		return
+	}
+	defer func() {
+		err := os.RemoveAll("tmp")
+		if err != nil {
+			t.Logf("remove tmp directory failed: %v", err)
+		}
+	}()
+	srcRdb := filepath.Join("cases", "memory.rdb")
+	data, err := os.ReadFile(srcRdb)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	compare := func(name string, expectFilename string, actual []byte) {
+		expect, err := os.ReadFile(expectFilename)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if !bytes.Equal(expect, actual) {
+			t.Errorf("%s: output of reader api is different from file api", name)
+		}
+	}
+
+	var buf bytes.Buffer
+	err = helper.ToJsonsFromReader(bytes.NewReader(data), &buf)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = helper.ToJsons(srcRdb, filepath.Join("tmp", "memory.json"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	compare("json", filepath.Join("tmp", "memory.json"), buf.Bytes())
+
+	buf.Reset()
+	err = helper.ToAOFFromReader(bytes.NewReader(data), &buf)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	compare("aof", filepath.Join("cases", "memory.aof"), buf.Bytes())
+
+	// aof as input
+	aof := buf.Bytes()
+	buf = bytes.Buffer{}
+	err = helper.MemoryProfileFromReader(bytes.NewReader(aof), &buf)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !strings.HasPrefix(buf.String(), "database,key,type") || strings.Count(buf.String(), "\n") < 2 {
+		t.Errorf("wrong memory report: %s", buf.String())
+	}
+
+	buf.Reset()
+	err = helper.FindBiggestKeysFromReader(bytes.NewReader(data), 2, &buf)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	bigkeyFile, err := os.Create(filepath.Join("tmp", "bigkey.csv"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	err = helper.FindBiggestKeys(srcRdb, 2, bigkeyFile)
+	_ = bigkeyFile.Close()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	compare("bigkey", filepath.Join("tmp", "bigkey.csv"), buf.Bytes())
+
+	buf.Reset()
+	err = helper.FlameGraphFromReader(bytes.NewReader(data), &buf, nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if !strings.Contains(buf.String(), `"n":"db:0"`) || strings.Contains(buf.String(), `d3.json("stacks.json"`) {
+		t.Error("data is not embedded in flamegraph page")
+	}
+
+	err = helper.ToJsonsFromReader(strings.NewReader("REDIS0009 broken"), &buf)
+	if err == nil {
+		t.Error("expect error of broken rdb")
+	}
+	err = helper.ToJsonsFromReader(nil, &buf)
+	if err == nil {
+		t.Error("expect error of nil input")
+	}
+}
+
+func TestToJsonsMarshalError(t *testing.T) {
+	// redis allows infinite scores, which could not be represented in json
+	buf := bytes.NewBuffer(nil)
+	enc := core.NewEncoder(buf)
+	errs := []error{
+		enc.WriteHeader(),
+		enc.WriteDBHeader(0, 1, 0),
+		enc.WriteZSetObject("z", []*model.ZSetEntry{{Member: "a", Score: math.Inf(1)}}),
+		enc.WriteEnd(),
+	}
+	for _, err := range errs {
+		if err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	for _, workers := range []int{1, 2} {
+		var output bytes.Buffer
+		err := helper.ToJsonsFromReader(bytes.NewReader(buf.Bytes()), &output, helper.WithParallelOption(workers))
+		if err == nil || !strings.Contains(err.Error(), "z") {
+			t.Errorf("expect marshal error of z with %d workers, actual: %v", workers, err)
+		}
+		if strings.Contains(output.String(), "marshal") {
+			t.Error("error should not be written into output")
+		}
+	}
+}
+
+func TestDecodeObject(t *testing.T) {
+	files, err := filepath.Glob("cases/*.rdb")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	for _, filename := range files {
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		dec := core.NewDecoder(bytes.NewReader(data))
+		err = dec.Parse(func(object model.RedisObject) bool {
+			end := dec.Offset()
+			start := end - object.GetSize() - 1
+			obj, err := core.DecodeObject(bytes.NewReader(data[start:end]), dec.Version())
+			if err != nil {
+				t.Errorf("%s: decode %s failed: %v", filename, object.GetKey(), err)
+				return false
+			}
+			base := obj.(model.BaseObjectHolder).GetBaseObject()
+			base.DB, base.Expiration = object.GetDBIndex(), object.GetExpiration()
+			expect, _ := json.Marshal(object)
+			actual, _ := json.Marshal(obj)
+			if !bytes.Equal(expect, actual) {
+				t.Errorf("%s: wrong object %s", filename, object.GetKey())
+				return false
+			}
+			return true
+		})
+		if err != nil {
+			t.Errorf("%s: %v", filename, err)
+		}
+	}
+	_, err = core.DecodeObject(bytes.NewReader([]byte{0}), 9)
+	if err == nil {
+		t.Error("expect error of truncated input")
+	}
+}
+
+func TestParallelOption(t *testing.T) {
+	dir := t.TempDir()
+	for _, src := range []string{"cases/memory.rdb", "cases/ziplist_that_compresses_easily.rdb", "cases/tree.rdb"} {
+		for _, tc := range []struct {
+			name    string
+			convert func(src, output string, options ...interface{}) error
+			options []interface{}
+		}{
+			{"json", helper.ToJsons, nil},
+			{"json", helper.ToJsons, []interface{}{helper.WithRegexOption(".*")}},
+			{"csv", helper.MemoryProfile, nil},
+			{"aof", helper.ToAOF, nil},
+		} {
+			expectFile := filepath.Join(dir, "expect."+tc.name)
+			actualFile := filepath.Join(dir, "actual."+tc.name)
+			err := tc.convert(src, expectFile, tc.options...)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			err = tc.convert(src, actualFile, append(tc.options, helper.WithParallelOption(4))...)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			expect, _ := os.ReadFile(expectFile)
+			actual, _ := os.ReadFile(actualFile)
+			if len(expect) == 0 || !bytes.Equal(expect, actual) {
+				t.Errorf("%s: wrong %s with parallel option", src, tc.name)
+			}
+		}
+	}
+}